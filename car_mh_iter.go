@@ -0,0 +1,162 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+)
+
+// carSectionPeekSize is the number of bytes initially read at the start of every CAR section in
+// order to decode its length and CID, which is sufficient for the vast majority of CIDs.
+const carSectionPeekSize = 128
+
+var _ MultihashIterator = (*carDataMhIterator)(nil)
+
+// carDataMhIterator iterates over the multihashes of the blocks in a CARv1 data payload by reading
+// the section headers only, skipping the block data.
+type carDataMhIterator struct {
+	r          io.ReaderAt
+	opts       car.Options
+	offset     int64
+	headerRead bool
+	buf        []byte
+}
+
+// CarDataMultihashIterator constructs a new MultihashIterator that streams multihashes directly
+// from the CARv1 data payload read from r, such as the one returned by car.Reader.DataReader.
+//
+// This iterator supplies multihashes in the order in which their blocks appear in the payload,
+// i.e. in order of their CAR offset. The multihashes supplied are identical to the ones supplied
+// by CarMultihashIterator over an index generated from the same CAR with the same options; unlike
+// CarMultihashIterator, it requires no index and reads only the section headers. Therefore, memory
+// used is bounded regardless of the number of blocks in the CAR.
+//
+// The options car.StoreIdentityCIDs, car.MaxIndexCidSize, car.ZeroLengthSectionAsEOF,
+// car.MaxAllowedHeaderSize and car.MaxAllowedSectionSize are respected as they would be when
+// generating an index.
+func CarDataMultihashIterator(r io.ReaderAt, opts ...car.Option) MultihashIterator {
+	return &carDataMhIterator{
+		r:    r,
+		opts: car.ApplyOptions(opts...),
+		buf:  make([]byte, carSectionPeekSize),
+	}
+}
+
+// Next implements the MultihashIterator interface.
+func (it *carDataMhIterator) Next() (multihash.Multihash, error) {
+	if !it.headerRead {
+		if err := it.skipHeader(); err != nil {
+			return nil, fmt.Errorf("error reading car header: %w", err)
+		}
+		it.headerRead = true
+	}
+	for {
+		c, err := it.nextCid()
+		if err != nil {
+			return nil, err
+		}
+		if c.Prefix().MhType == multihash.IDENTITY && !it.opts.StoreIdentityCIDs {
+			continue
+		}
+		if cidLen := uint64(c.ByteLen()); cidLen > it.opts.MaxIndexCidSize {
+			return nil, &car.ErrCidTooLarge{MaxSize: it.opts.MaxIndexCidSize, CurrentSize: cidLen}
+		}
+		return c.Hash(), nil
+	}
+}
+
+func (it *carDataMhIterator) skipHeader() error {
+	headerLen, n, err := it.readUvarint()
+	if err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if headerLen == 0 {
+		return errors.New("invalid header length: 0")
+	}
+	if headerLen > it.opts.MaxAllowedHeaderSize {
+		return fmt.Errorf("invalid header length: %d exceeds maximum allowed %d", headerLen, it.opts.MaxAllowedHeaderSize)
+	}
+	it.offset += int64(n) + int64(headerLen)
+	return nil
+}
+
+// nextCid reads the CID of the section at the current offset, and advances the offset to the next
+// section. io.EOF is returned once there are no more sections.
+func (it *carDataMhIterator) nextCid() (cid.Cid, error) {
+	sectionLen, n, err := it.readUvarint()
+	if err != nil {
+		if err == io.EOF && !it.endsAtOffset() {
+			return cid.Undef, io.ErrUnexpectedEOF
+		}
+		return cid.Undef, err
+	}
+	if sectionLen == 0 {
+		if it.opts.ZeroLengthSectionAsEOF {
+			return cid.Undef, io.EOF
+		}
+		return cid.Undef, errors.New("carv1 null padding not allowed by default; see ZeroLengthSectionAsEOF")
+	}
+	if sectionLen > it.opts.MaxAllowedSectionSize {
+		return cid.Undef, fmt.Errorf("invalid section length: %d exceeds maximum allowed %d", sectionLen, it.opts.MaxAllowedSectionSize)
+	}
+
+	// Read the CID, and grow the buffer up to the whole section if the CID does not fit.
+	cidOffset := it.offset + int64(n)
+	read, err := it.r.ReadAt(it.buf, cidOffset)
+	if err != nil && err != io.EOF {
+		return cid.Undef, err
+	}
+	cidLen, c, err := cid.CidFromBytes(it.buf[:read])
+	if err != nil {
+		if uint64(read) >= sectionLen || read < len(it.buf) {
+			return cid.Undef, fmt.Errorf("invalid section at offset %d: %w", it.offset, err)
+		}
+		it.buf = make([]byte, sectionLen)
+		if _, err = it.r.ReadAt(it.buf, cidOffset); err != nil {
+			return cid.Undef, err
+		}
+		if cidLen, c, err = cid.CidFromBytes(it.buf); err != nil {
+			return cid.Undef, fmt.Errorf("invalid section at offset %d: %w", it.offset, err)
+		}
+	}
+	if uint64(cidLen) > sectionLen {
+		return cid.Undef, fmt.Errorf("invalid section at offset %d: cid length %d exceeds section length %d", it.offset, cidLen, sectionLen)
+	}
+	it.offset = cidOffset + int64(sectionLen)
+	return c, nil
+}
+
+// endsAtOffset checks whether the data preceding the current offset is present, i.e. the header
+// or section that precedes the offset is not truncated.
+func (it *carDataMhIterator) endsAtOffset() bool {
+	_, err := it.r.ReadAt(it.buf[:1], it.offset-1)
+	return err == nil
+}
+
+// readUvarint reads a varint at the current offset, returning io.EOF if there are no more bytes
+// to read.
+func (it *carDataMhIterator) readUvarint() (uint64, int, error) {
+	read, err := it.r.ReadAt(it.buf[:varint.MaxLenUvarint63], it.offset)
+	if read == 0 {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, 0, err
+	}
+	if err != nil && err != io.EOF {
+		return 0, 0, err
+	}
+	v, n, err := varint.FromUvarint(it.buf[:read])
+	if err != nil {
+		return 0, 0, err
+	}
+	return v, n, nil
+}
@@ -0,0 +1,168 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCarDataMultihashIterator_MatchesCarMultihashIterator(t *testing.T) {
+	for _, path := range []string{
+		"testdata/sample-v1.car",
+		"testdata/sample-v1-2.car",
+		"testdata/sample-wrapped-v2.car",
+		"testdata/sample-wrapped-v2-2.car",
+	} {
+		path := path
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			idx, err := car.GenerateIndexFromFile(path)
+			require.NoError(t, err)
+			iterIdx, ok := idx.(index.IterableIndex)
+			require.True(t, ok)
+			want, err := CarMultihashIterator(iterIdx)
+			require.NoError(t, err)
+
+			cr, err := car.OpenReader(path)
+			require.NoError(t, err)
+			defer cr.Close()
+			dr, err := cr.DataReader()
+			require.NoError(t, err)
+			got := CarDataMultihashIterator(dr)
+
+			wantMhs := collectMultihashes(t, want)
+			require.NotEmpty(t, wantMhs)
+			require.Equal(t, wantMhs, collectMultihashes(t, got))
+		})
+	}
+}
+
+func TestCarDataMultihashIterator_ZeroLengthSection(t *testing.T) {
+	payload, err := os.ReadFile("testdata/sample-v1.car")
+	require.NoError(t, err)
+	wantMhs := collectMultihashes(t, CarDataMultihashIterator(bytes.NewReader(payload)))
+
+	padded := append(payload, make([]byte, 64)...)
+	subject := CarDataMultihashIterator(bytes.NewReader(padded))
+	for range wantMhs {
+		_, err := subject.Next()
+		require.NoError(t, err)
+	}
+	_, err = subject.Next()
+	require.Error(t, err)
+	require.NotEqual(t, io.EOF, err)
+
+	subject = CarDataMultihashIterator(bytes.NewReader(padded), car.ZeroLengthSectionAsEOF(true))
+	require.Equal(t, wantMhs, collectMultihashes(t, subject))
+}
+
+func TestCarDataMultihashIterator_FailsOnTruncatedPayload(t *testing.T) {
+	payload, err := os.ReadFile("testdata/sample-v1.car")
+	require.NoError(t, err)
+
+	subject := CarDataMultihashIterator(bytes.NewReader(payload[:len(payload)/2]))
+	for {
+		_, err = subject.Next()
+		if err != nil {
+			break
+		}
+	}
+	require.NotEqual(t, io.EOF, err)
+
+	subject = CarDataMultihashIterator(bytes.NewReader(payload[:3]))
+	_, err = subject.Next()
+	require.Error(t, err)
+	require.NotEqual(t, io.EOF, err)
+}
+
+func BenchmarkCarMultihashIterators(b *testing.B) {
+	const blockCount = 100_000
+	path := filepath.Join(b.TempDir(), "bench.car")
+	writeRandomCar(b, path, blockCount)
+
+	b.Run("CarMultihashIterator", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			cr, err := car.OpenReader(path)
+			require.NoError(b, err)
+			ir, err := cr.IndexReader()
+			require.NoError(b, err)
+			idx, err := index.ReadFrom(ir)
+			require.NoError(b, err)
+			subject, err := CarMultihashIterator(idx.(index.IterableIndex))
+			require.NoError(b, err)
+			require.Equal(b, blockCount, countMultihashes(b, subject))
+			require.NoError(b, cr.Close())
+		}
+	})
+	b.Run("CarDataMultihashIterator", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			cr, err := car.OpenReader(path)
+			require.NoError(b, err)
+			dr, err := cr.DataReader()
+			require.NoError(b, err)
+			subject := CarDataMultihashIterator(dr)
+			require.Equal(b, blockCount, countMultihashes(b, subject))
+			require.NoError(b, cr.Close())
+		}
+	})
+}
+
+// writeRandomCar writes a CARv2 with an index at the given path, containing the given number of
+// random blocks.
+func writeRandomCar(t testing.TB, path string, blockCount int) {
+	ctx := context.Background()
+	rng := rand.New(rand.NewSource(1413))
+
+	var blks []blocks.Block
+	for i := 0; i < blockCount; i++ {
+		data := make([]byte, 1024)
+		rng.Read(data)
+		mh, err := multihash.Sum(data, multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		blk, err := blocks.NewBlockWithCid(data, cid.NewCidV1(cid.Raw, mh))
+		require.NoError(t, err)
+		blks = append(blks, blk)
+	}
+
+	bs, err := blockstore.OpenReadWrite(path, []cid.Cid{blks[0].Cid()})
+	require.NoError(t, err)
+	require.NoError(t, bs.PutMany(ctx, blks))
+	require.NoError(t, bs.Finalize())
+}
+
+func collectMultihashes(t testing.TB, mhi MultihashIterator) []multihash.Multihash {
+	var mhs []multihash.Multihash
+	for {
+		mh, err := mhi.Next()
+		if err == io.EOF {
+			return mhs
+		}
+		require.NoError(t, err)
+		mhs = append(mhs, mh)
+	}
+}
+
+func countMultihashes(t testing.TB, mhi MultihashIterator) int {
+	var count int
+	for {
+		_, err := mhi.Next()
+		if err == io.EOF {
+			return count
+		}
+		require.NoError(t, err)
+		count++
+	}
+}
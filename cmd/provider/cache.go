@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	adminserver "github.com/filecoin-project/index-provider/server/admin/http"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+)
+
+var CacheCmd = &cli.Command{
+	Name:        "cache",
+	Usage:       "Commands to inspect and warm up the advertisement entries cache of the provider",
+	Subcommands: []*cli.Command{cacheStatsSubCmd, cacheWarmUpSubCmd, cacheWarmUpStatusSubCmd},
+}
+
+var cacheStatsSubCmd = &cli.Command{
+	Name:  "stats",
+	Usage: "Shows the usage of the advertisement entries cache",
+	Description: `Shows the number of cached entries DAGs and the total size of cached chunks, along with their
+configured limits. A limit of zero means unlimited.
+
+Hits and misses count the entries chunks requested by indexers since the daemon was started, that
+were found in cache and that had to be regenerated respectively. Mismatches count the regenerated
+entries that did not match the advertised entries; see the list corrupted command.`,
+	Flags: []cli.Flag{
+		adminAPIFlag,
+	},
+	Action: cacheStatsCommand,
+}
+
+func cacheStatsCommand(cctx *cli.Context) error {
+	req, err := http.NewRequestWithContext(cctx.Context, http.MethodGet, adminAPIFlagValue+"/admin/cache/stats", nil)
+	if err != nil {
+		return err
+	}
+	cl := &http.Client{}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errFromHttpResp(resp)
+	}
+
+	var res adminserver.CacheStatsRes
+	if _, err := res.ReadFrom(resp.Body); err != nil {
+		return fmt.Errorf("received ok response from server but cannot decode response body. %v", err)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Cached DAGs: %d (max %d)\n", res.Len, res.Cap)
+	fmt.Fprintf(&b, "Cached bytes: %d (max %d)\n", res.Bytes, res.MaxBytes)
+	fmt.Fprintf(&b, "Hits: %d\n", res.Hits)
+	fmt.Fprintf(&b, "Misses: %d\n", res.Misses)
+	fmt.Fprintf(&b, "Hit rate: %.2f%%\n", res.HitRate*100)
+	fmt.Fprintf(&b, "Mismatches: %d\n", res.Mismatches)
+	_, err = cctx.App.Writer.Write(b.Bytes())
+	return err
+}
+
+var cacheWarmUpSubCmd = &cli.Command{
+	Name:  "warmup",
+	Usage: "Starts generating the entries of published advertisements into the entries cache",
+	Description: `Starts generating the entries of the most recent advertisements in the background and storing
+them in the entries cache, so that indexers syncing advertisements are not held up by on-demand
+generation of entries, e.g. after the daemon is restarted with a purged cache.
+
+Advertisements are selected backwards from the latest advertisement until either --ads number of
+advertisements are selected, or the advertisement with --since CID is reached. If neither is
+specified, all advertisements are warmed up. Removed context IDs are skipped.
+
+The progress of warm-up is shown by the warmup-status command.`,
+	Flags:  cacheWarmUpFlags,
+	Action: cacheWarmUpCommand,
+}
+
+var cacheWarmUpStatusSubCmd = &cli.Command{
+	Name:  "warmup-status",
+	Usage: "Shows the progress of warming up the advertisement entries cache",
+	Flags: []cli.Flag{
+		adminAPIFlag,
+	},
+	Action: cacheWarmUpStatusCommand,
+}
+
+func cacheWarmUpCommand(cctx *cli.Context) error {
+	req := &adminserver.WarmUpCacheReq{
+		Ads:         cctx.Int("ads"),
+		Concurrency: cctx.Int("concurrency"),
+	}
+	if cctx.IsSet("since") {
+		var err error
+		req.Since, err = cid.Decode(cctx.String("since"))
+		if err != nil {
+			return fmt.Errorf("invalid advertisement CID: %w", err)
+		}
+	}
+	resp, err := doHttpPostReq(cctx.Context, adminAPIFlagValue+"/admin/cache/warmup", req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errFromHttpResp(resp)
+	}
+
+	var res adminserver.WarmUpCacheRes
+	if _, err := res.ReadFrom(resp.Body); err != nil {
+		return fmt.Errorf("received ok response from server but cannot decode response body. %v", err)
+	}
+	_, err = fmt.Fprintf(cctx.App.Writer, "Started warming up entries cache at %s\n", res.StartedAt.Format(time.RFC3339))
+	return err
+}
+
+func cacheWarmUpStatusCommand(cctx *cli.Context) error {
+	req, err := http.NewRequestWithContext(cctx.Context, http.MethodGet, adminAPIFlagValue+"/admin/cache/warmup", nil)
+	if err != nil {
+		return err
+	}
+	cl := &http.Client{}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errFromHttpResp(resp)
+	}
+
+	var res adminserver.WarmUpCacheRes
+	if _, err := res.ReadFrom(resp.Body); err != nil {
+		return fmt.Errorf("received ok response from server but cannot decode response body. %v", err)
+	}
+
+	var b bytes.Buffer
+	switch {
+	case res.StartedAt.IsZero():
+		b.WriteString("Entries cache has not been warmed up\n")
+	case res.Running:
+		fmt.Fprintf(&b, "Warming up since %s\n", res.StartedAt.Format(time.RFC3339))
+	case res.Error != "":
+		fmt.Fprintf(&b, "Stopped at %s: %s\n", res.FinishedAt.Format(time.RFC3339), res.Error)
+	default:
+		fmt.Fprintf(&b, "Finished at %s in %s\n", res.FinishedAt.Format(time.RFC3339), res.FinishedAt.Sub(res.StartedAt))
+	}
+	if !res.StartedAt.IsZero() {
+		fmt.Fprintf(&b, "Advertisements: %d of %d done\n", res.Generated+res.Cached+res.Failed, res.Total)
+		fmt.Fprintf(&b, "Generated: %d\n", res.Generated)
+		fmt.Fprintf(&b, "Already cached: %d\n", res.Cached)
+		fmt.Fprintf(&b, "Failed: %d\n", res.Failed)
+	}
+	_, err = cctx.App.Writer.Write(b.Bytes())
+	return err
+}
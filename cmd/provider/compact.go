@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	adminserver "github.com/filecoin-project/index-provider/server/admin/http"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+)
+
+var CompactCmd = &cli.Command{
+	Name:  "compact",
+	Usage: "Compacts the advertisement chain to contain only the currently advertised context IDs",
+	Description: `Publishes a fresh advertisement chain that contains a single advertisement per context ID
+that is currently advertised, and omits the context IDs that were removed.
+
+The previous advertisements are garbage collected by the daemon once the indexers configured in
+Compaction.IndexerURLs have synced past them.
+
+Use the dry-run option to report the outcome of compaction without publishing anything.`,
+	Flags:  compactFlags,
+	Action: compactCommand,
+}
+
+func compactCommand(cctx *cli.Context) error {
+	req := adminserver.CompactReq{
+		DryRun: cctx.Bool("dry-run"),
+	}
+	resp, err := doHttpPostReq(cctx.Context, adminAPIFlagValue+"/admin/compact", req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errFromHttpResp(resp)
+	}
+
+	var res adminserver.CompactRes
+	if _, err := res.ReadFrom(resp.Body); err != nil {
+		return fmt.Errorf("received ok response from server but cannot decode response body. %v", err)
+	}
+
+	var b bytes.Buffer
+	switch {
+	case res.DryRun:
+		b.WriteString("Dry-run; advertisement chain is not compacted.\n")
+	case res.AdvId == cid.Undef:
+		b.WriteString("Advertisement chain is already compact.\n")
+	default:
+		b.WriteString("Successfully compacted advertisement chain.\n")
+	}
+	fmt.Fprintf(&b, "\t Chain length: %d\n", res.ChainLength)
+	fmt.Fprintf(&b, "\t Live context IDs: %d\n", res.LiveContextIDs)
+	fmt.Fprintf(&b, "\t Previous advertisement ID: %s\n", res.PreviousAdvId)
+	if res.AdvId != cid.Undef {
+		fmt.Fprintf(&b, "\t Advertisement ID: %s\n", res.AdvId)
+	}
+	_, err = cctx.App.Writer.Write(b.Bytes())
+	return err
+}
@@ -15,7 +15,11 @@ import (
 	"github.com/filecoin-project/index-provider/engine"
 	"github.com/filecoin-project/index-provider/engine/policy"
 	adminserver "github.com/filecoin-project/index-provider/server/admin/http"
+	"github.com/filecoin-project/index-provider/signer"
 	"github.com/filecoin-project/index-provider/supplier"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
 	leveldb "github.com/ipfs/go-ds-leveldb"
 	gsimpl "github.com/ipfs/go-graphsync/impl"
 	gsnet "github.com/ipfs/go-graphsync/network"
@@ -66,7 +70,7 @@ func daemonCommand(cctx *cli.Context) error {
 	ctx, cancelp2p := context.WithCancel(cctx.Context)
 	defer cancelp2p()
 
-	peerID, privKey, err := cfg.Identity.Decode()
+	peerID, privKey, err := decodeIdentity(cctx, cfg)
 	if err != nil {
 		return err
 	}
@@ -76,6 +80,11 @@ func daemonCommand(cctx *cli.Context) error {
 		return err
 	}
 
+	entriesFormatOpt, err := entriesFormatOption(cfg.Ingest)
+	if err != nil {
+		return err
+	}
+
 	p2pmaddr, err := multiaddr.NewMultiaddr(cfg.ProviderServer.ListenMultiaddr)
 	if err != nil {
 		return fmt.Errorf("bad p2p address in config %s: %s", cfg.ProviderServer.ListenMultiaddr, err)
@@ -92,18 +101,11 @@ func daemonCommand(cctx *cli.Context) error {
 	log.Infow("libp2p host initialized", "host_id", h.ID(), "multiaddr", p2pmaddr)
 
 	// Initialize datastore
-	if cfg.Datastore.Type != "levelds" {
-		return fmt.Errorf("only levelds datastore type supported, %q not supported", cfg.Datastore.Type)
-	}
-	dataStorePath, err := config.Path("", cfg.Datastore.Dir)
+	ds, err := openDatastore(cfg)
 	if err != nil {
 		return err
 	}
-	err = checkWritable(dataStorePath)
-	if err != nil {
-		return err
-	}
-	ds, err := leveldb.NewDatastore(dataStorePath, nil)
+	cacheDs, err := openCacheDatastore(cfg)
 	if err != nil {
 		return err
 	}
@@ -121,17 +123,37 @@ func daemonCommand(cctx *cli.Context) error {
 		return err
 	}
 
-	// Starting provider core
-	eng, err := engine.New(
+	engOpts := []engine.Option{
 		engine.WithDatastore(ds),
 		engine.WithDataTransfer(dt),
 		engine.WithDirectAnnounce(cfg.DirectAnnounce.URLs...),
+		engine.WithGCIndexers(cfg.Compaction.IndexerURLs...),
+		engine.WithGCInterval(time.Duration(cfg.Compaction.GCInterval)),
 		engine.WithHost(h),
-		engine.WithEntriesCacheCapacity(cfg.Ingest.LinkCacheSize),
-		engine.WithChainedEntries(cfg.Ingest.LinkedChunkSize),
+		entriesFormatOpt,
+		engine.WithMultihashIndex(cfg.Ingest.IndexMultihashes),
+		engine.WithRemoveCorruptedEntries(cfg.Ingest.RemoveCorruptedEntries),
 		engine.WithTopicName(cfg.Ingest.PubSubTopic),
 		engine.WithPublisherKind(engine.PublisherKind(cfg.Ingest.PublisherKind)),
-		engine.WithSyncPolicy(syncPolicy))
+		engine.WithSyncPolicy(syncPolicy),
+	}
+	engOpts = append(engOpts, entriesCacheOptions(cfg.Ingest)...)
+	if cacheDs != nil {
+		log.Infow("Storing entries cache in separate datastore", "type", cfg.CacheDatastore.Type)
+		engOpts = append(engOpts, engine.WithEntriesCacheDatastore(cacheDs))
+	}
+	if cfg.Identity.SignerSocket != "" {
+		s, err := signer.NewSocketSigner(ctx, cfg.Identity.SignerSocket)
+		if err != nil {
+			return fmt.Errorf("cannot connect to signer: %w", err)
+		}
+		log.Infow("Signing advertisements via signer", "socket", cfg.Identity.SignerSocket)
+		log.Warn("Identity private key is still held in memory as the libp2p host identity while signing via signer")
+		engOpts = append(engOpts, engine.WithSigner(s))
+	}
+
+	// Starting provider core
+	eng, err := engine.New(engOpts...)
 	if err != nil {
 		return err
 	}
@@ -144,6 +166,13 @@ func daemonCommand(cctx *cli.Context) error {
 	// Instantiate CAR supplier and register it as the multihash lister onto the engine.
 	cs := supplier.NewCarSupplier(eng, ds, car.ZeroLengthSectionAsEOF(carZeroLengthAsEOFFlagValue))
 
+	// Warm up the entries cache in the background now that the multihash lister is registered.
+	if warmUp := cfg.Ingest.LinkCacheWarmUp; warmUp.Ads > 0 {
+		if err := eng.WarmUpEntriesCache(warmUp.Ads, cid.Undef, warmUp.Concurrency); err != nil {
+			return fmt.Errorf("cannot warm up entries cache: %w", err)
+		}
+	}
+
 	// Start serving CAR files for retrieval requests
 	err = cardatatransfer.StartCarDataTransfer(dt, cs)
 	if err != nil {
@@ -225,6 +254,12 @@ func daemonCommand(cctx *cli.Context) error {
 		log.Errorf("Error closing provider datastore: %s", err)
 		finalErr = ErrDaemonStop
 	}
+	if cacheDs != nil {
+		if err = cacheDs.Close(); err != nil {
+			log.Errorf("Error closing entries cache datastore: %s", err)
+			finalErr = ErrDaemonStop
+		}
+	}
 
 	// cancel libp2p server
 	cancelp2p()
@@ -236,3 +271,85 @@ func daemonCommand(cctx *cli.Context) error {
 	log.Infow("node stopped")
 	return finalErr
 }
+
+// openDatastore opens the datastore configured in the given config.
+func openDatastore(cfg *config.Config) (*leveldb.Datastore, error) {
+	if cfg.Datastore.Type != "levelds" {
+		return nil, fmt.Errorf("only levelds datastore type supported, %q not supported", cfg.Datastore.Type)
+	}
+	dataStorePath, err := config.Path("", cfg.Datastore.Dir)
+	if err != nil {
+		return nil, err
+	}
+	err = checkWritable(dataStorePath)
+	if err != nil {
+		return nil, err
+	}
+	return leveldb.NewDatastore(dataStorePath, nil)
+}
+
+// openCacheDatastore opens the entries cache datastore configured in the given config, or returns
+// nil if the cache is to be stored in the main datastore.
+func openCacheDatastore(cfg *config.Config) (datastore.Batching, error) {
+	switch cfg.CacheDatastore.Type {
+	case "":
+		return nil, nil
+	case config.CacheDatastoreTypeMemory:
+		return dssync.MutexWrap(datastore.NewMapDatastore()), nil
+	case config.CacheDatastoreTypeLevelDS:
+		cacheStorePath, err := config.Path("", cfg.CacheDatastore.Dir)
+		if err != nil {
+			return nil, err
+		}
+		if err = checkWritable(cacheStorePath); err != nil {
+			return nil, err
+		}
+		return leveldb.NewDatastore(cacheStorePath, nil)
+	default:
+		return nil, fmt.Errorf("cache datastore type %q not supported", cfg.CacheDatastore.Type)
+	}
+}
+
+// entriesFormatOption returns the engine option that sets the advertisement entries format as
+// configured in the given ingest config, or an error if the configured format is not valid.
+func entriesFormatOption(cfg config.Ingest) (engine.Option, error) {
+	if err := cfg.ValidateEntriesFormat(); err != nil {
+		return nil, err
+	}
+	if cfg.EntriesFormat.Type == config.EntriesFormatTypeHamt {
+		hamtCfg := cfg.EntriesFormat.Hamt
+		hashAlg, err := hamtCfg.HashAlgCode()
+		if err != nil {
+			return nil, err
+		}
+		return engine.WithHamtEntries(hashAlg, hamtCfg.BitWidth, hamtCfg.BucketSize), nil
+	}
+	return chainedEntriesOption(cfg), nil
+}
+
+// chainedEntriesOption returns the engine option that sets the advertisement entries format to
+// chained entry chunks as configured in the given ingest config.
+func chainedEntriesOption(cfg config.Ingest) engine.Option {
+	switch {
+	case cfg.SortedEntries && cfg.LinkedChunkWorkers > 1:
+		return engine.WithSortedParallelChainedEntries(cfg.LinkedChunkSize, cfg.LinkedChunkWorkers)
+	case cfg.SortedEntries:
+		return engine.WithSortedChainedEntries(cfg.LinkedChunkSize)
+	case cfg.LinkedChunkWorkers > 1:
+		return engine.WithParallelChainedEntries(cfg.LinkedChunkSize, cfg.LinkedChunkWorkers)
+	default:
+		return engine.WithChainedEntries(cfg.LinkedChunkSize)
+	}
+}
+
+// entriesCacheOptions returns the engine options that limit the advertisement entries cache as
+// configured in the given ingest config.
+func entriesCacheOptions(cfg config.Ingest) []engine.Option {
+	if cfg.LinkCacheMaxBytes > 0 {
+		return []engine.Option{
+			engine.WithEntriesCacheCapacity(0),
+			engine.WithEntriesCacheMaxBytes(cfg.LinkCacheMaxBytes),
+		}
+	}
+	return []engine.Option{engine.WithEntriesCacheCapacity(cfg.LinkCacheSize)}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/index-provider/cmd/provider/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_entriesFormatOption_ValidatesConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(*config.Ingest)
+		wantErr bool
+	}{
+		{
+			name:   "default",
+			mutate: func(*config.Ingest) {},
+		},
+		{
+			name: "hamt",
+			mutate: func(c *config.Ingest) {
+				c.EntriesFormat.Type = config.EntriesFormatTypeHamt
+			},
+		},
+		{
+			name: "hamt with sha2-256",
+			mutate: func(c *config.Ingest) {
+				c.EntriesFormat.Type = config.EntriesFormatTypeHamt
+				c.EntriesFormat.Hamt.HashAlg = "sha2-256"
+			},
+		},
+		{
+			name: "unknown type",
+			mutate: func(c *config.Ingest) {
+				c.EntriesFormat.Type = "fish"
+			},
+			wantErr: true,
+		},
+		{
+			name: "zero chunk size",
+			mutate: func(c *config.Ingest) {
+				c.LinkedChunkSize = 0
+			},
+			wantErr: true,
+		},
+		{
+			name: "hamt with sorted entries",
+			mutate: func(c *config.Ingest) {
+				c.EntriesFormat.Type = config.EntriesFormatTypeHamt
+				c.SortedEntries = true
+			},
+			wantErr: true,
+		},
+		{
+			name: "hamt with unknown hash algorithm",
+			mutate: func(c *config.Ingest) {
+				c.EntriesFormat.Type = config.EntriesFormatTypeHamt
+				c.EntriesFormat.Hamt.HashAlg = "fish"
+			},
+			wantErr: true,
+		},
+		{
+			name: "hamt with unsupported hash algorithm",
+			mutate: func(c *config.Ingest) {
+				c.EntriesFormat.Type = config.EntriesFormatTypeHamt
+				c.EntriesFormat.Hamt.HashAlg = "sha2-512"
+			},
+			wantErr: true,
+		},
+		{
+			name: "hamt with small bit-width",
+			mutate: func(c *config.Ingest) {
+				c.EntriesFormat.Type = config.EntriesFormatTypeHamt
+				c.EntriesFormat.Hamt.BitWidth = 2
+			},
+			wantErr: true,
+		},
+		{
+			name: "hamt with large bit-width",
+			mutate: func(c *config.Ingest) {
+				c.EntriesFormat.Type = config.EntriesFormatTypeHamt
+				c.EntriesFormat.Hamt.BitWidth = 17
+			},
+			wantErr: true,
+		},
+		{
+			name: "hamt with negative bucket size",
+			mutate: func(c *config.Ingest) {
+				c.EntriesFormat.Type = config.EntriesFormatTypeHamt
+				c.EntriesFormat.Hamt.BucketSize = -1
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.NewIngest()
+			tt.mutate(&cfg)
+			opt, err := entriesFormatOption(cfg)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, opt)
+		})
+	}
+}
@@ -22,9 +22,103 @@ var daemonFlags = []cli.Flag{
 		Value:    "info",
 		Required: false,
 	},
+	passphraseFileFlag,
 }
 
-var initFlags = []cli.Flag{}
+var initFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "encrypt",
+		Usage: "Encrypt the private key of generated identity with a passphrase, read from " + envPassphrase + " environment variable, passphrase file, or prompted for.",
+	},
+	passphraseFileFlag,
+}
+
+var passphraseFileFlag = &cli.StringFlag{
+	Name:  "passphrase-file",
+	Usage: "Path to file containing the passphrase of encrypted identity. Overridden by " + envPassphrase + " environment variable.",
+}
+
+var rotateFlags = []cli.Flag{
+	passphraseFileFlag,
+}
+
+var rotatePassphraseFlags = []cli.Flag{
+	passphraseFileFlag,
+	&cli.StringFlag{
+		Name:  "new-passphrase-file",
+		Usage: "Path to file containing the new passphrase. Overridden by " + envNewPassphrase + " environment variable.",
+	},
+	&cli.BoolFlag{
+		Name:  "decrypt",
+		Usage: "Store the private key unencrypted instead of re-encrypting it with a new passphrase.",
+	},
+}
+
+var updateMetadataFlags = []cli.Flag{
+	adminAPIFlag,
+	&cli.StringFlag{
+		Name:    "add",
+		Usage:   "Base64 encoded metadata bytes, the protocols of which are added to the metadata of each context ID.",
+		Aliases: []string{"a"},
+	},
+	&cli.BoolFlag{
+		Name:  "add-bitswap",
+		Usage: "Add Bitswap protocol to the metadata of each context ID.",
+	},
+	&cli.BoolFlag{
+		Name:  "add-http-gateway",
+		Usage: "Add trustless IPFS gateway HTTP protocol to the metadata of each context ID.",
+	},
+	&cli.StringSliceFlag{
+		Name:    "remove",
+		Usage:   "Multicodec name or code of protocol to remove from the metadata of each context ID, multiple OK",
+		Aliases: []string{"r"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "key",
+		Usage:   "Base64 encoded context ID to update, multiple OK. If not specified, all advertised context IDs are updated.",
+		Aliases: []string{"k"},
+	},
+}
+
+var compactFlags = []cli.Flag{
+	adminAPIFlag,
+	&cli.BoolFlag{
+		Name:    "dry-run",
+		Usage:   "Report the outcome of compaction without making any changes",
+		Aliases: []string{"n"},
+	},
+}
+
+var setAddrsFlags = []cli.Flag{
+	adminAPIFlag,
+	&cli.StringSliceFlag{
+		Name:    "addr",
+		Usage:   "Retrieval multiaddr of the provider. Multiple OK.",
+		Aliases: []string{"a"},
+	},
+	&cli.BoolFlag{
+		Name:  "reset",
+		Usage: "Reset the retrieval addresses to the ones the provider was started with.",
+	},
+}
+
+var cacheWarmUpFlags = []cli.Flag{
+	adminAPIFlag,
+	&cli.IntFlag{
+		Name:  "ads",
+		Usage: "Maximum number of most recent advertisements to warm up. Zero means unlimited.",
+	},
+	&cli.StringFlag{
+		Name:  "since",
+		Usage: "CID of the advertisement after which to warm up advertisements, excluding it.",
+	},
+	&cli.IntFlag{
+		Name:  "concurrency",
+		Usage: "Number of advertisements to warm up at a time.",
+		Value: 1,
+	},
+}
 
 var connectFlags = []cli.Flag{
 	&cli.StringFlag{
@@ -83,17 +177,20 @@ var indexFlags = []cli.Flag{
 		Required: true,
 	},
 	metadataFlag,
+	passphraseFileFlag,
 }
 
 var registerFlags = []cli.Flag{
 	indexerFlag,
 	addrFlag,
+	passphraseFileFlag,
 }
 
 var importCarFlags = []cli.Flag{
 	adminAPIFlag,
 	carPathFlag,
 	metadataFlag,
+	httpGatewayFlag,
 	keyFlag,
 }
 
@@ -107,13 +204,23 @@ var (
 	metadataFlagValue string
 	metadataFlag      = &cli.StringFlag{
 		Name:        "metadata",
-		Usage:       "Base64 encoded metadata bytes.",
+		Usage:       "Metadata as a JSON object, such as '{\"bitswap\":{}}', or as base64 encoded bytes.",
 		Aliases:     []string{"m"},
 		Required:    false,
 		Destination: &metadataFlagValue,
 	}
 )
 
+var (
+	httpGatewayFlagValue bool
+	httpGatewayFlag      = &cli.BoolFlag{
+		Name:        "http-gateway",
+		Usage:       "Whether to advertise retrieval over HTTP from a trustless IPFS gateway in addition to the protocols in metadata.",
+		Aliases:     []string{"hg"},
+		Destination: &httpGatewayFlagValue,
+	}
+)
+
 var (
 	keyFlagValue string
 	keyFlag      = &cli.StringFlag{
@@ -174,7 +281,7 @@ var (
 	adEntriesRecurLimitFlag      = &cli.Int64Flag{
 		Name:        "ad-entries-recursion-limit",
 		Aliases:     []string{"aerl"},
-		Usage:       "The maximum recursion depth when fetching advertisement entries chain.",
+		Usage:       "The maximum recursion depth when fetching advertisement entries chain. Entries represented as a HAMT are always fetched in full.",
 		Value:       100,
 		DefaultText: "100 (set to '0' for unlimited)",
 		Destination: &adEntriesRecurLimitFlagValue,
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/filecoin-project/index-provider/cmd/provider/internal/config"
+	"github.com/filecoin-project/index-provider/engine"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/urfave/cli/v2"
+)
+
+var IdentityCmd = &cli.Command{
+	Name:        "identity",
+	Usage:       "Manages the identity of the provider",
+	Subcommands: []*cli.Command{rotateSubCmd, rotatePassphraseSubCmd},
+}
+
+var rotateSubCmd = &cli.Command{
+	Name:  "rotate",
+	Usage: "Generates a new provider identity and re-advertises all content under it",
+	Description: `Generates a new identity for the provider, and re-advertises all currently advertised context IDs
+under the new provider ID by appending to the existing advertisement chain: for each context ID a
+removal advertisement is published under the current identity, followed by an advertisement
+of the same content under the new identity. The identity in config is then replaced by the new one.
+
+The daemon must not be running during rotation. Once rotated, start the daemon to announce the
+advertisements to indexers. If the current private key is encrypted, the new key is encrypted with
+the same passphrase.`,
+	Flags:  rotateFlags,
+	Action: rotateCommand,
+}
+
+var rotatePassphraseSubCmd = &cli.Command{
+	Name:  "rotate-passphrase",
+	Usage: "Re-encrypts the private key of the provider identity with a new passphrase",
+	Description: `Decrypts the private key using the current passphrase, if encrypted, and encrypts it with a
+new passphrase. Plaintext keys are encrypted for the first time.
+
+The current passphrase is read from ` + envPassphrase + ` environment variable, the passphrase file,
+or prompted for. Similarly, the new passphrase is read from ` + envNewPassphrase + ` environment
+variable, the new passphrase file, or prompted for.
+
+Use the decrypt option to store the private key unencrypted instead.`,
+	Flags:  rotatePassphraseFlags,
+	Action: rotatePassphraseCommand,
+}
+
+func rotatePassphraseCommand(cctx *cli.Context) error {
+	cfg, err := config.Load("")
+	if err != nil {
+		return err
+	}
+
+	var passphrase string
+	if cfg.Identity.IsEncrypted() {
+		passphrase, err = readPassphrase(cctx, envPassphrase, passphraseFileFlag.Name, "Enter current passphrase: ")
+		if err != nil {
+			return err
+		}
+	}
+
+	var newPassphrase string
+	if !cctx.Bool("decrypt") {
+		newPassphrase, err = readNewPassphrase(cctx, envNewPassphrase, "new-passphrase-file")
+		if err != nil {
+			return err
+		}
+		if newPassphrase == "" {
+			return errors.New("new passphrase must not be empty; use decrypt option to store the key unencrypted")
+		}
+	}
+
+	if err = encryptIdentity(&cfg.Identity, passphrase, newPassphrase); err != nil {
+		return err
+	}
+	if err = cfg.Save(""); err != nil {
+		return err
+	}
+
+	if newPassphrase == "" {
+		fmt.Fprintln(cctx.App.Writer, "Private key is now stored unencrypted.")
+	} else {
+		fmt.Fprintln(cctx.App.Writer, "Private key re-encrypted with new passphrase.")
+	}
+	return nil
+}
+
+func rotateCommand(cctx *cli.Context) error {
+	configFile, err := config.Filename("")
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return err
+	}
+	if cfg.Identity.SignerSocket != "" {
+		return errors.New("identity rotation is not supported when signing via signer socket")
+	}
+
+	passphrase, err := identityPassphrase(cctx, cfg)
+	if err != nil {
+		return err
+	}
+	_, privKey, err := cfg.Identity.Decode(passphrase)
+	if err != nil {
+		return err
+	}
+
+	entriesFormatOpt, err := entriesFormatOption(cfg.Ingest)
+	if err != nil {
+		return err
+	}
+
+	// Open datastore first, which fails if the daemon is running.
+	ds, err := openDatastore(cfg)
+	if err != nil {
+		return fmt.Errorf("cannot open datastore; make sure daemon is not running: %w", err)
+	}
+	defer ds.Close()
+	cacheDs, err := openCacheDatastore(cfg)
+	if err != nil {
+		return fmt.Errorf("cannot open cache datastore; make sure daemon is not running: %w", err)
+	}
+	if cacheDs != nil {
+		defer cacheDs.Close()
+	}
+
+	p2pmaddr, err := multiaddr.NewMultiaddr(cfg.ProviderServer.ListenMultiaddr)
+	if err != nil {
+		return fmt.Errorf("bad p2p address in config %s: %s", cfg.ProviderServer.ListenMultiaddr, err)
+	}
+	// Listen on the same address as the daemon, so that retrieval addresses match.
+	h, err := libp2p.New(libp2p.Identity(privKey), libp2p.ListenAddrs(p2pmaddr))
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	engOpts := []engine.Option{
+		engine.WithDatastore(ds),
+		engine.WithHost(h),
+		entriesFormatOpt,
+		engine.WithMultihashIndex(cfg.Ingest.IndexMultihashes),
+	}
+	engOpts = append(engOpts, entriesCacheOptions(cfg.Ingest)...)
+	if cacheDs != nil {
+		engOpts = append(engOpts, engine.WithEntriesCacheDatastore(cacheDs))
+	}
+	eng, err := engine.New(engOpts...)
+	if err != nil {
+		return err
+	}
+	if err = eng.Start(cctx.Context); err != nil {
+		return err
+	}
+	defer eng.Shutdown()
+
+	newIdentity, err := config.CreateIdentity(cctx.App.Writer)
+	if err != nil {
+		return err
+	}
+	newKey, err := newIdentity.DecodePrivateKey("")
+	if err != nil {
+		return err
+	}
+	if err = newIdentity.SetPrivateKey(newKey, passphrase); err != nil {
+		return err
+	}
+
+	// Write the config with new identity before publishing any advertisements, so that the new
+	// identity is not lost if replacing the config fails.
+	cfg.Identity = newIdentity
+	pendingFile := configFile + ".rotating"
+	if err = cfg.Save(pendingFile); err != nil {
+		return err
+	}
+
+	report, err := eng.RotateIdentity(cctx.Context, newKey, func(done, total int) {
+		if done%1000 == 0 || done == total {
+			fmt.Fprintf(cctx.App.Writer, "Generated %d of %d advertisements\n", done, total)
+		}
+	})
+	if err != nil {
+		if report != nil && report.Head != cid.Undef {
+			// Advertisements signed by the new key are already published; keep the only copy of
+			// the new key.
+			return fmt.Errorf("advertisements under the new identity were published, but rotation failed; replace %s with %s manually: %w", configFile, pendingFile, err)
+		}
+		_ = os.Remove(pendingFile)
+		return fmt.Errorf("failed to rotate identity: %w", err)
+	}
+	if err = os.Rename(pendingFile, configFile); err != nil {
+		return fmt.Errorf("failed to replace config with new identity; replace %s with %s manually: %w", configFile, pendingFile, err)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("Successfully rotated provider identity.\n")
+	fmt.Fprintf(&b, "\t Previous provider ID: %s\n", report.PreviousProvider)
+	fmt.Fprintf(&b, "\t Provider ID: %s\n", report.Provider)
+	fmt.Fprintf(&b, "\t Re-advertised context IDs: %d\n", report.LiveContextIDs)
+	if report.Head != cid.Undef {
+		fmt.Fprintf(&b, "\t Advertisement ID: %s\n", report.Head)
+	}
+	_, err = cctx.App.Writer.Write(b.Bytes())
+	return err
+}
+
+// encryptIdentity decrypts the private key of the given identity using the given passphrase, and
+// encrypts it with the new passphrase. The key is stored unencrypted if the new passphrase is
+// empty.
+func encryptIdentity(ident *config.Identity, passphrase, newPassphrase string) error {
+	key, err := ident.DecodePrivateKey(passphrase)
+	if err != nil {
+		return fmt.Errorf("could not decode private key: %w", err)
+	}
+	return ident.SetPrivateKey(key, newPassphrase)
+}
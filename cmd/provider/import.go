@@ -47,12 +47,8 @@ func beforeImportCar(cctx *cli.Context) error {
 		importCarKey = h.Sum(nil)
 	}
 	if cctx.IsSet(metadataFlag.Name) {
-		decoded, err := base64.StdEncoding.DecodeString(metadataFlagValue)
-		if err != nil {
-			return errors.New("metadata is not a valid base64 encoded string")
-		}
-		err = md.UnmarshalBinary(decoded)
-		if err != nil {
+		var err error
+		if md, err = parseMetadata(metadataFlagValue); err != nil {
 			return err
 		}
 	} else {
@@ -65,6 +61,9 @@ func beforeImportCar(cctx *cli.Context) error {
 		}
 		md = metadata.New(tp)
 	}
+	if httpGatewayFlagValue {
+		md = md.With(metadata.IpfsGatewayHttp{})
+	}
 	return nil
 }
 
@@ -1,12 +1,10 @@
 package main
 
 import (
-	"encoding/base64"
 	"errors"
 	"fmt"
 
 	"github.com/filecoin-project/index-provider/cmd/provider/internal/config"
-	"github.com/filecoin-project/index-provider/metadata"
 	httpc "github.com/filecoin-project/storetheindex/api/v0/ingest/client/http"
 	"github.com/ipfs/go-cid"
 	"github.com/multiformats/go-multihash"
@@ -51,7 +49,7 @@ func indexCommand(cctx *cli.Context) error {
 		return err
 	}
 
-	peerID, privKey, err := cfg.Identity.Decode()
+	peerID, privKey, err := decodeIdentity(cctx, cfg)
 	if err != nil {
 		return err
 	}
@@ -61,12 +59,11 @@ func indexCommand(cctx *cli.Context) error {
 		return err
 	}
 
-	decoded, err := base64.StdEncoding.DecodeString(metadataFlagValue)
+	md, err := parseMetadata(metadataFlagValue)
 	if err != nil {
-		return errors.New("metadata is not a valid base64 encoded string")
+		return err
 	}
-	md = metadata.New()
-	err = md.UnmarshalBinary(decoded)
+	decoded, err := md.MarshalBinary()
 	if err != nil {
 		return err
 	}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+
 	"github.com/filecoin-project/index-provider/cmd/provider/internal/config"
 	"github.com/urfave/cli/v2"
 )
@@ -40,7 +42,18 @@ func initCommand(cctx *cli.Context) error {
 	}
 
 	// Use values from flags to override defaults
-	// cfg.Identity = struct{}{}
+	if cctx.Bool("encrypt") {
+		passphrase, err := readNewPassphrase(cctx, envPassphrase, passphraseFileFlag.Name)
+		if err != nil {
+			return err
+		}
+		if passphrase == "" {
+			return errors.New("passphrase must not be empty")
+		}
+		if err = encryptIdentity(&cfg.Identity, "", passphrase); err != nil {
+			return err
+		}
+	}
 
 	return cfg.Save(configFile)
 }
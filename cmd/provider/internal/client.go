@@ -31,7 +31,8 @@ type (
 		store     *ProviderClientStore
 		publisher peer.AddrInfo
 
-		adSel ipld.Node
+		adSel   ipld.Node
+		hamtSel ipld.Node
 	}
 )
 
@@ -58,6 +59,7 @@ func NewProviderClient(provAddr peer.AddrInfo, o ...Option) (ProviderClient, err
 		func(efsb selectorbuilder.ExploreFieldsSpecBuilder) {
 			efsb.Insert("PreviousID", ssb.ExploreRecursiveEdge())
 		})).Node()
+	hamtSel := ssb.ExploreRecursive(selector.RecursionLimitNone(), ssb.ExploreAll(ssb.ExploreRecursiveEdge())).Node()
 
 	return &providerClient{
 		options:   opts,
@@ -65,6 +67,7 @@ func NewProviderClient(provAddr peer.AddrInfo, o ...Option) (ProviderClient, err
 		publisher: provAddr,
 		store:     store,
 		adSel:     adSel,
+		hamtSel:   hamtSel,
 	}, nil
 }
 
@@ -92,6 +95,11 @@ func (p *providerClient) GetAdvertisement(ctx context.Context, id cid.Cid) (*Adv
 	// Only sync its entries recursively if it is not a removal advertisement and has entries.
 	if !ad.IsRemove && ad.HasEntries() {
 		_, err = p.syncEntriesWithRetry(ctx, ad.Entries.root)
+		// The entries chain selector only syncs the root of entries represented as a HAMT, in
+		// which case sync the entire HAMT.
+		if err == nil && ad.Entries.IsHamt() {
+			err = p.syncHamtWithRetry(ctx, ad.Entries.root)
+		}
 	}
 
 	// Return the partially synced advertisement useful for output to client.
@@ -141,6 +149,26 @@ func (p *providerClient) syncEntriesWithRetry(ctx context.Context, id cid.Cid) (
 	}
 }
 
+// syncHamtWithRetry syncs all the nodes of entries represented as a HAMT. The entries recursion
+// limit does not apply to HAMTs, since the depth of HAMT is not proportional to the number of
+// entries synced.
+func (p *providerClient) syncHamtWithRetry(ctx context.Context, id cid.Cid) error {
+	var attempt uint64
+	for {
+		_, err := p.sub.Sync(ctx, p.publisher.ID, id, p.hamtSel, p.publisher.Addrs[0])
+		if err == nil {
+			return nil
+		}
+		if attempt > p.maxSyncRetry {
+			log.Errorw("Reached maximum retry attempt while syncing HAMT entries", "cid", id, "attempt", attempt, "err", err)
+			return err
+		}
+		attempt++
+		log.Infow("Retrying HAMT entries sync", "attempt", attempt, "err", err)
+		time.Sleep(p.syncRetryBackoff)
+	}
+}
+
 func (p *providerClient) findNextMissingChunkLink(ctx context.Context, next cid.Cid) (cid.Cid, int64, bool) {
 	var depth int64
 	for {
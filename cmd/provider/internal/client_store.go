@@ -9,10 +9,12 @@ import (
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	dssync "github.com/ipfs/go-datastore/sync"
+	hamt "github.com/ipld/go-ipld-adl-hamt"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/multicodec"
+	"github.com/ipld/go-ipld-prime/node/bindnode"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/multiformats/go-multihash"
 
@@ -109,6 +111,25 @@ func (s *ProviderClientStore) getEntriesChunk(ctx context.Context, target cid.Ci
 	return next, chunk.Entries, nil
 }
 
+// getHamtRoot loads the entries root with the given CID as a HAMT root node. An error is returned
+// if the entries root is not present or is not a HAMT, e.g. because it is an entries chunk.
+func (s *ProviderClientStore) getHamtRoot(ctx context.Context, target cid.Cid) (*hamt.HashMapRoot, error) {
+	n, err := s.LinkSystem.Load(linking.LinkContext{Ctx: ctx}, cidlink.Link{Cid: target}, hamt.HashMapRootPrototype)
+	if err != nil {
+		return nil, err
+	}
+	return bindnode.Unwrap(n).(*hamt.HashMapRoot), nil
+}
+
+// getHamtNode loads the non-root HAMT node with the given CID.
+func (s *ProviderClientStore) getHamtNode(ctx context.Context, target cid.Cid) (*hamt.HashMapNode, error) {
+	n, err := s.LinkSystem.Load(linking.LinkContext{Ctx: ctx}, cidlink.Link{Cid: target}, hamt.HashMapNodePrototype)
+	if err != nil {
+		return nil, err
+	}
+	return bindnode.Unwrap(n).(*hamt.HashMapNode), nil
+}
+
 func (s *ProviderClientStore) getAdvertisement(ctx context.Context, id cid.Cid) (*Advertisement, error) {
 	val, err := s.Batching.Get(ctx, datastore.NewKey(id.String()))
 	if err != nil {
@@ -0,0 +1,28 @@
+package config
+
+const defaultCacheWarmUpConcurrency = 1
+
+// CacheWarmUp configures the generation of advertisement entries into the link cache when the
+// daemon starts, so that indexers syncing advertisements are not held up by on-demand generation
+// of entries after a restart.
+type CacheWarmUp struct {
+	// Ads is the number of most recent advertisements the entries of which are generated on
+	// daemon startup. Zero disables warm-up on startup.
+	Ads int
+	// Concurrency is the number of advertisements warmed up at a time.
+	Concurrency int
+}
+
+// NewCacheWarmUp returns CacheWarmUp with values set to their defaults.
+func NewCacheWarmUp() CacheWarmUp {
+	return CacheWarmUp{
+		Concurrency: defaultCacheWarmUpConcurrency,
+	}
+}
+
+// PopulateDefaults replaces zero-values in the config with default values.
+func (c *CacheWarmUp) PopulateDefaults() {
+	if c.Concurrency == 0 {
+		c.Concurrency = defaultCacheWarmUpConcurrency
+	}
+}
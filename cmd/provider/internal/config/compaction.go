@@ -0,0 +1,31 @@
+package config
+
+import "time"
+
+const defaultGCInterval = Duration(10 * time.Minute)
+
+// Compaction configures the garbage collection of advertisements made obsolete by compacting the
+// advertisement chain.
+type Compaction struct {
+	// IndexerURLs is the list of indexer find API URLs used to verify that the indexers have
+	// synced past a compacted advertisement chain. Obsolete advertisements are garbage collected
+	// only once all listed indexers have synced past them, and never if the list is empty.
+	IndexerURLs []string
+	// GCInterval is the interval at which garbage collection of obsolete advertisements is
+	// attempted.
+	GCInterval Duration
+}
+
+// NewCompaction returns Compaction with values set to their defaults.
+func NewCompaction() Compaction {
+	return Compaction{
+		GCInterval: defaultGCInterval,
+	}
+}
+
+// PopulateDefaults replaces zero-values in the config with default values.
+func (c *Compaction) PopulateDefaults() {
+	if c.GCInterval == 0 {
+		c.GCInterval = defaultGCInterval
+	}
+}
@@ -13,11 +13,13 @@ import (
 type Config struct {
 	Identity       Identity
 	Datastore      Datastore
+	CacheDatastore CacheDatastore
 	Ingest         Ingest
 	ProviderServer ProviderServer
 	AdminServer    AdminServer
 	Bootstrap      Bootstrap
 	DirectAnnounce DirectAnnounce
+	Compaction     Compaction
 }
 
 const (
@@ -97,10 +99,12 @@ func Load(filePath string) (*Config, error) {
 	cfg := Config{
 		Bootstrap:      NewBootstrap(),
 		Datastore:      NewDatastore(),
+		CacheDatastore: NewCacheDatastore(),
 		Ingest:         NewIngest(),
 		AdminServer:    NewAdminServer(),
 		ProviderServer: NewProviderServer(),
 		DirectAnnounce: NewDirectAnnounce(),
+		Compaction:     NewCompaction(),
 	}
 
 	if err = json.NewDecoder(f).Decode(&cfg); err != nil {
@@ -153,7 +157,9 @@ func (c *Config) String() string {
 
 func (c *Config) PopulateDefaults() {
 	c.AdminServer.PopulateDefaults()
+	c.Compaction.PopulateDefaults()
 	c.Datastore.PopulateDefaults()
+	c.CacheDatastore.PopulateDefaults()
 	c.Ingest.PopulateDefaults()
 	c.ProviderServer.PopulateDefaults()
 }
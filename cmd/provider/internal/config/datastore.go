@@ -30,3 +30,39 @@ func (c *Datastore) PopulateDefaults() {
 		c.Dir = defaultDatastoreDir
 	}
 }
+
+const (
+	defaultCacheDatastoreDir = "cachestore"
+
+	// CacheDatastoreTypeLevelDS stores the entries cache in a separate LevelDB datastore.
+	CacheDatastoreTypeLevelDS = "levelds"
+	// CacheDatastoreTypeMemory stores the entries cache in memory, which means the cache is lost
+	// when the daemon stops.
+	CacheDatastoreTypeMemory = "memory"
+)
+
+// CacheDatastore tracks the configuration of the datastore in which the advertisement entries
+// cache is stored.
+type CacheDatastore struct {
+	// Type is the type of datastore; either "levelds" or "memory". If empty, the cache is stored
+	// in the main datastore. Otherwise, any cache stored in the main datastore is moved to this
+	// datastore when the daemon starts.
+	Type string
+	// Dir is the directory within the config root where the datastore is kept, if its type is
+	// "levelds".
+	Dir string
+}
+
+// NewCacheDatastore instantiates a new CacheDatastore config with default values.
+func NewCacheDatastore() CacheDatastore {
+	return CacheDatastore{
+		Dir: defaultCacheDatastoreDir,
+	}
+}
+
+// PopulateDefaults replaces zero-values in the config with default values.
+func (c *CacheDatastore) PopulateDefaults() {
+	if c.Dir == "" {
+		c.Dir = defaultCacheDatastoreDir
+	}
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/multiformats/go-multicodec"
+)
+
+const (
+	// EntriesFormatTypeChain formats advertisement entries as a chain of entry chunks, configured
+	// by the LinkedChunkSize, LinkedChunkWorkers and SortedEntries settings of Ingest config.
+	EntriesFormatTypeChain = "chain"
+	// EntriesFormatTypeHamt formats advertisement entries as a HAMT, configured by the Hamt
+	// settings of EntriesFormat config.
+	EntriesFormatTypeHamt = "hamt"
+
+	defaultHamtHashAlg    = "murmur3-x64-64"
+	defaultHamtBitWidth   = 8
+	defaultHamtBucketSize = 3
+
+	minHamtBitWidth = 3
+	maxHamtBitWidth = 16
+)
+
+// EntriesFormat configures the format of advertisement entries.
+//
+// Changing the format only applies to content advertised afterwards; the entries of previously
+// advertised content are regenerated in the format they were advertised in. Any entries cached in
+// a different format are cleared when the daemon starts.
+type EntriesFormat struct {
+	// Type is the format of advertisement entries; either "chain" or "hamt".
+	Type string
+	// Hamt configures the HAMT format, used when Type is "hamt".
+	Hamt HamtEntries
+}
+
+// HamtEntries configures the format of advertisement entries represented as a HAMT.
+type HamtEntries struct {
+	// HashAlg is the multicodec name of the hash algorithm used to hash multihashes into the HAMT;
+	// either "identity", "sha2-256" or "murmur3-x64-64".
+	HashAlg string
+	// BitWidth is the number of bits of hash used at each level of the HAMT; at least 3 and at
+	// most 16.
+	BitWidth int
+	// BucketSize is the maximum number of multihashes stored in a bucket before the bucket is
+	// split into a child node; at least 1.
+	BucketSize int
+}
+
+// NewEntriesFormat instantiates a new EntriesFormat config with default values.
+func NewEntriesFormat() EntriesFormat {
+	return EntriesFormat{
+		Type: EntriesFormatTypeChain,
+		Hamt: HamtEntries{
+			HashAlg:    defaultHamtHashAlg,
+			BitWidth:   defaultHamtBitWidth,
+			BucketSize: defaultHamtBucketSize,
+		},
+	}
+}
+
+// HashAlgCode returns the multicodec code of the configured hash algorithm, or an error if the
+// algorithm is unknown or not supported.
+func (c HamtEntries) HashAlgCode() (multicodec.Code, error) {
+	var hashAlg multicodec.Code
+	if err := hashAlg.Set(c.HashAlg); err != nil {
+		return 0, fmt.Errorf("invalid HAMT hash algorithm: %w", err)
+	}
+	switch hashAlg {
+	case multicodec.Identity, multicodec.Sha2_256, multicodec.Murmur3X64_64:
+		return hashAlg, nil
+	default:
+		return 0, fmt.Errorf("HAMT hash algorithm must be one of %s, %s or %s; got: %s",
+			multicodec.Identity, multicodec.Sha2_256, multicodec.Murmur3X64_64, hashAlg)
+	}
+}
+
+// Validate checks that the HAMT config is valid.
+func (c HamtEntries) Validate() error {
+	if _, err := c.HashAlgCode(); err != nil {
+		return err
+	}
+	if c.BitWidth < minHamtBitWidth || c.BitWidth > maxHamtBitWidth {
+		return fmt.Errorf("HAMT bit-width must be at least %d and at most %d; got: %d", minHamtBitWidth, maxHamtBitWidth, c.BitWidth)
+	}
+	if c.BucketSize < 1 {
+		return fmt.Errorf("HAMT bucket size must be at least 1; got: %d", c.BucketSize)
+	}
+	return nil
+}
+
+// ValidateEntriesFormat checks that the configured advertisement entries format is valid, along
+// with the settings of the ingest config it depends on.
+func (c Ingest) ValidateEntriesFormat() error {
+	switch c.EntriesFormat.Type {
+	case EntriesFormatTypeChain:
+		if c.LinkedChunkSize < 1 {
+			return fmt.Errorf("linked chunk size must be at least 1; got: %d", c.LinkedChunkSize)
+		}
+		return nil
+	case EntriesFormatTypeHamt:
+		if c.SortedEntries {
+			return errors.New("sorted entries are only supported with chain entries format")
+		}
+		return c.EntriesFormat.Hamt.Validate()
+	default:
+		return fmt.Errorf("entries format type %q not supported", c.EntriesFormat.Type)
+	}
+}
+
+// PopulateDefaults replaces zero-values in the config with default values.
+func (c *EntriesFormat) PopulateDefaults() {
+	if c.Type == "" {
+		c.Type = EntriesFormatTypeChain
+	}
+	if c.Hamt.HashAlg == "" {
+		c.Hamt.HashAlg = defaultHamtHashAlg
+	}
+	if c.Hamt.BitWidth == 0 {
+		c.Hamt.BitWidth = defaultHamtBitWidth
+	}
+	if c.Hamt.BucketSize == 0 {
+		c.Hamt.BucketSize = defaultHamtBucketSize
+	}
+}
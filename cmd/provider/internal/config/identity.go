@@ -1,41 +1,171 @@
 package config
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 
 	ic "github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// Default scrypt parameters used to derive the key that encrypts the private key, as
+	// recommended for interactive logins.
+	defaultScryptN = 1 << 15
+	defaultScryptR = 8
+	defaultScryptP = 1
+
+	scryptKeyLen  = 32
+	scryptSaltLen = 32
+)
+
+var (
+	// ErrPassphraseRequired signals that the private key is encrypted and cannot be decoded without
+	// a passphrase.
+	ErrPassphraseRequired = errors.New("private key is encrypted; passphrase is required")
+	// ErrWrongPassphrase signals that the private key cannot be decrypted using the given
+	// passphrase.
+	ErrWrongPassphrase = errors.New("cannot decrypt private key; wrong passphrase")
 )
 
 // Identity tracks the configuration of the local node's identity.
 type Identity struct {
-	PeerID  string
+	PeerID string
+	// PrivKey is the base64 encoded private key, if the key is stored unencrypted.
 	PrivKey string `json:",omitempty"`
+	// EncryptedPrivKey is the private key encrypted with a passphrase, if the key is stored
+	// encrypted.
+	EncryptedPrivKey *EncryptedKey `json:",omitempty"`
+	// SignerSocket is the optional path to the Unix socket of a signing daemon. When set,
+	// advertisements are signed by the daemon instead of PrivKey.
+	//
+	// Note that the private key is still decoded and kept in memory as the identity of the libp2p
+	// host, i.e. it must remain configured and is not kept out of the provider daemon.
+	SignerSocket string `json:",omitempty"`
 }
 
-func (i Identity) Decode() (peer.ID, ic.PrivKey, error) {
+// EncryptedKey represents a private key encrypted with AES-256-GCM, using a key derived from a
+// passphrase with scrypt. Binary values are base64 encoded when marshalled as JSON.
+type EncryptedKey struct {
+	// The scrypt CPU/memory cost parameter.
+	ScryptN int
+	// The scrypt block size parameter.
+	ScryptR int
+	// The scrypt parallelization parameter.
+	ScryptP int
+	// The random salt used to derive the encryption key.
+	Salt []byte
+	// The random nonce used to encrypt the private key.
+	Nonce []byte
+	// The encrypted private key, authenticated along with the peer ID.
+	Ciphertext []byte
+}
+
+func (i Identity) Decode(passphrase string) (peer.ID, ic.PrivKey, error) {
 	peerID, err := peer.Decode(i.PeerID)
 	if err != nil {
 		return "", nil, fmt.Errorf("could not decode peer id: %s", err)
 	}
 
-	privKey, err := i.DecodePrivateKey("")
+	privKey, err := i.DecodePrivateKey(passphrase)
 	if err != nil {
-		return "", nil, fmt.Errorf("could not decode private key: %s", err)
+		return "", nil, fmt.Errorf("could not decode private key: %w", err)
 	}
 
 	return peerID, privKey, nil
 }
 
-// DecodePrivateKey is a helper to decode the user's PrivateKey.
+// IsEncrypted returns whether the private key is stored encrypted.
+func (i Identity) IsEncrypted() bool {
+	return i.EncryptedPrivKey != nil
+}
+
+// DecodePrivateKey is a helper to decode the user's PrivateKey. The passphrase is only used if
+// the private key is stored encrypted, in which case ErrPassphraseRequired is returned if it is
+// empty.
 func (i Identity) DecodePrivateKey(passphrase string) (ic.PrivKey, error) {
-	pkb, err := base64.StdEncoding.DecodeString(i.PrivKey)
+	if !i.IsEncrypted() {
+		pkb, err := base64.StdEncoding.DecodeString(i.PrivKey)
+		if err != nil {
+			return nil, err
+		}
+		return ic.UnmarshalPrivateKey(pkb)
+	}
+
+	if passphrase == "" {
+		return nil, ErrPassphraseRequired
+	}
+	ek := i.EncryptedPrivKey
+	aead, err := newKeyCipher(passphrase, ek.Salt, ek.ScryptN, ek.ScryptR, ek.ScryptP)
 	if err != nil {
 		return nil, err
 	}
-
-	// currently storing key unencrypted. in the future we need to encrypt it.
-	// TODO(security)
+	if len(ek.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce length: %d", len(ek.Nonce))
+	}
+	pkb, err := aead.Open(nil, ek.Nonce, ek.Ciphertext, []byte(i.PeerID))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
 	return ic.UnmarshalPrivateKey(pkb)
 }
+
+// SetPrivateKey stores the given private key, encrypted with the given passphrase. The key is
+// stored unencrypted if the passphrase is empty.
+//
+// The PeerID must be set prior to calling this function, since it is authenticated along with the
+// encrypted key.
+func (i *Identity) SetPrivateKey(key ic.PrivKey, passphrase string) error {
+	pkb, err := ic.MarshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if passphrase == "" {
+		i.PrivKey = base64.StdEncoding.EncodeToString(pkb)
+		i.EncryptedPrivKey = nil
+		return nil
+	}
+
+	ek := &EncryptedKey{
+		ScryptN: defaultScryptN,
+		ScryptR: defaultScryptR,
+		ScryptP: defaultScryptP,
+		Salt:    make([]byte, scryptSaltLen),
+	}
+	if _, err := rand.Read(ek.Salt); err != nil {
+		return err
+	}
+	aead, err := newKeyCipher(passphrase, ek.Salt, ek.ScryptN, ek.ScryptR, ek.ScryptP)
+	if err != nil {
+		return err
+	}
+	ek.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(ek.Nonce); err != nil {
+		return err
+	}
+	ek.Ciphertext = aead.Seal(nil, ek.Nonce, pkb, []byte(i.PeerID))
+
+	i.PrivKey = ""
+	i.EncryptedPrivKey = ek
+	return nil
+}
+
+// newKeyCipher derives a key from the given passphrase using scrypt, and returns an AES-256-GCM
+// cipher that uses it.
+func newKeyCipher(passphrase string, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, n, r, p, scryptKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("could not derive key from passphrase: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
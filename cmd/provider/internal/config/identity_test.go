@@ -0,0 +1,74 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestIdentity_EncryptDecrypt(t *testing.T) {
+	id, err := CreateIdentity(ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := id.DecodePrivateKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = id.SetPrivateKey(want, "fish"); err != nil {
+		t.Fatal(err)
+	}
+	if !id.IsEncrypted() || id.PrivKey != "" {
+		t.Fatal("expected private key to be stored encrypted only")
+	}
+
+	// Assert encrypted identity survives JSON round trip.
+	b, err := json.Marshal(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id2 Identity
+	if err = json.Unmarshal(b, &id2); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = id2.DecodePrivateKey(""); err != ErrPassphraseRequired {
+		t.Fatal("expected passphrase required error; got:", err)
+	}
+	if _, err = id2.DecodePrivateKey("lobster"); err != ErrWrongPassphrase {
+		t.Fatal("expected wrong passphrase error; got:", err)
+	}
+	got, err := id2.DecodePrivateKey("fish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !want.Equals(got) {
+		t.Fatal("decrypted private key does not match")
+	}
+
+	// Assert the encrypted key is bound to the peer ID.
+	other, err := CreateIdentity(ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2.PeerID = other.PeerID
+	if _, err = id2.DecodePrivateKey("fish"); err != ErrWrongPassphrase {
+		t.Fatal("expected wrong passphrase error; got:", err)
+	}
+
+	// Assert the key can be stored unencrypted again.
+	if err = id.SetPrivateKey(got, ""); err != nil {
+		t.Fatal(err)
+	}
+	if id.IsEncrypted() {
+		t.Fatal("expected private key to be stored unencrypted")
+	}
+	got, err = id.DecodePrivateKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !want.Equals(got) {
+		t.Fatal("decoded private key does not match")
+	}
+}
@@ -21,15 +21,43 @@ type Ingest struct {
 	// LRU eviction.  If a single linked list has more links than the cache can
 	// hold, the cache is resized to be able to hold all links.
 	LinkCacheSize int
+	// LinkCacheMaxBytes is the maximum total size in bytes of the chunks that the cache can store
+	// before LRU eviction. Chunks shared between linked lists are counted once. When set,
+	// LinkCacheSize does not apply and the cache is only limited by size. Zero means unlimited.
+	LinkCacheMaxBytes int64 `json:",omitempty"`
 	// LinkedChunkSize is the number of multihashes in each chunk of in the
 	// advertised entries linked list.  If multihashes are 128 bytes, then
 	// setting LinkedChunkSize = 16384 will result in blocks of about 2Mb when
 	// full.
 	LinkedChunkSize int
+	// LinkedChunkWorkers is the number of workers that encode the chunks of the advertised
+	// entries linked list in parallel. Chunks are encoded sequentially if set to less than 2.
+	// The generated entries are the same regardless of the number of workers.
+	LinkedChunkWorkers int `json:",omitempty"`
+	// SortedEntries tells whether to omit duplicate multihashes from the advertised entries
+	// linked list, and to sort the multihashes within each chunk. Changing this setting only
+	// applies to content advertised afterwards.
+	SortedEntries bool `json:",omitempty"`
+	// EntriesFormat configures whether advertisement entries are formatted as a chain of entry
+	// chunks or as a HAMT. LinkedChunkSize, LinkedChunkWorkers and SortedEntries only apply to the
+	// chain format.
+	EntriesFormat EntriesFormat
 	// PubSubTopic used to advertise ingestion announcements.
 	PubSubTopic string
 	// PurgeLinkCache tells whether to purge the link cache on daemon startup.
 	PurgeLinkCache bool
+	// LinkCacheWarmUp configures the generation of the entries of recent advertisements into
+	// the link cache on daemon startup.
+	LinkCacheWarmUp CacheWarmUp
+	// IndexMultihashes tells whether to maintain an index of multihashes to the context IDs that
+	// contain them, which allows looking up advertised context IDs by multihash via the admin
+	// server. Only the context IDs advertised while the index is enabled are indexed.
+	IndexMultihashes bool
+	// RemoveCorruptedEntries tells whether to publish a removal advertisement for a context ID
+	// when the entries regenerated for it do not match the advertised entries, e.g. because the
+	// CAR file from which it was advertised has changed. Such context IDs are listed via the
+	// admin server regardless.
+	RemoveCorruptedEntries bool
 
 	// HttpPublisher configures the go-legs httpsync publisher.
 	HttpPublisher HttpPublisher
@@ -48,6 +76,8 @@ func NewIngest() Ingest {
 		LinkCacheSize:   defaultLinkCacheSize,
 		LinkedChunkSize: defaultLinkedChunkSize,
 		PubSubTopic:     defaultPubSubTopic,
+		LinkCacheWarmUp: NewCacheWarmUp(),
+		EntriesFormat:   NewEntriesFormat(),
 		HttpPublisher:   NewHttpPublisher(),
 		PublisherKind:   DTSyncPublisherKind,
 		SyncPolicy:      NewPolicy(),
@@ -65,4 +95,6 @@ func (c *Ingest) PopulateDefaults() {
 	if c.PubSubTopic == "" {
 		c.PubSubTopic = defaultPubSubTopic
 	}
+	c.LinkCacheWarmUp.PopulateDefaults()
+	c.EntriesFormat.PopulateDefaults()
 }
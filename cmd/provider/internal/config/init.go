@@ -2,7 +2,6 @@ package config
 
 import (
 	"crypto/rand"
-	"encoding/base64"
 	"fmt"
 	"io"
 
@@ -23,9 +22,11 @@ func InitWithIdentity(identity Identity) (*Config, error) {
 		Identity:       identity,
 		Bootstrap:      NewBootstrap(),
 		Datastore:      NewDatastore(),
+		CacheDatastore: NewCacheDatastore(),
 		Ingest:         NewIngest(),
 		ProviderServer: NewProviderServer(),
 		AdminServer:    NewAdminServer(),
+		Compaction:     NewCompaction(),
 	}, nil
 }
 
@@ -46,19 +47,16 @@ func CreateIdentity(out io.Writer) (Identity, error) {
 	sk = priv
 	pk = pub
 
-	// currently storing key unencrypted. in the future we need to encrypt it.
-	// TODO(security)
-	skbytes, err := crypto.MarshalPrivateKey(sk)
+	id, err := peer.IDFromPublicKey(pk)
 	if err != nil {
 		return ident, err
 	}
-	ident.PrivKey = base64.StdEncoding.EncodeToString(skbytes)
+	ident.PeerID = id.Pretty()
 
-	id, err := peer.IDFromPublicKey(pk)
-	if err != nil {
+	// Store the key unencrypted; it can be encrypted afterwards via Identity.SetPrivateKey.
+	if err = ident.SetPrivateKey(sk, ""); err != nil {
 		return ident, err
 	}
-	ident.PeerID = id.Pretty()
 	fmt.Fprintf(out, "peer identity: %s\n", ident.PeerID)
 	return ident, nil
 }
@@ -3,10 +3,15 @@ package internal
 import (
 	"context"
 	"io"
+	"math/bits"
 
 	provider "github.com/filecoin-project/index-provider"
 	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	hamt "github.com/ipld/go-ipld-adl-hamt"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-multihash"
 )
 
@@ -23,6 +28,31 @@ type (
 		next       cid.Cid
 		chunkIter  *sliceMhIterator
 		chunkCount int
+
+		// hamtRoot is the root of entries represented as a HAMT, or nil if the entries are
+		// represented as a chain of entry chunks. hamtIter iterates over the multihashes in the HAMT.
+		hamtRoot *hamt.HashMapRoot
+		hamtIter provider.MultihashIterator
+		// hamtChecked is whether the synced root has been checked to be a HAMT root, such that it
+		// is loaded at most once.
+		hamtChecked bool
+	}
+
+	// HamtInfo describes the shape of advertisement entries represented as a HAMT.
+	HamtInfo struct {
+		// HashAlg is the hash algorithm used to hash multihashes into the HAMT.
+		HashAlg multicodec.Code
+		// BitWidth is the number of bits of hash used at each level of the HAMT.
+		BitWidth int
+		// BucketSize is the maximum number of multihashes stored in a bucket before the bucket
+		// is split into a child node.
+		BucketSize int
+		// NodeCount is the number of nodes in the HAMT, including the root.
+		NodeCount int
+		// Depth is the number of levels of nodes in the HAMT.
+		Depth int
+		// BucketCount is the number of buckets across all nodes.
+		BucketCount int
 	}
 	sliceMhIterator struct {
 		mhs    []multihash.Multihash
@@ -42,12 +72,39 @@ func isPresent(c cid.Cid) bool {
 	return c != schema.NoEntries.Cid && c != cid.Undef
 }
 
+// IsHamt returns whether the entries are represented as a HAMT. Entries are only known to be a
+// HAMT once their root is synced.
+func (d *EntriesIterator) IsHamt() bool {
+	if !d.hamtChecked && isPresent(d.root) {
+		// Only check once the root is synced; it then fails to load as a HAMT root if it is an
+		// entries chunk.
+		if synced, err := d.store.Has(d.ctx, datastore.NewKey(d.root.String())); err == nil && synced {
+			d.hamtRoot, _ = d.store.getHamtRoot(d.ctx, d.root)
+			d.hamtChecked = true
+		}
+	}
+	return d.hamtRoot != nil
+}
+
 func (d *EntriesIterator) Next() (multihash.Multihash, error) {
 
 	if !d.IsPresent() {
 		return nil, io.EOF
 	}
 
+	if d.IsHamt() {
+		if d.hamtIter == nil {
+			d.hamtIter = provider.HamtMultihashIterator(d.hamtRoot, d.store.LinkSystem)
+		}
+		mh, err := d.hamtIter.Next()
+		if err == io.EOF && d.chunkCount == 0 {
+			if info, err := d.Hamt(); err == nil {
+				d.chunkCount = info.NodeCount
+			}
+		}
+		return mh, err
+	}
+
 	if d.chunkIter != nil && d.chunkIter.hasNext() {
 		return d.chunkIter.Next()
 	}
@@ -85,7 +142,8 @@ func (d *EntriesIterator) Drain() ([]multihash.Multihash, error) {
 
 //ChunkCount returns the number of current chunk in iteration.
 // This function returns the final count of entries chunk when iteration reaches its end, i.e.
-// calling EntriesIterator.Next returns io.EOF error.
+// calling EntriesIterator.Next returns io.EOF error. For entries represented as a HAMT, the count
+// of HAMT nodes is returned once iteration reaches its end.
 func (d *EntriesIterator) ChunkCount() int {
 	return d.chunkCount
 }
@@ -102,3 +160,44 @@ func (s *sliceMhIterator) Next() (multihash.Multihash, error) {
 func (s *sliceMhIterator) hasNext() bool {
 	return s.offset < len(s.mhs)
 }
+
+// Hamt walks the nodes of entries represented as a HAMT and returns its shape, or nil if the
+// entries are not a HAMT. An error is returned if any of the HAMT nodes are not synced.
+func (d *EntriesIterator) Hamt() (*HamtInfo, error) {
+	if !d.IsHamt() {
+		return nil, nil
+	}
+	info := &HamtInfo{
+		HashAlg:    d.hamtRoot.HashAlg,
+		BitWidth:   bits.Len(uint(len(d.hamtRoot.Hamt.Map)*8)) - 1,
+		BucketSize: d.hamtRoot.BucketSize,
+	}
+	if err := d.walkHamt(&d.hamtRoot.Hamt, 1, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (d *EntriesIterator) walkHamt(node *hamt.HashMapNode, depth int, info *HamtInfo) error {
+	info.NodeCount++
+	if depth > info.Depth {
+		info.Depth = depth
+	}
+	for _, e := range node.Data {
+		if e.Bucket != nil {
+			info.BucketCount++
+			continue
+		}
+		if e.HashMapNode == nil {
+			continue
+		}
+		child, err := d.store.getHamtNode(d.ctx, (*e.HashMapNode).(cidlink.Link).Cid)
+		if err != nil {
+			return err
+		}
+		if err := d.walkHamt(child, depth+1, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/filecoin-project/index-provider/cmd/provider/internal"
 	adminserver "github.com/filecoin-project/index-provider/server/admin/http"
@@ -21,7 +23,7 @@ var (
 	ListCmd = &cli.Command{
 		Name:        "list",
 		Aliases:     []string{"ls"},
-		Subcommands: []*cli.Command{listAdSubCmd, listCarSubCmd},
+		Subcommands: []*cli.Command{listAdSubCmd, listCarSubCmd, listCorruptedSubCmd},
 	}
 
 	adCid      = cid.Undef
@@ -72,6 +74,19 @@ var (
 			adminAPIFlag,
 		},
 	}
+
+	listCorruptedSubCmd = &cli.Command{
+		Name:  "corrupted",
+		Usage: "Lists the context IDs the regenerated entries of which do not match the advertised entries.",
+		Description: `Lists the context IDs for which the entries regenerated on cache miss do not match the advertised
+entries, e.g. because the CAR file from which they were advertised has changed. Indexers cannot sync
+the entries of such context IDs. If RemoveCorruptedEntries is enabled in the Ingest config, the CID
+of the removal advertisement published for each context ID is also shown.`,
+		Action: doListCorrupted,
+		Flags: []cli.Flag{
+			adminAPIFlag,
+		},
+	}
 )
 
 func beforeGetAdvertisements(cctx *cli.Context) error {
@@ -133,6 +148,7 @@ func doGetAdvertisements(cctx *cli.Context) error {
 	fmt.Printf("ProviderID:  %s\n", ad.ProviderID)
 	fmt.Printf("Addresses:   %v\n", ad.Addresses)
 	fmt.Printf("Is Remove:   %v\n", ad.IsRemove)
+	fmt.Printf("Metadata:    %s\n", formatMetadata(ad.Metadata, "             "))
 
 	if ad.IsRemove {
 		if ad.HasEntries() {
@@ -158,7 +174,20 @@ func doGetAdvertisements(cctx *cli.Context) error {
 		}
 		fmt.Println("  ---------------------")
 	}
-	fmt.Printf("  Chunk Count: %d\n", ad.Entries.ChunkCount())
+	if ad.Entries.IsHamt() {
+		hamtInfo, err := ad.Entries.Hamt()
+		if err != nil {
+			return err
+		}
+		fmt.Println("  Format: HAMT")
+		fmt.Printf("  Hash Algorithm: %s\n", hamtInfo.HashAlg)
+		fmt.Printf("  Bit Width: %d\n", hamtInfo.BitWidth)
+		fmt.Printf("  Bucket Size: %d\n", hamtInfo.BucketSize)
+		fmt.Printf("  Node Count: %d\n", hamtInfo.NodeCount)
+		fmt.Printf("  Depth: %d\n", hamtInfo.Depth)
+	} else {
+		fmt.Printf("  Chunk Count: %d\n", ad.Entries.ChunkCount())
+	}
 	fmt.Printf("  Total Count: %d\n", len(entries))
 	if entriesOutput != "" {
 		fmt.Println(entriesOutput)
@@ -189,3 +218,33 @@ func doListCars(cctx *cli.Context) error {
 	_, err = cctx.App.Writer.Write(b.Bytes())
 	return err
 }
+
+func doListCorrupted(cctx *cli.Context) error {
+	cl := &http.Client{}
+	resp, err := cl.Get(adminAPIFlagValue + "/admin/list/corrupted")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errFromHttpResp(resp)
+	}
+
+	var res adminserver.ListCorruptedContextIDsRes
+	if _, err := res.ReadFrom(resp.Body); err != nil {
+		return fmt.Errorf("received ok response from server but cannot decode response body. %v", err)
+	}
+	var b bytes.Buffer
+	for _, cc := range res.ContextIDs {
+		fmt.Fprintf(&b, "Context ID: %s\n", base64.StdEncoding.EncodeToString(cc.ContextID))
+		fmt.Fprintf(&b, "  Advertised entries: %s\n", cc.AdvertisedEntries)
+		fmt.Fprintf(&b, "  Regenerated entries: %s\n", cc.RegeneratedEntries)
+		fmt.Fprintf(&b, "  Detected at: %s\n", cc.DetectedAt.Format(time.RFC3339))
+		if cc.RemovalAdvId != cid.Undef {
+			fmt.Fprintf(&b, "  Removal advertisement: %s\n", cc.RemovalAdvId)
+		}
+	}
+	_, err = cctx.App.Writer.Write(b.Bytes())
+	return err
+}
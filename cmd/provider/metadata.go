@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/filecoin-project/index-provider/metadata"
+)
+
+// parseMetadata parses metadata given either as a JSON object, such as
+// {"bitswap":{}}, or as base64 encoded bytes.
+func parseMetadata(value string) (metadata.Metadata, error) {
+	var md metadata.Metadata
+	if value = strings.TrimSpace(value); strings.HasPrefix(value, "{") {
+		if err := md.UnmarshalJSON([]byte(value)); err != nil {
+			return metadata.Metadata{}, fmt.Errorf("metadata is not valid JSON: %w", err)
+		}
+		return md, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return metadata.Metadata{}, errors.New("metadata is not a valid base64 encoded string")
+	}
+	if err := md.UnmarshalBinary(decoded); err != nil {
+		return metadata.Metadata{}, err
+	}
+	return md, nil
+}
+
+// formatMetadata returns the given encoded metadata as indented JSON, with each line after the
+// first prefixed by the given prefix. Metadata that fails to decode is returned as base64 along
+// with the decoding error.
+func formatMetadata(mdBytes []byte, prefix string) string {
+	if len(mdBytes) == 0 {
+		return "None"
+	}
+	var md metadata.Metadata
+	if err := md.UnmarshalBinary(mdBytes); err != nil {
+		return fmt.Sprintf("%s\n%s⚠️ Failed to decode: %s", base64.StdEncoding.EncodeToString(mdBytes), prefix, err)
+	}
+	formatted, err := json.MarshalIndent(md, prefix, "  ")
+	if err != nil {
+		return fmt.Sprintf("%s\n%s⚠️ Failed to format: %s", base64.StdEncoding.EncodeToString(mdBytes), prefix, err)
+	}
+	return string(formatted)
+}
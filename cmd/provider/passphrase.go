@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/filecoin-project/index-provider/cmd/provider/internal/config"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+const (
+	// envPassphrase is the environment variable used to specify the passphrase of the identity.
+	envPassphrase = "PROVIDER_PASSPHRASE"
+	// envNewPassphrase is the environment variable used to specify the new passphrase of the
+	// identity when rotating the passphrase.
+	envNewPassphrase = "PROVIDER_NEW_PASSPHRASE"
+)
+
+// stdin reads passphrases from stdin when it is not a terminal. It is shared across prompts, since
+// a reader may buffer more than the line it reads, e.g. the confirmation of a new passphrase.
+var stdin = bufio.NewReader(os.Stdin)
+
+// decodeIdentity decodes the identity in the given config, reading the passphrase if the private
+// key is stored encrypted.
+// See: readPassphrase.
+func decodeIdentity(cctx *cli.Context, cfg *config.Config) (peer.ID, crypto.PrivKey, error) {
+	passphrase, err := identityPassphrase(cctx, cfg)
+	if err != nil {
+		return "", nil, err
+	}
+	return cfg.Identity.Decode(passphrase)
+}
+
+// identityPassphrase reads the passphrase of the identity in the given config, or returns an empty
+// passphrase if the private key is stored unencrypted.
+func identityPassphrase(cctx *cli.Context, cfg *config.Config) (string, error) {
+	if !cfg.Identity.IsEncrypted() {
+		return "", nil
+	}
+	return readPassphrase(cctx, envPassphrase, passphraseFileFlag.Name, "Enter passphrase: ")
+}
+
+// readPassphrase reads a passphrase from the given environment variable, or the file specified by
+// the given flag, or prompts for it on the terminal, in that order of precedence.
+func readPassphrase(cctx *cli.Context, env, fileFlag, prompt string) (string, error) {
+	if passphrase, ok := os.LookupEnv(env); ok {
+		return passphrase, nil
+	}
+	if path := cctx.String(fileFlag); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("cannot read passphrase file: %w", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	return promptPassphrase(prompt)
+}
+
+// readNewPassphrase reads a new passphrase similar to readPassphrase, and asks for confirmation if
+// the passphrase is read from the terminal.
+func readNewPassphrase(cctx *cli.Context, env, fileFlag string) (string, error) {
+	if _, ok := os.LookupEnv(env); ok || cctx.String(fileFlag) != "" {
+		return readPassphrase(cctx, env, fileFlag, "")
+	}
+	passphrase, err := promptPassphrase("Enter new passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	confirm, err := promptPassphrase("Confirm new passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase != confirm {
+		return "", errors.New("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+// promptPassphrase prints the given prompt to stderr, and reads a passphrase from stdin without
+// echoing it if stdin is a terminal.
+func promptPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		passphrase, err := term.ReadPassword(fd)
+		if err != nil {
+			return "", fmt.Errorf("cannot read passphrase from terminal: %w", err)
+		}
+		return string(passphrase), nil
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && !(err == io.EOF && line != "") {
+		return "", fmt.Errorf("cannot read passphrase: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
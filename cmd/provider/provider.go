@@ -43,15 +43,20 @@ func run() int {
 		Commands: []*cli.Command{
 			AnnounceCmd,
 			AnnounceHttpCmd,
+			CacheCmd,
+			CompactCmd,
 			ConnectCmd,
 			DaemonCmd,
 			FindCmd,
+			IdentityCmd,
 			ImportCmd,
 			IndexCmd,
 			InitCmd,
 			ListCmd,
 			RegisterCmd,
 			RemoveCmd,
+			SetAddrsCmd,
+			UpdateMetadataCmd,
 			VerifyIngestCmd,
 		},
 	}
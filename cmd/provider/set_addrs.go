@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+
+	adminserver "github.com/filecoin-project/index-provider/server/admin/http"
+	"github.com/urfave/cli/v2"
+)
+
+var SetAddrsCmd = &cli.Command{
+	Name:  "set-addrs",
+	Usage: "Sets the retrieval addresses of the provider without restarting it",
+	Description: `Sets the retrieval addresses of the provider, and publishes an advertisement that signals the
+change to indexers without re-sending any entries. The addresses are persisted and take precedence
+over the configured addresses when the daemon is restarted, unless the configured addresses have
+since changed.
+
+Use the reset option to revert to the addresses the provider was started with.`,
+	Flags:  setAddrsFlags,
+	Action: setAddrsCommand,
+}
+
+func setAddrsCommand(cctx *cli.Context) error {
+	addrs := cctx.StringSlice("addr")
+	reset := cctx.Bool("reset")
+	switch {
+	case reset && len(addrs) != 0:
+		return errors.New("addr and reset options are mutually exclusive")
+	case !reset && len(addrs) == 0:
+		return errors.New("at least one addr or the reset option must be specified")
+	}
+
+	req := adminserver.SetRetrievalAddrsReq{
+		Addrs: addrs,
+	}
+	resp, err := doHttpPostReq(cctx.Context, adminAPIFlagValue+"/admin/addrs", req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errFromHttpResp(resp)
+	}
+
+	var res adminserver.RetrievalAddrsRes
+	if _, err := res.ReadFrom(resp.Body); err != nil {
+		return fmt.Errorf("received ok response from server but cannot decode response body. %v", err)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("Successfully set retrieval addresses.\n")
+	for _, addr := range res.Addrs {
+		fmt.Fprintf(&b, "\t Address: %s\n", addr)
+	}
+	fmt.Fprintf(&b, "\t Advertisement ID: %s\n", res.AdvId)
+	_, err = cctx.App.Writer.Write(b.Bytes())
+	return err
+}
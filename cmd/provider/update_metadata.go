@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/filecoin-project/index-provider/metadata"
+	adminserver "github.com/filecoin-project/index-provider/server/admin/http"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	updateMetadataReq adminserver.UpdateMetadataReq
+	UpdateMetadataCmd = &cli.Command{
+		Name:    "update-metadata",
+		Aliases: []string{"um"},
+		Usage:   "Updates the metadata of advertised context IDs.",
+		Description: `Re-advertises the context IDs advertised by the provider with updated metadata, reusing their
+previously advertised entries.
+
+The metadata of each context ID is updated by first removing the protocols specified via remove
+option, then adding the protocols specified via add, add-bitswap or add-http-gateway options. Added protocols replace
+any existing protocol with the same ID.
+
+All advertised context IDs are updated unless one or more context IDs are specified via key option.`,
+		Flags:  updateMetadataFlags,
+		Before: beforeUpdateMetadata,
+		Action: doUpdateMetadata,
+	}
+)
+
+func beforeUpdateMetadata(cctx *cli.Context) error {
+	var add metadata.Metadata
+	if cctx.IsSet("add") {
+		decoded, err := base64.StdEncoding.DecodeString(cctx.String("add"))
+		if err != nil {
+			return fmt.Errorf("metadata to add is not a valid base64 encoded string")
+		}
+		if err := add.UnmarshalBinary(decoded); err != nil {
+			return err
+		}
+	}
+	if cctx.Bool("add-bitswap") {
+		add = add.With(metadata.Bitswap{})
+	}
+	if cctx.Bool("add-http-gateway") {
+		add = add.With(metadata.IpfsGatewayHttp{})
+	}
+	if add.Len() != 0 {
+		addBytes, err := add.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		updateMetadataReq.Add = addBytes
+	}
+
+	for _, name := range cctx.StringSlice("remove") {
+		code, err := metadata.ParseProtocol(name)
+		if err != nil {
+			return fmt.Errorf("unknown protocol to remove: %s", name)
+		}
+		updateMetadataReq.Remove = append(updateMetadataReq.Remove, code)
+	}
+	if len(updateMetadataReq.Add) == 0 && len(updateMetadataReq.Remove) == 0 {
+		return cli.Exit("at least one of add, add-bitswap, add-http-gateway or remove must be specified", 1)
+	}
+
+	for _, key := range cctx.StringSlice("key") {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return fmt.Errorf("key is not a valid base64 encoded string: %s", key)
+		}
+		updateMetadataReq.ContextIDs = append(updateMetadataReq.ContextIDs, decoded)
+	}
+	return nil
+}
+
+func doUpdateMetadata(cctx *cli.Context) error {
+	resp, err := doHttpPostReq(cctx.Context, adminAPIFlagValue+"/admin/update/metadata", updateMetadataReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errFromHttpResp(resp)
+	}
+
+	var res adminserver.UpdateMetadataRes
+	if _, err := res.ReadFrom(resp.Body); err != nil {
+		return fmt.Errorf("received ok response from server but cannot decode response body. %v", err)
+	}
+	var b bytes.Buffer
+	if res.AdvId == cid.Undef {
+		b.WriteString("No context IDs required metadata update.\n")
+	} else {
+		b.WriteString("Successfully updated metadata.\n")
+		fmt.Fprintf(&b, "\t Context IDs updated: %d\n", res.Updated)
+		fmt.Fprintf(&b, "\t Advertisement ID: %s\n", res.AdvId)
+	}
+	_, err = cctx.App.Writer.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,261 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/filecoin-project/index-provider/metadata"
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// retrievalAddrsKey stores the retrieval addresses set via Engine.SetRetrievalAddrs.
+	retrievalAddrsKey = "provider/retrievalAddrs/"
+	// configuredRetrievalAddrsKey stores the configured retrieval addresses overridden by the
+	// ones stored at retrievalAddrsKey.
+	configuredRetrievalAddrsKey = "provider/configuredRetrievalAddrs/"
+)
+
+var (
+	dsRetrievalAddrsKey           = datastore.NewKey(retrievalAddrsKey)
+	dsConfiguredRetrievalAddrsKey = datastore.NewKey(configuredRetrievalAddrsKey)
+)
+
+// ErrEmptyContextID signals that the empty context ID cannot be advertised, since it is reserved
+// for the advertisements that update the retrieval addresses. See: Engine.SetRetrievalAddrs.
+var ErrEmptyContextID = errors.New("empty context ID cannot be advertised")
+
+// RetrievalAddrs returns the current retrieval addresses of the provider.
+// See: Engine.SetRetrievalAddrs.
+func (e *Engine) RetrievalAddrs() []multiaddr.Multiaddr {
+	e.addrsLk.RLock()
+	defer e.addrsLk.RUnlock()
+	addrs := make([]multiaddr.Multiaddr, len(e.retrievalAddrs))
+	copy(addrs, e.retrievalAddrs)
+	return addrs
+}
+
+// SetRetrievalAddrs sets the retrieval addresses of the provider, and publishes an advertisement
+// that signals the change to indexers. If no addresses are given, the retrieval addresses are
+// reset to the ones configured via WithRetrievalAddrs, or the host listen addresses if none were
+// configured.
+//
+// The addresses are persisted in the datastore and take precedence over the configured addresses
+// when the engine is restarted, unless the configured addresses have since changed, in which case
+// the persisted addresses are discarded. All advertisements published subsequently carry the new
+// addresses.
+//
+// The published advertisement carries no entries, and signals the removal of the empty context
+// ID, which cannot otherwise be advertised; see ErrEmptyContextID. Since indexers refresh the
+// provider addresses upon every advertisement they ingest, it effectively updates the provider
+// addresses without re-sending any entries.
+func (e *Engine) SetRetrievalAddrs(ctx context.Context, addrs ...multiaddr.Multiaddr) (cid.Cid, error) {
+	c, err := e.commitRetrievalAddrs(ctx, addrs)
+	if err != nil {
+		return cid.Undef, err
+	}
+	// The new addresses are persisted along with the advertisement; keep them even if announcing
+	// the advertisement fails.
+	if err := e.announce(ctx, c); err != nil {
+		return cid.Undef, err
+	}
+	return c, nil
+}
+
+// commitRetrievalAddrs persists the given retrieval addresses, or the configured ones if none are
+// given, and commits the advertisement that carries them.
+func (e *Engine) commitRetrievalAddrs(ctx context.Context, addrs []multiaddr.Multiaddr) (cid.Cid, error) {
+	e.publishLk.Lock()
+	defer e.publishLk.Unlock()
+
+	b, err := newDsBatch(ctx, e.ds)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("could not create datastore batch: %w", err)
+	}
+	if len(addrs) == 0 {
+		addrs = e.options.provider.Addrs
+		err = deleteRetrievalAddrs(ctx, b)
+	} else {
+		err = putRetrievalAddrs(ctx, b, addrs, e.options.provider.Addrs)
+	}
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to persist retrieval addresses: %w", err)
+	}
+
+	e.addrsLk.Lock()
+	prevAddrs := e.retrievalAddrs
+	e.retrievalAddrs = addrs
+	e.addrsLk.Unlock()
+
+	c, err := e.commitAddrsAdv(ctx, b)
+	if err != nil {
+		// Restore the previous addresses, since the new ones are not persisted.
+		e.addrsLk.Lock()
+		e.retrievalAddrs = prevAddrs
+		e.addrsLk.Unlock()
+		return cid.Undef, err
+	}
+	log.Infow("Updated retrieval addresses", "retrievalAddrs", addrs, "adCid", c)
+	return c, nil
+}
+
+// commitAddrsAdv generates and stores an advertisement that carries the current retrieval
+// addresses, and commits it along with the writes in the given batch.
+func (e *Engine) commitAddrsAdv(ctx context.Context, b *dsBatch) (cid.Cid, error) {
+	// The advertisement requires a valid metadata even though it is not used for removal.
+	md := metadata.New(metadata.Bitswap{})
+	mdBytes, err := md.MarshalBinary()
+	if err != nil {
+		return cid.Undef, err
+	}
+	adv := schema.Advertisement{
+		Provider:  e.options.provider.ID.String(),
+		Addresses: e.retrievalAddrsAsString(),
+		Entries:   schema.NoEntries,
+		ContextID: []byte{},
+		Metadata:  mdBytes,
+		IsRm:      true,
+	}
+	prevAdvID, err := getLatestAdCid(ctx, b)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("could not get latest advertisement: %s", err)
+	}
+	if prevAdvID != cid.Undef {
+		prev := ipld.Link(cidlink.Link{Cid: prevAdvID})
+		adv.PreviousID = &prev
+	}
+	if err := adv.Sign(e.key); err != nil {
+		return cid.Undef, err
+	}
+
+	c, err := e.storeAdv(ctx, b, adv)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to store advertisement: %w", err)
+	}
+	if err := b.Commit(ctx); err != nil {
+		return cid.Undef, fmt.Errorf("failed to commit advertisement: %w", err)
+	}
+	return c, nil
+}
+
+// loadRetrievalAddrs sets the retrieval addresses to the ones persisted via
+// Engine.SetRetrievalAddrs, if any. The persisted addresses are discarded if the configured
+// addresses have changed since they were set.
+func (e *Engine) loadRetrievalAddrs(ctx context.Context) error {
+	addrStrs, err := getAddrStrs(ctx, e.ds, dsRetrievalAddrsKey)
+	if err != nil {
+		return fmt.Errorf("could not get retrieval addresses: %w", err)
+	}
+	if addrStrs == nil {
+		return nil
+	}
+	// Retrieval addresses persisted by previous versions of the engine do not record the
+	// configured addresses they override, and are always used.
+	configuredStrs, err := getAddrStrs(ctx, e.ds, dsConfiguredRetrievalAddrsKey)
+	if err != nil {
+		return fmt.Errorf("could not get configured retrieval addresses: %w", err)
+	}
+	if configuredStrs != nil && !equalAddrStrs(configuredStrs, addrsAsStrings(e.options.provider.Addrs)) {
+		log.Warnw("Configured retrieval addresses have changed since retrieval addresses were set; discarding the set retrieval addresses",
+			"retrievalAddrs", e.options.provider.Addrs, "discarded", addrStrs, "previouslyConfigured", configuredStrs)
+		return deleteRetrievalAddrs(ctx, e.ds)
+	}
+	addrs := make([]multiaddr.Multiaddr, 0, len(addrStrs))
+	for _, addrStr := range addrStrs {
+		addr, err := multiaddr.NewMultiaddr(addrStr)
+		if err != nil {
+			return fmt.Errorf("could not decode retrieval address %q: %w", addrStr, err)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	e.addrsLk.Lock()
+	e.retrievalAddrs = addrs
+	e.addrsLk.Unlock()
+	log.Warnw("Using retrieval addresses set via SetRetrievalAddrs instead of configured ones; reset them to use the configured ones",
+		"retrievalAddrs", addrs, "configured", e.options.provider.Addrs)
+	return nil
+}
+
+// putRetrievalAddrs persists the given retrieval addresses, along with the configured ones they
+// override.
+func putRetrievalAddrs(ctx context.Context, rw dsReadWriter, addrs, configured []multiaddr.Multiaddr) error {
+	if err := putAddrStrs(ctx, rw, dsRetrievalAddrsKey, addrsAsStrings(addrs)); err != nil {
+		return err
+	}
+	return putAddrStrs(ctx, rw, dsConfiguredRetrievalAddrsKey, addrsAsStrings(configured))
+}
+
+func deleteRetrievalAddrs(ctx context.Context, rw dsReadWriter) error {
+	if err := rw.Delete(ctx, dsRetrievalAddrsKey); err != nil {
+		return err
+	}
+	return rw.Delete(ctx, dsConfiguredRetrievalAddrsKey)
+}
+
+func putAddrStrs(ctx context.Context, rw dsReadWriter, key datastore.Key, addrStrs []string) error {
+	v, err := json.Marshal(addrStrs)
+	if err != nil {
+		return err
+	}
+	return rw.Put(ctx, key, v)
+}
+
+// getAddrStrs gets the addresses stored at the given key, or nil if there are none.
+func getAddrStrs(ctx context.Context, rw dsReadWriter, key datastore.Key) ([]string, error) {
+	v, err := rw.Get(ctx, key)
+	if err != nil {
+		if err == datastore.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	addrStrs := []string{}
+	if err := json.Unmarshal(v, &addrStrs); err != nil {
+		return nil, err
+	}
+	return addrStrs, nil
+}
+
+func equalAddrStrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func addrsAsStrings(addrs []multiaddr.Multiaddr) []string {
+	addrStrs := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		addrStrs = append(addrStrs, addr.String())
+	}
+	return addrStrs
+}
+
+// isAddrsAdv checks whether the given advertisement only updates the retrieval addresses, as
+// published by Engine.SetRetrievalAddrs. Such advertisements do not affect any context ID.
+func isAddrsAdv(ad *schema.Advertisement) bool {
+	return ad.IsRm && len(ad.ContextID) == 0
+}
+
+func (e *Engine) retrievalAddrsAsString() []string {
+	e.addrsLk.RLock()
+	defer e.addrsLk.RUnlock()
+	var ras []string
+	for _, ra := range e.retrievalAddrs {
+		ras = append(ras, ra.String())
+	}
+	return ras
+}
@@ -0,0 +1,261 @@
+package engine
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	provider "github.com/filecoin-project/index-provider"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+var (
+	_ dsReadWriter = (*dsBatch)(nil)
+	_ dsWriter     = (*streamingBatch)(nil)
+)
+
+type (
+	// dsReadWriter is the subset of datastore operations used by the engine to read and mutate
+	// its state. It is implemented by datastore.Batching as well as dsBatch, which allows the same
+	// logic to either write directly to the datastore or to group writes into a single batch.
+	dsReadWriter interface {
+		dsWriter
+		Get(ctx context.Context, key datastore.Key) ([]byte, error)
+		Query(ctx context.Context, q dsq.Query) (dsq.Results, error)
+	}
+
+	// dsWriter is the subset of datastore operations used by the engine to mutate its state,
+	// implemented by dsReadWriter as well as streamingBatch.
+	dsWriter interface {
+		Put(ctx context.Context, key datastore.Key, value []byte) error
+		Delete(ctx context.Context, key datastore.Key) error
+	}
+
+	// dsBatch groups writes into a datastore.Batch, while keeping an in-memory view of the pending
+	// writes so that reads made before the batch is committed observe the writes made earlier in the
+	// same batch.
+	//
+	// See: newDsBatch.
+	dsBatch struct {
+		ds    datastore.Datastore
+		batch datastore.Batch
+		// pending holds the values written to batch, keyed by datastore key. A nil value signals
+		// that the key has been deleted.
+		pending map[datastore.Key][]byte
+	}
+
+	// streamingBatch groups writes into a datastore.Batch that is committed every given number of
+	// writes. Unlike dsBatch, writes are not atomic and are not held in memory, which makes it
+	// suitable for writes that are too numerous to hold in memory, such as the multihash index.
+	//
+	// See: newStreamingBatch.
+	streamingBatch struct {
+		ds      datastore.Batching
+		batch   datastore.Batch
+		size    int
+		maxSize int
+	}
+)
+
+func newDsBatch(ctx context.Context, ds datastore.Batching) (*dsBatch, error) {
+	batch, err := ds.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &dsBatch{
+		ds:      ds,
+		batch:   batch,
+		pending: make(map[datastore.Key][]byte),
+	}, nil
+}
+
+func (b *dsBatch) Get(ctx context.Context, key datastore.Key) ([]byte, error) {
+	if v, ok := b.pending[key]; ok {
+		if v == nil {
+			return nil, datastore.ErrNotFound
+		}
+		return v, nil
+	}
+	return b.ds.Get(ctx, key)
+}
+
+func (b *dsBatch) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	if err := b.batch.Put(ctx, key, value); err != nil {
+		return err
+	}
+	// Defensively copy the value, since the caller may reuse the slice.
+	v := make([]byte, len(value))
+	copy(v, value)
+	b.pending[key] = v
+	return nil
+}
+
+func (b *dsBatch) Delete(ctx context.Context, key datastore.Key) error {
+	if err := b.batch.Delete(ctx, key); err != nil {
+		return err
+	}
+	b.pending[key] = nil
+	return nil
+}
+
+// Query queries the backing datastore, overlaid with the pending writes in this batch.
+//
+// Note that the entries matching the query prefix are fully loaded into memory before the rest of
+// the query is applied.
+func (b *dsBatch) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	results, err := b.ds.Query(ctx, dsq.Query{Prefix: q.Prefix})
+	if err != nil {
+		return nil, err
+	}
+	stored, err := results.Rest()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]dsq.Entry, 0, len(stored))
+	for _, entry := range stored {
+		if _, ok := b.pending[datastore.NewKey(entry.Key)]; !ok {
+			entries = append(entries, entry)
+		}
+	}
+	for key, value := range b.pending {
+		if value != nil {
+			entries = append(entries, dsq.Entry{Key: key.String(), Value: value, Size: len(value)})
+		}
+	}
+	return dsq.NaiveQueryApply(q, dsq.ResultsWithEntries(q, entries)), nil
+}
+
+// Commit commits all the writes made to this batch to the backing datastore.
+func (b *dsBatch) Commit(ctx context.Context) error {
+	return b.batch.Commit(ctx)
+}
+
+func newStreamingBatch(ctx context.Context, ds datastore.Batching, maxSize int) (*streamingBatch, error) {
+	batch, err := ds.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &streamingBatch{
+		ds:      ds,
+		batch:   batch,
+		maxSize: maxSize,
+	}, nil
+}
+
+func (b *streamingBatch) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	if err := b.batch.Put(ctx, key, value); err != nil {
+		return err
+	}
+	return b.written(ctx)
+}
+
+func (b *streamingBatch) Delete(ctx context.Context, key datastore.Key) error {
+	if err := b.batch.Delete(ctx, key); err != nil {
+		return err
+	}
+	return b.written(ctx)
+}
+
+// written commits the pending writes once their number reaches the maximum batch size.
+func (b *streamingBatch) written(ctx context.Context) error {
+	b.size++
+	if b.size < b.maxSize {
+		return nil
+	}
+	return b.Commit(ctx)
+}
+
+// Commit commits the pending writes to the backing datastore, and starts a new batch.
+func (b *streamingBatch) Commit(ctx context.Context) error {
+	if b.size == 0 {
+		return nil
+	}
+	if err := b.batch.Commit(ctx); err != nil {
+		return err
+	}
+	batch, err := b.ds.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	b.batch = batch
+	b.size = 0
+	return nil
+}
+
+// NotifyBatch publishes a chain of advertisements, one per given notification, that signal the
+// put or removal of context IDs in the order in which the notifications are given.
+//
+// All advertisements are generated, signed and linked to each other while holding a lock, and are
+// committed to the datastore along with their corresponding mappings as a single
+// datastore.Batch. Only the last advertisement in the batch, i.e. the new head of the
+// advertisement chain, is announced.
+//
+// The returned slice contains the advertisement CID generated for each notification at the same
+// index. Put notifications that would result in an advertisement identical to the one previously
+// published are skipped; their corresponding CID is set to cid.Undef. Any other error causes
+// the entire batch to be discarded, in which case no advertisements are published. This includes
+// put notifications with invalid metadata; see metadata.ErrInvalidMetadata.
+//
+// Note that prior to calling this function a provider.MultihashLister must be registered.
+//
+// See: Engine.NotifyPut, Engine.NotifyRemove.
+func (e *Engine) NotifyBatch(ctx context.Context, notifs []provider.Notification) ([]cid.Cid, error) {
+	e.publishLk.Lock()
+	adCids, head, err := e.notifyBatch(ctx, notifs)
+	e.publishLk.Unlock()
+	if err != nil || head == cid.Undef {
+		return adCids, err
+	}
+	if err := e.announce(ctx, head); err != nil {
+		return nil, err
+	}
+	return adCids, nil
+}
+
+// notifyBatch generates, stores and commits the advertisements of Engine.NotifyBatch, and returns
+// their CIDs along with the CID of the latest one, or cid.Undef if none were generated. The caller
+// must hold publishLk, and announce the latest advertisement once publishLk is released.
+func (e *Engine) notifyBatch(ctx context.Context, notifs []provider.Notification) ([]cid.Cid, cid.Cid, error) {
+	b, err := newDsBatch(ctx, e.ds)
+	if err != nil {
+		return nil, cid.Undef, fmt.Errorf("could not create datastore batch: %w", err)
+	}
+
+	adCids := make([]cid.Cid, len(notifs))
+	var head cid.Cid
+	for i, n := range notifs {
+		if !n.IsRemove {
+			if err := n.Metadata.Validate(); err != nil {
+				return nil, cid.Undef, fmt.Errorf("invalid metadata for notification at index %d: %w", i, err)
+			}
+		}
+		adv, err := e.generateAdvForIndex(ctx, b, n.ContextID, n.Metadata, n.IsRemove)
+		if err != nil {
+			if err == provider.ErrAlreadyAdvertised {
+				log.Infow("Skipped already advertised context ID in batch", "contextID", base64.StdEncoding.EncodeToString(n.ContextID))
+				continue
+			}
+			return nil, cid.Undef, fmt.Errorf("failed to generate advertisement for notification at index %d: %w", i, err)
+		}
+		c, err := e.storeAdv(ctx, b, *adv)
+		if err != nil {
+			return nil, cid.Undef, fmt.Errorf("failed to store advertisement for notification at index %d: %w", i, err)
+		}
+		adCids[i] = c
+		head = c
+	}
+
+	if head == cid.Undef {
+		log.Info("No advertisements were generated for batch")
+		return adCids, cid.Undef, nil
+	}
+
+	if err := b.Commit(ctx); err != nil {
+		log.Errorw("Failed to commit batch of advertisements", "err", err)
+		return nil, cid.Undef, fmt.Errorf("failed to commit batch of advertisements: %w", err)
+	}
+	log.Infow("Committed batch of advertisements", "count", len(notifs), "head", head)
+	return adCids, head, nil
+}
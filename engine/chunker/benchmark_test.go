@@ -2,6 +2,7 @@ package chunker_test
 
 import (
 	"context"
+	"io"
 	"math/rand"
 	"testing"
 	"time"
@@ -11,6 +12,9 @@ import (
 	"github.com/filecoin-project/index-provider/testutil"
 	"github.com/ipfs/go-datastore"
 	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/require"
@@ -98,3 +102,46 @@ func BenchmarkRestoreCache_ChainChunker(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkChainChunkers(b *testing.B) {
+	const chunkSize = 16384
+	const mhCount = 10 * chunkSize
+	const byteSize = mhCount * 256 / 8 // multicodec.Sha2_256
+
+	rng := rand.New(rand.NewSource(1413))
+	mhs := make([]multihash.Multihash, mhCount)
+	for i := range mhs {
+		data := make([]byte, 32)
+		rng.Read(data)
+		mh, err := multihash.Sum(data, multihash.SHA2_256, -1)
+		require.NoError(b, err)
+		mhs[i] = mh
+	}
+
+	b.Run("ChainChunker", benchmarkChainChunker(byteSize, mhs, chunker.NewChainChunkerFunc(chunkSize)))
+	b.Run("ParallelChainChunker/Workers_1", benchmarkChainChunker(byteSize, mhs, chunker.NewParallelChainChunkerFunc(chunkSize, 1)))
+	b.Run("ParallelChainChunker/Workers_2", benchmarkChainChunker(byteSize, mhs, chunker.NewParallelChainChunkerFunc(chunkSize, 2)))
+	b.Run("ParallelChainChunker/Workers_4", benchmarkChainChunker(byteSize, mhs, chunker.NewParallelChainChunkerFunc(chunkSize, 4)))
+	b.Run("ParallelChainChunker/Workers_8", benchmarkChainChunker(byteSize, mhs, chunker.NewParallelChainChunkerFunc(chunkSize, 8)))
+}
+
+func benchmarkChainChunker(byteSize int64, mhs []multihash.Multihash, c chunker.NewChunkerFunc) func(b *testing.B) {
+	return func(b *testing.B) {
+		b.SetBytes(byteSize)
+		b.ReportAllocs()
+
+		ls := cidlink.DefaultLinkSystem()
+		ls.StorageWriteOpener = func(linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+			return io.Discard, func(ipld.Link) error { return nil }, nil
+		}
+		subject, err := c(&ls)
+		require.NoError(b, err)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			root, err := subject.Chunk(context.Background(), provider.SliceMultihashIterator(mhs))
+			require.NoError(b, err)
+			require.NotNil(b, root)
+		}
+	}
+}
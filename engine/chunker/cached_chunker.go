@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 
 	provider "github.com/filecoin-project/index-provider"
 	"github.com/golang/groupcache/lru"
@@ -19,6 +20,7 @@ import (
 	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
 )
 
 var (
@@ -27,6 +29,7 @@ var (
 	log               = logging.Logger("chunker/cached-entries-chunker")
 	rootKeyPrefix     = datastore.NewKey("root")
 	loverlapKeyPrefix = datastore.NewKey("overlap")
+	formatKey         = datastore.NewKey("format")
 )
 
 type (
@@ -35,13 +38,29 @@ type (
 	// ChainChunker.
 	//
 	// The DAGs are guaranteed to either be fully cached or not at all. If DAGs overlap, the smaller
-	// overlapping portion is not evicted unless all the DAGs that link to it are evicted.
+	// overlapping portion is not evicted unless all the DAGs that link to it are evicted. Only the
+	// chunks reachable from the root of a HAMT are cached; intermediate nodes written while the HAMT
+	// is built are discarded.
 	//
 	// The number of DAGs cached will be at most equal to the given capacity. The capacity is
-	// immutable. DAGs are evicted as needed if the capacity is reached.
+	// immutable. DAGs are evicted as needed if the capacity is reached. Optionally, the total
+	// size of cached chunks can be limited to a number of bytes, in which case DAGs are also
+	// evicted as needed to stay within the limit.
 	//
-	// See: NewCachedEntriesChunker.
+	// The format of cached DAGs is persisted along with them. If the DAGs generated by the chunker
+	// are in a different format when the cache is restored, e.g. because the chunk size has
+	// changed, the cache is cleared instead. DAGs in a previous format can still be generated via
+	// CachedEntriesChunker.ChunkAs.
+	//
+	// See: NewCachedEntriesChunker, NewCachedEntriesChunkerWithMaxBytes.
 	CachedEntriesChunker struct {
+		// bytes is the total size of cached chunks in bytes, accessed atomically.
+		bytes int64
+		// len is the number of cached DAGs, accessed atomically so that it can be read while
+		// chunking is in progress.
+		len int64
+		// maxBytes is the maximum total size of cached chunks in bytes, or zero if unlimited.
+		maxBytes int64
 		// ds is the backing storage for the cached entry chunks and the caching metadata.
 		ds datastore.Batching
 		// lsys is used to store the IPLD representation of cached entry chunks.
@@ -72,6 +91,29 @@ type (
 		lock sync.Mutex
 		// chunker is the underlying chunker that generates a DAG from a provider.MultihashIterator.
 		chunker EntriesChunker
+		// pruneUnreachable specifies whether the chunks stored by chunker that are not reachable
+		// from the root of the generated DAG should be pruned from the cache. This is the case for
+		// HamtChunker, which stores intermediate versions of HAMT nodes as multihashes are inserted.
+		pruneUnreachable bool
+		// format is the format of DAGs generated by chunker, or empty if unknown. It is persisted
+		// along with cached DAGs in order to detect format changes across restarts.
+		format string
+		// previousFormat is the format of the DAGs cached prior to a format change detected upon
+		// instantiation, or empty if none.
+		previousFormat string
+	}
+
+	// CacheStats represents the usage of a CachedEntriesChunker.
+	CacheStats struct {
+		// Len is the number of cached DAGs.
+		Len int
+		// Cap is the maximum number of cached DAGs, or zero if unlimited.
+		Cap int
+		// Bytes is the total size of cached chunks in bytes. Chunks shared by overlapping DAGs
+		// are counted once.
+		Bytes int64
+		// MaxBytes is the maximum total size of cached chunks in bytes, or zero if unlimited.
+		MaxBytes int64
 	}
 
 	// NewChunkerFunc instantiates the core EntriesChunker to use for generating advertisement
@@ -116,10 +158,31 @@ type (
 //
 // See: CachedEntriesChunker.Chunk, CachedEntriesChunker.GetRawCachedChunk.
 func NewCachedEntriesChunker(ctx context.Context, ds datastore.Batching, capacity int, newChunker NewChunkerFunc, purge bool) (*CachedEntriesChunker, error) {
+	return NewCachedEntriesChunkerWithMaxBytes(ctx, ds, capacity, 0, newChunker, purge)
+}
+
+// NewCachedEntriesChunkerWithMaxBytes instantiates a new CachedEntriesChunker backed by a given
+// datastore, the total size of cached chunks of which is limited to maxBytes. A maxBytes of zero
+// means the size is unlimited, and a capacity of zero means the number of DAGs is unlimited.
+//
+// The size of cached chunks is the size of their serialized form, where chunks shared by
+// overlapping DAGs are counted once. When the size exceeds maxBytes, the least recently used DAGs
+// are evicted until it is within the limit. Similar to capacity, the most recently cached DAG is
+// never evicted, even if its size alone exceeds maxBytes. This is to avoid partial caching of
+// chunks within a single DAG.
+//
+// Note that the size of caching metadata is not counted towards maxBytes.
+//
+// See: NewCachedEntriesChunker.
+func NewCachedEntriesChunkerWithMaxBytes(ctx context.Context, ds datastore.Batching, capacity int, maxBytes int64, newChunker NewChunkerFunc, purge bool) (*CachedEntriesChunker, error) {
+	if maxBytes < 0 {
+		return nil, fmt.Errorf("max bytes must not be negative; got: %d", maxBytes)
+	}
 	ls := &CachedEntriesChunker{
-		ds:    ds,
-		lsys:  cidlink.DefaultLinkSystem(),
-		cache: lru.New(capacity),
+		ds:       ds,
+		lsys:     cidlink.DefaultLinkSystem(),
+		cache:    lru.New(capacity),
+		maxBytes: maxBytes,
 	}
 
 	ls.lsys.StorageReadOpener = ls.storageReadOpener
@@ -131,6 +194,16 @@ func NewCachedEntriesChunker(ctx context.Context, ds datastore.Batching, capacit
 		return nil, err
 	}
 	ls.chunker = chunker
+	_, ls.pruneUnreachable = chunker.(*HamtChunker)
+	ls.format = formatOf(chunker)
+
+	// Clear the cache if it was stored in a different format, since the cached DAGs would no
+	// longer be generated by the chunker.
+	if !purge {
+		if purge, err = ls.formatChanged(ctx); err != nil {
+			return nil, err
+		}
+	}
 
 	// If cache is to be cleared don't bother restoring it.
 	if purge {
@@ -170,8 +243,10 @@ func (ls *CachedEntriesChunker) storageWriteOpener(lctx linking.LinkContext) (io
 		err = ls.ds.Put(ctx, dsKey(lnk), buf.Bytes())
 		if err != nil {
 			log.Errorf("Could not put cache entry for key %s", lnk)
+			return err
 		}
-		return err
+		atomic.AddInt64(&ls.bytes, int64(buf.Len()))
+		return nil
 	}, nil
 }
 
@@ -200,34 +275,142 @@ func (ls *CachedEntriesChunker) onEvicted(k lru.Key, val interface{}) {
 		return
 	}
 	for _, link := range chunkLinks {
-		count, err := ls.countOverlap(ls.onEvictedCtx, link)
-		if err != nil {
+		if err := ls.releaseChunk(ls.onEvictedCtx, link); err != nil {
 			ls.onEvictedErr = err
 			return
 		}
+	}
+
+	// Prune the persisted cache key
+	err := ls.ds.Delete(ls.onEvictedCtx, ls.dsRootPrefixedKey(chunkRoot))
+	if err != nil {
+		log.Errorw("failed to prune persisted cache key after eviction", "err", err)
+		ls.onEvictedErr = err
+	}
+}
+
+// releaseChunk releases a single reference to the cached chunk with the given link, deleting the
+// chunk if it is not referenced by any other cached DAG.
+func (ls *CachedEntriesChunker) releaseChunk(ctx context.Context, link ipld.Link) error {
+	count, err := ls.countOverlap(ctx, link)
+	if err != nil {
+		return err
+	}
+	if count != 0 {
+		return ls.decrementOverlap(ctx, link)
+	}
+
+	size, err := ls.ds.GetSize(ctx, dsKey(link))
+	if err != nil && err != datastore.ErrNotFound {
+		log.Errorw("failed to get size of cache", "key", link, "err", err)
+		return err
+	}
+	if err := ls.ds.Delete(ctx, dsKey(link)); err != nil {
+		log.Errorw("failed to delete cache", "key", link, "err", err)
+		return err
+	}
+	if size > 0 {
+		atomic.AddInt64(&ls.bytes, -int64(size))
+	}
+	return nil
+}
 
-		if count == 0 {
-			if err := ls.ds.Delete(ls.onEvictedCtx, dsKey(link)); err != nil {
-				log.Errorw("failed to delete cache", "key", link, "err", err)
-				ls.onEvictedErr = err
-				return
+// pruneLinks releases the references to the chunks in the given links that are either not
+// reachable from the given root, or are duplicates of the links that precede them, and returns the
+// remaining links. The links are the ones stored while generating the DAG with the given root, one
+// per stored chunk, each of which holds a reference to the chunk.
+func (ls *CachedEntriesChunker) pruneLinks(ctx context.Context, root ipld.Link, links []ipld.Link) ([]ipld.Link, error) {
+	reachable, err := ls.listReachable(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	pruned := make([]ipld.Link, 0, len(reachable))
+	kept := make(map[ipld.Link]struct{}, len(reachable))
+	for _, link := range links {
+		if _, ok := reachable[link]; ok {
+			if _, ok := kept[link]; !ok {
+				kept[link] = struct{}{}
+				pruned = append(pruned, link)
+				continue
 			}
-			continue
 		}
+		if err := ls.releaseChunk(ctx, link); err != nil {
+			return nil, err
+		}
+	}
+	return pruned, nil
+}
 
-		err = ls.decrementOverlap(ls.onEvictedCtx, link)
+// listReachable traverses the cached DAG with the given root, and returns the links to all the
+// chunks that make up the DAG, including the root. An error is returned if any of the chunks is
+// not cached.
+func (ls *CachedEntriesChunker) listReachable(ctx context.Context, root ipld.Link) (map[ipld.Link]struct{}, error) {
+	reachable := map[ipld.Link]struct{}{root: {}}
+	pending := []ipld.Link{root}
+	for len(pending) != 0 {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		next := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		n, err := ls.lsys.Load(ipld.LinkContext{Ctx: ctx}, next, basicnode.Prototype.Any)
 		if err != nil {
-			ls.onEvictedErr = err
-			return
+			return nil, fmt.Errorf("cannot load cached chunk %s: %w", next, err)
+		}
+		err = forEachLink(n, func(l ipld.Link) {
+			if _, ok := reachable[l]; !ok {
+				reachable[l] = struct{}{}
+				pending = append(pending, l)
+			}
+		})
+		if err != nil {
+			return nil, err
 		}
 	}
+	return reachable, nil
+}
 
-	// Prune the persisted cache key
-	err := ls.ds.Delete(ls.onEvictedCtx, ls.dsRootPrefixedKey(chunkRoot))
-	if err != nil {
-		log.Errorw("failed to prune persisted cache key after eviction", "err", err)
-		ls.onEvictedErr = err
+// forEachLink calls f with every link in the given node, recursively.
+func forEachLink(n ipld.Node, f func(ipld.Link)) error {
+	switch n.Kind() {
+	case datamodel.Kind_Link:
+		l, err := n.AsLink()
+		if err != nil {
+			return err
+		}
+		f(l)
+	case datamodel.Kind_Map:
+		mi := n.MapIterator()
+		for !mi.Done() {
+			_, v, err := mi.Next()
+			if err != nil {
+				return err
+			}
+			if err := forEachLink(v, f); err != nil {
+				return err
+			}
+		}
+	case datamodel.Kind_List:
+		li := n.ListIterator()
+		for !li.Done() {
+			_, v, err := li.Next()
+			if err != nil {
+				return err
+			}
+			if err := forEachLink(v, f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func encodeLinks(links []ipld.Link) []byte {
+	var linksEnc []byte
+	for _, link := range links {
+		linksEnc = append(linksEnc, link.(cidlink.Link).Cid.Bytes()...)
 	}
+	return linksEnc
 }
 
 func dsKey(l ipld.Link) datastore.Key {
@@ -236,6 +419,45 @@ func dsKey(l ipld.Link) datastore.Key {
 
 // Chunk chunks the multihashes supplied by the given mhi into a DAG and returns the link to root.
 func (ls *CachedEntriesChunker) Chunk(ctx context.Context, mhi provider.MultihashIterator) (ipld.Link, error) {
+	return ls.chunk(ctx, ls.chunker, ls.pruneUnreachable, mhi)
+}
+
+// ChunkAs chunks the multihashes supplied by the given mhi into a DAG in the given format, instead
+// of the format of the underlying chunker, and returns the link to root. This allows DAGs generated
+// in a previous format to be regenerated identically after the format has changed.
+//
+// The format must be one returned by CachedEntriesChunker.Format. An empty format is equivalent to
+// the format of the underlying chunker.
+func (ls *CachedEntriesChunker) ChunkAs(ctx context.Context, format string, mhi provider.MultihashIterator) (ipld.Link, error) {
+	if format == "" || format == ls.format {
+		return ls.Chunk(ctx, mhi)
+	}
+	newChunker, err := newChunkerFuncOf(format)
+	if err != nil {
+		return nil, err
+	}
+	chunker, err := newChunker(&ls.lsys)
+	if err != nil {
+		return nil, err
+	}
+	_, pruneUnreachable := chunker.(*HamtChunker)
+	return ls.chunk(ctx, chunker, pruneUnreachable, mhi)
+}
+
+// Format returns the format of DAGs generated by CachedEntriesChunker.Chunk, or empty string if
+// the format of the underlying chunker is unknown.
+func (ls *CachedEntriesChunker) Format() string {
+	return ls.format
+}
+
+// PreviousFormat returns the format of the DAGs that were cached prior to a change of format,
+// detected when the CachedEntriesChunker was instantiated, or empty string if the format did not
+// change.
+func (ls *CachedEntriesChunker) PreviousFormat() string {
+	return ls.previousFormat
+}
+
+func (ls *CachedEntriesChunker) chunk(ctx context.Context, chunker EntriesChunker, pruneUnreachable bool, mhi provider.MultihashIterator) (ipld.Link, error) {
 	ls.lock.Lock()
 	defer func() {
 		ls.lsys.StorageWriteOpener = ls.storageWriteOpener
@@ -243,7 +465,6 @@ func (ls *CachedEntriesChunker) Chunk(ctx context.Context, mhi provider.Multihas
 	}()
 
 	var links []ipld.Link
-	var linksEnc []byte
 	// Intercept the links that are being stored.
 	// It is safe to swap the StorageWriteOpener, because:
 	//  - Chunk is the only place we expect to write to the linksystem, and
@@ -257,29 +478,95 @@ func (ls *CachedEntriesChunker) Chunk(ctx context.Context, mhi provider.Multihas
 		}
 		return opener, func(link datamodel.Link) error {
 			links = append(links, link)
-			linksEnc = append(linksEnc, link.(cidlink.Link).Cid.Bytes()...)
 			return committer(link)
 		}, nil
 	}
 
 	// Store the multihashes in mhi as a DAG and get the root link.
-	root, err := ls.chunker.Chunk(ctx, mhi)
+	root, err := chunker.Chunk(ctx, mhi)
 	if err != nil {
 		return nil, err
 	}
 
+	// If the DAG is already cached, release the references held by the chunks just stored, since
+	// they are already held by the cached DAG.
+	var cached bool
+	err = ls.performOnCache(ctx, func(cache *lru.Cache) {
+		_, cached = cache.Get(root)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cached {
+		for _, link := range links {
+			if err := ls.releaseChunk(ctx, link); err != nil {
+				return nil, err
+			}
+		}
+		return root, ls.sync(ctx)
+	}
+
+	if pruneUnreachable {
+		if links, err = ls.pruneLinks(ctx, root, links); err != nil {
+			return nil, err
+		}
+	}
+
 	// Store internal mappings for caching purposes.
-	err = ls.performOnCache(ctx, func(cache *lru.Cache) { cache.Add(root, links) })
+	err = ls.performOnCache(ctx, func(cache *lru.Cache) {
+		cache.Add(root, links)
+		ls.evictToMaxBytes(cache)
+	})
 	if err != nil {
 		return nil, err
 	}
-	err = ls.ds.Put(ctx, ls.dsRootPrefixedKey(root), linksEnc)
+	err = ls.ds.Put(ctx, ls.dsRootPrefixedKey(root), encodeLinks(links))
 	if err != nil {
 		return nil, err
 	}
+	if ls.format != "" {
+		if err = ls.ds.Put(ctx, formatKey, []byte(ls.format)); err != nil {
+			return nil, err
+		}
+	}
 	return root, ls.sync(ctx)
 }
 
+// formatChanged checks whether the DAGs cached in the datastore were generated in a different
+// format than the one generated by the chunker, e.g. because the configured entries format has
+// changed between restarts. A non-empty cache with no recorded format is considered changed,
+// unless the format of the chunker is unknown.
+func (ls *CachedEntriesChunker) formatChanged(ctx context.Context) (bool, error) {
+	stored, err := ls.ds.Get(ctx, formatKey)
+	if err == datastore.ErrNotFound {
+		if ls.format == "" {
+			return false, nil
+		}
+		results, err := ls.ds.Query(ctx, dsq.Query{Prefix: rootKeyPrefix.String(), KeysOnly: true, Limit: 1})
+		if err != nil {
+			return false, err
+		}
+		entries, err := results.Rest()
+		if err != nil {
+			return false, err
+		}
+		if len(entries) == 0 {
+			return false, nil
+		}
+		log.Infow("Cached DAGs have no recorded format; clearing cache", "format", ls.format)
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot get cached DAGs format: %w", err)
+	}
+	if string(stored) == ls.format {
+		return false, nil
+	}
+	ls.previousFormat = string(stored)
+	log.Infow("Cached DAGs were generated in a different format; clearing cache", "cachedFormat", string(stored), "format", ls.format)
+	return true, nil
+}
+
 func (ls *CachedEntriesChunker) sync(ctx context.Context) error {
 	return ls.ds.Sync(ctx, datastore.NewKey("/"))
 }
@@ -296,6 +583,16 @@ func (ls *CachedEntriesChunker) GetRawCachedChunk(ctx context.Context, l ipld.Li
 	return raw, nil
 }
 
+// Release removes the DAG with the given root from the cache, deleting the chunks that are not
+// referenced by any other cached DAG. It is a no-op if the DAG is not cached.
+func (ls *CachedEntriesChunker) Release(ctx context.Context, root ipld.Link) error {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+	return ls.performOnCache(ctx, func(cache *lru.Cache) {
+		cache.Remove(root)
+	})
+}
+
 // Clear purges all stored items from the CachedEntriesChunker.
 func (ls *CachedEntriesChunker) Clear(ctx context.Context) error {
 	ls.lock.Lock()
@@ -335,6 +632,7 @@ func (ls *CachedEntriesChunker) Clear(ctx context.Context) error {
 			return err
 		}
 	}
+	atomic.StoreInt64(&ls.bytes, 0)
 	log.Info("Cleared the cache successfully")
 	return nil
 }
@@ -362,6 +660,8 @@ func (ls *CachedEntriesChunker) restoreCache(ctx context.Context) error {
 
 	// For each root key
 	var count int
+	// Track the chunks whose size is counted, since overlapping chains share chunks.
+	sized := make(map[ipld.Link]struct{})
 	for r := range results.Next() {
 		if ctx.Err() != nil {
 			return ctx.Err()
@@ -401,8 +701,43 @@ func (ls *CachedEntriesChunker) restoreCache(ctx context.Context) error {
 			return err
 		}
 
+		// Count the size of chunks not counted already. A missing chunk means the cache is
+		// corrupt, in which case the error causes the cache to be cleared.
+		for _, link := range links {
+			if _, ok := sized[link]; ok {
+				continue
+			}
+			size, err := ls.ds.GetSize(ctx, dsKey(link))
+			if err != nil {
+				return fmt.Errorf("cannot get size of cached chunk %s: %w", link, err)
+			}
+			atomic.AddInt64(&ls.bytes, int64(size))
+			sized[link] = struct{}{}
+		}
+
+		// Prune the chunks of HAMTs that are not reachable from their root, e.g. cached prior to
+		// unreachable chunks being pruned. This also verifies that the entire HAMT is cached. Note
+		// that pruning takes place once the size of chunks is counted, since the size of deleted
+		// chunks is subtracted.
+		if ls.pruneUnreachable {
+			pruned, err := ls.pruneLinks(ctx, l, links)
+			if err != nil {
+				return err
+			}
+			if len(pruned) != len(links) {
+				log.Infow("Pruned unreachable chunks of cached DAG", "root", l, "count", len(links)-len(pruned))
+				if err := ls.ds.Put(ctx, rawKey, encodeLinks(pruned)); err != nil {
+					return err
+				}
+				links = pruned
+			}
+		}
+
 		// Update in memory cache with root link and its list of links
-		err = ls.performOnCache(ctx, func(cache *lru.Cache) { cache.Add(l, links) })
+		err = ls.performOnCache(ctx, func(cache *lru.Cache) {
+			cache.Add(l, links)
+			ls.evictToMaxBytes(cache)
+		})
 		if err != nil {
 			return err
 		}
@@ -444,14 +779,14 @@ func (ls *CachedEntriesChunker) restoreCache(ctx context.Context) error {
 		if prunedCount != 0 {
 			log.Infow("No caching metadata is persisted but datastore is non-empty; pruned lingering cache entries", "count", prunedCount)
 		}
-	} else if ls.Cap() < count {
-		// If the cache capacity was too small to restore all entries present, it means cache was
-		// evicted during restore and records were pruned as needed.
+	} else if ls.Len() < count {
+		// If the cache capacity or max bytes was too small to restore all entries present, it
+		// means cache was evicted during restore and records were pruned as needed.
 		//
 		// Log an informative message to let the user know.
-		log.Infow("Cache capacity is smaller than previously persisted cache; pruned persisted cache.", "persistedCacheCount", count, "capacity", ls.cache.MaxEntries)
+		log.Infow("Cache capacity is smaller than previously persisted cache; pruned persisted cache.", "persistedCacheCount", count, "capacity", ls.cache.MaxEntries, "maxBytes", ls.maxBytes)
 	} else {
-		log.Debugw("Cache restored successfully", "restoredCacheCount", ls.Len(), "capacity", ls.Cap())
+		log.Debugw("Cache restored successfully", "restoredCacheCount", ls.Len(), "capacity", ls.Cap(), "bytes", atomic.LoadInt64(&ls.bytes))
 	}
 
 	return nil
@@ -467,10 +802,24 @@ func (ls *CachedEntriesChunker) performOnCache(ctx context.Context, action func(
 		ls.onEvictedErr = nil
 	}()
 	action(ls.cache)
+	atomic.StoreInt64(&ls.len, int64(ls.cache.Len()))
 	err := ls.onEvictedErr
 	return err
 }
 
+// evictToMaxBytes evicts the least recently used DAGs from the given cache until the total size
+// of cached chunks is within maxBytes, if set. The most recently used DAG is never evicted.
+//
+// It must only be called as part of an action passed to performOnCache.
+func (ls *CachedEntriesChunker) evictToMaxBytes(cache *lru.Cache) {
+	if ls.maxBytes == 0 {
+		return
+	}
+	for atomic.LoadInt64(&ls.bytes) > ls.maxBytes && cache.Len() > 1 && ls.onEvictedErr == nil {
+		cache.RemoveOldest()
+	}
+}
+
 // Cap returns the maximum number of chained entries chunks this cache stores.
 //
 // Note, the maximum number refers to the number of chains as a unit and not the total sum of
@@ -489,6 +838,16 @@ func (ls *CachedEntriesChunker) Len() int {
 	return ls.cache.Len()
 }
 
+// Stats returns the current usage of the cache. It does not block on chunking in progress.
+func (ls *CachedEntriesChunker) Stats() CacheStats {
+	return CacheStats{
+		Len:      int(atomic.LoadInt64(&ls.len)),
+		Cap:      ls.cache.MaxEntries,
+		Bytes:    atomic.LoadInt64(&ls.bytes),
+		MaxBytes: ls.maxBytes,
+	}
+}
+
 func (ls *CachedEntriesChunker) dsRootPrefixedKey(l ipld.Link) datastore.Key {
 	return rootKeyPrefix.Child(dsKey(l))
 }
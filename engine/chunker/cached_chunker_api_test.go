@@ -21,3 +21,8 @@ func (ls *CachedEntriesChunker) LinkSystem() ipld.LinkSystem {
 func RootPrefixedDSKey(l ipld.Link) datastore.Key {
 	return rootKeyPrefix.Child(dsKey(l))
 }
+
+// ListReachable is exposed for testing purposes only.
+func (ls *CachedEntriesChunker) ListReachable(ctx context.Context, root ipld.Link) (map[ipld.Link]struct{}, error) {
+	return ls.listReachable(ctx, root)
+}
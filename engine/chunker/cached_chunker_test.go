@@ -6,6 +6,7 @@ import (
 	"io"
 	"math"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,11 +15,13 @@ import (
 	"github.com/filecoin-project/index-provider/testutil"
 	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
 	dssync "github.com/ipfs/go-datastore/sync"
 	hamt "github.com/ipld/go-ipld-adl-hamt"
 	"github.com/ipld/go-ipld-prime"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	ipldcodec "github.com/ipld/go-ipld-prime/multicodec"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/ipld/go-ipld-prime/node/bindnode"
 	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-multihash"
@@ -76,6 +79,8 @@ func TestCachedEntriesChunker(t *testing.T) {
 		c        chunker.NewChunkerFunc
 	}{
 		{42, chunker.NewChainChunkerFunc(10)},
+		{42, chunker.NewParallelChainChunkerFunc(10, 4)},
+		{42, chunker.NewSortedChainChunkerFunc(10)},
 		{42, chunker.NewHamtChunkerFunc(multicodec.Murmur3X64_64, 3, 1)},
 	}
 	for _, test := range tests {
@@ -466,6 +471,321 @@ func testCachedEntriesChunker_OldFormatIsHandledGracefully(t *testing.T, capacit
 	require.Equal(t, 0, subject.Len())
 }
 
+func TestCachedEntriesChunker_MaxBytes(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	store := dssync.MutexWrap(datastore.NewMapDatastore())
+
+	// Learn the size of a DAG with 2 full chunks using an unbounded cache.
+	probe, err := chunker.NewCachedEntriesChunker(ctx, datastore.NewMapDatastore(), 0, chunker.NewChainChunkerFunc(10), false)
+	require.NoError(t, err)
+	probeLnk, err := probe.Chunk(ctx, provider.SliceMultihashIterator(testutil.RandomMultihashes(t, rng, 20)))
+	require.NoError(t, err)
+	dagSize := requireChainSize(t, probe, probeLnk)
+	require.Equal(t, dagSize, probe.Stats().Bytes)
+
+	// Allow two and a half DAGs worth of bytes, with no limit on the number of DAGs.
+	maxBytes := dagSize * 5 / 2
+	subject, err := chunker.NewCachedEntriesChunkerWithMaxBytes(ctx, store, 0, maxBytes, chunker.NewChainChunkerFunc(10), false)
+	require.NoError(t, err)
+	defer subject.Close()
+	require.Equal(t, chunker.CacheStats{MaxBytes: maxBytes}, subject.Stats())
+
+	var lnks []ipld.Link
+	var mhs [][]multihash.Multihash
+	for i := 0; i < 3; i++ {
+		mhs = append(mhs, testutil.RandomMultihashes(t, rng, 20))
+		lnk, err := subject.Chunk(ctx, provider.SliceMultihashIterator(mhs[i]))
+		require.NoError(t, err)
+		lnks = append(lnks, lnk)
+	}
+	// Assert the least recently used DAG is evicted to stay within max bytes.
+	requireChunkIsNotCached(t, subject, lnks[0])
+	requireChunkIsCached(t, subject, lnks[1:]...)
+	stats := subject.Stats()
+	require.Equal(t, 2, stats.Len)
+	require.Equal(t, requireChainSize(t, subject, lnks[1])+requireChainSize(t, subject, lnks[2]), stats.Bytes)
+	require.LessOrEqual(t, stats.Bytes, maxBytes)
+
+	// Cache a DAG that overlaps with the most recent one, and assert the overlapping chunks are
+	// counted once.
+	overlapping := append(mhs[2], testutil.RandomMultihashes(t, rng, 5)...)
+	overlappingLnk, err := subject.Chunk(ctx, provider.SliceMultihashIterator(overlapping))
+	require.NoError(t, err)
+	requireChunkIsCached(t, subject, lnks[1], lnks[2], overlappingLnk)
+	overlappingChain := listEntriesChain(t, subject, overlappingLnk)
+	require.Len(t, overlappingChain, 3)
+	requireOverlapCount(t, subject, 1, overlappingChain[1:]...)
+	stats = subject.Stats()
+	require.Equal(t, 3, stats.Len)
+	require.Equal(t, requireChainSize(t, subject, lnks[1])+requireChainSize(t, subject, overlappingLnk), stats.Bytes)
+
+	// Cache a new DAG and assert the least recently used one is evicted.
+	newLnk, err := subject.Chunk(ctx, provider.SliceMultihashIterator(testutil.RandomMultihashes(t, rng, 20)))
+	require.NoError(t, err)
+	requireChunkIsNotCached(t, subject, lnks[1])
+	requireChunkIsCached(t, subject, lnks[2], overlappingLnk, newLnk)
+	stats = subject.Stats()
+	require.Equal(t, 3, stats.Len)
+	require.Equal(t, requireChainSize(t, subject, overlappingLnk)+requireChainSize(t, subject, newLnk), stats.Bytes)
+
+	// Cache another new DAG and assert that evicting the least recently used DAG does not free
+	// any bytes since its chunks overlap with the next one, which is therefore evicted too.
+	anotherLnk, err := subject.Chunk(ctx, provider.SliceMultihashIterator(testutil.RandomMultihashes(t, rng, 20)))
+	require.NoError(t, err)
+	requireChunkIsNotCached(t, subject, lnks[2], overlappingLnk)
+	requireChunkIsCached(t, subject, newLnk, anotherLnk)
+	requireOverlapCount(t, subject, 0, overlappingChain...)
+	stats = subject.Stats()
+	require.Equal(t, 2, stats.Len)
+	require.Equal(t, requireChainSize(t, subject, newLnk)+requireChainSize(t, subject, anotherLnk), stats.Bytes)
+	require.LessOrEqual(t, stats.Bytes, maxBytes)
+
+	// Assert the size is restored.
+	require.NoError(t, subject.Close())
+	subject, err = chunker.NewCachedEntriesChunkerWithMaxBytes(ctx, store, 0, maxBytes, chunker.NewChainChunkerFunc(10), false)
+	require.NoError(t, err)
+	require.Equal(t, stats, subject.Stats())
+
+	// Assert the size is restored within a smaller max bytes, by evicting as needed.
+	require.NoError(t, subject.Close())
+	subject, err = chunker.NewCachedEntriesChunkerWithMaxBytes(ctx, store, 0, 1, chunker.NewChainChunkerFunc(10), false)
+	require.NoError(t, err)
+	stats = subject.Stats()
+	require.Equal(t, 1, stats.Len)
+	require.Greater(t, stats.Bytes, int64(1))
+
+	// Assert a single DAG larger than max bytes is cached in full.
+	bigMhs := testutil.RandomMultihashes(t, rng, 50)
+	bigLnk, err := subject.Chunk(ctx, provider.SliceMultihashIterator(bigMhs))
+	require.NoError(t, err)
+	requireChunkEntriesMatch(t, requireDecodeAllMultihashes(t, bigLnk, subject.LinkSystem()), bigMhs)
+	stats = subject.Stats()
+	require.Equal(t, 1, stats.Len)
+	require.Equal(t, requireChainSize(t, subject, bigLnk), stats.Bytes)
+
+	require.NoError(t, subject.Clear(ctx))
+	require.Equal(t, chunker.CacheStats{MaxBytes: 1}, subject.Stats())
+}
+
+func TestCachedEntriesChunker_Hamt_OverlapAndEviction(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	store := dssync.MutexWrap(datastore.NewMapDatastore())
+	newHamtChunker := chunker.NewHamtChunkerFunc(multicodec.Murmur3X64_64, 3, 1)
+
+	subject, err := chunker.NewCachedEntriesChunker(ctx, store, 2, newHamtChunker, false)
+	require.NoError(t, err)
+	defer subject.Close()
+
+	// Assert that only the chunks reachable from the root of HAMT are cached, even though the
+	// intermediate versions of HAMT nodes are stored while multihashes are inserted.
+	h1Mhs := testutil.RandomMultihashes(t, rng, 50)
+	h1Lnk, err := subject.Chunk(ctx, provider.SliceMultihashIterator(h1Mhs))
+	require.NoError(t, err)
+	requireChunkEntriesMatch(t, requireDecodeAllMultihashes(t, h1Lnk, subject.LinkSystem()), h1Mhs)
+	h1Chunks := requireOnlyReachableChunksAreCached(t, subject, store, h1Lnk)
+	require.Equal(t, requireChunksSize(t, subject, h1Chunks), subject.Stats().Bytes)
+
+	// Assert that caching the same HAMT again is no-op.
+	h1LnkAgain, err := subject.Chunk(ctx, provider.SliceMultihashIterator(h1Mhs))
+	require.NoError(t, err)
+	require.Equal(t, h1Lnk, h1LnkAgain)
+	require.Equal(t, 1, subject.Len())
+	requireOverlapCount(t, subject, 0, h1Chunks...)
+
+	// Cache a HAMT that shares all but one multihash with the first, and assert that the shared
+	// chunks are counted once.
+	h2Mhs := append(h1Mhs[:len(h1Mhs):len(h1Mhs)], testutil.RandomMultihashes(t, rng, 1)...)
+	h2Lnk, err := subject.Chunk(ctx, provider.SliceMultihashIterator(h2Mhs))
+	require.NoError(t, err)
+	require.Equal(t, 2, subject.Len())
+	requireChunkEntriesMatch(t, requireDecodeAllMultihashes(t, h2Lnk, subject.LinkSystem()), h2Mhs)
+	requireOnlyReachableChunksAreCached(t, subject, store, h1Lnk, h2Lnk)
+	h2Chunks, err := subject.ListReachable(ctx, h2Lnk)
+	require.NoError(t, err)
+	var shared []ipld.Link
+	for _, l := range h1Chunks {
+		if _, ok := h2Chunks[l]; ok {
+			shared = append(shared, l)
+		}
+	}
+	require.NotEmpty(t, shared)
+	requireOverlapCount(t, subject, 1, shared...)
+
+	// Assert that evicting the first HAMT retains the chunks shared with the second.
+	h3Lnk, err := subject.Chunk(ctx, provider.SliceMultihashIterator(testutil.RandomMultihashes(t, rng, 20)))
+	require.NoError(t, err)
+	requireChunkIsNotCached(t, subject, h1Lnk)
+	requireChunkEntriesMatch(t, requireDecodeAllMultihashes(t, h2Lnk, subject.LinkSystem()), h2Mhs)
+	requireOverlapCount(t, subject, 0, shared...)
+	h3Chunks := requireOnlyReachableChunksAreCached(t, subject, store, h2Lnk, h3Lnk)
+	require.Equal(t, requireChunksSize(t, subject, h3Chunks), subject.Stats().Bytes)
+
+	// Append a chunk that is not reachable from the root of the second HAMT to its cached links,
+	// mimicking the cache of HAMTs prior to unreachable chunks being pruned, and assert that it is
+	// pruned on restore.
+	lsys := subject.LinkSystem()
+	unreachableLnk, err := lsys.Store(ipld.LinkContext{Ctx: ctx}, schema.Linkproto, basicnode.NewString("fish"))
+	require.NoError(t, err)
+	h2RootKey := chunker.RootPrefixedDSKey(h2Lnk)
+	h2Links, err := store.Get(ctx, h2RootKey)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(ctx, h2RootKey, append(h2Links, unreachableLnk.(cidlink.Link).Cid.Bytes()...)))
+	require.NoError(t, subject.Close())
+
+	subject, err = chunker.NewCachedEntriesChunker(ctx, store, 2, newHamtChunker, false)
+	require.NoError(t, err)
+	require.Equal(t, 2, subject.Len())
+	requireChunkIsNotCached(t, subject, unreachableLnk)
+	gotH2Links, err := store.Get(ctx, h2RootKey)
+	require.NoError(t, err)
+	require.Equal(t, h2Links, gotH2Links)
+	requireOnlyReachableChunksAreCached(t, subject, store, h2Lnk, h3Lnk)
+	require.Equal(t, requireChunksSize(t, subject, h3Chunks), subject.Stats().Bytes)
+}
+
+func TestCachedEntriesChunker_FormatChangeClearsCache(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	store := dssync.MutexWrap(datastore.NewMapDatastore())
+	mhs := testutil.RandomMultihashes(t, rng, 10)
+
+	newCachedChunker := func(newChunker chunker.NewChunkerFunc) *chunker.CachedEntriesChunker {
+		subject, err := chunker.NewCachedEntriesChunker(ctx, store, 10, newChunker, false)
+		require.NoError(t, err)
+		return subject
+	}
+
+	subject := newCachedChunker(chunker.NewChainChunkerFunc(3))
+	chainLnk, err := subject.Chunk(ctx, provider.SliceMultihashIterator(mhs))
+	require.NoError(t, err)
+	require.NoError(t, subject.Close())
+
+	// Assert that the cache is restored when chunks are encoded in parallel, since the generated
+	// chains are identical.
+	subject = newCachedChunker(chunker.NewParallelChainChunkerFunc(3, 2))
+	require.Equal(t, 1, subject.Len())
+	require.NoError(t, subject.Close())
+
+	// Assert that the cache is cleared when the chunk size changes.
+	subject = newCachedChunker(chunker.NewChainChunkerFunc(4))
+	require.Equal(t, 0, subject.Len())
+	require.Zero(t, subject.Stats().Bytes)
+	chunk, err := subject.GetRawCachedChunk(ctx, chainLnk)
+	require.NoError(t, err)
+	require.Nil(t, chunk)
+	_, err = subject.Chunk(ctx, provider.SliceMultihashIterator(mhs))
+	require.NoError(t, err)
+	require.NoError(t, subject.Close())
+
+	// Assert that the cache is cleared when the format changes to HAMT, and only the HAMT remains
+	// cached.
+	subject = newCachedChunker(chunker.NewHamtChunkerFunc(multicodec.Murmur3X64_64, 3, 1))
+	require.Equal(t, 0, subject.Len())
+	hamtLnk, err := subject.Chunk(ctx, provider.SliceMultihashIterator(mhs))
+	require.NoError(t, err)
+	requireOnlyReachableChunksAreCached(t, subject, store, hamtLnk)
+	require.NoError(t, subject.Close())
+
+	subject = newCachedChunker(chunker.NewHamtChunkerFunc(multicodec.Murmur3X64_64, 3, 1))
+	require.Equal(t, 1, subject.Len())
+	require.NoError(t, subject.Close())
+
+	// Assert that a cache with no recorded format is cleared.
+	require.NoError(t, store.Delete(ctx, datastore.NewKey("format")))
+	subject = newCachedChunker(chunker.NewHamtChunkerFunc(multicodec.Murmur3X64_64, 3, 1))
+	defer subject.Close()
+	require.Equal(t, 0, subject.Len())
+	entries, err := store.Query(ctx, query.Query{KeysOnly: true})
+	require.NoError(t, err)
+	keys, err := entries.Rest()
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
+
+func TestCachedEntriesChunker_Release(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	store := dssync.MutexWrap(datastore.NewMapDatastore())
+	subject, err := chunker.NewCachedEntriesChunker(ctx, store, 10, chunker.NewChainChunkerFunc(10), false)
+	require.NoError(t, err)
+	defer subject.Close()
+
+	// Cache two overlapping chains.
+	c1Mhs := testutil.RandomMultihashes(t, rng, 20)
+	c1Lnk, err := subject.Chunk(ctx, provider.SliceMultihashIterator(c1Mhs))
+	require.NoError(t, err)
+	c1Chain := listEntriesChain(t, subject, c1Lnk)
+	c2Mhs := append(testutil.RandomMultihashes(t, rng, 10), c1Mhs...)
+	c2Lnk, err := subject.Chunk(ctx, provider.SliceMultihashIterator(c2Mhs))
+	require.NoError(t, err)
+	c2Chain := listEntriesChain(t, subject, c2Lnk)
+	require.Equal(t, 2, subject.Len())
+
+	// Assert that releasing a chain keeps the chunks shared with the other chain.
+	require.NoError(t, subject.Release(ctx, c2Lnk))
+	require.Equal(t, 1, subject.Len())
+	requireChunkIsNotCached(t, subject, c2Chain[0])
+	requireChunkIsCached(t, subject, c1Chain...)
+	requireOnlyReachableChunksAreCached(t, subject, store, c1Lnk)
+	require.Equal(t, requireChunksSize(t, subject, c1Chain), subject.Stats().Bytes)
+
+	// Assert that releasing a chain that is not cached is a no-op.
+	require.NoError(t, subject.Release(ctx, c2Lnk))
+	require.Equal(t, 1, subject.Len())
+}
+
+func TestCachedEntriesChunker_ChunkAs(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	store := dssync.MutexWrap(datastore.NewMapDatastore())
+	mhs := testutil.RandomMultihashes(t, rng, 10)
+
+	chainChunker, err := chunker.NewCachedEntriesChunker(ctx, store, 10, chunker.NewChainChunkerFunc(3), false)
+	require.NoError(t, err)
+	chainFormat := chainChunker.Format()
+	require.Equal(t, "chain/3", chainFormat)
+	chainLnk, err := chainChunker.Chunk(ctx, provider.SliceMultihashIterator(mhs))
+	require.NoError(t, err)
+	require.NoError(t, chainChunker.Close())
+
+	subject, err := chunker.NewCachedEntriesChunker(ctx, store, 10, chunker.NewHamtChunkerFunc(multicodec.Murmur3X64_64, 3, 1), false)
+	require.NoError(t, err)
+	defer subject.Close()
+	hamtFormat := subject.Format()
+	require.Equal(t, "hamt/murmur3-x64-64/3/1", hamtFormat)
+
+	// Assert that DAGs are regenerated identically in their previous format, and are cached.
+	gotLnk, err := subject.ChunkAs(ctx, chainFormat, provider.SliceMultihashIterator(mhs))
+	require.NoError(t, err)
+	require.Equal(t, chainLnk, gotLnk)
+	requireOnlyReachableChunksAreCached(t, subject, store, chainLnk)
+
+	// Assert that the format of the underlying chunker, or no format, is equivalent to Chunk.
+	hamtLnk, err := subject.Chunk(ctx, provider.SliceMultihashIterator(mhs))
+	require.NoError(t, err)
+	for _, format := range []string{hamtFormat, ""} {
+		gotLnk, err = subject.ChunkAs(ctx, format, provider.SliceMultihashIterator(mhs))
+		require.NoError(t, err)
+		require.Equal(t, hamtLnk, gotLnk)
+	}
+	require.Equal(t, 2, subject.Len())
+
+	_, err = subject.ChunkAs(ctx, "fish/3", provider.SliceMultihashIterator(mhs))
+	require.EqualError(t, err, `unknown entries format: "fish/3"`)
+}
+
+func TestNewCachedEntriesChunkerWithMaxBytes_FailsOnNegativeMaxBytes(t *testing.T) {
+	_, err := chunker.NewCachedEntriesChunkerWithMaxBytes(context.Background(), datastore.NewMapDatastore(), 1, -1, chunker.NewChainChunkerFunc(10), false)
+	require.EqualError(t, err, "max bytes must not be negative; got: -1")
+}
+
 func requireChunkIsCached(t *testing.T, e *chunker.CachedEntriesChunker, l ...ipld.Link) {
 	for _, link := range l {
 		chunk, err := e.GetRawCachedChunk(context.TODO(), link)
@@ -575,3 +895,56 @@ func requireOverlapCount(t *testing.T, s *chunker.CachedEntriesChunker, want uin
 		require.Equal(t, want, got)
 	}
 }
+
+// requireOnlyReachableChunksAreCached asserts that the chunks cached in the given store are exactly
+// the ones reachable from the given roots, and returns them.
+func requireOnlyReachableChunksAreCached(t *testing.T, s *chunker.CachedEntriesChunker, store datastore.Datastore, roots ...ipld.Link) []ipld.Link {
+	want := make(map[string]struct{})
+	var links []ipld.Link
+	for _, root := range roots {
+		reachable, err := s.ListReachable(context.TODO(), root)
+		require.NoError(t, err)
+		for l := range reachable {
+			if _, ok := want[dsKey(l)]; !ok {
+				want[dsKey(l)] = struct{}{}
+				links = append(links, l)
+			}
+		}
+	}
+	results, err := store.Query(context.TODO(), query.Query{KeysOnly: true})
+	require.NoError(t, err)
+	entries, err := results.Rest()
+	require.NoError(t, err)
+	got := make(map[string]struct{})
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Key, "/root/") && !strings.HasPrefix(e.Key, "/overlap/") && e.Key != "/format" {
+			got[e.Key] = struct{}{}
+		}
+	}
+	require.Equal(t, want, got)
+	return links
+}
+
+func dsKey(l ipld.Link) string {
+	return datastore.NewKey(l.(cidlink.Link).Cid.String()).String()
+}
+
+func requireChunksSize(t *testing.T, e *chunker.CachedEntriesChunker, links []ipld.Link) int64 {
+	var size int64
+	for _, l := range links {
+		raw, err := e.GetRawCachedChunk(context.TODO(), l)
+		require.NoError(t, err)
+		size += int64(len(raw))
+	}
+	return size
+}
+
+func requireChainSize(t *testing.T, e *chunker.CachedEntriesChunker, root ipld.Link) int64 {
+	var size int64
+	for _, l := range listEntriesChain(t, e, root) {
+		raw, err := e.GetRawCachedChunk(context.TODO(), l)
+		require.NoError(t, err)
+		size += int64(len(raw))
+	}
+	return size
+}
@@ -18,6 +18,8 @@ var _ EntriesChunker = (*ChainChunker)(nil)
 type ChainChunker struct {
 	ls        *ipld.LinkSystem
 	chunkSize int
+	// sorted specifies whether to deduplicate multihashes and sort them within each chunk.
+	sorted bool
 }
 
 // NewChainChunker instantiates a new chain chunker that given a provider.MultihashIterator it drains
@@ -35,18 +37,57 @@ func NewChainChunker(ls *ipld.LinkSystem, chunkSize int) (*ChainChunker, error)
 	}, nil
 }
 
+func (ls *ChainChunker) format() string {
+	return chainFormat(ls.chunkSize, ls.sorted)
+}
+
+// chainFormat returns the format of entries chains with the given chunk size.
+func chainFormat(chunkSize int, sorted bool) string {
+	if sorted {
+		return fmt.Sprintf("sorted-chain/%d", chunkSize)
+	}
+	return fmt.Sprintf("chain/%d", chunkSize)
+}
+
 func NewChainChunkerFunc(chunkSize int) NewChunkerFunc {
 	return func(ls *ipld.LinkSystem) (EntriesChunker, error) {
 		return NewChainChunker(ls, chunkSize)
 	}
 }
 
+// NewSortedChainChunker instantiates a new chain chunker that, unlike NewChainChunker, omits any
+// duplicate multihashes returned by the provider.MultihashIterator and sorts the multihashes
+// within each schema.EntryChunk node by their bytes. Multihashes are assigned to chunks in the
+// order in which they are first returned by the iterator, which means the generated chain is
+// deterministic as long as the iterator order is.
+//
+// Note that every unique multihash is held in memory while chunking in order to omit duplicates.
+//
+// See: NewChainChunker.
+func NewSortedChainChunker(ls *ipld.LinkSystem, chunkSize int) (*ChainChunker, error) {
+	c, err := NewChainChunker(ls, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	c.sorted = true
+	return c, nil
+}
+
+func NewSortedChainChunkerFunc(chunkSize int) NewChunkerFunc {
+	return func(ls *ipld.LinkSystem) (EntriesChunker, error) {
+		return NewSortedChainChunker(ls, chunkSize)
+	}
+}
+
 // Chunk chunks all the mulithashes returned by the given iterator into a chain of schema.EntryChunk
 // nodes where each chunk contains no more than chunkSize number of multihashes and returns the link
 // the root chunk node.
 //
 // See: schema.EntryChunk.
 func (ls *ChainChunker) Chunk(ctx context.Context, mhi provider.MultihashIterator) (ipld.Link, error) {
+	if ls.sorted {
+		mhi = newSortedChunksIterator(mhi, ls.chunkSize)
+	}
 	mhs := make([]multihash.Multihash, 0, ls.chunkSize)
 	var next ipld.Link
 	var mhCount, chunkCount int
@@ -1,8 +1,10 @@
 package chunker_test
 
 import (
+	"bytes"
 	"context"
 	"math/rand"
+	"sort"
 	"testing"
 
 	provider "github.com/filecoin-project/index-provider"
@@ -12,6 +14,7 @@ import (
 	"github.com/ipld/go-ipld-prime"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/require"
 )
 
@@ -51,3 +54,60 @@ func TestChainChunker_Chunk(t *testing.T) {
 		chunkHasExpectedMhs(t, subject)
 	})
 }
+
+func TestSortedChainChunker_Chunk(t *testing.T) {
+	ctx := context.TODO()
+	rng := rand.New(rand.NewSource(1413))
+	unique := testutil.RandomMultihashes(t, rng, 50)
+	// Repeat some multihashes, as if the same block is present in a CAR more than once.
+	mhs := append([]multihash.Multihash{}, unique...)
+	for _, i := range rng.Perm(len(unique))[:20] {
+		mhs = append(mhs, unique[i])
+	}
+	rng.Shuffle(len(mhs), func(i, j int) { mhs[i], mhs[j] = mhs[j], mhs[i] })
+
+	chunk := func(t *testing.T, newChunker chunker.NewChunkerFunc) (ipld.Link, ipld.LinkSystem) {
+		store := &memstore.Store{}
+		ls := cidlink.DefaultLinkSystem()
+		ls.SetReadStorage(store)
+		ls.SetWriteStorage(store)
+		subject, err := newChunker(&ls)
+		require.NoError(t, err)
+		root, err := subject.Chunk(ctx, provider.SliceMultihashIterator(mhs))
+		require.NoError(t, err)
+		return root, ls
+	}
+
+	root, ls := chunk(t, chunker.NewSortedChainChunkerFunc(7))
+	var gotMhs []multihash.Multihash
+	var chunkCount int
+	for next := root; next != nil; {
+		n, err := ls.Load(ipld.LinkContext{Ctx: ctx}, next, schema.EntryChunkPrototype)
+		require.NoError(t, err)
+		entryChunk, err := schema.UnwrapEntryChunk(n)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(entryChunk.Entries), 7)
+		require.True(t, sort.SliceIsSorted(entryChunk.Entries, func(i, j int) bool {
+			return bytes.Compare(entryChunk.Entries[i], entryChunk.Entries[j]) < 0
+		}), "entries of chunk %d are not sorted", chunkCount)
+		gotMhs = append(gotMhs, entryChunk.Entries...)
+		chunkCount++
+		next = nil
+		if entryChunk.Next != nil {
+			next = *entryChunk.Next
+		}
+	}
+	// 50 unique multihashes in chunks of 7.
+	require.Equal(t, 8, chunkCount)
+	requireChunkEntriesMatch(t, gotMhs, unique)
+
+	// Assert that the chain is the same when regenerated, regardless of parallelism.
+	again, _ := chunk(t, chunker.NewSortedChainChunkerFunc(7))
+	require.Equal(t, root, again)
+	parallel, _ := chunk(t, chunker.NewSortedParallelChainChunkerFunc(7, 3))
+	require.Equal(t, root, parallel)
+
+	// Assert that the chain differs from the one with duplicate multihashes in iterator order.
+	unsorted, _ := chunk(t, chunker.NewChainChunkerFunc(7))
+	require.NotEqual(t, root, unsorted)
+}
@@ -2,9 +2,13 @@ package chunker
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"strings"
 
 	provider "github.com/filecoin-project/index-provider"
 	"github.com/ipld/go-ipld-prime"
+	"github.com/multiformats/go-multicodec"
 )
 
 // EntriesChunker chunks multihashes supplied by a given provider.MultihashIterator into a chain of
@@ -14,3 +18,72 @@ type EntriesChunker interface {
 	// schema.EntryChunk and returns the link of the chain root.
 	Chunk(context.Context, provider.MultihashIterator) (ipld.Link, error)
 }
+
+// formatter is implemented by the EntriesChunker implementations in this package to describe the
+// format of the DAGs they generate. Chunkers that generate identical DAGs return the same format.
+type formatter interface {
+	format() string
+}
+
+// formatOf returns the format of DAGs generated by the given chunker, or empty string if the
+// format is unknown.
+func formatOf(c EntriesChunker) string {
+	if f, ok := c.(formatter); ok {
+		return f.format()
+	}
+	return ""
+}
+
+// ChainCounterpart returns the format of sorted chain DAGs with the same chunk size as the given
+// format of unsorted chain DAGs, and vice versa. Empty string is returned for any other format.
+// See: CachedEntriesChunker.Format.
+func ChainCounterpart(format string) string {
+	switch {
+	case strings.HasPrefix(format, "chain/"):
+		return "sorted-" + format
+	case strings.HasPrefix(format, "sorted-chain/"):
+		return strings.TrimPrefix(format, "sorted-")
+	default:
+		return ""
+	}
+}
+
+// newChunkerFuncOf returns the NewChunkerFunc of a chunker that generates DAGs in the given
+// format, as returned by formatOf.
+func newChunkerFuncOf(format string) (NewChunkerFunc, error) {
+	parts := strings.Split(format, "/")
+	atoi := func(s string) (int, error) {
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid entries format %q: %w", format, err)
+		}
+		return i, nil
+	}
+	switch {
+	case len(parts) == 2 && (parts[0] == "chain" || parts[0] == "sorted-chain"):
+		chunkSize, err := atoi(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if parts[0] == "sorted-chain" {
+			return NewSortedChainChunkerFunc(chunkSize), nil
+		}
+		return NewChainChunkerFunc(chunkSize), nil
+	case len(parts) == 4 && parts[0] == "hamt":
+		var hashAlg multicodec.Code
+		if err := hashAlg.Set(parts[1]); err != nil {
+			return nil, fmt.Errorf("invalid entries format %q: %w", format, err)
+		}
+		bitWidth, err := atoi(parts[2])
+		if err != nil {
+			return nil, err
+		}
+		bucketSize, err := atoi(parts[3])
+		if err != nil {
+			return nil, err
+		}
+		return NewHamtChunkerFunc(hashAlg, bitWidth, bucketSize), nil
+	default:
+		return nil, fmt.Errorf("unknown entries format: %q", format)
+	}
+}
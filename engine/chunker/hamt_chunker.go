@@ -15,6 +15,10 @@ import (
 
 var _ EntriesChunker = (*HamtChunker)(nil)
 
+// maxHamtBitWidth is the maximum bit-width of HAMT nodes. Each node holds a bitmap of 2^bitWidth
+// bits, which becomes prohibitively large beyond this.
+const maxHamtBitWidth = 16
+
 // HamtChunker chunks advertisement entries as an IPLD HAMT data structure.
 // See: NewHamtChunker.
 type HamtChunker struct {
@@ -28,14 +32,14 @@ type HamtChunker struct {
 // all its mulithashes and stores them in the given link system represented as an IPLD HAMT ADL.
 //
 // Only multicodec.Identity, multicodec.Sha2_256 and multicodec.Murmur3X64_64 are supported as hash
-// algorithm. The bit-width and bucket size must be at least 3 and 1 respectively.
+// algorithm. The bit-width must be at least 3 and at most 16, and the bucket size at least 1.
 //
 // See:
 //  - https://ipld.io/specs/advanced-data-layouts/hamt/spec
 //  - https://github.com/ipld/go-ipld-adl-hamt
 func NewHamtChunker(ls *ipld.LinkSystem, hashAlg multicodec.Code, bitWidth, bucketSize int) (*HamtChunker, error) {
-	if bitWidth < 3 {
-		return nil, fmt.Errorf("bit-width must be at least 3; got: %d", bitWidth)
+	if bitWidth < 3 || bitWidth > maxHamtBitWidth {
+		return nil, fmt.Errorf("bit-width must be at least 3 and at most %d; got: %d", maxHamtBitWidth, bitWidth)
 	}
 	if bucketSize < 1 {
 		return nil, fmt.Errorf("bucket size must be at least 1; got: %d", bucketSize)
@@ -55,6 +59,10 @@ func NewHamtChunker(ls *ipld.LinkSystem, hashAlg multicodec.Code, bitWidth, buck
 	}, nil
 }
 
+func (h *HamtChunker) format() string {
+	return fmt.Sprintf("hamt/%s/%d/%d", h.hashAlg, h.bitWidth, h.bucketSize)
+}
+
 func NewHamtChunkerFunc(hashAlg multicodec.Code, bitWidth, bucketSize int) NewChunkerFunc {
 	return func(ls *ipld.LinkSystem) (EntriesChunker, error) {
 		return NewHamtChunker(ls, hashAlg, bitWidth, bucketSize)
@@ -0,0 +1,334 @@
+package chunker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	provider "github.com/filecoin-project/index-provider"
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multihash"
+)
+
+var _ EntriesChunker = (*ParallelChainChunker)(nil)
+
+// ParallelChainChunker chunks advertisement entries as a chained series of schema.EntryChunk
+// nodes, encoding chunks concurrently across a number of workers. The generated DAG is identical
+// to the one generated by ChainChunker given the same chunk size.
+//
+// Each chunk links to the previously generated chunk, which means its link is not known until all
+// the chunks before it are stored. Workers therefore encode chunks with a placeholder link of the
+// same length, which is replaced by the actual link once the previous chunk is stored. Hashing and
+// storing chunks remains sequential.
+//
+// See: NewParallelChainChunker
+type ParallelChainChunker struct {
+	ls        *ipld.LinkSystem
+	chunkSize int
+	workers   int
+	// sorted specifies whether to deduplicate multihashes and sort them within each chunk.
+	sorted bool
+
+	encoder codec.Encoder
+	// placeholder is the link with which chunks are encoded prior to knowing their next link.
+	placeholder ipld.Link
+	// linkOffset is the offset of placeholder within encoded chunks, measured from the end if
+	// linkFromEnd is true, or from the start otherwise.
+	linkOffset  int
+	linkFromEnd bool
+}
+
+// parallelChunk represents a chunk of multihashes, and its encoding once encoded by a worker.
+type parallelChunk struct {
+	seq int
+	mhs []multihash.Multihash
+	enc []byte
+	err error
+}
+
+// NewParallelChainChunker instantiates a new chain chunker that given a
+// provider.MultihashIterator it drains all its multihashes and stores them in the given link
+// system represented as a chain of schema.EntryChunk nodes where each chunk contains no more than
+// chunkSize number of multihashes. Chunks are encoded concurrently by the given number of workers.
+//
+// See: schema.EntryChunk, ChainChunker.
+func NewParallelChainChunker(ls *ipld.LinkSystem, chunkSize, workers int) (*ParallelChainChunker, error) {
+	if chunkSize < 1 {
+		return nil, fmt.Errorf("chunk size must be at least 1; got: %d", chunkSize)
+	}
+	if workers < 1 {
+		return nil, fmt.Errorf("workers must be at least 1; got: %d", workers)
+	}
+	encoder, err := ls.EncoderChooser(schema.Linkproto)
+	if err != nil {
+		return nil, err
+	}
+	// Any link with the same prefix as the actual links has the same encoded length.
+	placeholder, err := schema.Linkproto.Sum(nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &ParallelChainChunker{
+		ls:          ls,
+		chunkSize:   chunkSize,
+		workers:     workers,
+		encoder:     encoder,
+		placeholder: cidlink.Link{Cid: placeholder},
+	}
+	if err := c.findLinkOffset(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// format returns the same format as ChainChunker, since the generated chains are identical.
+func (p *ParallelChainChunker) format() string {
+	return chainFormat(p.chunkSize, p.sorted)
+}
+
+func NewParallelChainChunkerFunc(chunkSize, workers int) NewChunkerFunc {
+	return func(ls *ipld.LinkSystem) (EntriesChunker, error) {
+		return NewParallelChainChunker(ls, chunkSize, workers)
+	}
+}
+
+// NewSortedParallelChainChunker instantiates a new parallel chain chunker that generates the same
+// chain as NewSortedChainChunker given the same chunk size, i.e. with duplicate multihashes
+// omitted and multihashes sorted within each chunk.
+//
+// See: NewParallelChainChunker, NewSortedChainChunker.
+func NewSortedParallelChainChunker(ls *ipld.LinkSystem, chunkSize, workers int) (*ParallelChainChunker, error) {
+	c, err := NewParallelChainChunker(ls, chunkSize, workers)
+	if err != nil {
+		return nil, err
+	}
+	c.sorted = true
+	return c, nil
+}
+
+func NewSortedParallelChainChunkerFunc(chunkSize, workers int) NewChunkerFunc {
+	return func(ls *ipld.LinkSystem) (EntriesChunker, error) {
+		return NewSortedParallelChainChunker(ls, chunkSize, workers)
+	}
+}
+
+// findLinkOffset finds where the next link is positioned within encoded chunks. The position is
+// either fixed relative to the start of the encoding when the link is encoded before the entries,
+// or relative to the end when it is encoded after them. Encoding two chunks with a different
+// number of entries tells which one it is.
+func (p *ParallelChainChunker) findLinkOffset() error {
+	var offsets, tails [2]int
+	for i := range offsets {
+		mhs := make([]multihash.Multihash, i+1)
+		for j := range mhs {
+			mh, err := multihash.Sum([]byte{byte(j)}, multihash.IDENTITY, -1)
+			if err != nil {
+				return err
+			}
+			mhs[j] = mh
+		}
+		enc, err := p.encode(mhs, p.placeholder)
+		if err != nil {
+			return err
+		}
+		ph := []byte(p.placeholder.String())
+		offset := bytes.Index(enc, ph)
+		if offset < 0 || bytes.LastIndex(enc, ph) != offset {
+			return errors.New("cannot find next link in encoded entry chunk")
+		}
+		offsets[i] = offset
+		tails[i] = len(enc) - offset
+	}
+	switch {
+	case offsets[0] == offsets[1]:
+		p.linkOffset = offsets[0]
+	case tails[0] == tails[1]:
+		p.linkOffset = tails[0]
+		p.linkFromEnd = true
+	default:
+		return errors.New("next link is not at a fixed position in encoded entry chunks")
+	}
+	return nil
+}
+
+// Chunk chunks all the mulithashes returned by the given iterator into a chain of schema.EntryChunk
+// nodes where each chunk contains no more than chunkSize number of multihashes and returns the link
+// the root chunk node.
+//
+// See: schema.EntryChunk.
+func (p *ParallelChainChunker) Chunk(ctx context.Context, mhi provider.MultihashIterator) (ipld.Link, error) {
+	if p.sorted {
+		mhi = newSortedChunksIterator(mhi, p.chunkSize)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	// Wait for all goroutines to return, so that the iterator is no longer used once Chunk returns.
+	var running sync.WaitGroup
+	defer func() {
+		cancel()
+		running.Wait()
+	}()
+
+	// Limit the number of chunks held in memory while waiting to be stored.
+	inflight := make(chan struct{}, 2*p.workers)
+	chunks := make(chan *parallelChunk)
+	encoded := make(chan *parallelChunk)
+
+	// The producer results are only safe to read once produced is closed; encoded may be closed
+	// before then, since workers stop draining chunks once the context is canceled.
+	var mhCount int
+	var readErr error
+	produced := make(chan struct{})
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		defer close(produced)
+		defer close(chunks)
+		var seq int
+		mhs := make([]multihash.Multihash, 0, p.chunkSize)
+		send := func() bool {
+			select {
+			case inflight <- struct{}{}:
+			case <-ctx.Done():
+				return false
+			}
+			select {
+			case chunks <- &parallelChunk{seq: seq, mhs: mhs}:
+			case <-ctx.Done():
+				return false
+			}
+			seq++
+			// Workers hold on to sent multihashes; allocate a new slice.
+			mhs = make([]multihash.Multihash, 0, p.chunkSize)
+			return true
+		}
+		for {
+			mh, err := mhi.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				readErr = err
+				return
+			}
+			mhs = append(mhs, mh)
+			mhCount++
+			if len(mhs) >= p.chunkSize && !send() {
+				return
+			}
+		}
+		if len(mhs) != 0 {
+			send()
+		}
+	}()
+
+	var workers sync.WaitGroup
+	workers.Add(p.workers)
+	running.Add(p.workers)
+	for i := 0; i < p.workers; i++ {
+		go func() {
+			defer running.Done()
+			defer workers.Done()
+			for c := range chunks {
+				// The first chunk is the end of the chain and has no next link.
+				var next ipld.Link
+				if c.seq != 0 {
+					next = p.placeholder
+				}
+				c.enc, c.err = p.encode(c.mhs, next)
+				select {
+				case encoded <- c:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(encoded)
+	}()
+
+	// Store chunks in order, since each chunk links to the one stored before it.
+	pending := make(map[int]*parallelChunk)
+	var next ipld.Link
+	var chunkCount int
+	for c := range encoded {
+		if c.err != nil {
+			return nil, c.err
+		}
+		pending[c.seq] = c
+		for {
+			c, ok := pending[chunkCount]
+			if !ok {
+				break
+			}
+			delete(pending, chunkCount)
+			var err error
+			next, err = p.store(ctx, c.enc, next)
+			if err != nil {
+				return nil, err
+			}
+			chunkCount++
+			<-inflight
+		}
+	}
+	<-produced
+	if readErr != nil {
+		return nil, readErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	log.Infow("Generated linked chunks of multihashes", "totalMhCount", mhCount, "chunkCount", chunkCount, "workers", p.workers)
+	return next, nil
+}
+
+func (p *ParallelChainChunker) encode(mhs []multihash.Multihash, next ipld.Link) ([]byte, error) {
+	cNode, err := newEntriesChunkNode(mhs, next)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := p.encoder(cNode, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// store replaces the placeholder link in the given encoded chunk with next, if any, and stores
+// it in the link system.
+func (p *ParallelChainChunker) store(ctx context.Context, enc []byte, next ipld.Link) (ipld.Link, error) {
+	if next != nil {
+		offset := p.linkOffset
+		if p.linkFromEnd {
+			offset = len(enc) - p.linkOffset
+		}
+		ph := p.placeholder.String()
+		nextStr := next.(cidlink.Link).Cid.String()
+		if len(nextStr) != len(ph) || offset < 0 || offset+len(ph) > len(enc) || string(enc[offset:offset+len(ph)]) != ph {
+			return nil, fmt.Errorf("cannot replace placeholder with next link %s", next)
+		}
+		copy(enc[offset:], nextStr)
+	}
+
+	hasher, err := p.ls.HasherChooser(schema.Linkproto)
+	if err != nil {
+		return nil, err
+	}
+	w, commit, err := p.ls.StorageWriteOpener(ipld.LinkContext{Ctx: ctx})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.MultiWriter(w, hasher).Write(enc); err != nil {
+		return nil, err
+	}
+	lnk := schema.Linkproto.BuildLink(hasher.Sum(nil))
+	return lnk, commit(lnk)
+}
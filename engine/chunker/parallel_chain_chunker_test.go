@@ -0,0 +1,194 @@
+package chunker_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	provider "github.com/filecoin-project/index-provider"
+	"github.com/filecoin-project/index-provider/engine/chunker"
+	"github.com/filecoin-project/index-provider/testutil"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParallelChainChunker_ValidatesConfig(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	_, err := chunker.NewParallelChainChunker(&ls, 0, 1)
+	require.EqualError(t, err, "chunk size must be at least 1; got: 0")
+	_, err = chunker.NewParallelChainChunker(&ls, 1, 0)
+	require.EqualError(t, err, "workers must be at least 1; got: 0")
+}
+
+func TestParallelChainChunker_GeneratesSameDagAsChainChunker(t *testing.T) {
+	ctx := context.TODO()
+	rng := rand.New(rand.NewSource(1413))
+	tests := []struct {
+		chunkSize int
+		mhCount   int
+		workers   int
+	}{
+		{1, 1, 1},
+		{1, 17, 3},
+		{7, 100, 4},
+		{10, 100, 4},
+		{16, 100, 2},
+		{100, 100, 8},
+		{1000, 100, 8},
+		{16, 1000, 8},
+	}
+	for _, test := range tests {
+		name := fmt.Sprintf("ChunkSize_%d/MhCount_%d/Workers_%d", test.chunkSize, test.mhCount, test.workers)
+		t.Run(name, func(t *testing.T) {
+			mhs := testutil.RandomMultihashes(t, rng, test.mhCount)
+
+			wantStore := &memstore.Store{}
+			wantLs := cidlink.DefaultLinkSystem()
+			wantLs.SetWriteStorage(wantStore)
+			want, err := chunker.NewChainChunker(&wantLs, test.chunkSize)
+			require.NoError(t, err)
+			wantRoot, err := want.Chunk(ctx, provider.SliceMultihashIterator(mhs))
+			require.NoError(t, err)
+
+			gotStore := &memstore.Store{}
+			gotLs := cidlink.DefaultLinkSystem()
+			gotLs.SetReadStorage(gotStore)
+			gotLs.SetWriteStorage(gotStore)
+			subject, err := chunker.NewParallelChainChunker(&gotLs, test.chunkSize, test.workers)
+			require.NoError(t, err)
+			gotRoot, err := subject.Chunk(ctx, provider.SliceMultihashIterator(mhs))
+			require.NoError(t, err)
+
+			require.Equal(t, wantRoot, gotRoot)
+			require.Equal(t, wantStore.Bag, gotStore.Bag)
+			requireChunkEntriesMatch(t, requireDecodeAllMultihashes(t, gotRoot, gotLs), mhs)
+		})
+	}
+}
+
+func TestParallelChainChunker_NoMultihashes(t *testing.T) {
+	store := &memstore.Store{}
+	ls := cidlink.DefaultLinkSystem()
+	ls.SetWriteStorage(store)
+	subject, err := chunker.NewParallelChainChunker(&ls, 10, 4)
+	require.NoError(t, err)
+	root, err := subject.Chunk(context.TODO(), provider.SliceMultihashIterator(nil))
+	require.NoError(t, err)
+	require.Nil(t, root)
+	require.Empty(t, store.Bag)
+}
+
+func TestParallelChainChunker_FailsOnIteratorError(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	ls := cidlink.DefaultLinkSystem()
+	ls.SetWriteStorage(&memstore.Store{})
+	subject, err := chunker.NewParallelChainChunker(&ls, 3, 4)
+	require.NoError(t, err)
+
+	wantErr := errors.New("fish")
+	mhi := &failingMultihashIterator{
+		mhs: testutil.RandomMultihashes(t, rng, 100),
+		at:  50,
+		err: wantErr,
+	}
+	root, err := subject.Chunk(context.TODO(), mhi)
+	require.ErrorIs(t, err, wantErr)
+	require.Nil(t, root)
+}
+
+func TestParallelChainChunker_FailsWhenContextIsCancelled(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	ls := cidlink.DefaultLinkSystem()
+	ls.SetWriteStorage(&memstore.Store{})
+	subject, err := chunker.NewParallelChainChunker(&ls, 3, 4)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mhs := testutil.RandomMultihashes(t, rng, 100)
+	root, err := subject.Chunk(ctx, provider.SliceMultihashIterator(mhs))
+	require.ErrorIs(t, err, context.Canceled)
+	require.Nil(t, root)
+}
+
+func TestParallelChainChunker_FailsOnIteratorErrorWhenContextIsCancelled(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Block storing the first chunk until released, so that the worker stops on the canceled
+	// context while holding the second chunk.
+	storing := make(chan struct{})
+	release := make(chan struct{})
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageWriteOpener = func(ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+		return io.Discard, func(ipld.Link) error {
+			close(storing)
+			<-release
+			return nil
+		}, nil
+	}
+	subject, err := chunker.NewParallelChainChunker(&ls, 1, 1)
+	require.NoError(t, err)
+
+	// Fail the iterator only after the context is canceled and the worker has stopped.
+	wantErr := errors.New("fish")
+	mhs := testutil.RandomMultihashes(t, rng, 2)
+	mhi := &blockingMultihashIterator{
+		mhs: mhs,
+		next: func() error {
+			<-ctx.Done()
+			time.Sleep(50 * time.Millisecond)
+			return wantErr
+		},
+	}
+	go func() {
+		<-storing
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	root, err := subject.Chunk(ctx, mhi)
+	require.ErrorIs(t, err, wantErr)
+	require.Nil(t, root)
+}
+
+// blockingMultihashIterator returns the given multihashes, followed by the error returned by next.
+type blockingMultihashIterator struct {
+	mhs  []multihash.Multihash
+	next func() error
+}
+
+func (b *blockingMultihashIterator) Next() (multihash.Multihash, error) {
+	if len(b.mhs) == 0 {
+		return nil, b.next()
+	}
+	mh := b.mhs[0]
+	b.mhs = b.mhs[1:]
+	return mh, nil
+}
+
+// failingMultihashIterator returns the given error after returning the first at multihashes.
+type failingMultihashIterator struct {
+	mhs []multihash.Multihash
+	at  int
+	err error
+}
+
+func (f *failingMultihashIterator) Next() (multihash.Multihash, error) {
+	if f.at == 0 {
+		return nil, f.err
+	}
+	f.at--
+	mh := f.mhs[0]
+	f.mhs = f.mhs[1:]
+	return mh, nil
+}
@@ -0,0 +1,80 @@
+package chunker
+
+import (
+	"bytes"
+	"io"
+	"sort"
+
+	provider "github.com/filecoin-project/index-provider"
+	"github.com/multiformats/go-multihash"
+)
+
+var _ provider.MultihashIterator = (*sortedChunksIterator)(nil)
+
+// sortedChunksIterator wraps a provider.MultihashIterator, skipping any multihash that it has
+// already returned and returning the rest in consecutive groups of chunkSize multihashes, each
+// sorted by multihash bytes. When chunked with the same chunk size, every chunk therefore contains
+// unique multihashes in sorted order. The order is deterministic as long as the wrapped iterator
+// returns multihashes in a deterministic order.
+//
+// Note that the iterator keeps track of every unique multihash returned, which means its memory
+// usage grows with the number of unique multihashes.
+type sortedChunksIterator struct {
+	mhi       provider.MultihashIterator
+	chunkSize int
+	seen      map[string]struct{}
+	buf       []multihash.Multihash
+	pos       int
+	eof       bool
+}
+
+func newSortedChunksIterator(mhi provider.MultihashIterator, chunkSize int) *sortedChunksIterator {
+	return &sortedChunksIterator{
+		mhi:       mhi,
+		chunkSize: chunkSize,
+		seen:      make(map[string]struct{}),
+		buf:       make([]multihash.Multihash, 0, chunkSize),
+	}
+}
+
+func (s *sortedChunksIterator) Next() (multihash.Multihash, error) {
+	if s.pos >= len(s.buf) {
+		if s.eof {
+			return nil, io.EOF
+		}
+		if err := s.fill(); err != nil {
+			return nil, err
+		}
+		if len(s.buf) == 0 {
+			return nil, io.EOF
+		}
+	}
+	mh := s.buf[s.pos]
+	s.pos++
+	return mh, nil
+}
+
+// fill reads the next chunkSize unique multihashes from the wrapped iterator and sorts them.
+func (s *sortedChunksIterator) fill() error {
+	s.buf = s.buf[:0]
+	s.pos = 0
+	for len(s.buf) < s.chunkSize {
+		mh, err := s.mhi.Next()
+		if err == io.EOF {
+			s.eof = true
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := s.seen[string(mh)]; ok {
+			continue
+		}
+		s.seen[string(mh)] = struct{}{}
+		s.buf = append(s.buf, mh)
+	}
+	sort.Slice(s.buf, func(i, j int) bool {
+		return bytes.Compare(s.buf[i], s.buf[j]) < 0
+	})
+	return nil
+}
@@ -0,0 +1,388 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	httpfinderclient "github.com/filecoin-project/storetheindex/api/v0/finder/client/http"
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// compactionKey stores the state of advertisement chain compaction that is awaiting garbage
+// collection.
+const compactionKey = "sync/compaction/"
+
+var (
+	dsCompactionKey = datastore.NewKey(compactionKey)
+
+	// ErrNoLiveContextIDs signals that the advertisement chain cannot be compacted because it
+	// advertises no live context IDs, i.e. the compacted chain would be empty.
+	ErrNoLiveContextIDs = errors.New("no live context IDs to compact advertisement chain into")
+	// ErrIndexersNotSynced signals that obsolete advertisements cannot be garbage collected yet,
+	// because at least one of the configured indexers has not synced past them.
+	ErrIndexersNotSynced = errors.New("indexers have not synced past compacted advertisement chain")
+	// ErrNoGCIndexers signals that obsolete advertisements cannot be garbage collected, because
+	// there are no indexers configured to verify that they are no longer needed.
+	// See: WithGCIndexers.
+	ErrNoGCIndexers = errors.New("no indexers are configured to verify garbage collection")
+)
+
+type (
+	// CompactionReport summarizes the outcome of an advertisement chain compaction.
+	// See: Engine.Compact.
+	CompactionReport struct {
+		// DryRun signals whether the compaction was a dry-run, i.e. no changes were made.
+		DryRun bool
+		// ChainLength is the number of advertisements in the chain prior to compaction.
+		ChainLength int
+		// LiveContextIDs is the number of context IDs that are advertised by the chain.
+		LiveContextIDs int
+		// PreviousHead is the head of the advertisement chain prior to compaction.
+		PreviousHead cid.Cid
+		// Head is the head of the compacted advertisement chain, or cid.Undef if no compaction
+		// took place.
+		Head cid.Cid
+	}
+
+	// compactionState is the persisted state of compaction, used to garbage collect obsolete
+	// advertisements once indexers have synced past them.
+	compactionState struct {
+		// Tail is the first advertisement of the most recently compacted chain.
+		Tail cid.Cid
+		// Obsolete is the list of heads of advertisement chains that were replaced by compaction
+		// and are awaiting garbage collection.
+		Obsolete []cid.Cid
+	}
+)
+
+// Compact publishes a fresh advertisement chain that contains exactly one put advertisement per
+// context ID that is currently advertised by the provider, in the order in which they were last
+// advertised. Context IDs that were removed are not present in the compacted chain. Context IDs
+// updated via Engine.NotifyUpdate retain one put advertisement per delta entries.
+//
+// The compacted chain shares no advertisements with the previous chain, which becomes obsolete.
+// The obsolete advertisements are kept in the datastore until all indexers configured via
+// WithGCIndexers have synced past them, at which point they are garbage collected. Garbage
+// collection is attempted periodically, as well as on demand via Engine.CollectGarbage.
+//
+// When dryRun is set, the returned report describes the compaction without publishing anything.
+// No compaction takes place if the chain is already compact. ErrNoLiveContextIDs is returned if
+// there are no context IDs advertised.
+func (e *Engine) Compact(ctx context.Context, dryRun bool) (*CompactionReport, error) {
+	report, err := e.compact(ctx, dryRun)
+	if err != nil || report.Head == cid.Undef {
+		return report, err
+	}
+	if err := e.announce(ctx, report.Head); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// compact commits the compacted advertisement chain of Engine.Compact without announcing it. The
+// head of the compacted chain is set in the returned report, if any.
+func (e *Engine) compact(ctx context.Context, dryRun bool) (*CompactionReport, error) {
+	e.publishLk.Lock()
+	defer e.publishLk.Unlock()
+
+	head, err := e.getLatestAdCid(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get latest advertisement cid: %w", err)
+	}
+	report := &CompactionReport{
+		DryRun:       dryRun,
+		PreviousHead: head,
+	}
+	if head == cid.Undef {
+		log.Info("Skipped compaction: no advertisements published")
+		return report, nil
+	}
+
+	live, chainLength, liveContextIDs, err := e.liveAdvs(ctx, head)
+	if err != nil {
+		return nil, err
+	}
+	report.ChainLength = chainLength
+	report.LiveContextIDs = liveContextIDs
+	log := log.With("chainLength", chainLength, "liveContextIDs", liveContextIDs, "dryRun", dryRun)
+
+	if len(live) == 0 {
+		return nil, ErrNoLiveContextIDs
+	}
+	if len(live) == chainLength {
+		log.Info("Skipped compaction: advertisement chain is already compact")
+		return report, nil
+	}
+	if dryRun {
+		log.Info("Computed advertisement chain compaction")
+		return report, nil
+	}
+
+	state, err := e.getCompactionState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := newDsBatch(ctx, e.ds)
+	if err != nil {
+		return nil, fmt.Errorf("could not create datastore batch: %w", err)
+	}
+	var prev cid.Cid
+	for i, ad := range live {
+		compacted := schema.Advertisement{
+			Provider:  e.options.provider.ID.String(),
+			Addresses: e.retrievalAddrsAsString(),
+			Entries:   ad.Entries,
+			ContextID: ad.ContextID,
+			Metadata:  ad.Metadata,
+		}
+		if prev != cid.Undef {
+			prevLnk := ipld.Link(cidlink.Link{Cid: prev})
+			compacted.PreviousID = &prevLnk
+		}
+		if err := compacted.Sign(e.key); err != nil {
+			return nil, err
+		}
+		if prev, err = e.storeAdv(ctx, b, compacted); err != nil {
+			return nil, fmt.Errorf("failed to store compacted advertisement: %w", err)
+		}
+		if i == 0 {
+			state.Tail = prev
+		}
+	}
+	state.Obsolete = append(state.Obsolete, head)
+	if err := putCompactionState(ctx, b, state); err != nil {
+		return nil, err
+	}
+	if err := b.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit compacted advertisement chain: %w", err)
+	}
+	report.Head = prev
+	log.Infow("Compacted advertisement chain", "head", prev)
+	return report, nil
+}
+
+// CollectGarbage deletes the advertisements made obsolete by Engine.Compact, provided that all
+// indexers configured via WithGCIndexers have synced past them, and returns the number of
+// advertisements deleted.
+//
+// ErrIndexersNotSynced is returned if at least one indexer has not synced past the compacted
+// chain, and ErrNoGCIndexers is returned if there are no indexers configured.
+func (e *Engine) CollectGarbage(ctx context.Context) (int, error) {
+	e.publishLk.Lock()
+	defer e.publishLk.Unlock()
+
+	state, err := e.getCompactionState(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(state.Obsolete) == 0 {
+		return 0, nil
+	}
+	if len(e.gcIndexers) == 0 {
+		return 0, ErrNoGCIndexers
+	}
+
+	// Collect the advertisements published since compaction; every configured indexer must have
+	// synced one of them.
+	head, err := e.getLatestAdCid(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("could not get latest advertisement cid: %w", err)
+	}
+	current := make(map[cid.Cid]struct{})
+	err = e.walkChain(ctx, head, func(c cid.Cid, _ *schema.Advertisement) bool {
+		current[c] = struct{}{}
+		return c != state.Tail
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, indexer := range e.gcIndexers {
+		synced, err := e.indexerSyncedTo(ctx, indexer)
+		if err != nil {
+			return 0, err
+		}
+		if _, ok := current[synced]; !ok {
+			log.Infow("Indexer has not synced past compacted advertisement chain", "indexer", indexer, "lastAdvertisement", synced)
+			return 0, ErrIndexersNotSynced
+		}
+	}
+
+	b, err := newDsBatch(ctx, e.ds)
+	if err != nil {
+		return 0, fmt.Errorf("could not create datastore batch: %w", err)
+	}
+	var deleted int
+	for _, obsoleteHead := range state.Obsolete {
+		var obsolete []cid.Cid
+		err := e.walkChain(ctx, obsoleteHead, func(c cid.Cid, _ *schema.Advertisement) bool {
+			obsolete = append(obsolete, c)
+			return true
+		})
+		// Stop at advertisements that have already been deleted.
+		if err != nil && !errors.Is(err, datastore.ErrNotFound) {
+			return 0, err
+		}
+		for _, c := range obsolete {
+			// Defensively skip advertisements that are identical to the ones in current chain.
+			if _, ok := current[c]; ok {
+				continue
+			}
+			if err := b.Delete(ctx, datastore.NewKey(c.String())); err != nil {
+				return 0, err
+			}
+			deleted++
+		}
+	}
+
+	orphans, err := e.listOrphanCidKeyMappings(ctx, b)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range orphans {
+		if err := deleteCidKeyMap(ctx, b, c); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := b.Delete(ctx, dsCompactionKey); err != nil {
+		return 0, err
+	}
+	if err := b.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit garbage collection: %w", err)
+	}
+	log.Infow("Garbage collected obsolete advertisements", "deleted", deleted, "orphanMappings", len(orphans))
+	return deleted, nil
+}
+
+// gcLoop periodically attempts to garbage collect obsolete advertisements until the given context
+// is done.
+func (e *Engine) gcLoop(ctx context.Context) {
+	defer close(e.gcDone)
+	ticker := time.NewTicker(e.gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := e.CollectGarbage(ctx)
+			switch {
+			case errors.Is(err, ErrIndexersNotSynced):
+				log.Debug("Deferred garbage collection until indexers have synced")
+			case err != nil:
+				log.Errorw("Failed to garbage collect obsolete advertisements", "err", err)
+			case deleted > 0:
+				log.Infow("Garbage collected obsolete advertisements periodically", "deleted", deleted)
+			}
+		}
+	}
+}
+
+// liveAdvs walks the advertisement chain backwards starting from the given head, and returns the
+// put advertisements of every context ID that is currently advertised, ordered from the oldest to
+// the newest, along with the total number of advertisements in the chain and the number of
+// context IDs advertised.
+//
+// The latest put advertisement is returned per entries of a context ID since it was last removed,
+// so that the delta entries advertised via Engine.NotifyUpdate are retained along with the entries
+// they are added to.
+func (e *Engine) liveAdvs(ctx context.Context, head cid.Cid) ([]*schema.Advertisement, int, int, error) {
+	removed := make(map[string]struct{})
+	seen := make(map[string]struct{})
+	contextIDs := make(map[string]struct{})
+	var live []*schema.Advertisement
+	var chainLength int
+	err := e.walkChain(ctx, head, func(_ cid.Cid, ad *schema.Advertisement) bool {
+		chainLength++
+		if isAddrsAdv(ad) {
+			return true
+		}
+		if _, ok := removed[string(ad.ContextID)]; ok {
+			return true
+		}
+		if ad.IsRm {
+			removed[string(ad.ContextID)] = struct{}{}
+			return true
+		}
+		key := string(ad.ContextID) + ad.Entries.String()
+		if _, ok := seen[key]; ok {
+			return true
+		}
+		seen[key] = struct{}{}
+		contextIDs[string(ad.ContextID)] = struct{}{}
+		live = append(live, ad)
+		return true
+	})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	for i, j := 0, len(live)-1; i < j; i, j = i+1, j-1 {
+		live[i], live[j] = live[j], live[i]
+	}
+	return live, chainLength, len(contextIDs), nil
+}
+
+// walkChain walks the advertisement chain backwards starting from the given head, calling f with
+// every advertisement until either the end of the chain is reached or f returns false.
+func (e *Engine) walkChain(ctx context.Context, head cid.Cid, f func(cid.Cid, *schema.Advertisement) bool) error {
+	next := head
+	for next != cid.Undef {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		ad, err := e.GetAdv(ctx, next)
+		if err != nil {
+			return fmt.Errorf("failed to load advertisement %s while walking chain: %w", next, err)
+		}
+		if !f(next, ad) || ad.PreviousID == nil {
+			return nil
+		}
+		next = (*ad.PreviousID).(cidlink.Link).Cid
+	}
+	return nil
+}
+
+// indexerSyncedTo returns the latest advertisement of this provider that is synced by the indexer
+// at the given URL.
+func (e *Engine) indexerSyncedTo(ctx context.Context, indexer string) (cid.Cid, error) {
+	client, err := httpfinderclient.New(indexer)
+	if err != nil {
+		return cid.Undef, err
+	}
+	info, err := client.GetProvider(ctx, e.options.provider.ID)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to get provider info from indexer %s: %w", indexer, err)
+	}
+	return info.LastAdvertisement, nil
+}
+
+func (e *Engine) getCompactionState(ctx context.Context) (*compactionState, error) {
+	var state compactionState
+	v, err := e.ds.Get(ctx, dsCompactionKey)
+	if err != nil {
+		if err == datastore.ErrNotFound {
+			return &state, nil
+		}
+		return nil, fmt.Errorf("could not get compaction state: %w", err)
+	}
+	if err := json.Unmarshal(v, &state); err != nil {
+		return nil, fmt.Errorf("could not decode compaction state: %w", err)
+	}
+	return &state, nil
+}
+
+func putCompactionState(ctx context.Context, rw dsReadWriter, state *compactionState) error {
+	v, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return rw.Put(ctx, dsCompactionKey, v)
+}
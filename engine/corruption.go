@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	provider "github.com/filecoin-project/index-provider"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// Corrupted context ID prefix, keyed by base64url encoded context ID.
+const corruptedKeyPrefix = "map/corruptKey/"
+
+// EntriesMismatchError signals that the entries regenerated for an advertised context ID do not
+// match the entries CID with which it was advertised, e.g. because the content from which its
+// multihashes are listed has changed since it was advertised.
+type EntriesMismatchError struct {
+	// ContextID is the context ID the entries of which are regenerated.
+	ContextID []byte
+	// Advertised is the CID of the advertised entries.
+	Advertised cid.Cid
+	// Regenerated is the CID of the regenerated entries.
+	Regenerated cid.Cid
+}
+
+func (e *EntriesMismatchError) Error() string {
+	return fmt.Sprintf("regenerated entries %s of context ID %s do not match advertised entries %s",
+		e.Regenerated, base64.StdEncoding.EncodeToString(e.ContextID), e.Advertised)
+}
+
+// CorruptedContextID represents an advertised context ID the entries of which could not be
+// regenerated as advertised.
+// See: EntriesMismatchError, Engine.ListCorruptedContextIDs.
+type CorruptedContextID struct {
+	// ContextID is the corrupted context ID.
+	ContextID []byte
+	// AdvertisedEntries is the CID of the advertised entries.
+	AdvertisedEntries cid.Cid
+	// RegeneratedEntries is the CID of the entries regenerated when the mismatch was detected.
+	RegeneratedEntries cid.Cid
+	// DetectedAt is the time at which the mismatch was first detected.
+	DetectedAt time.Time
+	// RemovalAdvId is the CID of the removal advertisement published for the context ID as a
+	// result of the mismatch, or cid.Undef if none was published.
+	// See: WithRemoveCorruptedEntries.
+	RemovalAdvId cid.Cid
+}
+
+// ListCorruptedContextIDs lists the context IDs the entries of which were found to mismatch the
+// advertised entries when regenerated, sorted by context ID. A context ID is no longer listed
+// once it is advertised again via Engine.NotifyPut after being removed.
+func (e *Engine) ListCorruptedContextIDs(ctx context.Context) ([]CorruptedContextID, error) {
+	results, err := e.ds.Query(ctx, dsq.Query{Prefix: corruptedKeyPrefix})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var corrupted []CorruptedContextID
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("cannot list corrupted context IDs: %w", r.Error)
+		}
+		var cc CorruptedContextID
+		if err := json.Unmarshal(r.Value, &cc); err != nil {
+			return nil, fmt.Errorf("could not decode corrupted context ID: %w", err)
+		}
+		corrupted = append(corrupted, cc)
+	}
+	sort.Slice(corrupted, func(i, j int) bool {
+		return bytes.Compare(corrupted[i].ContextID, corrupted[j].ContextID) < 0
+	})
+	return corrupted, nil
+}
+
+// handleEntriesMismatch records the context ID of the given mismatch as corrupted, and publishes
+// a removal advertisement for it in the background if enabled.
+//
+// The regenerated entries are released from the cache, unless advertised by another context ID,
+// so that they do not evict entries that can be served.
+func (e *Engine) handleEntriesMismatch(ctx context.Context, mismatch *EntriesMismatchError) {
+	atomic.AddUint64(&e.entMismatches, 1)
+	log := log.With("contextID", base64.StdEncoding.EncodeToString(mismatch.ContextID), "advertised", mismatch.Advertised, "regenerated", mismatch.Regenerated)
+	log.Errorw("Regenerated entries do not match advertised entries")
+
+	e.releaseRegeneratedEntries(ctx, mismatch.Regenerated)
+
+	e.corruptLk.Lock()
+	defer e.corruptLk.Unlock()
+	_, err := getCorruptedContextID(ctx, e.ds, mismatch.ContextID)
+	if err == nil {
+		// Already recorded; a removal is published when first recorded, if enabled.
+		return
+	}
+	if err != datastore.ErrNotFound {
+		log.Errorw("Failed to get corrupted context ID", "err", err)
+		return
+	}
+	err = putCorruptedContextID(ctx, e.ds, &CorruptedContextID{
+		ContextID:          mismatch.ContextID,
+		AdvertisedEntries:  mismatch.Advertised,
+		RegeneratedEntries: mismatch.Regenerated,
+		DetectedAt:         time.Now(),
+	})
+	if err != nil {
+		log.Errorw("Failed to record corrupted context ID", "err", err)
+		return
+	}
+
+	if e.rmCorrupted && !e.corruptClosing {
+		// The mismatch is detected while an indexer is syncing; publish the removal without
+		// holding up the sync.
+		e.corruptRmWg.Add(1)
+		go func() {
+			defer e.corruptRmWg.Done()
+			e.removeCorruptedContextID(e.corruptRmCtx, mismatch.ContextID)
+		}()
+	}
+}
+
+// releaseRegeneratedEntries releases the regenerated entries with the given root from the cache,
+// unless they are advertised by a context ID.
+func (e *Engine) releaseRegeneratedEntries(ctx context.Context, root cid.Cid) {
+	if _, err := e.getCidKeyMap(ctx, root); err == datastore.ErrNotFound {
+		if err := e.entriesChunker.Release(ctx, cidlink.Link{Cid: root}); err != nil {
+			log.Errorw("Failed to release regenerated entries from cache", "root", root, "err", err)
+		}
+	} else if err != nil {
+		log.Errorw("Failed to get context ID of regenerated entries", "root", root, "err", err)
+	}
+}
+
+// removeCorruptedContextID publishes a removal advertisement for the given corrupted context ID,
+// and records it as part of the corrupted context ID.
+func (e *Engine) removeCorruptedContextID(ctx context.Context, contextID []byte) {
+	log := log.With("contextID", base64.StdEncoding.EncodeToString(contextID))
+
+	adCid, err := e.NotifyRemove(ctx, contextID)
+	if err != nil {
+		if errors.Is(err, provider.ErrContextIDNotFound) {
+			log.Infow("Corrupted context ID is already removed")
+			return
+		}
+		log.Errorw("Failed to remove corrupted context ID", "err", err)
+		return
+	}
+	log.Infow("Removed corrupted context ID", "adCid", adCid)
+
+	e.corruptLk.Lock()
+	defer e.corruptLk.Unlock()
+	cc, err := getCorruptedContextID(ctx, e.ds, contextID)
+	if err != nil {
+		log.Errorw("Failed to get corrupted context ID", "err", err)
+		return
+	}
+	cc.RemovalAdvId = adCid
+	if err := putCorruptedContextID(ctx, e.ds, cc); err != nil {
+		log.Errorw("Failed to record removal of corrupted context ID", "err", err)
+	}
+}
+
+func corruptedKey(contextID []byte) datastore.Key {
+	return datastore.NewKey(corruptedKeyPrefix + base64.RawURLEncoding.EncodeToString(contextID))
+}
+
+func getCorruptedContextID(ctx context.Context, rw dsReadWriter, contextID []byte) (*CorruptedContextID, error) {
+	v, err := rw.Get(ctx, corruptedKey(contextID))
+	if err != nil {
+		return nil, err
+	}
+	var cc CorruptedContextID
+	if err := json.Unmarshal(v, &cc); err != nil {
+		return nil, fmt.Errorf("could not decode corrupted context ID: %w", err)
+	}
+	return &cc, nil
+}
+
+func putCorruptedContextID(ctx context.Context, rw dsReadWriter, cc *CorruptedContextID) error {
+	v, err := json.Marshal(cc)
+	if err != nil {
+		return err
+	}
+	return rw.Put(ctx, corruptedKey(cc.ContextID), v)
+}
+
+func deleteCorruptedContextID(ctx context.Context, rw dsReadWriter, contextID []byte) error {
+	return rw.Delete(ctx, corruptedKey(contextID))
+}
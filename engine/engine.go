@@ -3,7 +3,9 @@ package engine
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/url"
 	"sync"
 
@@ -30,6 +32,9 @@ const (
 	keyToCidMapPrefix      = "map/keyCid/"
 	cidToKeyMapPrefix      = "map/cidKey/"
 	keyToMetadataMapPrefix = "map/keyMD/"
+	contextIDSetPrefix     = "map/key/"
+	keyToFormatMapPrefix   = "map/keyFormat/"
+	entriesFormatsKey      = "sync/entriesFormats"
 	latestAdvKey           = "sync/adv/"
 	linksCachePath         = "/cache/links"
 )
@@ -37,11 +42,20 @@ const (
 var (
 	log = logging.Logger("provider/engine")
 
-	dsLatestAdvKey = datastore.NewKey(latestAdvKey)
+	dsLatestAdvKey      = datastore.NewKey(latestAdvKey)
+	dsEntriesFormatsKey = datastore.NewKey(entriesFormatsKey)
 )
 
 // Engine is an implementation of the core reference provider interface.
 type Engine struct {
+	// entCacheHits and entCacheMisses count the lookups of entries chunks requested by indexers
+	// that are found in cache, and that are not found and regenerated respectively. entMismatches
+	// counts the regenerated entries that do not match the advertised entries. Accessed
+	// atomically.
+	entCacheHits   uint64
+	entCacheMisses uint64
+	entMismatches  uint64
+
 	*options
 	lsys ipld.LinkSystem
 
@@ -51,6 +65,39 @@ type Engine struct {
 
 	mhLister provider.MultihashLister
 	cblk     sync.Mutex
+	// publishLk serializes the generation and storage of advertisements, so that each
+	// advertisement links to the one published immediately before it and the advertisement chain
+	// never forks under concurrent callers.
+	publishLk sync.Mutex
+	// announceLk serializes the announcement of advertisements, which is made without holding
+	// publishLk so that slow announcements do not hold up publishing; see Engine.announce.
+	announceLk sync.Mutex
+
+	// gcCancel stops the periodic garbage collection of obsolete advertisements, and gcDone is
+	// closed once it has stopped.
+	gcCancel context.CancelFunc
+	gcDone   chan struct{}
+
+	// warmUp is the progress of the current or last warm-up of the entries cache. warmUpCancel
+	// stops the warm-up in progress, and warmUpDone is closed once it has stopped.
+	warmUp       WarmUpProgress
+	warmUpCancel context.CancelFunc
+	warmUpDone   chan struct{}
+	warmUpLk     sync.Mutex
+
+	// corruptLk serializes updates to corrupted context IDs, and corruptRmWg tracks the removal
+	// of corrupted context IDs in progress. Once corruptClosing is set, under corruptLk, no more
+	// removals are started. corruptRmCtx is the context of removals, canceled on shutdown.
+	corruptLk       sync.Mutex
+	corruptRmWg     sync.WaitGroup
+	corruptClosing  bool
+	corruptRmCtx    context.Context
+	corruptRmCancel context.CancelFunc
+
+	// retrievalAddrs are the current retrieval addresses of the provider, initialized from the
+	// configured addresses and updated via Engine.SetRetrievalAddrs.
+	retrievalAddrs []multiaddr.Multiaddr
+	addrsLk        sync.RWMutex
 }
 
 var _ provider.Interface = (*Engine)(nil)
@@ -86,8 +133,10 @@ func New(o ...Option) (*Engine, error) {
 	}
 
 	e := &Engine{
-		options: opts,
+		options:        opts,
+		retrievalAddrs: opts.provider.Addrs,
 	}
+	e.corruptRmCtx, e.corruptRmCancel = context.WithCancel(context.Background())
 
 	e.lsys = e.mkLinkSystem()
 
@@ -103,11 +152,31 @@ func New(o ...Option) (*Engine, error) {
 func (e *Engine) Start(ctx context.Context) error {
 	var err error
 	// Create datastore entriesChunker.
-	entriesCacheDs := dsn.Wrap(e.ds, datastore.NewKey(linksCachePath))
-	e.entriesChunker, err = chunker.NewCachedEntriesChunker(ctx, entriesCacheDs, e.entCacheCap, e.chunker, e.purgeCache)
+	var entriesCacheDs datastore.Batching
+	if e.entCacheDs == nil {
+		entriesCacheDs = dsn.Wrap(e.ds, datastore.NewKey(linksCachePath))
+	} else {
+		if err = e.migrateEntriesCache(ctx, e.entCacheDs); err != nil {
+			return fmt.Errorf("failed to migrate entries cache: %w", err)
+		}
+		entriesCacheDs = e.entCacheDs
+	}
+	e.entriesChunker, err = chunker.NewCachedEntriesChunkerWithMaxBytes(ctx, entriesCacheDs, e.entCacheCap, e.entCacheMaxBytes, e.chunker, e.purgeCache)
 	if err != nil {
 		return err
 	}
+	if err = e.recordEntriesFormats(ctx); err != nil {
+		return fmt.Errorf("failed to record entries formats: %w", err)
+	}
+
+	// Repair any half-written state left behind by an interrupted run before advertising again.
+	if err = e.recover(ctx); err != nil {
+		return fmt.Errorf("failed to recover engine state: %w", err)
+	}
+
+	if err = e.loadRetrievalAddrs(ctx); err != nil {
+		return err
+	}
 
 	e.publisher, err = e.newPublisher()
 	if err != nil {
@@ -128,6 +197,13 @@ func (e *Engine) Start(ctx context.Context) error {
 		}
 	}
 
+	if len(e.gcIndexers) != 0 {
+		var gcCtx context.Context
+		gcCtx, e.gcCancel = context.WithCancel(context.Background())
+		e.gcDone = make(chan struct{})
+		go e.gcLoop(gcCtx)
+	}
+
 	return nil
 }
 
@@ -163,6 +239,29 @@ func (e *Engine) newPublisher() (legs.Publisher, error) {
 //
 // See: Engine.Publish.
 func (e *Engine) PublishLocal(ctx context.Context, adv schema.Advertisement) (cid.Cid, error) {
+	e.publishLk.Lock()
+	defer e.publishLk.Unlock()
+	return e.publishLocal(ctx, adv)
+}
+
+func (e *Engine) publishLocal(ctx context.Context, adv schema.Advertisement) (cid.Cid, error) {
+	b, err := newDsBatch(ctx, e.ds)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("could not create datastore batch: %w", err)
+	}
+	c, err := e.storeAdv(ctx, b, adv)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := b.Commit(ctx); err != nil {
+		return cid.Undef, fmt.Errorf("failed to commit advertisement: %w", err)
+	}
+	return c, nil
+}
+
+// storeAdv validates and stores the given advertisement via the given dsReadWriter, and marks it
+// as the latest advertisement.
+func (e *Engine) storeAdv(ctx context.Context, rw dsReadWriter, adv schema.Advertisement) (cid.Cid, error) {
 	if err := adv.Validate(); err != nil {
 		return cid.Undef, err
 	}
@@ -172,7 +271,8 @@ func (e *Engine) PublishLocal(ctx context.Context, adv schema.Advertisement) (ci
 		return cid.Undef, err
 	}
 
-	lnk, err := e.lsys.Store(ipld.LinkContext{Ctx: ctx}, schema.Linkproto, adNode)
+	lsys := writerLinkSystem(rw)
+	lnk, err := lsys.Store(ipld.LinkContext{Ctx: ctx}, schema.Linkproto, adNode)
 	if err != nil {
 		return cid.Undef, fmt.Errorf("cannot generate advertisement link: %s", err)
 	}
@@ -180,7 +280,7 @@ func (e *Engine) PublishLocal(ctx context.Context, adv schema.Advertisement) (ci
 	log := log.With("adCid", c)
 	log.Info("Stored ad in local link system")
 
-	if err = e.putLatestAdv(ctx, c.Bytes()); err != nil {
+	if err = putLatestAdv(ctx, rw, c.Bytes()); err != nil {
 		log.Errorw("Failed to update reference to the latest advertisement", "err", err)
 		return cid.Undef, fmt.Errorf("failed to update reference to latest advertisement: %w", err)
 	}
@@ -201,24 +301,48 @@ func (e *Engine) Publish(ctx context.Context, adv schema.Advertisement) (cid.Cid
 		return cid.Undef, fmt.Errorf("failed to publish advertisement locally: %w", err)
 	}
 
+	if err := e.announce(ctx, c); err != nil {
+		return cid.Undef, err
+	}
+	return c, nil
+}
+
+// announce signals the change in the latest advertisement to indexer nodes, both via the
+// configured publisher and via direct HTTP announcements. No announcements are made if the
+// publisher is not configured.
+//
+// Announcements are made without holding publishLk, which means further advertisements may be
+// committed in the meantime. The latest committed advertisement is therefore announced in place of
+// the given one if they differ, so that announcements never move the published root backwards.
+func (e *Engine) announce(ctx context.Context, c cid.Cid) error {
 	// Only announce the advertisement CID if publisher is configured.
 	if e.publisher != nil {
+		e.announceLk.Lock()
+		defer e.announceLk.Unlock()
+		latest, err := e.getLatestAdCid(ctx)
+		if err != nil {
+			return fmt.Errorf("could not get latest advertisement cid: %w", err)
+		}
+		if latest != c {
+			log.Debugw("Announcing latest advertisement in place of superseded advertisement", "adCid", c, "latest", latest)
+			c = latest
+		}
+
 		log := log.With("adCid", c)
 		log.Info("Announcing advertisement in pubsub channel")
 		err = e.publisher.UpdateRoot(ctx, c)
 		if err != nil {
 			log.Errorw("Failed to announce advertisement on pubsub channel ", "err", err)
-			return cid.Undef, err
+			return err
 		}
 
 		err = e.httpAnnounce(ctx, c, e.announceURLs)
 		if err != nil {
 			log.Errorw("Failed to announce advertisement via http", "err", err)
-			return cid.Undef, err
+			return err
 		}
 	}
-
-	return c, nil
+	return nil
 }
 
 func (e *Engine) latestAdToPublish(ctx context.Context) (cid.Cid, error) {
@@ -243,6 +367,9 @@ func (e *Engine) latestAdToPublish(ctx context.Context) (cid.Cid, error) {
 
 // PublishLatest re-publishes the latest existing advertisement to pubsub.
 func (e *Engine) PublishLatest(ctx context.Context) (cid.Cid, error) {
+	e.announceLk.Lock()
+	defer e.announceLk.Unlock()
+
 	adCid, err := e.latestAdToPublish(ctx)
 	if err != nil {
 		return cid.Undef, err
@@ -351,10 +478,14 @@ func (e *Engine) RegisterMultihashLister(mhl provider.MultihashLister) {
 // up the list of multihashes associated to a context ID.
 //
 // Note that prior to calling this function a provider.MultihashLister must be
-// registered.
+// registered. An error of type metadata.ErrInvalidMetadata is returned if the
+// given metadata is not valid, and ErrEmptyContextID if the context ID is empty.
 //
 // See: Engine.RegisterMultihashLister, Engine.Publish.
 func (e *Engine) NotifyPut(ctx context.Context, contextID []byte, md metadata.Metadata) (cid.Cid, error) {
+	if err := md.Validate(); err != nil {
+		return cid.Undef, err
+	}
 	// The multihash lister must have been registered for the linkSystem to
 	// know how to go from contextID to list of CIDs.
 	return e.publishAdvForIndex(ctx, contextID, md, false)
@@ -375,6 +506,18 @@ func (e *Engine) NotifyRemove(ctx context.Context, contextID []byte) (cid.Cid, e
 // engine. The engine is no longer usable after the call to this function.
 func (e *Engine) Shutdown() error {
 	var errs error
+	if e.gcCancel != nil {
+		e.gcCancel()
+		<-e.gcDone
+	}
+	e.stopWarmUp()
+	// Stop starting removals of corrupted context IDs before waiting for the ones in progress, so
+	// that none outlives the publisher and the entries chunker.
+	e.corruptLk.Lock()
+	e.corruptClosing = true
+	e.corruptLk.Unlock()
+	e.corruptRmCancel()
+	e.corruptRmWg.Wait()
 	if e.publisher != nil {
 		if err := e.publisher.Close(); err != nil {
 			errs = multierror.Append(errs, fmt.Errorf("error closing leg publisher: %s", err))
@@ -395,7 +538,7 @@ func (e *Engine) GetAdv(_ context.Context, adCid cid.Cid) (*schema.Advertisement
 	lsys := e.vanillaLinkSystem()
 	n, err := lsys.Load(ipld.LinkContext{}, cidlink.Link{Cid: adCid}, schema.AdvertisementPrototype)
 	if err != nil {
-		return nil, fmt.Errorf("cannot load advertisement from blockstore with vanilla linksystem: %s", err)
+		return nil, fmt.Errorf("cannot load advertisement from blockstore with vanilla linksystem: %w", err)
 	}
 	return schema.UnwrapAdvertisement(n)
 }
@@ -420,16 +563,68 @@ func (e *Engine) GetLatestAdv(ctx context.Context) (cid.Cid, *schema.Advertiseme
 	return latestAdCid, ad, nil
 }
 
+// publishAdvForIndex generates, stores and announces an advertisement that signals the put or
+// removal of the given context ID.
+//
+// The updated mappings, the advertisement itself and the reference to the latest advertisement are
+// committed to the datastore as a single batch, so that a crash never leaves behind mappings that
+// are not referenced by the latest advertisement. Concurrent calls are serialized to guarantee a
+// linear advertisement chain.
 func (e *Engine) publishAdvForIndex(ctx context.Context, contextID []byte, md metadata.Metadata, isRm bool) (cid.Cid, error) {
+	c, err := e.commitAdvForIndex(ctx, contextID, md, isRm)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := e.announce(ctx, c); err != nil {
+		return cid.Undef, err
+	}
+	return c, nil
+}
+
+// commitAdvForIndex generates, stores and commits the advertisement that signals the put or removal
+// of the given context ID, and returns its CID.
+func (e *Engine) commitAdvForIndex(ctx context.Context, contextID []byte, md metadata.Metadata, isRm bool) (cid.Cid, error) {
+	e.publishLk.Lock()
+	defer e.publishLk.Unlock()
+
+	b, err := newDsBatch(ctx, e.ds)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("could not create datastore batch: %w", err)
+	}
+	adv, err := e.generateAdvForIndex(ctx, b, contextID, md, isRm)
+	if err != nil {
+		return cid.Undef, err
+	}
+	c, err := e.storeAdv(ctx, b, *adv)
+	if err != nil {
+		log.Errorw("Failed to store advertisement locally", "err", err)
+		return cid.Undef, fmt.Errorf("failed to publish advertisement locally: %w", err)
+	}
+	if err := b.Commit(ctx); err != nil {
+		log.Errorw("Failed to commit advertisement", "err", err)
+		return cid.Undef, fmt.Errorf("failed to commit advertisement: %w", err)
+	}
+	return c, nil
+}
+
+// generateAdvForIndex generates a signed advertisement that signals the put or removal of the given
+// context ID, linked to the latest advertisement. The mappings between context ID, entries CID and
+// metadata are read and updated via the given dsReadWriter.
+//
+// Note that the generated advertisement is not stored; see: Engine.storeAdv.
+func (e *Engine) generateAdvForIndex(ctx context.Context, rw dsReadWriter, contextID []byte, md metadata.Metadata, isRm bool) (*schema.Advertisement, error) {
 	var err error
 	var cidsLnk cidlink.Link
 
+	if len(contextID) == 0 {
+		return nil, ErrEmptyContextID
+	}
 	log := log.With("contextID", base64.StdEncoding.EncodeToString(contextID))
 
-	c, err := e.getKeyCidMap(ctx, contextID)
+	c, err := getKeyCidMap(ctx, rw, contextID)
 	if err != nil {
 		if err != datastore.ErrNotFound {
-			return cid.Undef, fmt.Errorf("cound not not get entries cid by context id: %s", err)
+			return nil, fmt.Errorf("cound not not get entries cid by context id: %s", err)
 		}
 	}
 
@@ -444,34 +639,69 @@ func (e *Engine) publishAdvForIndex(ctx context.Context, contextID []byte, md me
 			log.Info("Generating entries linked list for advertisement")
 			// If no lister registered return error.
 			if e.mhLister == nil {
-				return cid.Undef, provider.ErrNoMultihashLister
+				return nil, provider.ErrNoMultihashLister
 			}
 
 			// Call the lister.
 			mhIter, err := e.mhLister(ctx, contextID)
 			if err != nil {
-				return cid.Undef, err
+				return nil, err
+			}
+			defer closeMhIterator(mhIter)
+			// The multihash index may be too large to hold in memory until the advertisement is
+			// committed, and is therefore written directly to the datastore.
+			var indexWriter *streamingBatch
+			if e.mhIndex {
+				if err := deleteMultihashIndex(ctx, e.ds, contextID); err != nil {
+					return nil, fmt.Errorf("failed to delete multihash index of context id: %s", err)
+				}
+				indexWriter, err = e.newMultihashIndexWriter(ctx, contextID)
+				if err != nil {
+					return nil, fmt.Errorf("failed to create multihash index writer: %s", err)
+				}
+				mhIter = &indexingMultihashIterator{ctx: ctx, w: indexWriter, contextID: contextID, mhi: mhIter}
 			}
 			// Generate the linked list ipld.Link that is added to the
 			// advertisement and used for ingestion.
 			lnk, err := e.entriesChunker.Chunk(ctx, mhIter)
 			if err != nil {
-				return cid.Undef, fmt.Errorf("could not generate entries list: %s", err)
+				return nil, fmt.Errorf("could not generate entries list: %s", err)
 			}
 			cidsLnk = lnk.(cidlink.Link)
 
+			if indexWriter != nil {
+				if err := indexWriter.Commit(ctx); err != nil {
+					return nil, fmt.Errorf("failed to write multihash index: %s", err)
+				}
+				// The index is complete once committed along with the advertisement.
+				if err := putIndexedEntries(ctx, rw, contextID, cidsLnk.Cid); err != nil {
+					return nil, fmt.Errorf("failed to write context id to indexed entries mapping: %s", err)
+				}
+			}
+
 			// Store the relationship between contextID and CID of the
 			// advertised list of Cids.
-			err = e.putKeyCidMap(ctx, contextID, cidsLnk.Cid)
+			err = putKeyCidMap(ctx, rw, contextID, cidsLnk.Cid)
 			if err != nil {
-				return cid.Undef, fmt.Errorf("failed to write context id to entries cid mapping: %s", err)
+				return nil, fmt.Errorf("failed to write context id to entries cid mapping: %s", err)
+			}
+			// Store the format of the entries, so that they can be regenerated identically even
+			// if the configured format changes.
+			err = putKeyFormatMap(ctx, rw, contextID, e.entriesChunker.Format())
+			if err != nil {
+				return nil, fmt.Errorf("failed to write context id to entries format mapping: %s", err)
+			}
+			// The context ID is advertised afresh; it is no longer corrupted, if it ever was.
+			err = deleteCorruptedContextID(ctx, rw, contextID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to delete corrupted context id: %s", err)
 			}
 		} else {
 			// Lookup metadata for this contextID.
-			prevMetadata, err := e.getKeyMetadataMap(ctx, contextID)
+			prevMetadata, err := getKeyMetadataMap(ctx, rw, contextID)
 			if err != nil {
 				if err != datastore.ErrNotFound {
-					return cid.Undef, fmt.Errorf("could not get metadata for context id: %s", err)
+					return nil, fmt.Errorf("could not get metadata for context id: %s", err)
 				}
 				log.Warn("No metadata for existing context ID, generating new advertisement")
 			}
@@ -479,7 +709,7 @@ func (e *Engine) publishAdvForIndex(ctx context.Context, contextID []byte, md me
 			if md.Equal(prevMetadata) {
 				// Metadata is the same; no change, no need for new
 				// advertisement.
-				return cid.Undef, provider.ErrAlreadyAdvertised
+				return nil, provider.ErrAlreadyAdvertised
 			}
 
 			// Linked list is the same, but metadata is different, so generate
@@ -487,29 +717,38 @@ func (e *Engine) publishAdvForIndex(ctx context.Context, contextID []byte, md me
 			cidsLnk = cidlink.Link{Cid: c}
 		}
 
-		if err = e.putKeyMetadataMap(ctx, contextID, &md); err != nil {
-			return cid.Undef, fmt.Errorf("failed to write context id to metadata mapping: %s", err)
+		if err = putKeyMetadataMap(ctx, rw, contextID, &md); err != nil {
+			return nil, fmt.Errorf("failed to write context id to metadata mapping: %s", err)
 		}
 	} else {
 		log.Info("Creating removal advertisement")
 
 		if c == cid.Undef {
-			return cid.Undef, provider.ErrContextIDNotFound
+			return nil, provider.ErrContextIDNotFound
 		}
 
 		// If removing by context ID, it means the list of CIDs is not needed
 		// anymore, so we can remove the entry from the datastore.
-		err = e.deleteKeyCidMap(ctx, contextID)
+		err = deleteKeyCidMap(ctx, rw, contextID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete context id to entries cid mapping: %s", err)
+		}
+		err = deleteCidKeyMap(ctx, rw, c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete entries cid to context id mapping: %s", err)
+		}
+		err = deleteKeyMetadataMap(ctx, rw, contextID)
 		if err != nil {
-			return cid.Undef, fmt.Errorf("failed to delete context id to entries cid mapping: %s", err)
+			return nil, fmt.Errorf("failed to delete context id to metadata mapping: %s", err)
 		}
-		err = e.deleteCidKeyMap(ctx, c)
+		// Delete any indexed multihashes, regardless of whether the index is currently enabled.
+		err = deleteMultihashIndex(ctx, e.ds, contextID)
 		if err != nil {
-			return cid.Undef, fmt.Errorf("failed to delete entries cid to context id mapping: %s", err)
+			return nil, fmt.Errorf("failed to delete multihash index of context id: %s", err)
 		}
-		err = e.deleteKeyMetadataMap(ctx, contextID)
+		err = deleteDeltaEntries(ctx, rw, contextID)
 		if err != nil {
-			return cid.Undef, fmt.Errorf("failed to delete context id to metadata mapping: %s", err)
+			return nil, fmt.Errorf("failed to delete delta entries of context id: %s", err)
 		}
 
 		// Create an advertisement to delete content by contextID by specifying
@@ -521,24 +760,40 @@ func (e *Engine) publishAdvForIndex(ctx context.Context, contextID []byte, md me
 		md = metadata.New(metadata.Bitswap{})
 	}
 
+	return e.newSignedAdv(ctx, rw, contextID, cidsLnk, md, isRm)
+}
+
+// closeMhIterator closes the given iterator if it implements io.Closer, since the engine may stop
+// iterating before reaching the end. See: provider.MultihashIterator.
+func closeMhIterator(mhi provider.MultihashIterator) {
+	if c, ok := mhi.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Warnw("Failed to close multihash iterator", "err", err)
+		}
+	}
+}
+
+// newSignedAdv generates a signed advertisement with the given entries, context ID and metadata,
+// linked to the latest advertisement read via the given dsReadWriter.
+func (e *Engine) newSignedAdv(ctx context.Context, rw dsReadWriter, contextID []byte, entries ipld.Link, md metadata.Metadata, isRm bool) (*schema.Advertisement, error) {
 	mdBytes, err := md.MarshalBinary()
 	if err != nil {
-		return cid.Undef, err
+		return nil, err
 	}
 
 	adv := schema.Advertisement{
 		Provider:  e.options.provider.ID.String(),
 		Addresses: e.retrievalAddrsAsString(),
-		Entries:   cidsLnk,
+		Entries:   entries,
 		ContextID: contextID,
 		Metadata:  mdBytes,
 		IsRm:      isRm,
 	}
 
 	// Get the previous advertisement that was generated.
-	prevAdvID, err := e.getLatestAdCid(ctx)
+	prevAdvID, err := getLatestAdCid(ctx, rw)
 	if err != nil {
-		return cid.Undef, fmt.Errorf("could not get latest advertisement: %s", err)
+		return nil, fmt.Errorf("could not get latest advertisement: %s", err)
 	}
 
 	// Check for cid.Undef for the previous link. If this is the case, then
@@ -551,25 +806,30 @@ func (e *Engine) publishAdvForIndex(ctx context.Context, contextID []byte, md me
 
 	// Sign the advertisement.
 	if err := adv.Sign(e.key); err != nil {
-		return cid.Undef, err
+		return nil, err
 	}
-	return e.Publish(ctx, adv)
+	return &adv, nil
 }
 
-func (e *Engine) putKeyCidMap(ctx context.Context, contextID []byte, c cid.Cid) error {
+func putKeyCidMap(ctx context.Context, rw dsReadWriter, contextID []byte, c cid.Cid) error {
 	// Store the map Key-Cid to know what CidLink to put in advertisement when
 	// notifying about a removal.
-	err := e.ds.Put(ctx, datastore.NewKey(keyToCidMapPrefix+string(contextID)), c.Bytes())
+	err := rw.Put(ctx, datastore.NewKey(keyToCidMapPrefix+string(contextID)), c.Bytes())
 	if err != nil {
 		return err
 	}
 	// And the other way around when graphsync is making a request, so the
 	// lister in the linksystem knows to what contextID the CID referrs to.
-	return e.ds.Put(ctx, datastore.NewKey(cidToKeyMapPrefix+c.String()), contextID)
+	err = rw.Put(ctx, datastore.NewKey(cidToKeyMapPrefix+c.String()), contextID)
+	if err != nil {
+		return err
+	}
+	// Add the contextID to the set of context IDs so that it can be listed.
+	return rw.Put(ctx, contextIDSetKey(contextID), contextID)
 }
 
-func (e *Engine) getKeyCidMap(ctx context.Context, contextID []byte) (cid.Cid, error) {
-	b, err := e.ds.Get(ctx, datastore.NewKey(keyToCidMapPrefix+string(contextID)))
+func getKeyCidMap(ctx context.Context, rw dsReadWriter, contextID []byte) (cid.Cid, error) {
+	b, err := rw.Get(ctx, datastore.NewKey(keyToCidMapPrefix+string(contextID)))
 	if err != nil {
 		return cid.Undef, err
 	}
@@ -577,28 +837,105 @@ func (e *Engine) getKeyCidMap(ctx context.Context, contextID []byte) (cid.Cid, e
 	return d, err
 }
 
-func (e *Engine) deleteKeyCidMap(ctx context.Context, contextID []byte) error {
-	return e.ds.Delete(ctx, datastore.NewKey(keyToCidMapPrefix+string(contextID)))
+func deleteKeyCidMap(ctx context.Context, rw dsReadWriter, contextID []byte) error {
+	if err := rw.Delete(ctx, contextIDSetKey(contextID)); err != nil {
+		return err
+	}
+	if err := rw.Delete(ctx, datastore.NewKey(keyToFormatMapPrefix+string(contextID))); err != nil {
+		return err
+	}
+	if err := rw.Delete(ctx, indexedEntriesKey(contextID)); err != nil {
+		return err
+	}
+	return rw.Delete(ctx, datastore.NewKey(keyToCidMapPrefix+string(contextID)))
+}
+
+// putKeyFormatMap stores the format in which the entries advertised for the given context ID were
+// generated. Nothing is stored if the format is unknown.
+func putKeyFormatMap(ctx context.Context, rw dsReadWriter, contextID []byte, format string) error {
+	if format == "" {
+		return nil
+	}
+	return rw.Put(ctx, datastore.NewKey(keyToFormatMapPrefix+string(contextID)), []byte(format))
 }
 
-func (e *Engine) deleteCidKeyMap(ctx context.Context, c cid.Cid) error {
-	return e.ds.Delete(ctx, datastore.NewKey(cidToKeyMapPrefix+c.String()))
+// getKeyFormatMap gets the format in which the entries advertised for the given context ID were
+// generated, or empty string if unknown.
+func getKeyFormatMap(ctx context.Context, rw dsReadWriter, contextID []byte) (string, error) {
+	b, err := rw.Get(ctx, datastore.NewKey(keyToFormatMapPrefix+string(contextID)))
+	if err == datastore.ErrNotFound {
+		return "", nil
+	}
+	return string(b), err
+}
+
+// recordEntriesFormats records the format of the entries chunker, preceded by the format of the
+// entries cached prior to any format change, as the most recently used entries formats.
+// See: Engine.entriesFormatCandidates.
+func (e *Engine) recordEntriesFormats(ctx context.Context) error {
+	used, err := getEntriesFormats(ctx, e.ds)
+	if err != nil {
+		return err
+	}
+	var formats []string
+	for _, format := range []string{e.entriesChunker.Format(), e.entriesChunker.PreviousFormat()} {
+		if format != "" {
+			formats = append(formats, format)
+		}
+	}
+	for _, format := range used {
+		if format != e.entriesChunker.Format() && format != e.entriesChunker.PreviousFormat() {
+			formats = append(formats, format)
+		}
+	}
+	v, err := json.Marshal(formats)
+	if err != nil {
+		return err
+	}
+	return e.ds.Put(ctx, dsEntriesFormatsKey, v)
+}
+
+// getEntriesFormats gets the entries formats used by the engine, from the most recently used.
+func getEntriesFormats(ctx context.Context, rw dsReadWriter) ([]string, error) {
+	v, err := rw.Get(ctx, dsEntriesFormatsKey)
+	if err != nil {
+		if err == datastore.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var formats []string
+	if err := json.Unmarshal(v, &formats); err != nil {
+		return nil, fmt.Errorf("cannot decode entries formats: %w", err)
+	}
+	return formats, nil
+}
+
+// contextIDSetKey returns the key of the given context ID in the set of context IDs mapped to
+// entries. Context IDs are base64url encoded in the key so that they can be listed exactly; the
+// keys of other mappings may not preserve arbitrary context ID bytes.
+func contextIDSetKey(contextID []byte) datastore.Key {
+	return datastore.NewKey(contextIDSetPrefix + base64.RawURLEncoding.EncodeToString(contextID))
+}
+
+func deleteCidKeyMap(ctx context.Context, rw dsReadWriter, c cid.Cid) error {
+	return rw.Delete(ctx, datastore.NewKey(cidToKeyMapPrefix+c.String()))
 }
 
 func (e *Engine) getCidKeyMap(ctx context.Context, c cid.Cid) ([]byte, error) {
 	return e.ds.Get(ctx, datastore.NewKey(cidToKeyMapPrefix+c.String()))
 }
 
-func (e *Engine) putKeyMetadataMap(ctx context.Context, contextID []byte, metadata *metadata.Metadata) error {
+func putKeyMetadataMap(ctx context.Context, rw dsReadWriter, contextID []byte, metadata *metadata.Metadata) error {
 	data, err := metadata.MarshalBinary()
 	if err != nil {
 		return err
 	}
-	return e.ds.Put(ctx, datastore.NewKey(keyToMetadataMapPrefix+string(contextID)), data)
+	return rw.Put(ctx, datastore.NewKey(keyToMetadataMapPrefix+string(contextID)), data)
 }
 
-func (e *Engine) getKeyMetadataMap(ctx context.Context, contextID []byte) (metadata.Metadata, error) {
-	data, err := e.ds.Get(ctx, datastore.NewKey(keyToMetadataMapPrefix+string(contextID)))
+func getKeyMetadataMap(ctx context.Context, rw dsReadWriter, contextID []byte) (metadata.Metadata, error) {
+	data, err := rw.Get(ctx, datastore.NewKey(keyToMetadataMapPrefix+string(contextID)))
 	if err != nil {
 		return metadata.Metadata{}, err
 	}
@@ -609,16 +946,20 @@ func (e *Engine) getKeyMetadataMap(ctx context.Context, contextID []byte) (metad
 	return md, nil
 }
 
-func (e *Engine) deleteKeyMetadataMap(ctx context.Context, contextID []byte) error {
-	return e.ds.Delete(ctx, datastore.NewKey(keyToMetadataMapPrefix+string(contextID)))
+func deleteKeyMetadataMap(ctx context.Context, rw dsReadWriter, contextID []byte) error {
+	return rw.Delete(ctx, datastore.NewKey(keyToMetadataMapPrefix+string(contextID)))
 }
 
-func (e *Engine) putLatestAdv(ctx context.Context, advID []byte) error {
-	return e.ds.Put(ctx, dsLatestAdvKey, advID)
+func putLatestAdv(ctx context.Context, rw dsReadWriter, advID []byte) error {
+	return rw.Put(ctx, dsLatestAdvKey, advID)
 }
 
 func (e *Engine) getLatestAdCid(ctx context.Context) (cid.Cid, error) {
-	b, err := e.ds.Get(ctx, dsLatestAdvKey)
+	return getLatestAdCid(ctx, e.ds)
+}
+
+func getLatestAdCid(ctx context.Context, rw dsReadWriter) (cid.Cid, error) {
+	b, err := rw.Get(ctx, dsLatestAdvKey)
 	if err != nil {
 		if err == datastore.ErrNotFound {
 			return cid.Undef, nil
@@ -3,11 +3,15 @@ package engine_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -17,19 +21,24 @@ import (
 	"github.com/filecoin-project/index-provider/engine"
 	"github.com/filecoin-project/index-provider/metadata"
 	"github.com/filecoin-project/index-provider/testutil"
+	"github.com/filecoin-project/storetheindex/api/v0/finder/model"
 	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/ipld/go-ipld-prime/storage/memstore"
 	"github.com/ipld/go-ipld-prime/traversal/selector"
 	selectorbuilder "github.com/ipld/go-ipld-prime/traversal/selector/builder"
 	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/peer"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/time/rate"
 )
@@ -309,6 +318,66 @@ func TestEngine_NotifyPutWithoutListerIsError(t *testing.T) {
 	require.Equal(t, cid.Undef, gotCid)
 }
 
+func TestEngine_NotifyPutWithInvalidMetadataIsError(t *testing.T) {
+	ctx := contextWithTimeout(t)
+	subject, err := engine.New()
+	require.NoError(t, err)
+	err = subject.Start(ctx)
+	require.NoError(t, err)
+	defer subject.Shutdown()
+	subject.RegisterMultihashLister(func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(nil), nil
+	})
+
+	gotCid, err := subject.NotifyPut(ctx, []byte("fish"), metadata.New(&metadata.GraphsyncFilecoinV1{}))
+	require.ErrorIs(t, err, metadata.ErrInvalidProtocol)
+	require.Equal(t, cid.Undef, gotCid)
+
+	_, err = subject.NotifyBatch(ctx, []provider.Notification{{ContextID: []byte("fish"), Metadata: metadata.New()}})
+	require.ErrorIs(t, err, metadata.ErrNoProtocols)
+
+	headCid, _, err := subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+	require.Equal(t, cid.Undef, headCid)
+}
+
+func TestEngine_ClosesMultihashIterators(t *testing.T) {
+	ctx := contextWithTimeout(t)
+	rng := rand.New(rand.NewSource(1413))
+
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+	var opened, closed int
+	subject, err := engine.New(engine.WithMultihashIndex(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+	subject.RegisterMultihashLister(func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		opened++
+		return &closableMhIterator{
+			MultihashIterator: provider.SliceMultihashIterator(mhs),
+			close:             func() { closed++ },
+		}, nil
+	})
+
+	_, err = subject.NotifyPut(ctx, []byte("fish"), metadata.New(metadata.Bitswap{}))
+	require.NoError(t, err)
+	_, err = subject.NotifyUpdate(ctx, []byte("fish"), metadata.New(metadata.Bitswap{}, &metadata.GraphsyncFilecoinV1{PieceCID: testutil.RandomCids(t, rng, 1)[0]}))
+	require.NoError(t, err)
+	require.Equal(t, 2, opened)
+	require.Equal(t, opened, closed)
+}
+
+// closableMhIterator is a provider.MultihashIterator that implements io.Closer.
+type closableMhIterator struct {
+	provider.MultihashIterator
+	close func()
+}
+
+func (i *closableMhIterator) Close() error {
+	i.close()
+	return nil
+}
+
 func TestEngine_NotifyPutThenNotifyRemove(t *testing.T) {
 	ctx := contextWithTimeout(t)
 	rng := rand.New(rand.NewSource(1413))
@@ -347,6 +416,864 @@ func TestEngine_NotifyPutThenNotifyRemove(t *testing.T) {
 	require.NotEqual(t, gotLatestAfterRmAdCid, gotLatestAdCid)
 }
 
+func TestEngine_NotifyBatch(t *testing.T) {
+	ctx := contextWithTimeout(t)
+	rng := rand.New(rand.NewSource(1413))
+
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+
+	subject, err := engine.New()
+	require.NoError(t, err)
+	err = subject.Start(ctx)
+	require.NoError(t, err)
+	defer subject.Shutdown()
+
+	subject.RegisterMultihashLister(func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	})
+
+	md := metadata.New(metadata.Bitswap{})
+	gotAdCids, err := subject.NotifyBatch(ctx, []provider.Notification{
+		{ContextID: []byte("fish"), Metadata: md},
+		{ContextID: []byte("lobster"), Metadata: md},
+		{ContextID: []byte("fish"), IsRemove: true},
+	})
+	require.NoError(t, err)
+	require.Len(t, gotAdCids, 3)
+
+	// Assert the advertisements are linked in the order of notifications.
+	gotLatestAdCid, gotLatestAd, err := subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+	require.Equal(t, gotAdCids[2], gotLatestAdCid)
+	require.True(t, gotLatestAd.IsRm)
+	require.Equal(t, []byte("fish"), gotLatestAd.ContextID)
+	for i := len(gotAdCids) - 1; i > 0; i-- {
+		ad, err := subject.GetAdv(ctx, gotAdCids[i])
+		require.NoError(t, err)
+		require.NotNil(t, ad.PreviousID)
+		require.Equal(t, gotAdCids[i-1], (*ad.PreviousID).(cidlink.Link).Cid)
+	}
+	firstAd, err := subject.GetAdv(ctx, gotAdCids[0])
+	require.NoError(t, err)
+	require.Nil(t, firstAd.PreviousID)
+
+	// Assert already advertised context IDs are skipped, and the remaining are published.
+	gotAdCids2, err := subject.NotifyBatch(ctx, []provider.Notification{
+		{ContextID: []byte("lobster"), Metadata: md},
+		{ContextID: []byte("fish"), Metadata: md},
+	})
+	require.NoError(t, err)
+	require.Equal(t, cid.Undef, gotAdCids2[0])
+	require.NotEqual(t, cid.Undef, gotAdCids2[1])
+	gotLatestAdCid, _, err = subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+	require.Equal(t, gotAdCids2[1], gotLatestAdCid)
+
+	// Assert a failing notification discards the entire batch.
+	_, err = subject.NotifyBatch(ctx, []provider.Notification{
+		{ContextID: []byte("fish"), IsRemove: true},
+		{ContextID: []byte("unknown"), IsRemove: true},
+	})
+	require.ErrorIs(t, err, provider.ErrContextIDNotFound)
+	gotLatestAdCid, _, err = subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+	require.Equal(t, gotAdCids2[1], gotLatestAdCid)
+	_, err = subject.NotifyPut(ctx, []byte("fish"), md)
+	require.Equal(t, provider.ErrAlreadyAdvertised, err)
+}
+
+func TestEngine_ConcurrentNotifyProducesLinearChain(t *testing.T) {
+	ctx := contextWithTimeout(t)
+	rng := rand.New(rand.NewSource(1413))
+
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+
+	subject, err := engine.New()
+	require.NoError(t, err)
+	err = subject.Start(ctx)
+	require.NoError(t, err)
+	defer subject.Shutdown()
+
+	subject.RegisterMultihashLister(func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	})
+
+	const workers = 16
+	const putsPerWorker = 8
+	md := metadata.New(metadata.Bitswap{})
+	adCids := make(chan cid.Cid, workers*putsPerWorker*2)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < putsPerWorker; i++ {
+				contextID := []byte(fmt.Sprintf("fish-%d-%d", w, i))
+				c, err := subject.NotifyPut(ctx, contextID, md)
+				if !assert.NoError(t, err) {
+					return
+				}
+				adCids <- c
+				if i%2 == 0 {
+					c, err = subject.NotifyRemove(ctx, contextID)
+					if !assert.NoError(t, err) {
+						return
+					}
+					adCids <- c
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(adCids)
+
+	want := make(map[cid.Cid]struct{})
+	for c := range adCids {
+		want[c] = struct{}{}
+	}
+	require.Len(t, want, workers*putsPerWorker*3/2)
+
+	// Assert that every advertisement published is reachable from the latest one.
+	next, _, err := subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+	var chainLen int
+	for next != cid.Undef {
+		_, ok := want[next]
+		require.True(t, ok, "unexpected advertisement in chain: %s", next)
+		chainLen++
+		ad, err := subject.GetAdv(ctx, next)
+		require.NoError(t, err)
+		if ad.PreviousID == nil {
+			break
+		}
+		next = (*ad.PreviousID).(cidlink.Link).Cid
+	}
+	require.Equal(t, len(want), chainLen)
+}
+
+func TestEngine_CompactAndCollectGarbage(t *testing.T) {
+	ctx := contextWithTimeout(t)
+	rng := rand.New(rand.NewSource(1413))
+
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+
+	h, err := libp2p.New()
+	require.NoError(t, err)
+	defer h.Close()
+
+	var syncedAdCid cid.Cid
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &model.ProviderInfo{
+			AddrInfo:          peer.AddrInfo{ID: h.ID()},
+			LastAdvertisement: syncedAdCid,
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(info))
+	}))
+	defer indexer.Close()
+
+	subject, err := engine.New(engine.WithHost(h), engine.WithGCIndexers(indexer.URL))
+	require.NoError(t, err)
+	err = subject.Start(ctx)
+	require.NoError(t, err)
+	defer subject.Shutdown()
+
+	subject.RegisterMultihashLister(func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	})
+
+	bitswap := metadata.New(metadata.Bitswap{})
+	graphsync := metadata.New(&metadata.GraphsyncFilecoinV1{PieceCID: testutil.RandomCids(t, rng, 1)[0]})
+	_, err = subject.NotifyPut(ctx, []byte("fish"), bitswap)
+	require.NoError(t, err)
+	_, err = subject.NotifyPut(ctx, []byte("lobster"), bitswap)
+	require.NoError(t, err)
+	_, err = subject.NotifyPut(ctx, []byte("squid"), bitswap)
+	require.NoError(t, err)
+	_, err = subject.NotifyRemove(ctx, []byte("lobster"))
+	require.NoError(t, err)
+	_, err = subject.NotifyPut(ctx, []byte("fish"), graphsync)
+	require.NoError(t, err)
+	oldHead, _, err := subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+
+	// Assert dry-run makes no changes.
+	report, err := subject.Compact(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, 5, report.ChainLength)
+	require.Equal(t, 2, report.LiveContextIDs)
+	require.Equal(t, cid.Undef, report.Head)
+	gotHead, _, err := subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+	require.Equal(t, oldHead, gotHead)
+
+	report, err = subject.Compact(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, oldHead, report.PreviousHead)
+	gotHead, gotAd, err := subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+	require.Equal(t, report.Head, gotHead)
+
+	// Assert the compacted chain contains live context IDs in the order they were last advertised.
+	require.Equal(t, []byte("fish"), gotAd.ContextID)
+	wantMd, err := graphsync.MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, wantMd, gotAd.Metadata)
+	require.NotNil(t, gotAd.PreviousID)
+	tail, err := subject.GetAdv(ctx, (*gotAd.PreviousID).(cidlink.Link).Cid)
+	require.NoError(t, err)
+	require.Equal(t, []byte("squid"), tail.ContextID)
+	require.False(t, tail.IsRm)
+	require.Nil(t, tail.PreviousID)
+
+	// Assert compacting a compact chain is no-op.
+	report, err = subject.Compact(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, cid.Undef, report.Head)
+
+	// Assert obsolete advertisements are kept until the indexer has synced past them.
+	syncedAdCid = oldHead
+	_, err = subject.CollectGarbage(ctx)
+	require.ErrorIs(t, err, engine.ErrIndexersNotSynced)
+	_, err = subject.GetAdv(ctx, oldHead)
+	require.NoError(t, err)
+
+	syncedAdCid = gotHead
+	deleted, err := subject.CollectGarbage(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 5, deleted)
+	_, err = subject.GetAdv(ctx, oldHead)
+	require.Error(t, err)
+	_, err = subject.GetAdv(ctx, gotHead)
+	require.NoError(t, err)
+
+	// Assert mappings of live context IDs are intact.
+	_, err = subject.NotifyPut(ctx, []byte("fish"), graphsync)
+	require.Equal(t, provider.ErrAlreadyAdvertised, err)
+	_, err = subject.NotifyRemove(ctx, []byte("lobster"))
+	require.Equal(t, provider.ErrContextIDNotFound, err)
+}
+
+func TestEngine_CollectGarbageStopsAtDeletedAdv(t *testing.T) {
+	ctx := contextWithTimeout(t)
+	rng := rand.New(rand.NewSource(1413))
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+
+	h, err := libp2p.New()
+	require.NoError(t, err)
+	defer h.Close()
+	var syncedAdCid cid.Cid
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &model.ProviderInfo{
+			AddrInfo:          peer.AddrInfo{ID: h.ID()},
+			LastAdvertisement: syncedAdCid,
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(info))
+	}))
+	defer indexer.Close()
+
+	subject, err := engine.New(engine.WithDatastore(ds), engine.WithHost(h), engine.WithGCIndexers(indexer.URL))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+	subject.RegisterMultihashLister(func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	})
+
+	bitswap := metadata.New(metadata.Bitswap{})
+	_, err = subject.NotifyPut(ctx, []byte("fish"), bitswap)
+	require.NoError(t, err)
+	lobsterAdCid, err := subject.NotifyPut(ctx, []byte("lobster"), bitswap)
+	require.NoError(t, err)
+	_, err = subject.NotifyRemove(ctx, []byte("lobster"))
+	require.NoError(t, err)
+	report, err := subject.Compact(ctx, false)
+	require.NoError(t, err)
+	syncedAdCid = report.Head
+
+	// Simulate an obsolete chain that is partially deleted.
+	require.NoError(t, ds.Delete(ctx, datastore.NewKey(lobsterAdCid.String())))
+
+	deleted, err := subject.CollectGarbage(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	_, err = subject.GetAdv(ctx, report.PreviousHead)
+	require.ErrorIs(t, err, datastore.ErrNotFound)
+
+	deleted, err = subject.CollectGarbage(ctx)
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+	_, err = subject.GetAdv(ctx, report.Head)
+	require.NoError(t, err)
+}
+
+func TestEngine_ListContextIDsAndLookupMultihash(t *testing.T) {
+	ctx := contextWithTimeout(t)
+	rng := rand.New(rand.NewSource(1413))
+
+	mhs := testutil.RandomMultihashes(t, rng, 40)
+	mhsByContextID := map[string][]multihash.Multihash{
+		"fish":    mhs[:20],
+		"lobster": mhs[10:30],
+		"squid":   mhs[30:],
+	}
+
+	subject, err := engine.New(engine.WithMultihashIndex(true))
+	require.NoError(t, err)
+	err = subject.Start(ctx)
+	require.NoError(t, err)
+	defer subject.Shutdown()
+
+	subject.RegisterMultihashLister(func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhsByContextID[string(contextID)]), nil
+	})
+
+	bitswap := metadata.New(metadata.Bitswap{})
+	fishAdCid, err := subject.NotifyPut(ctx, []byte("fish"), bitswap)
+	require.NoError(t, err)
+	_, err = subject.NotifyPut(ctx, []byte("lobster"), bitswap)
+	require.NoError(t, err)
+	// Put and remove squid in the same batch to assert its index does not outlive the batch.
+	_, err = subject.NotifyBatch(ctx, []provider.Notification{
+		{ContextID: []byte("squid"), Metadata: bitswap},
+		{ContextID: []byte("squid"), IsRemove: true},
+	})
+	require.NoError(t, err)
+
+	fishAd, err := subject.GetAdv(ctx, fishAdCid)
+	require.NoError(t, err)
+	gotInfos, err := subject.ListContextIDs(ctx)
+	require.NoError(t, err)
+	require.Len(t, gotInfos, 2)
+	require.Equal(t, []byte("fish"), gotInfos[0].ContextID)
+	require.Equal(t, fishAd.Entries.(cidlink.Link).Cid, gotInfos[0].EntriesCid)
+	require.True(t, bitswap.Equal(gotInfos[0].Metadata))
+	require.Equal(t, []byte("lobster"), gotInfos[1].ContextID)
+
+	assertLookup := func(mh multihash.Multihash, wantContextIDs ...string) {
+		gotInfos, err := subject.LookupMultihash(ctx, mh)
+		require.NoError(t, err)
+		var gotContextIDs []string
+		for _, info := range gotInfos {
+			gotContextIDs = append(gotContextIDs, string(info.ContextID))
+		}
+		require.Equal(t, wantContextIDs, gotContextIDs)
+	}
+	assertLookup(mhs[5], "fish")
+	assertLookup(mhs[15], "fish", "lobster")
+	assertLookup(mhs[35])
+
+	_, err = subject.NotifyRemove(ctx, []byte("fish"))
+	require.NoError(t, err)
+	assertLookup(mhs[5])
+	assertLookup(mhs[15], "lobster")
+	gotInfos, err = subject.ListContextIDs(ctx)
+	require.NoError(t, err)
+	require.Len(t, gotInfos, 1)
+	require.Equal(t, []byte("lobster"), gotInfos[0].ContextID)
+}
+
+func TestEngine_LookupMultihashWithoutIndexIsError(t *testing.T) {
+	ctx := contextWithTimeout(t)
+	rng := rand.New(rand.NewSource(1413))
+
+	subject, err := engine.New()
+	require.NoError(t, err)
+	err = subject.Start(ctx)
+	require.NoError(t, err)
+	defer subject.Shutdown()
+
+	_, err = subject.LookupMultihash(ctx, testutil.RandomMultihashes(t, rng, 1)[0])
+	require.Equal(t, engine.ErrNoMultihashIndex, err)
+}
+
+func TestEngine_UpdateMetadata(t *testing.T) {
+	ctx := contextWithTimeout(t)
+	rng := rand.New(rand.NewSource(1413))
+
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+
+	subject, err := engine.New()
+	require.NoError(t, err)
+	err = subject.Start(ctx)
+	require.NoError(t, err)
+	defer subject.Shutdown()
+
+	var listed int
+	subject.RegisterMultihashLister(func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		listed++
+		return provider.SliceMultihashIterator(mhs), nil
+	})
+
+	graphsync := metadata.New(&metadata.GraphsyncFilecoinV1{PieceCID: testutil.RandomCids(t, rng, 1)[0]})
+	fishAdCid, err := subject.NotifyPut(ctx, []byte("fish"), graphsync)
+	require.NoError(t, err)
+	_, err = subject.NotifyPut(ctx, []byte("lobster"), graphsync)
+	require.NoError(t, err)
+	_, err = subject.NotifyPut(ctx, []byte("squid"), graphsync)
+	require.NoError(t, err)
+	_, err = subject.NotifyRemove(ctx, []byte("squid"))
+	require.NoError(t, err)
+	require.Equal(t, 3, listed)
+
+	addBitswapExceptLobster := func(contextID []byte, md metadata.Metadata) (metadata.Metadata, bool, error) {
+		if string(contextID) == "lobster" {
+			return md, false, nil
+		}
+		return md.With(metadata.Bitswap{}), true, nil
+	}
+	updated, head, err := subject.UpdateMetadata(ctx, addBitswapExceptLobster)
+	require.NoError(t, err)
+	require.Equal(t, 1, updated)
+
+	// Assert the updated advertisement reuses the previously advertised entries.
+	gotLatestAdCid, gotLatestAd, err := subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+	require.Equal(t, head, gotLatestAdCid)
+	require.Equal(t, []byte("fish"), gotLatestAd.ContextID)
+	fishAd, err := subject.GetAdv(ctx, fishAdCid)
+	require.NoError(t, err)
+	require.Equal(t, fishAd.Entries, gotLatestAd.Entries)
+	require.Equal(t, 3, listed)
+	var gotMd metadata.Metadata
+	require.NoError(t, gotMd.UnmarshalBinary(gotLatestAd.Metadata))
+	require.True(t, graphsync.With(metadata.Bitswap{}).Equal(gotMd))
+
+	// Assert updating to the same metadata is no-op.
+	updated, head, err = subject.UpdateMetadata(ctx, addBitswapExceptLobster)
+	require.NoError(t, err)
+	require.Equal(t, 0, updated)
+	require.Equal(t, cid.Undef, head)
+}
+
+func TestEngine_NotifyUpdate(t *testing.T) {
+	ctx := contextWithTimeout(t)
+	rng := rand.New(rand.NewSource(1413))
+
+	mhs := testutil.RandomMultihashes(t, rng, 40)
+	mhsByContextID := map[string][]multihash.Multihash{
+		"fish":    mhs[:20],
+		"lobster": mhs[30:],
+	}
+
+	subject, err := engine.New(engine.WithMultihashIndex(true), engine.WithEntriesCacheCapacity(1))
+	require.NoError(t, err)
+	err = subject.Start(ctx)
+	require.NoError(t, err)
+	defer subject.Shutdown()
+
+	subject.RegisterMultihashLister(func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhsByContextID[string(contextID)]), nil
+	})
+	loadEntries := func(entries ipld.Link) []multihash.Multihash {
+		chunks := requireLoadEntryChunkFromEngine(t, subject, entries)
+		require.Nil(t, chunks[0].Next)
+		return chunks[0].Entries
+	}
+
+	bitswap := metadata.New(metadata.Bitswap{})
+	fishAdCid, err := subject.NotifyPut(ctx, []byte("fish"), bitswap)
+	require.NoError(t, err)
+	fishAd, err := subject.GetAdv(ctx, fishAdCid)
+	require.NoError(t, err)
+
+	// Assert that only the added multihashes are advertised.
+	mhsByContextID["fish"] = mhs[:25]
+	deltaAdCid, err := subject.NotifyUpdate(ctx, []byte("fish"), bitswap)
+	require.NoError(t, err)
+	deltaAd, err := subject.GetAdv(ctx, deltaAdCid)
+	require.NoError(t, err)
+	require.False(t, deltaAd.IsRm)
+	require.Equal(t, []byte("fish"), deltaAd.ContextID)
+	require.Equal(t, mhs[20:25], loadEntries(deltaAd.Entries))
+	gotInfos, err := subject.LookupMultihash(ctx, mhs[22])
+	require.NoError(t, err)
+	require.Len(t, gotInfos, 1)
+	require.Equal(t, fishAd.Entries.(cidlink.Link).Cid, gotInfos[0].EntriesCid)
+
+	_, err = subject.NotifyUpdate(ctx, []byte("fish"), bitswap)
+	require.Equal(t, provider.ErrAlreadyAdvertised, err)
+
+	// Assert that the previously advertised entries are regenerated as advertised once evicted.
+	_, err = subject.NotifyPut(ctx, []byte("lobster"), bitswap)
+	require.NoError(t, err)
+	requireChunkIsNotCached(t, subject.Chunker(), fishAd.Entries)
+	require.Equal(t, mhs[:20], loadEntries(fishAd.Entries))
+
+	// Assert that compaction retains the delta entries.
+	report, err := subject.Compact(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.LiveContextIDs)
+	_, gotLatestAd, err := subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte("lobster"), gotLatestAd.ContextID)
+	gotDeltaAd, err := subject.GetAdv(ctx, (*gotLatestAd.PreviousID).(cidlink.Link).Cid)
+	require.NoError(t, err)
+	require.Equal(t, deltaAd.Entries, gotDeltaAd.Entries)
+	require.Equal(t, mhs[20:25], loadEntries(gotDeltaAd.Entries))
+
+	// Assert that removing multihashes re-advertises the context ID.
+	mhsByContextID["fish"] = mhs[5:30]
+	putAdCid, err := subject.NotifyUpdate(ctx, []byte("fish"), bitswap)
+	require.NoError(t, err)
+	putAd, err := subject.GetAdv(ctx, putAdCid)
+	require.NoError(t, err)
+	require.False(t, putAd.IsRm)
+	require.Equal(t, mhs[5:30], loadEntries(putAd.Entries))
+	rmAd, err := subject.GetAdv(ctx, (*putAd.PreviousID).(cidlink.Link).Cid)
+	require.NoError(t, err)
+	require.True(t, rmAd.IsRm)
+	require.Equal(t, []byte("fish"), rmAd.ContextID)
+	gotInfos, err = subject.LookupMultihash(ctx, mhs[2])
+	require.NoError(t, err)
+	require.Empty(t, gotInfos)
+	_, err = subject.LinkSystem().Load(ipld.LinkContext{}, deltaAd.Entries, schema.EntryChunkPrototype)
+	require.Error(t, err)
+}
+
+func TestEngine_SetRetrievalAddrs(t *testing.T) {
+	ctx := contextWithTimeout(t)
+	rng := rand.New(rand.NewSource(1413))
+
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+	lister := func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	}
+	configured, err := multiaddr.NewMultiaddr("/ip4/1.2.3.4/tcp/1234")
+	require.NoError(t, err)
+	updated, err := multiaddr.NewMultiaddr("/dns4/fish.invalid/tcp/443/https")
+	require.NoError(t, err)
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	subject, err := engine.New(engine.WithDatastore(ds), engine.WithRetrievalAddrs(configured))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	subject.RegisterMultihashLister(lister)
+
+	putAdCid, err := subject.NotifyPut(ctx, []byte("fish"), metadata.New(metadata.Bitswap{}))
+	require.NoError(t, err)
+
+	gotAdCid, err := subject.SetRetrievalAddrs(ctx, updated)
+	require.NoError(t, err)
+	require.Equal(t, []multiaddr.Multiaddr{updated}, subject.RetrievalAddrs())
+
+	// Assert the published ad carries the new addresses and no entries.
+	gotHead, gotAd, err := subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+	require.Equal(t, gotAdCid, gotHead)
+	require.Equal(t, []string{updated.String()}, gotAd.Addresses)
+	require.Equal(t, schema.NoEntries, gotAd.Entries)
+	require.True(t, gotAd.IsRm)
+	require.Empty(t, gotAd.ContextID)
+	require.Equal(t, putAdCid, (*gotAd.PreviousID).(cidlink.Link).Cid)
+
+	// Assert the advertised context IDs are unaffected, and the empty context ID reserved for
+	// address updates cannot be advertised.
+	infos, err := subject.ListContextIDs(ctx)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	_, err = subject.NotifyPut(ctx, []byte{}, metadata.New(metadata.Bitswap{}))
+	require.ErrorIs(t, err, engine.ErrEmptyContextID)
+	require.NoError(t, subject.Shutdown())
+
+	// Assert the persisted addresses take precedence over the configured ones upon restart.
+	subject, err = engine.New(engine.WithDatastore(ds), engine.WithRetrievalAddrs(configured))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	subject.RegisterMultihashLister(lister)
+	require.Equal(t, []multiaddr.Multiaddr{updated}, subject.RetrievalAddrs())
+
+	_, err = subject.NotifyPut(ctx, []byte("lobster"), metadata.New(metadata.Bitswap{}))
+	require.NoError(t, err)
+	_, gotAd, err = subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{updated.String()}, gotAd.Addresses)
+
+	// Assert resetting reverts to the configured addresses.
+	_, err = subject.SetRetrievalAddrs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []multiaddr.Multiaddr{configured}, subject.RetrievalAddrs())
+	_, gotAd, err = subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{configured.String()}, gotAd.Addresses)
+	_, err = subject.SetRetrievalAddrs(ctx, updated)
+	require.NoError(t, err)
+	require.NoError(t, subject.Shutdown())
+
+	// Assert the persisted addresses are discarded once the configured addresses change.
+	reconfigured, err := multiaddr.NewMultiaddr("/ip4/5.6.7.8/tcp/5678")
+	require.NoError(t, err)
+	subject, err = engine.New(engine.WithDatastore(ds), engine.WithRetrievalAddrs(reconfigured))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+	require.Equal(t, []multiaddr.Multiaddr{reconfigured}, subject.RetrievalAddrs())
+}
+
+func TestEngine_SignsWithSigner(t *testing.T) {
+	ctx := contextWithTimeout(t)
+	rng := rand.New(rand.NewSource(1413))
+
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+	key, _, err := crypto.GenerateEd25519Key(rng)
+	require.NoError(t, err)
+	wantSignerID, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+
+	subject, err := engine.New(engine.WithSigner(&keySigner{key}))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+	subject.RegisterMultihashLister(func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	})
+
+	_, err = subject.NotifyPut(ctx, []byte("fish"), metadata.New(metadata.Bitswap{}))
+	require.NoError(t, err)
+	_, gotAd, err := subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+	gotSignerID, err := gotAd.VerifySignature()
+	require.NoError(t, err)
+	require.Equal(t, wantSignerID, gotSignerID)
+}
+
+// keySigner is an engine.Signer that is not a crypto.PrivKey.
+type keySigner struct {
+	key crypto.PrivKey
+}
+
+func (s *keySigner) Sign(data []byte) ([]byte, error) {
+	return s.key.Sign(data)
+}
+
+func (s *keySigner) GetPublic() crypto.PubKey {
+	return s.key.GetPublic()
+}
+
+func TestEngine_SetRetrievalAddrsKeepsCommittedAddrsWhenAnnounceFails(t *testing.T) {
+	ctx := contextWithTimeout(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "fish", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+	updated, err := multiaddr.NewMultiaddr("/dns4/fish.invalid/tcp/443/https")
+	require.NoError(t, err)
+
+	h, err := libp2p.New()
+	require.NoError(t, err)
+	defer h.Close()
+	subject, err := engine.New(
+		engine.WithHost(h),
+		engine.WithDirectAnnounce(ts.URL),
+		engine.WithPublisherKind(engine.DataTransferPublisher),
+	)
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+
+	_, err = subject.SetRetrievalAddrs(ctx, updated)
+	require.Error(t, err)
+
+	// Assert the addresses in use match the committed advertisement.
+	require.Equal(t, []multiaddr.Multiaddr{updated}, subject.RetrievalAddrs())
+	_, gotAd, err := subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{updated.String()}, gotAd.Addresses)
+}
+
+func TestEngine_SlowAnnounceDoesNotHoldUpPublishing(t *testing.T) {
+	ctx := contextWithTimeout(t)
+	rng := rand.New(rand.NewSource(1413))
+	received := make(chan struct{}, 2)
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	h, err := libp2p.New()
+	require.NoError(t, err)
+	defer h.Close()
+	subject, err := engine.New(
+		engine.WithHost(h),
+		engine.WithDirectAnnounce(ts.URL),
+		engine.WithPublisherKind(engine.DataTransferPublisher),
+	)
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+	subject.RegisterMultihashLister(func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	})
+
+	// Block the announcement of the first advertisement.
+	errs := make(chan error, 2)
+	notifyPut := func(contextID string) {
+		_, err := subject.NotifyPut(ctx, []byte(contextID), metadata.New(metadata.Bitswap{}))
+		errs <- err
+	}
+	go notifyPut("fish")
+	select {
+	case <-received:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for announcement")
+	}
+
+	// Assert that further advertisements are committed while the announcement is blocked.
+	go notifyPut("lobster")
+	requireTrueEventually(t, func() bool {
+		_, ad, err := subject.GetLatestAdv(ctx)
+		require.NoError(t, err)
+		return string(ad.ContextID) == "lobster"
+	}, 10*time.Millisecond, 10*time.Second, "timed out waiting for advertisement to be committed")
+
+	close(release)
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+}
+
+func TestEngine_RotateIdentity(t *testing.T) {
+	ctx := contextWithTimeout(t)
+	rng := rand.New(rand.NewSource(1413))
+
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+	lister := func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	}
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+
+	h, err := libp2p.New()
+	require.NoError(t, err)
+	defer h.Close()
+	subject, err := engine.New(engine.WithDatastore(ds), engine.WithHost(h))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	subject.RegisterMultihashLister(lister)
+
+	bitswap := metadata.New(metadata.Bitswap{})
+	_, err = subject.NotifyPut(ctx, []byte("fish"), bitswap)
+	require.NoError(t, err)
+	_, err = subject.NotifyPut(ctx, []byte("lobster"), bitswap)
+	require.NoError(t, err)
+	_, err = subject.NotifyRemove(ctx, []byte("lobster"))
+	require.NoError(t, err)
+	_, err = subject.NotifyPut(ctx, []byte("squid"), bitswap)
+	require.NoError(t, err)
+	oldHead, _, err := subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+
+	newKey, _, err := crypto.GenerateEd25519Key(rng)
+	require.NoError(t, err)
+	newID, err := peer.IDFromPrivateKey(newKey)
+	require.NoError(t, err)
+
+	var gotProgress []int
+	report, err := subject.RotateIdentity(ctx, newKey, func(done, total int) {
+		require.Equal(t, 4, total)
+		gotProgress = append(gotProgress, done)
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2, 3, 4}, gotProgress)
+	require.Equal(t, h.ID(), report.PreviousProvider)
+	require.Equal(t, newID, report.Provider)
+	require.Equal(t, 2, report.LiveContextIDs)
+	require.Equal(t, oldHead, report.PreviousHead)
+
+	// Assert the chain continues with removals under the old identity followed by puts under the
+	// new identity.
+	wantContextIDs := [][]byte{[]byte("squid"), []byte("fish"), []byte("squid"), []byte("fish")}
+	next := report.Head
+	for i, wantContextID := range wantContextIDs {
+		ad, err := subject.GetAdv(ctx, next)
+		require.NoError(t, err)
+		require.Equal(t, wantContextID, ad.ContextID)
+		signerID, err := ad.VerifySignature()
+		require.NoError(t, err)
+		if i < 2 {
+			require.False(t, ad.IsRm)
+			require.Equal(t, newID.String(), ad.Provider)
+			require.Equal(t, newID, signerID)
+		} else {
+			require.True(t, ad.IsRm)
+			require.Equal(t, h.ID().String(), ad.Provider)
+			require.Equal(t, h.ID(), signerID)
+		}
+		next = (*ad.PreviousID).(cidlink.Link).Cid
+	}
+	require.Equal(t, oldHead, next)
+	require.NoError(t, subject.Shutdown())
+
+	// Assert the advertised content is intact when restarted with the new identity.
+	newHost, err := libp2p.New(libp2p.Identity(newKey))
+	require.NoError(t, err)
+	defer newHost.Close()
+	subject, err = engine.New(engine.WithDatastore(ds), engine.WithHost(newHost))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+	subject.RegisterMultihashLister(lister)
+
+	infos, err := subject.ListContextIDs(ctx)
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	_, err = subject.NotifyPut(ctx, []byte("fish"), bitswap)
+	require.Equal(t, provider.ErrAlreadyAdvertised, err)
+	_, err = subject.NotifyRemove(ctx, []byte("fish"))
+	require.NoError(t, err)
+	_, gotAd, err := subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+	require.Equal(t, newID.String(), gotAd.Provider)
+}
+
+func TestEngine_RotateIdentityReturnsReportWhenAnnounceFails(t *testing.T) {
+	ctx := contextWithTimeout(t)
+	rng := rand.New(rand.NewSource(1413))
+
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+	var failAnnounce int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failAnnounce) == 1 {
+			http.Error(w, "fish", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	h, err := libp2p.New()
+	require.NoError(t, err)
+	defer h.Close()
+	subject, err := engine.New(
+		engine.WithHost(h),
+		engine.WithDirectAnnounce(ts.URL),
+		engine.WithPublisherKind(engine.DataTransferPublisher),
+	)
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+	subject.RegisterMultihashLister(func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	})
+	_, err = subject.NotifyPut(ctx, []byte("fish"), metadata.New(metadata.Bitswap{}))
+	require.NoError(t, err)
+
+	newKey, _, err := crypto.GenerateEd25519Key(rng)
+	require.NoError(t, err)
+	atomic.StoreInt32(&failAnnounce, 1)
+	report, err := subject.RotateIdentity(ctx, newKey, nil)
+	require.Error(t, err)
+
+	// Assert the report signals that advertisements under the new identity are committed.
+	require.NotNil(t, report)
+	require.NotEqual(t, cid.Undef, report.Head)
+	gotHead, _, err := subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+	require.Equal(t, report.Head, gotHead)
+}
+
 func contextWithTimeout(t *testing.T) context.Context {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	t.Cleanup(cancel)
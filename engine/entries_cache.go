@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/filecoin-project/index-provider/engine/chunker"
+	"github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// entriesCacheMigrationBatchSize is the maximum number of entries cache records moved per batch
+// when migrating the cache out of the engine datastore.
+const entriesCacheMigrationBatchSize = 1024
+
+// EntriesCacheStats represents the usage of the advertisement entries cache.
+type EntriesCacheStats struct {
+	chunker.CacheStats
+	// Hits is the number of entries chunks requested by indexers that were found in cache.
+	Hits uint64
+	// Misses is the number of entries chunks requested by indexers that were not found in cache,
+	// and had to be regenerated.
+	Misses uint64
+	// Mismatches is the number of times the regenerated entries did not match the advertised
+	// entries. See: EntriesMismatchError.
+	Mismatches uint64
+}
+
+// HitRate returns the ratio of entries chunk lookups that were found in cache, or zero if no
+// lookups were made.
+func (s EntriesCacheStats) HitRate() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// EntriesCacheStats returns the current usage of the advertisement entries cache, along with the
+// number of cache hits, misses and mismatches since the engine was started.
+//
+// The engine must be started before calling this function.
+func (e *Engine) EntriesCacheStats() EntriesCacheStats {
+	return EntriesCacheStats{
+		CacheStats: e.entriesChunker.Stats(),
+		Hits:       atomic.LoadUint64(&e.entCacheHits),
+		Misses:     atomic.LoadUint64(&e.entCacheMisses),
+		Mismatches: atomic.LoadUint64(&e.entMismatches),
+	}
+}
+
+// migrateEntriesCache moves the entries cache stored in the engine datastore under the
+// linksCachePath namespace to the given datastore, or deletes it if the cache is to be purged.
+//
+// Records are first written to dst and then deleted from the engine datastore, one batch at a
+// time. If interrupted, the migration resumes on next start, overwriting any records already
+// moved.
+func (e *Engine) migrateEntriesCache(ctx context.Context, dst datastore.Batching) error {
+	results, err := e.ds.Query(ctx, dsq.Query{Prefix: linksCachePath})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	var moved, deleted int
+	var puts []dsq.Entry
+	flush := func() error {
+		if len(puts) == 0 {
+			return nil
+		}
+		dstBatch, err := dst.Batch(ctx)
+		if err != nil {
+			return err
+		}
+		srcBatch, err := e.ds.Batch(ctx)
+		if err != nil {
+			return err
+		}
+		for _, r := range puts {
+			if !e.purgeCache {
+				key := datastore.NewKey(r.Key[len(linksCachePath):])
+				if err := dstBatch.Put(ctx, key, r.Value); err != nil {
+					return err
+				}
+			}
+			if err := srcBatch.Delete(ctx, datastore.RawKey(r.Key)); err != nil {
+				return err
+			}
+		}
+		if err := dstBatch.Commit(ctx); err != nil {
+			return fmt.Errorf("cannot write migrated entries cache: %w", err)
+		}
+		if err := srcBatch.Commit(ctx); err != nil {
+			return fmt.Errorf("cannot delete migrated entries cache: %w", err)
+		}
+		if e.purgeCache {
+			deleted += len(puts)
+		} else {
+			moved += len(puts)
+		}
+		puts = puts[:0]
+		return nil
+	}
+
+	for r := range results.Next() {
+		if r.Error != nil {
+			return fmt.Errorf("cannot read entries cache record: %w", r.Error)
+		}
+		puts = append(puts, r.Entry)
+		if len(puts) >= entriesCacheMigrationBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if moved != 0 {
+		if err := dst.Sync(ctx, datastore.NewKey("/")); err != nil {
+			return err
+		}
+		log.Infow("Moved entries cache out of engine datastore", "records", moved)
+	}
+	if deleted != 0 {
+		log.Infow("Deleted entries cache from engine datastore", "records", deleted)
+	}
+	return nil
+}
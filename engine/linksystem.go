@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"sync/atomic"
 
 	provider "github.com/filecoin-project/index-provider"
 	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
@@ -55,6 +56,18 @@ func (e *Engine) mkLinkSystem() ipld.LinkSystem {
 
 		// Not an advertisement, so this means we are receiving ingestion data.
 
+		// Delta entries advertised via Engine.NotifyUpdate are persisted, since they cannot be
+		// regenerated.
+		val, err = e.getDeltaEntries(ctx, c)
+		if err != nil {
+			log.Errorf("Error getting delta entries from datastore in linksystem: %s", err)
+			return nil, err
+		}
+		if val != nil {
+			log.Debugw("Retrieved delta entries from datastore", "cid", c, "size", len(val))
+			return bytes.NewBuffer(val), nil
+		}
+
 		// If no lister registered return error
 		if e.mhLister == nil {
 			log.Error("No multihash lister has been registered in engine")
@@ -79,6 +92,7 @@ func (e *Engine) mkLinkSystem() ipld.LinkSystem {
 		// The cache uses the entry chunk CID as a key that maps to the entry
 		// chunk data.
 		if b == nil {
+			atomic.AddUint64(&e.entCacheMisses, 1)
 			log.Infow("Entry for CID is not cached, generating chunks", "cid", c)
 			// If the link is not found, it means that the root link of the list has
 			// not been generated and we need to get the relationship between the cid
@@ -90,25 +104,31 @@ func (e *Engine) mkLinkSystem() ipld.LinkSystem {
 				return nil, err
 			}
 
-			// Get the car iterator needed to create the entry chunks.
-			// Normally for removal this is not needed since the indexer
-			// deletes all indexes for the contextID in the removal
+			// Regenerate the entry chunks from the multihashes listed for the
+			// context ID. Normally for removal this is not needed since the
+			// indexer deletes all indexes for the contextID in the removal
 			// advertisement.  Only if the removal had no contextID would the
 			// indexer ask for entry chunks to remove.
-			mhIter, err := e.mhLister(ctx, key)
-			if err != nil {
-				return nil, err
-			}
-
+			//
 			// Store the linked list entries in cache as we generate them.  We
 			// use the cache linksystem that stores entries in an in-memory
 			// datastore.
-			_, err = e.entriesChunker.Chunk(ctx, mhIter)
+			root, err := e.regenerateEntries(ctx, key, c)
 			if err != nil {
 				log.Errorf("Error generating linked list from multihash lister: %s", err)
 				return nil, err
 			}
+
+			// Check that the regenerated entries are the ones advertised. Otherwise, the
+			// content has changed since it was advertised and the advertised entries can no
+			// longer be served.
+			if regenerated := root.(cidlink.Link).Cid; regenerated != c {
+				mismatch := &EntriesMismatchError{ContextID: key, Advertised: c, Regenerated: regenerated}
+				e.handleEntriesMismatch(ctx, mismatch)
+				return nil, mismatch
+			}
 		} else {
+			atomic.AddUint64(&e.entCacheHits, 1)
 			log.Debugw("Found cache entry for CID", "cid", c)
 		}
 
@@ -153,11 +173,21 @@ func (e *Engine) vanillaLinkSystem() ipld.LinkSystem {
 		}
 		return bytes.NewBuffer(val), nil
 	}
+	lsys.StorageWriteOpener = writerLinkSystem(e.ds).StorageWriteOpener
+	return lsys
+}
+
+// writerLinkSystem instantiates a linksystem that stores links via the given dsReadWriter.
+//
+// This is used to store advertisements either directly onto the engine datastore or as part of a
+// batch of writes.
+func writerLinkSystem(rw dsReadWriter) ipld.LinkSystem {
+	lsys := cidlink.DefaultLinkSystem()
 	lsys.StorageWriteOpener = func(lctx ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
 		buf := bytes.NewBuffer(nil)
 		return buf, func(lnk ipld.Link) error {
 			c := lnk.(cidlink.Link).Cid
-			return e.ds.Put(lctx.Ctx, datastore.NewKey(c.String()), buf.Bytes())
+			return rw.Put(lctx.Ctx, datastore.NewKey(c.String()), buf.Bytes())
 		}, nil
 	}
 	return lsys
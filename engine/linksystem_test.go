@@ -5,7 +5,9 @@ import (
 	"context"
 	"errors"
 	"math/rand"
+	"sync"
 	"testing"
+	"time"
 
 	provider "github.com/filecoin-project/index-provider"
 	"github.com/filecoin-project/index-provider/engine"
@@ -15,10 +17,14 @@ import (
 	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
 	"github.com/ipld/go-car/v2/index"
+	hamt "github.com/ipld/go-ipld-adl-hamt"
 	"github.com/ipld/go-ipld-prime"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/multicodec"
+	gomulticodec "github.com/multiformats/go-multicodec"
 	"github.com/stretchr/testify/require"
 )
 
@@ -161,6 +167,344 @@ func Test_EvictedCachedEntriesChainIsRegeneratedGracefully(t *testing.T) {
 	require.Equal(t, a2Chunks, a2ChunksAfterReGen)
 }
 
+func Test_RegeneratedEntriesMismatchIsDetected(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	ctx := contextWithTimeout(t)
+
+	subject, err := engine.New(engine.WithEntriesCacheCapacity(1), engine.WithChainedEntries(2), engine.WithRemoveCorruptedEntries(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+
+	mhs := map[string][]cid.Cid{
+		"fish":    testutil.RandomCids(t, rng, 6),
+		"lobster": testutil.RandomCids(t, rng, 6),
+	}
+	var mhsLk sync.Mutex
+	subject.RegisterMultihashLister(func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		mhsLk.Lock()
+		defer mhsLk.Unlock()
+		return getMhIterator(t, mhs[string(contextID)]), nil
+	})
+
+	fishAdCid, err := subject.NotifyPut(ctx, []byte("fish"), testMetadata)
+	require.NoError(t, err)
+	fishAd, err := subject.GetAdv(ctx, fishAdCid)
+	require.NoError(t, err)
+	_, err = subject.NotifyPut(ctx, []byte("lobster"), testMetadata)
+	require.NoError(t, err)
+	requireChunkIsNotCached(t, subject.Chunker(), fishAd.Entries)
+	corrupted, err := subject.ListCorruptedContextIDs(ctx)
+	require.NoError(t, err)
+	require.Empty(t, corrupted)
+
+	// Change the content of the evicted entries and assert the mismatch is detected when the
+	// entries are regenerated.
+	mhsLk.Lock()
+	mhs["fish"] = testutil.RandomCids(t, rng, 6)
+	mhsLk.Unlock()
+	_, err = subject.LinkSystem().Load(ipld.LinkContext{Ctx: ctx}, fishAd.Entries, schema.EntryChunkPrototype)
+	var mismatch *engine.EntriesMismatchError
+	require.True(t, errors.As(err, &mismatch), "expected entries mismatch error; got: %v", err)
+	require.Equal(t, []byte("fish"), mismatch.ContextID)
+	require.Equal(t, fishAd.Entries.(cidlink.Link).Cid, mismatch.Advertised)
+	require.NotEqual(t, mismatch.Advertised, mismatch.Regenerated)
+	require.Equal(t, uint64(1), subject.EntriesCacheStats().Mismatches)
+
+	// Assert the regenerated entries are released from the cache.
+	requireChunkIsNotCached(t, subject.Chunker(), cidlink.Link{Cid: mismatch.Regenerated})
+	require.Equal(t, 0, subject.Chunker().Len())
+
+	// Assert the corrupted context ID is recorded and removed.
+	requireTrueEventually(t, func() bool {
+		corrupted, err = subject.ListCorruptedContextIDs(ctx)
+		require.NoError(t, err)
+		return len(corrupted) == 1 && corrupted[0].RemovalAdvId != cid.Undef
+	}, 10*time.Millisecond, 10*time.Second, "timed out waiting for corrupted context ID removal")
+	require.Equal(t, []byte("fish"), corrupted[0].ContextID)
+	require.Equal(t, mismatch.Advertised, corrupted[0].AdvertisedEntries)
+	require.Equal(t, mismatch.Regenerated, corrupted[0].RegeneratedEntries)
+	require.False(t, corrupted[0].DetectedAt.IsZero())
+	latestAdCid, latestAd, err := subject.GetLatestAdv(ctx)
+	require.NoError(t, err)
+	require.Equal(t, corrupted[0].RemovalAdvId, latestAdCid)
+	require.True(t, latestAd.IsRm)
+	require.Equal(t, []byte("fish"), latestAd.ContextID)
+
+	// Assert the context ID is no longer corrupted once advertised again.
+	_, err = subject.NotifyPut(ctx, []byte("fish"), testMetadata)
+	require.NoError(t, err)
+	corrupted, err = subject.ListCorruptedContextIDs(ctx)
+	require.NoError(t, err)
+	require.Empty(t, corrupted)
+}
+
+func Test_EntriesAdvertisedInPreviousFormatAreServed(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	ctx := contextWithTimeout(t)
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	mhs := testutil.RandomCids(t, rng, 6)
+	lister := func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return getMhIterator(t, mhs), nil
+	}
+
+	subject, err := engine.New(engine.WithDatastore(ds), engine.WithChainedEntries(2), engine.WithRemoveCorruptedEntries(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	subject.RegisterMultihashLister(lister)
+	fishAdCid, err := subject.NotifyPut(ctx, []byte("fish"), testMetadata)
+	require.NoError(t, err)
+	fishAd, err := subject.GetAdv(ctx, fishAdCid)
+	require.NoError(t, err)
+	fishEntriesChain := listEntriesChainFromCache(t, subject.Chunker(), fishAd.Entries)
+	require.Len(t, fishEntriesChain, 3)
+	wantChunks := requireLoadEntryChunkFromEngine(t, subject, fishEntriesChain...)
+	require.NoError(t, subject.Shutdown())
+
+	// Restart the engine with HAMT entries, which clears the entries cache.
+	subject, err = engine.New(engine.WithDatastore(ds), engine.WithHamtEntries(gomulticodec.Murmur3X64_64, 3, 1), engine.WithRemoveCorruptedEntries(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+	subject.RegisterMultihashLister(lister)
+	requireChunkIsNotCached(t, subject.Chunker(), fishEntriesChain...)
+
+	// Assert that the entries advertised as a chain are regenerated as a chain, and are not
+	// considered corrupted.
+	gotChunks := requireLoadEntryChunkFromEngine(t, subject, fishEntriesChain...)
+	require.Equal(t, wantChunks, gotChunks)
+	require.Zero(t, subject.EntriesCacheStats().Mismatches)
+	corrupted, err := subject.ListCorruptedContextIDs(ctx)
+	require.NoError(t, err)
+	require.Empty(t, corrupted)
+
+	// Assert that new advertisements use HAMT entries.
+	lobsterAdCid, err := subject.NotifyPut(ctx, []byte("lobster"), testMetadata)
+	require.NoError(t, err)
+	lobsterAd, err := subject.GetAdv(ctx, lobsterAdCid)
+	require.NoError(t, err)
+	require.NotEqual(t, fishAd.Entries, lobsterAd.Entries)
+	_, err = subject.LinkSystem().Load(ipld.LinkContext{Ctx: ctx}, lobsterAd.Entries, hamt.HashMapRootPrototype)
+	require.NoError(t, err)
+}
+
+func Test_EntriesCacheStatsCountHitsAndMisses(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	ctx := contextWithTimeout(t)
+
+	subject, err := engine.New(engine.WithEntriesCacheCapacity(1), engine.WithChainedEntries(2))
+	require.NoError(t, err)
+	err = subject.Start(ctx)
+	require.NoError(t, err)
+	defer subject.Shutdown()
+
+	ad1CtxID := []byte("first")
+	ad1Mhs := testutil.RandomCids(t, rng, 12)
+	ad2CtxID := []byte("second")
+	ad2Mhs := testutil.RandomCids(t, rng, 10)
+	subject.RegisterMultihashLister(func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		switch string(contextID) {
+		case string(ad1CtxID):
+			return getMhIterator(t, ad1Mhs), nil
+		case string(ad2CtxID):
+			return getMhIterator(t, ad2Mhs), nil
+		default:
+			return nil, errors.New("not found")
+		}
+	})
+
+	ad1Cid, err := subject.NotifyPut(ctx, ad1CtxID, testMetadata)
+	require.NoError(t, err)
+	ad1, err := subject.GetAdv(ctx, ad1Cid)
+	require.NoError(t, err)
+	ad1EntriesChain := listEntriesChainFromCache(t, subject.Chunker(), ad1.Entries)
+	ad2Cid, err := subject.NotifyPut(ctx, ad2CtxID, testMetadata)
+	require.NoError(t, err)
+	ad2, err := subject.GetAdv(ctx, ad2Cid)
+	require.NoError(t, err)
+	ad2EntriesChain := listEntriesChainFromCache(t, subject.Chunker(), ad2.Entries)
+
+	var wantBytes int64
+	for _, l := range ad2EntriesChain {
+		raw, err := subject.Chunker().GetRawCachedChunk(ctx, l)
+		require.NoError(t, err)
+		wantBytes += int64(len(raw))
+	}
+	stats := subject.EntriesCacheStats()
+	require.Equal(t, 1, stats.Len)
+	require.Equal(t, 1, stats.Cap)
+	require.Equal(t, wantBytes, stats.Bytes)
+	require.Zero(t, stats.Hits)
+	require.Zero(t, stats.Misses)
+	require.Zero(t, stats.HitRate())
+
+	// Assert loading cached entries counts as hits.
+	requireLoadEntryChunkFromEngine(t, subject, ad2EntriesChain...)
+	stats = subject.EntriesCacheStats()
+	require.Equal(t, uint64(len(ad2EntriesChain)), stats.Hits)
+	require.Zero(t, stats.Misses)
+
+	// Assert loading evicted entries counts as a single miss, since the entire chain is
+	// regenerated upon the first miss.
+	requireLoadEntryChunkFromEngine(t, subject, ad1EntriesChain...)
+	stats = subject.EntriesCacheStats()
+	wantHits := uint64(len(ad2EntriesChain) + len(ad1EntriesChain) - 1)
+	require.Equal(t, wantHits, stats.Hits)
+	require.Equal(t, uint64(1), stats.Misses)
+	require.Equal(t, float64(wantHits)/float64(wantHits+1), stats.HitRate())
+}
+
+func Test_EntriesCacheIsMigratedToSeparateDatastore(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	ctx := contextWithTimeout(t)
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	mhs := testutil.RandomCids(t, rng, 12)
+	lister := func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return getMhIterator(t, mhs), nil
+	}
+
+	// Publish an advertisement, the entries of which are cached in the engine datastore.
+	subject, err := engine.New(engine.WithDatastore(ds), engine.WithChainedEntries(2))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	subject.RegisterMultihashLister(lister)
+	adCid, err := subject.NotifyPut(ctx, []byte("fish"), testMetadata)
+	require.NoError(t, err)
+	ad, err := subject.GetAdv(ctx, adCid)
+	require.NoError(t, err)
+	entriesChain := listEntriesChainFromCache(t, subject.Chunker(), ad.Entries)
+	wantChunks := requireLoadEntryChunkFromEngine(t, subject, entriesChain...)
+	require.NoError(t, subject.Shutdown())
+	require.NotZero(t, countDatastoreKeys(t, ds, "/cache/links"))
+
+	// Restart with a separate cache datastore and assert the cache is moved to it.
+	cacheDs := dssync.MutexWrap(datastore.NewMapDatastore())
+	subject, err = engine.New(engine.WithDatastore(ds), engine.WithChainedEntries(2), engine.WithEntriesCacheDatastore(cacheDs))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	subject.RegisterMultihashLister(lister)
+	require.Zero(t, countDatastoreKeys(t, ds, "/cache/links"))
+	require.NotZero(t, countDatastoreKeys(t, cacheDs, "/"))
+	requireChunkIsCached(t, subject.Chunker(), entriesChain...)
+	require.Equal(t, wantChunks, requireLoadEntryChunkFromEngine(t, subject, entriesChain...))
+	stats := subject.EntriesCacheStats()
+	require.Equal(t, 1, stats.Len)
+	require.Zero(t, stats.Misses)
+	require.NoError(t, subject.Shutdown())
+
+	// Move the cache back to the engine datastore by restarting with the same datastore for
+	// both, then assert the cache is deleted instead of moved when purging on start.
+	subject, err = engine.New(engine.WithDatastore(ds), engine.WithChainedEntries(2))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	subject.RegisterMultihashLister(lister)
+	requireLoadEntryChunkFromEngine(t, subject, entriesChain...)
+	require.NoError(t, subject.Shutdown())
+	require.NotZero(t, countDatastoreKeys(t, ds, "/cache/links"))
+
+	cacheDs = dssync.MutexWrap(datastore.NewMapDatastore())
+	subject, err = engine.New(engine.WithDatastore(ds), engine.WithChainedEntries(2), engine.WithEntriesCacheDatastore(cacheDs), engine.WithPurgeCacheOnStart(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+	require.Zero(t, countDatastoreKeys(t, ds, "/cache/links"))
+	require.Zero(t, countDatastoreKeys(t, cacheDs, "/"))
+	requireChunkIsNotCached(t, subject.Chunker(), entriesChain...)
+}
+
+func Test_EntriesCacheIsWarmedUp(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	ctx := contextWithTimeout(t)
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	mhs := map[string][]cid.Cid{
+		"fish":      testutil.RandomCids(t, rng, 5),
+		"lobster":   testutil.RandomCids(t, rng, 5),
+		"barreleye": testutil.RandomCids(t, rng, 5),
+	}
+	var gate chan struct{}
+	lister := func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		if gate != nil {
+			<-gate
+		}
+		return getMhIterator(t, mhs[string(contextID)]), nil
+	}
+
+	// Publish advertisements, one of which is then removed.
+	subject, err := engine.New(engine.WithDatastore(ds), engine.WithChainedEntries(2))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	subject.RegisterMultihashLister(lister)
+	var adCids []cid.Cid
+	var entries []ipld.Link
+	for _, contextID := range []string{"fish", "lobster", "barreleye"} {
+		adCid, err := subject.NotifyPut(ctx, []byte(contextID), testMetadata)
+		require.NoError(t, err)
+		ad, err := subject.GetAdv(ctx, adCid)
+		require.NoError(t, err)
+		adCids = append(adCids, adCid)
+		entries = append(entries, ad.Entries)
+	}
+	_, err = subject.NotifyRemove(ctx, []byte("lobster"))
+	require.NoError(t, err)
+	require.NoError(t, subject.Shutdown())
+
+	// Restart with a purged cache, and assert the entries of advertised context IDs are generated.
+	subject, err = engine.New(engine.WithDatastore(ds), engine.WithChainedEntries(2), engine.WithPurgeCacheOnStart(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+	require.Equal(t, provider.ErrNoMultihashLister, subject.WarmUpEntriesCache(0, cid.Undef, 1))
+	subject.RegisterMultihashLister(lister)
+	require.Zero(t, subject.EntriesCacheWarmUpProgress())
+	requireChunkIsNotCached(t, subject.Chunker(), entries...)
+
+	gate = make(chan struct{})
+	require.NoError(t, subject.WarmUpEntriesCache(0, cid.Undef, 2))
+	require.Equal(t, engine.ErrWarmUpInProgress, subject.WarmUpEntriesCache(0, cid.Undef, 2))
+	require.True(t, subject.EntriesCacheWarmUpProgress().Running)
+	close(gate)
+	progress := requireWarmUpFinished(t, subject)
+	require.NoError(t, progress.Err)
+	require.Equal(t, 2, progress.Total)
+	require.Equal(t, 2, progress.Generated)
+	require.Zero(t, progress.Cached)
+	require.Zero(t, progress.Failed)
+	requireChunkIsCached(t, subject.Chunker(), entries[0], entries[2])
+	requireChunkIsNotCached(t, subject.Chunker(), entries[1])
+
+	// Assert that advertisements up to the given one are warmed up, skipping cached entries.
+	require.NoError(t, subject.WarmUpEntriesCache(0, adCids[1], 1))
+	progress = requireWarmUpFinished(t, subject)
+	require.NoError(t, progress.Err)
+	require.Equal(t, 1, progress.Total)
+	require.Equal(t, 1, progress.Cached)
+	require.Zero(t, progress.Generated)
+
+	// Assert that warm-up fails if the given advertisement is not in the chain.
+	require.NoError(t, subject.WarmUpEntriesCache(0, testutil.RandomCids(t, rng, 1)[0], 1))
+	progress = requireWarmUpFinished(t, subject)
+	require.Error(t, progress.Err)
+	require.Zero(t, progress.Done())
+
+	require.Error(t, subject.WarmUpEntriesCache(-1, cid.Undef, 1))
+	require.Error(t, subject.WarmUpEntriesCache(0, cid.Undef, 0))
+}
+
+func requireWarmUpFinished(t *testing.T, e *engine.Engine) engine.WarmUpProgress {
+	requireTrueEventually(t, func() bool {
+		return !e.EntriesCacheWarmUpProgress().Running
+	}, 10*time.Millisecond, 10*time.Second, "timed out waiting for entries cache warm-up")
+	return e.EntriesCacheWarmUpProgress()
+}
+
+func countDatastoreKeys(t *testing.T, ds datastore.Datastore, prefix string) int {
+	results, err := ds.Query(context.Background(), query.Query{Prefix: prefix, KeysOnly: true})
+	require.NoError(t, err)
+	entries, err := results.Rest()
+	require.NoError(t, err)
+	return len(entries)
+}
+
 func getMhIterator(t *testing.T, cids []cid.Cid) provider.MultihashIterator {
 	idx := index.NewMultihashSorted()
 	var records []index.Record
@@ -0,0 +1,266 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+
+	provider "github.com/filecoin-project/index-provider"
+	"github.com/filecoin-project/index-provider/metadata"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	// Multihash to context ID index prefix, keyed by base58 multihash followed by the base64url
+	// encoded context ID.
+	mhToKeyIndexPrefix = "map/mhKey/"
+	// Context ID to multihash index prefix, keyed by base64url encoded context ID followed by the
+	// base58 multihash.
+	keyToMhIndexPrefix = "map/keyMh/"
+	// Context ID to indexed entries CID prefix, keyed by base64url encoded context ID. The multihash
+	// index of a context ID is written outside the batch that commits its advertisement, and is
+	// only complete if the indexed entries CID matches the advertised one.
+	keyToIndexedPrefix = "map/keyMhIndexed/"
+
+	// indexBatchSize is the number of multihash index writes committed to the datastore at a time.
+	indexBatchSize = 16384
+)
+
+// ErrNoMultihashIndex signals that the multihash to context ID index is not enabled.
+// See: WithMultihashIndex.
+var ErrNoMultihashIndex = errors.New("multihash index is not enabled")
+
+// ContextIDInfo represents a context ID advertised by the provider, along with its entries CID
+// and metadata.
+type ContextIDInfo struct {
+	// ContextID is the advertised context ID.
+	ContextID []byte
+	// EntriesCid is the CID of the advertised entries.
+	EntriesCid cid.Cid
+	// Metadata is the metadata with which the context ID is advertised.
+	Metadata metadata.Metadata
+}
+
+// ListContextIDs lists all context IDs that are currently advertised via Engine.NotifyPut, along
+// with their entries CID and metadata, sorted by context ID.
+func (e *Engine) ListContextIDs(ctx context.Context) ([]ContextIDInfo, error) {
+	results, err := e.ds.Query(ctx, dsq.Query{Prefix: contextIDSetPrefix})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var infos []ContextIDInfo
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("cannot list context IDs: %w", r.Error)
+		}
+		info, err := e.contextIDInfo(ctx, r.Value)
+		if err != nil {
+			if err == datastore.ErrNotFound {
+				continue
+			}
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return bytes.Compare(infos[i].ContextID, infos[j].ContextID) < 0
+	})
+	return infos, nil
+}
+
+// LookupMultihash returns the currently advertised context IDs that contain the given multihash,
+// along with their entries CID and metadata, sorted by context ID.
+//
+// ErrNoMultihashIndex is returned if the multihash index is not enabled. Note that only the
+// context IDs advertised while the index is enabled are looked up.
+// See: WithMultihashIndex.
+func (e *Engine) LookupMultihash(ctx context.Context, mh multihash.Multihash) ([]ContextIDInfo, error) {
+	if !e.mhIndex {
+		return nil, ErrNoMultihashIndex
+	}
+
+	results, err := e.ds.Query(ctx, dsq.Query{Prefix: mhToKeyIndexPrefix + mh.B58String()})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var infos []ContextIDInfo
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("cannot look up multihash: %w", r.Error)
+		}
+		info, err := e.contextIDInfo(ctx, r.Value)
+		if err != nil {
+			if err == datastore.ErrNotFound {
+				continue
+			}
+			return nil, err
+		}
+		// Skip the context IDs whose index was not completed, e.g. due to a failed commit.
+		indexed, err := getIndexedEntries(ctx, e.ds, r.Value)
+		if err != nil {
+			return nil, err
+		}
+		if indexed != info.EntriesCid {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return bytes.Compare(infos[i].ContextID, infos[j].ContextID) < 0
+	})
+	return infos, nil
+}
+
+// contextIDInfo returns the info of the given context ID, or datastore.ErrNotFound if the context
+// ID is not advertised.
+func (e *Engine) contextIDInfo(ctx context.Context, contextID []byte) (ContextIDInfo, error) {
+	c, err := getKeyCidMap(ctx, e.ds, contextID)
+	if err != nil {
+		return ContextIDInfo{}, err
+	}
+	md, err := getKeyMetadataMap(ctx, e.ds, contextID)
+	if err != nil {
+		return ContextIDInfo{}, err
+	}
+	return ContextIDInfo{
+		ContextID:  contextID,
+		EntriesCid: c,
+		Metadata:   md,
+	}, nil
+}
+
+// indexingMultihashIterator adds every multihash returned by the wrapped iterator to the multihash
+// index of a context ID.
+type indexingMultihashIterator struct {
+	ctx       context.Context
+	w         dsWriter
+	contextID []byte
+	mhi       provider.MultihashIterator
+}
+
+func (i *indexingMultihashIterator) Next() (multihash.Multihash, error) {
+	mh, err := i.mhi.Next()
+	if err != nil {
+		return nil, err
+	}
+	if err := putMultihashIndex(i.ctx, i.w, i.contextID, mh); err != nil {
+		return nil, fmt.Errorf("failed to index multihash: %w", err)
+	}
+	return mh, nil
+}
+
+// newMultihashIndexWriter marks the multihash index of the given context ID as incomplete, and
+// returns a streamingBatch via which to write it. Once written, the index is completed by
+// committing putIndexedEntries along with the advertisement of the indexed entries.
+func (e *Engine) newMultihashIndexWriter(ctx context.Context, contextID []byte) (*streamingBatch, error) {
+	if err := e.ds.Delete(ctx, indexedEntriesKey(contextID)); err != nil {
+		return nil, err
+	}
+	return newStreamingBatch(ctx, e.ds, indexBatchSize)
+}
+
+func putMultihashIndex(ctx context.Context, rw dsWriter, contextID []byte, mh multihash.Multihash) error {
+	encContextID := base64.RawURLEncoding.EncodeToString(contextID)
+	mhStr := mh.B58String()
+	err := rw.Put(ctx, datastore.NewKey(mhToKeyIndexPrefix+mhStr+"/"+encContextID), contextID)
+	if err != nil {
+		return err
+	}
+	return rw.Put(ctx, datastore.NewKey(keyToMhIndexPrefix+encContextID+"/"+mhStr), []byte{})
+}
+
+// deleteMultihashIndex deletes all multihashes indexed for the given context ID, if any. The index
+// is deleted directly from the given datastore, a batch at a time, after marking it as incomplete.
+func deleteMultihashIndex(ctx context.Context, ds datastore.Batching, contextID []byte) error {
+	if err := ds.Delete(ctx, indexedEntriesKey(contextID)); err != nil {
+		return err
+	}
+	encContextID := base64.RawURLEncoding.EncodeToString(contextID)
+	results, err := ds.Query(ctx, dsq.Query{Prefix: keyToMhIndexPrefix + encContextID + "/", KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+	w, err := newStreamingBatch(ctx, ds, indexBatchSize)
+	if err != nil {
+		return err
+	}
+	for r := range results.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		key := datastore.NewKey(r.Key)
+		if err := w.Delete(ctx, datastore.NewKey(mhToKeyIndexPrefix+key.BaseNamespace()+"/"+encContextID)); err != nil {
+			return err
+		}
+		if err := w.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return w.Commit(ctx)
+}
+
+// hasMultihashIndex checks whether any multihashes are indexed for the given context ID.
+func hasMultihashIndex(ctx context.Context, ds dsReadWriter, contextID []byte) (bool, error) {
+	prefix := keyToMhIndexPrefix + base64.RawURLEncoding.EncodeToString(contextID) + "/"
+	results, err := ds.Query(ctx, dsq.Query{Prefix: prefix, KeysOnly: true, Limit: 1})
+	if err != nil {
+		return false, err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return false, err
+	}
+	return len(entries) != 0, nil
+}
+
+// putIndexedEntries records that the multihash index of the given context ID is complete for the
+// entries with the given CID.
+func putIndexedEntries(ctx context.Context, rw dsWriter, contextID []byte, c cid.Cid) error {
+	return rw.Put(ctx, indexedEntriesKey(contextID), c.Bytes())
+}
+
+// getIndexedEntries gets the CID of the entries for which the multihash index of the given context
+// ID is complete, or cid.Undef if the index is incomplete.
+func getIndexedEntries(ctx context.Context, rw dsReadWriter, contextID []byte) (cid.Cid, error) {
+	b, err := rw.Get(ctx, indexedEntriesKey(contextID))
+	if err != nil {
+		if err == datastore.ErrNotFound {
+			return cid.Undef, nil
+		}
+		return cid.Undef, err
+	}
+	_, c, err := cid.CidFromBytes(b)
+	return c, err
+}
+
+// isIndexed checks whether the multihash index of the given context ID is complete for its
+// currently advertised entries.
+func isIndexed(ctx context.Context, rw dsReadWriter, contextID []byte) (bool, error) {
+	indexed, err := getIndexedEntries(ctx, rw, contextID)
+	if err != nil || indexed == cid.Undef {
+		return false, err
+	}
+	c, err := getKeyCidMap(ctx, rw, contextID)
+	if err != nil {
+		if err == datastore.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return indexed == c, nil
+}
+
+func indexedEntriesKey(contextID []byte) datastore.Key {
+	return datastore.NewKey(keyToIndexedPrefix + base64.RawURLEncoding.EncodeToString(contextID))
+}
@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	provider "github.com/filecoin-project/index-provider"
+	"github.com/filecoin-project/index-provider/metadata"
+	"github.com/filecoin-project/index-provider/testutil"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_MultihashIndexIsUsedOnceCommitted(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rng := rand.New(rand.NewSource(1413))
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+	subject, err := New(WithDatastore(ds), WithPublisherKind(NoPublisher), WithMultihashIndex(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+	subject.RegisterMultihashLister(func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	})
+
+	fish := []byte("fish")
+	b, err := newDsBatch(ctx, ds)
+	require.NoError(t, err)
+	_, err = subject.generateAndStoreAdv(ctx, b, fish, metadata.New(metadata.Bitswap{}), false)
+	require.NoError(t, err)
+
+	// The index is written directly to the datastore, rather than held in the batch.
+	has, err := hasMultihashIndex(ctx, ds, fish)
+	require.NoError(t, err)
+	require.True(t, has)
+	for key := range b.pending {
+		require.NotContains(t, key.String(), keyToMhIndexPrefix)
+		require.NotContains(t, key.String(), mhToKeyIndexPrefix)
+	}
+	indexed, err := isIndexed(ctx, ds, fish)
+	require.NoError(t, err)
+	require.False(t, indexed)
+
+	require.NoError(t, b.Commit(ctx))
+	indexed, err = isIndexed(ctx, ds, fish)
+	require.NoError(t, err)
+	require.True(t, indexed)
+	infos, err := subject.LookupMultihash(ctx, mhs[7])
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	require.Equal(t, fish, infos[0].ContextID)
+}
+
+func TestEngine_MultihashIndexIsNotUsedWhenCommitFails(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rng := rand.New(rand.NewSource(1413))
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+	subject, err := New(WithDatastore(ds), WithPublisherKind(NoPublisher), WithMultihashIndex(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+	subject.RegisterMultihashLister(func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	})
+
+	fish := []byte("fish")
+	md := metadata.New(metadata.Bitswap{})
+	_, err = subject.NotifyPut(ctx, fish, md)
+	require.NoError(t, err)
+
+	// Simulate a removal and re-advertisement whose batch is never committed.
+	b, err := newDsBatch(ctx, ds)
+	require.NoError(t, err)
+	_, err = subject.generateAndStoreAdv(ctx, b, fish, md, true)
+	require.NoError(t, err)
+	_, err = subject.generateAndStoreAdv(ctx, b, fish, md, false)
+	require.NoError(t, err)
+
+	infos, err := subject.LookupMultihash(ctx, mhs[7])
+	require.NoError(t, err)
+	require.Empty(t, infos)
+	_, err = subject.NotifyUpdate(ctx, fish, metadata.New(metadata.Bitswap{}, &metadata.GraphsyncFilecoinV1{PieceCID: testutil.RandomCids(t, rng, 1)[0]}))
+	require.ErrorIs(t, err, ErrContextIDNotIndexed)
+
+	// Advertising the context ID afresh completes its index.
+	_, err = subject.NotifyRemove(ctx, fish)
+	require.NoError(t, err)
+	_, err = subject.NotifyPut(ctx, fish, md)
+	require.NoError(t, err)
+	infos, err = subject.LookupMultihash(ctx, mhs[7])
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+}
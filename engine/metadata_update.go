@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	provider "github.com/filecoin-project/index-provider"
+	"github.com/filecoin-project/index-provider/metadata"
+	"github.com/ipfs/go-cid"
+)
+
+// MetadataUpdater returns the updated metadata of the given context ID, given its currently
+// advertised metadata. Context IDs for which false is returned are not updated.
+// See: Engine.UpdateMetadata.
+type MetadataUpdater func(contextID []byte, md metadata.Metadata) (metadata.Metadata, bool, error)
+
+// UpdateMetadata re-advertises every context ID currently advertised via Engine.NotifyPut with the
+// metadata returned by the given updater, and returns the number of context IDs updated along with
+// the CID of the latest advertisement published.
+//
+// The updater may skip context IDs by returning false, which allows updating a subset of context
+// IDs. Context IDs whose updated metadata is equal to their current metadata are also skipped.
+// The advertisements published reuse the previously advertised entries, and are committed as a
+// single batch of which only the latest advertisement is announced. If no context IDs are updated,
+// cid.Undef is returned.
+//
+// See: Engine.NotifyBatch.
+func (e *Engine) UpdateMetadata(ctx context.Context, update MetadataUpdater) (int, cid.Cid, error) {
+	count, head, err := e.updateMetadata(ctx, update)
+	if err != nil || head == cid.Undef {
+		return count, head, err
+	}
+	if err := e.announce(ctx, head); err != nil {
+		return 0, cid.Undef, err
+	}
+	return count, head, nil
+}
+
+// updateMetadata commits the advertisements of Engine.UpdateMetadata without announcing them.
+func (e *Engine) updateMetadata(ctx context.Context, update MetadataUpdater) (int, cid.Cid, error) {
+	// Hold the lock while listing context IDs so that context IDs removed concurrently are not
+	// re-advertised.
+	e.publishLk.Lock()
+	defer e.publishLk.Unlock()
+
+	infos, err := e.ListContextIDs(ctx)
+	if err != nil {
+		return 0, cid.Undef, fmt.Errorf("could not list context IDs: %w", err)
+	}
+
+	var notifs []provider.Notification
+	for _, info := range infos {
+		md, ok, err := update(info.ContextID, info.Metadata)
+		if err != nil {
+			return 0, cid.Undef, err
+		}
+		if !ok || md.Equal(info.Metadata) {
+			continue
+		}
+		if err := md.Validate(); err != nil {
+			return 0, cid.Undef, fmt.Errorf("invalid metadata for context ID %x: %w", info.ContextID, err)
+		}
+		notifs = append(notifs, provider.Notification{
+			ContextID: info.ContextID,
+			Metadata:  md,
+		})
+	}
+	if len(notifs) == 0 {
+		log.Info("No context IDs required metadata update")
+		return 0, cid.Undef, nil
+	}
+
+	_, head, err := e.notifyBatch(ctx, notifs)
+	if err != nil {
+		return 0, cid.Undef, err
+	}
+	log.Infow("Updated metadata of context IDs", "count", len(notifs), "head", head)
+	return len(notifs), head, nil
+}
@@ -3,6 +3,7 @@ package engine
 import (
 	"fmt"
 	"net/url"
+	"time"
 
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/index-provider/engine/chunker"
@@ -49,11 +50,12 @@ type (
 		// announce messages to.
 		announceURLs []*url.URL
 
-		// key is always initialized from the host peerstore.
+		// key is initialized from the host peerstore, unless a signer is set.
 		// Setting an explicit identity must not be exposed unless it is tightly coupled with the
 		// host identity. Otherwise, the signature of advertisement will not match the libp2p host
 		// ID.
-		key crypto.PrivKey
+		key    crypto.PrivKey
+		signer Signer
 
 		provider peer.AddrInfo
 
@@ -64,11 +66,25 @@ type (
 		pubTopic           *pubsub.Topic
 		pubExtraGossipData []byte
 
-		entCacheCap int
-		purgeCache  bool
-		chunker     chunker.NewChunkerFunc
+		entCacheCap      int
+		entCacheMaxBytes int64
+		entCacheDs       datastore.Batching
+		purgeCache       bool
+		chunker          chunker.NewChunkerFunc
+
+		// rmCorrupted specifies whether to publish a removal advertisement for context IDs the
+		// regenerated entries of which do not match the advertised entries.
+		rmCorrupted bool
 
 		syncPolicy *policy.Policy
+
+		// mhIndex specifies whether to maintain an index of multihashes to context IDs.
+		mhIndex bool
+
+		// gcIndexers is the list of indexer URLs that must have synced past a compacted
+		// advertisement chain before obsolete advertisements are garbage collected.
+		gcIndexers []string
+		gcInterval time.Duration
 	}
 )
 
@@ -84,6 +100,7 @@ func newOptions(o ...Option) (*options, error) {
 		// 16384 multihashes per chunk.
 		chunker:    chunker.NewChainChunkerFunc(16384),
 		purgeCache: false,
+		gcInterval: 10 * time.Minute,
 	}
 
 	for _, apply := range o {
@@ -113,11 +130,20 @@ func newOptions(o ...Option) (*options, error) {
 		opts.h = h
 	}
 
-	// Initialize private key from libp2p host
-	opts.key = opts.h.Peerstore().PrivKey(opts.h.ID())
-	// Defensively check that host's self private key is indeed set.
-	if opts.key == nil {
-		return nil, fmt.Errorf("cannot find private key in self peerstore; libp2p host is misconfigured")
+	if opts.signer != nil {
+		opts.key = toPrivKey(opts.signer)
+		if signerID, err := peer.IDFromPublicKey(opts.key.GetPublic()); err != nil {
+			return nil, fmt.Errorf("cannot get peer ID of signer: %w", err)
+		} else if signerID != opts.h.ID() {
+			log.Warnw("Signer identity does not match libp2p host identity; indexers must trust the signer to publish advertisements on behalf of host.", "signerID", signerID, "hostID", opts.h.ID())
+		}
+	} else {
+		// Initialize private key from libp2p host
+		opts.key = opts.h.Peerstore().PrivKey(opts.h.ID())
+		// Defensively check that host's self private key is indeed set.
+		if opts.key == nil {
+			return nil, fmt.Errorf("cannot find private key in self peerstore; libp2p host is misconfigured")
+		}
 	}
 
 	if len(opts.provider.Addrs) == 0 {
@@ -132,14 +158,6 @@ func newOptions(o ...Option) (*options, error) {
 	return opts, nil
 }
 
-func (o *options) retrievalAddrsAsString() []string {
-	var ras []string
-	for _, ra := range o.provider.Addrs {
-		ras = append(ras, ra.String())
-	}
-	return ras
-}
-
 // WithPurgeCacheOnStart sets whether to clear any cached entries chunks when the provider engine
 // starts.
 // If unset, cache is rehydrated from previously cached entries stored in datastore if present.
@@ -166,6 +184,49 @@ func WithChainedEntries(chunkSize int) Option {
 	}
 }
 
+// WithParallelChainedEntries sets format of advertisement entries to chained Entry Chunk with
+// the given chunkSize as the maximum number of multihashes per chunk, where chunks are encoded
+// concurrently by the given number of workers.
+//
+// The generated entries are identical to the ones generated when using WithChainedEntries with
+// the same chunkSize. Using multiple workers speeds up generating large advertisements on
+// multi-core machines.
+//
+// See: chunker.ParallelChainChunker.
+func WithParallelChainedEntries(chunkSize, workers int) Option {
+	return func(o *options) error {
+		o.chunker = chunker.NewParallelChainChunkerFunc(chunkSize, workers)
+		return nil
+	}
+}
+
+// WithSortedChainedEntries sets format of advertisement entries to chained Entry Chunk with the
+// given chunkSize as the maximum number of multihashes per chunk, where duplicate multihashes are
+// omitted and the multihashes within each chunk are sorted by their bytes.
+//
+// The generated entries differ from the ones generated when using WithChainedEntries. Changing
+// the format only applies to context IDs advertised afterwards, since the entries of previously
+// advertised context IDs are regenerated in the format they were advertised in.
+//
+// See: chunker.NewSortedChainChunker.
+func WithSortedChainedEntries(chunkSize int) Option {
+	return func(o *options) error {
+		o.chunker = chunker.NewSortedChainChunkerFunc(chunkSize)
+		return nil
+	}
+}
+
+// WithSortedParallelChainedEntries sets format of advertisement entries the same as
+// WithSortedChainedEntries, where chunks are encoded concurrently by the given number of workers.
+//
+// See: WithParallelChainedEntries, chunker.NewSortedParallelChainChunker.
+func WithSortedParallelChainedEntries(chunkSize, workers int) Option {
+	return func(o *options) error {
+		o.chunker = chunker.NewSortedParallelChainChunkerFunc(chunkSize, workers)
+		return nil
+	}
+}
+
 // WithHamtEntries sets format of advertisement entries to HAMT with the given hash algorithm,
 // bit-width and bucket size.
 //
@@ -174,7 +235,7 @@ func WithChainedEntries(chunkSize int) Option {
 //
 // Only multicodec.Identity, multicodec.Sha2_256 and multicodec.Murmur3X64_64 are supported as hash
 // algorithm.
-// The bit-width and bucket size must be at least 3 and 1 respectively.
+// The bit-width must be at least 3 and at most 16, and the bucket size at least 1.
 // For more information on HAMT data structure, see:
 //  - https://ipld.io/specs/advanced-data-layouts/hamt/spec
 //  - https://github.com/ipld/go-ipld-adl-hamt
@@ -206,6 +267,55 @@ func WithEntriesCacheCapacity(s int) Option {
 	}
 }
 
+// WithEntriesCacheMaxBytes sets the maximum total size in bytes of advertisement entries chunks to
+// cache. When the size is exceeded, the least recently used DAGs are evicted until it is within
+// the limit. Chunks shared by overlapping DAGs are counted once.
+//
+// If unset or set to zero, the size is unlimited and the cache is only bound by its capacity.
+// Both limits apply if both are set; set the capacity to zero to only limit the cache by size.
+//
+// See: WithEntriesCacheCapacity, chunker.NewCachedEntriesChunkerWithMaxBytes.
+func WithEntriesCacheMaxBytes(n int64) Option {
+	return func(o *options) error {
+		if n < 0 {
+			return fmt.Errorf("entries cache max bytes must not be negative; got: %d", n)
+		}
+		o.entCacheMaxBytes = n
+		return nil
+	}
+}
+
+// WithEntriesCacheDatastore sets the datastore in which the advertisement entries cache is
+// stored, separately from the datastore set via WithDatastore. Since the cache is rebuilt as
+// needed, the datastore need not be durable; for example, an in-memory datastore may be used.
+//
+// If unset, the cache is stored in the engine datastore under the /cache/links namespace.
+// Otherwise, any cache stored in the engine datastore is moved to the given datastore when the
+// engine starts, or deleted if the cache is to be purged on start.
+//
+// The engine does not close the given datastore; it is the responsibility of the caller.
+//
+// See: WithDatastore, WithPurgeCacheOnStart.
+func WithEntriesCacheDatastore(ds datastore.Batching) Option {
+	return func(o *options) error {
+		o.entCacheDs = ds
+		return nil
+	}
+}
+
+// WithRemoveCorruptedEntries sets whether to publish a removal advertisement for a context ID
+// when its regenerated entries do not match its advertised entries, so that indexers stop
+// attempting to sync entries that can no longer be served. Corrupted context IDs are recorded
+// regardless. Defaults to disabled if unspecified.
+//
+// See: EntriesMismatchError, Engine.ListCorruptedContextIDs.
+func WithRemoveCorruptedEntries(enabled bool) Option {
+	return func(o *options) error {
+		o.rmCorrupted = enabled
+		return nil
+	}
+}
+
 // WithPublisherKind sets the kind of publisher used to announce new advertisements.
 // If unset, advertisements are only stored locally and no announcements are made.
 // See: PublisherKind.
@@ -303,6 +413,21 @@ func WithSyncPolicy(syncPolicy *policy.Policy) Option {
 	}
 }
 
+// WithSigner sets the signer used to sign advertisements, instead of the private key of the libp2p
+// host. This allows the signing key to be kept outside of process memory, e.g. by a local signing
+// daemon.
+//
+// The signer identity should match the libp2p host identity, since indexers verify the signature
+// of advertisements against the identity of the peer they sync from, unless configured to trust
+// the signer otherwise.
+// See: signer.SocketSigner.
+func WithSigner(s Signer) Option {
+	return func(o *options) error {
+		o.signer = s
+		return nil
+	}
+}
+
 // WithProvider sets the peer and addresses for the provider to put in indexing advertisements.
 // This value overrides `WithRetrievalAddrs`
 func WithProvider(provider peer.AddrInfo) Option {
@@ -339,3 +464,52 @@ func WithDirectAnnounce(announceURLs ...string) Option {
 		return nil
 	}
 }
+
+// WithGCIndexers sets the URLs of indexers that must have synced past a compacted advertisement
+// chain before the advertisements made obsolete by compaction are garbage collected. The URLs must
+// point to the indexers' find API, which is used to look up the latest advertisement synced from
+// this provider.
+//
+// Obsolete advertisements are never garbage collected if no indexers are set.
+// See: Engine.Compact, Engine.CollectGarbage.
+func WithGCIndexers(indexerURLs ...string) Option {
+	return func(o *options) error {
+		for _, urlStr := range indexerURLs {
+			if _, err := url.Parse(urlStr); err != nil {
+				return err
+			}
+		}
+		o.gcIndexers = append(o.gcIndexers, indexerURLs...)
+		return nil
+	}
+}
+
+// WithGCInterval sets the interval at which garbage collection of obsolete advertisements is
+// attempted. Defaults to 10 minutes if unspecified.
+// See: WithGCIndexers.
+func WithGCInterval(interval time.Duration) Option {
+	return func(o *options) error {
+		if interval <= 0 {
+			return fmt.Errorf("gc interval must be greater than zero; got %s", interval)
+		}
+		o.gcInterval = interval
+		return nil
+	}
+}
+
+// WithMultihashIndex sets whether to maintain an index of multihashes to the context IDs that
+// contain them, used to look up context IDs by multihash. When enabled, the multihashes of a
+// context ID are indexed as its entries are chunked. Defaults to disabled if unspecified.
+//
+// Note that enabling the index increases the storage consumed by the engine proportional to the
+// number of multihashes advertised, and context IDs advertised prior to enabling the index are not
+// indexed. The index is written to the datastore a batch at a time as the entries are chunked, and
+// is only used once the advertisement of its context ID is committed; the index of a context ID
+// whose advertisement fails to commit is not used until the context ID is advertised afresh.
+// See: Engine.LookupMultihash.
+func WithMultihashIndex(enabled bool) Option {
+	return func(o *options) error {
+		o.mhIndex = enabled
+		return nil
+	}
+}
@@ -0,0 +1,490 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// schemaVersionKey is the key at which the version of the engine state schema is stored.
+const schemaVersionKey = "sync/schemaVersion"
+
+// The versions of the engine state schema, each named after the change it introduces. To change
+// the engine state, add a version, set schemaVersion to it, and migrate the engine state from the
+// previous version in Engine.recover.
+const (
+	// schemaVersionVerified marks engine state verified to be consistent with the advertisement
+	// chain. Since advertisements and their mappings are written atomically, a single
+	// verification suffices to repair any half-written state left behind by previous versions of
+	// the engine.
+	schemaVersionVerified = iota + 1
+	// schemaVersionContextIDSet adds the set of context IDs; see contextIDSetPrefix.
+	schemaVersionContextIDSet
+	// schemaVersionEntriesFormat adds the entries format of context IDs; see keyToFormatMapPrefix.
+	// The format of context IDs advertised prior to this version is left unset.
+	schemaVersionEntriesFormat
+	// schemaVersionIndexedEntries adds the indexed entries of context IDs; see keyToIndexedPrefix.
+	schemaVersionIndexedEntries
+
+	// schemaVersion is the version of the engine state written by this version of the engine.
+	schemaVersion = schemaVersionIndexedEntries
+)
+
+var dsSchemaVersionKey = datastore.NewKey(schemaVersionKey)
+
+// recover verifies that the latest advertisement is present, and migrates the engine state to the
+// current schema version.
+//
+// If the latest advertisement is missing or corrupt, the head of the chain falls back on the last
+// resolvable advertisement; see Engine.findResolvableHead.
+//
+// The engine state is migrated by repairing any inconsistency between the context ID mappings and
+// the advertisement chain, which also sets the mappings added by later schema versions. The
+// mappings are repaired by walking the advertisement chain, and using the latest advertisement per
+// context ID as the source of truth:
+//   - context IDs whose latest advertisement is a removal, or that are not advertised at all, have
+//     their mappings deleted, and
+//   - context IDs whose latest advertisement is a put have their mappings set to the entries and
+//     metadata of that advertisement.
+//
+// All repairs are committed as a single batch along with the current schema version, after which
+// the chain walk is skipped on subsequent calls.
+func (e *Engine) recover(ctx context.Context) error {
+	e.publishLk.Lock()
+	defer e.publishLk.Unlock()
+
+	head, err := e.getLatestAdCid(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get latest advertisement cid: %w", err)
+	}
+	var headRepaired bool
+	if head != cid.Undef {
+		if _, err := e.GetAdv(ctx, head); err != nil {
+			log.Errorw("Latest advertisement is missing or corrupt; falling back on last resolvable advertisement", "head", head, "err", err)
+			missing := head
+			if head, err = e.findResolvableHead(ctx); err != nil {
+				return fmt.Errorf("latest advertisement %s is missing or corrupt, and %w", missing, err)
+			}
+			log.Warnw("Fell back on last resolvable advertisement as latest advertisement", "missing", missing, "head", head)
+			headRepaired = true
+		}
+	}
+
+	version, err := getSchemaVersion(ctx, e.ds)
+	if err != nil {
+		return err
+	}
+	if version > schemaVersion {
+		return fmt.Errorf("engine state schema version %d is newer than the supported version %d", version, schemaVersion)
+	}
+	if version == schemaVersion && !headRepaired {
+		log.Debug("Engine state is up to date; skipped recovery")
+		return nil
+	}
+
+	b, err := newDsBatch(ctx, e.ds)
+	if err != nil {
+		return err
+	}
+	if headRepaired {
+		if head == cid.Undef {
+			err = b.Delete(ctx, dsLatestAdvKey)
+		} else {
+			err = putLatestAdv(ctx, b, head.Bytes())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Infow("Verifying engine state against advertisement chain", "schemaVersion", version)
+	repaired, err := e.repairState(ctx, b, head, version)
+	if err != nil {
+		return err
+	}
+
+	if err := putSchemaVersion(ctx, b, schemaVersion); err != nil {
+		return err
+	}
+	if err := b.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit repaired engine state: %w", err)
+	}
+	log.Infow("Verified engine state against advertisement chain", "repaired", repaired, "schemaVersion", schemaVersion)
+	return nil
+}
+
+// repairState repairs the context ID mappings via the given dsBatch to match the advertisement
+// chain with the given head, migrating them from the given schema version, and returns the number
+// of repairs made.
+func (e *Engine) repairState(ctx context.Context, b *dsBatch, head cid.Cid, version int) (int, error) {
+	latest, err := e.latestAdPerContextID(ctx, head)
+	if err != nil {
+		return 0, err
+	}
+	var repaired int
+
+	// Previous versions of the engine committed the multihash index along with the advertisement,
+	// i.e. any index present is complete for the entries mapped to its context ID. Note that this
+	// must precede the repair of mappings, so that the index of repaired mappings is incomplete.
+	if version < schemaVersionIndexedEntries {
+		for _, ad := range latest {
+			if ad.IsRm {
+				continue
+			}
+			ok, err := e.backfillIndexedEntries(ctx, b, ad.ContextID)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				repaired++
+			}
+		}
+	}
+
+	// Delete mappings of context IDs that are no longer advertised. Note that mappings are compared
+	// by their datastore key, since context IDs cannot be reliably recovered from keys.
+	advertised := make(map[datastore.Key]struct{})
+	for _, ad := range latest {
+		if !ad.IsRm {
+			advertised[datastore.NewKey(keyToCidMapPrefix+string(ad.ContextID))] = struct{}{}
+			advertised[datastore.NewKey(keyToMetadataMapPrefix+string(ad.ContextID))] = struct{}{}
+			advertised[contextIDSetKey(ad.ContextID)] = struct{}{}
+			advertised[datastore.NewKey(keyToFormatMapPrefix+string(ad.ContextID))] = struct{}{}
+			advertised[indexedEntriesKey(ad.ContextID)] = struct{}{}
+		}
+	}
+	for _, prefix := range []string{keyToCidMapPrefix, keyToMetadataMapPrefix, contextIDSetPrefix, keyToFormatMapPrefix, keyToIndexedPrefix} {
+		stale, err := e.listStaleMappings(ctx, prefix, advertised)
+		if err != nil {
+			return 0, err
+		}
+		for _, r := range stale {
+			log.Warnw("Deleting mapping of context ID that is not advertised", "key", r.Key)
+			if prefix == keyToCidMapPrefix {
+				if _, c, err := cid.CidFromBytes(r.Value); err == nil {
+					if err := deleteCidKeyMap(ctx, b, c); err != nil {
+						return 0, err
+					}
+				}
+			}
+			if err := b.Delete(ctx, datastore.NewKey(r.Key)); err != nil {
+				return 0, err
+			}
+			repaired++
+		}
+	}
+
+	// Set mappings of advertised context IDs to match their latest advertisement.
+	for _, ad := range latest {
+		if ad.IsRm || ad.Entries == schema.NoEntries {
+			continue
+		}
+		// The mappings of context IDs updated via Engine.NotifyUpdate refer to the entries of
+		// their last put advertisement, not to the persisted delta entries.
+		delta, err := e.isDeltaEntries(ctx, ad.Entries)
+		if err != nil {
+			return 0, err
+		}
+		if delta {
+			continue
+		}
+		ok, err := e.repairMappings(ctx, b, ad)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			repaired++
+		}
+	}
+
+	// Delete entries CID to context ID mappings that have no counterpart.
+	orphans, err := e.listOrphanCidKeyMappings(ctx, b)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range orphans {
+		log.Warnw("Deleting orphan entries CID to context ID mapping", "entriesCid", c)
+		if err := deleteCidKeyMap(ctx, b, c); err != nil {
+			return 0, err
+		}
+		repaired++
+	}
+
+	return repaired, nil
+}
+
+// repairMappings sets the mappings of the context ID in the given advertisement to its entries and
+// metadata, and returns true if any changes were made.
+func (e *Engine) repairMappings(ctx context.Context, rw dsReadWriter, ad *schema.Advertisement) (bool, error) {
+	log := log.With("contextID", base64.StdEncoding.EncodeToString(ad.ContextID))
+	want := ad.Entries.(cidlink.Link).Cid
+
+	var repaired bool
+	got, err := getKeyCidMap(ctx, rw, ad.ContextID)
+	if err != nil && err != datastore.ErrNotFound {
+		return false, err
+	}
+	if got != want {
+		log.Warnw("Repairing context ID to entries CID mapping", "want", want, "got", got)
+		if got != cid.Undef {
+			if err := deleteCidKeyMap(ctx, rw, got); err != nil {
+				return false, err
+			}
+		}
+		if err := putKeyCidMap(ctx, rw, ad.ContextID, want); err != nil {
+			return false, err
+		}
+		repaired = true
+	} else {
+		gotContextID, err := rw.Get(ctx, datastore.NewKey(cidToKeyMapPrefix+want.String()))
+		if err != nil && err != datastore.ErrNotFound {
+			return false, err
+		}
+		inSet, err := rw.Get(ctx, contextIDSetKey(ad.ContextID))
+		if err != nil && err != datastore.ErrNotFound {
+			return false, err
+		}
+		if !bytes.Equal(gotContextID, ad.ContextID) || !bytes.Equal(inSet, ad.ContextID) {
+			log.Warnw("Repairing entries CID to context ID mapping", "entriesCid", want)
+			if err := putKeyCidMap(ctx, rw, ad.ContextID, want); err != nil {
+				return false, err
+			}
+			repaired = true
+		}
+	}
+
+	// The format of entries advertised by previous versions of the engine is not recorded, and is
+	// left unset; it is detected and recorded once the entries are regenerated, since the
+	// configured format may have changed since they were advertised.
+	// See: Engine.regenerateEntries.
+
+	gotMd, err := rw.Get(ctx, datastore.NewKey(keyToMetadataMapPrefix+string(ad.ContextID)))
+	if err != nil && err != datastore.ErrNotFound {
+		return false, err
+	}
+	if !bytes.Equal(gotMd, ad.Metadata) {
+		log.Warn("Repairing context ID to metadata mapping")
+		if err := rw.Put(ctx, datastore.NewKey(keyToMetadataMapPrefix+string(ad.ContextID)), ad.Metadata); err != nil {
+			return false, err
+		}
+		repaired = true
+	}
+	return repaired, nil
+}
+
+// backfillIndexedEntries records the multihash index of the given context ID as complete for its
+// mapped entries, if any multihashes are indexed for it, and returns true if recorded.
+func (e *Engine) backfillIndexedEntries(ctx context.Context, rw dsReadWriter, contextID []byte) (bool, error) {
+	c, err := getKeyCidMap(ctx, e.ds, contextID)
+	if err != nil {
+		if err == datastore.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	has, err := hasMultihashIndex(ctx, e.ds, contextID)
+	if err != nil || !has {
+		return false, err
+	}
+	log.Infow("Recording multihash index of context ID as complete", "contextID", base64.StdEncoding.EncodeToString(contextID))
+	if err := putIndexedEntries(ctx, rw, contextID, c); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// findResolvableHead finds the head of the advertisement chain among the advertisements stored in
+// the datastore, for use when the latest advertisement is missing or corrupt. The head is the
+// stored advertisement that is not the previous advertisement of any other stored advertisement,
+// excluding the chains made obsolete by Engine.Compact and any remnants of them that do not lead
+// to the tail of the compacted chain. cid.Undef is returned if no advertisements are stored.
+//
+// An error is returned if the head cannot be determined unambiguously.
+func (e *Engine) findResolvableHead(ctx context.Context) (cid.Cid, error) {
+	state, err := e.getCompactionState(ctx)
+	if err != nil {
+		return cid.Undef, err
+	}
+	results, err := e.ds.Query(ctx, dsq.Query{KeysOnly: true})
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer results.Close()
+
+	// Advertisements are stored at the root of the datastore, keyed by their CID.
+	lsys := e.vanillaLinkSystem()
+	previous := make(map[cid.Cid]cid.Cid)
+	linked := make(map[cid.Cid]struct{})
+	for r := range results.Next() {
+		if r.Error != nil {
+			return cid.Undef, fmt.Errorf("cannot list advertisements: %w", r.Error)
+		}
+		key := datastore.NewKey(r.Key)
+		if len(key.Namespaces()) != 1 {
+			continue
+		}
+		c, err := cid.Decode(key.BaseNamespace())
+		if err != nil {
+			continue
+		}
+		n, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: c}, schema.AdvertisementPrototype)
+		if err != nil {
+			continue
+		}
+		ad, err := schema.UnwrapAdvertisement(n)
+		if err != nil {
+			continue
+		}
+		previous[c] = cid.Undef
+		if ad.PreviousID != nil {
+			prev := (*ad.PreviousID).(cidlink.Link).Cid
+			previous[c] = prev
+			linked[prev] = struct{}{}
+		}
+	}
+	if len(previous) == 0 {
+		return cid.Undef, nil
+	}
+
+	obsolete := make(map[cid.Cid]struct{})
+	for _, c := range state.Obsolete {
+		obsolete[c] = struct{}{}
+	}
+	leadsToTail := func(c cid.Cid) bool {
+		for c != cid.Undef {
+			if c == state.Tail {
+				return true
+			}
+			c = previous[c]
+		}
+		return false
+	}
+	var heads []cid.Cid
+	for c := range previous {
+		if _, ok := linked[c]; ok {
+			continue
+		}
+		if _, ok := obsolete[c]; ok {
+			continue
+		}
+		if state.Tail != cid.Undef && !leadsToTail(c) {
+			continue
+		}
+		heads = append(heads, c)
+	}
+	switch len(heads) {
+	case 0:
+		return cid.Undef, fmt.Errorf("no chain head found among %d stored advertisements", len(previous))
+	case 1:
+		return heads[0], nil
+	default:
+		return cid.Undef, fmt.Errorf("cannot determine the chain head among stored advertisements %v", heads)
+	}
+}
+
+// getSchemaVersion gets the version of the engine state schema, or zero if none is stored.
+func getSchemaVersion(ctx context.Context, rw dsReadWriter) (int, error) {
+	b, err := rw.Get(ctx, dsSchemaVersionKey)
+	if err == datastore.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, fmt.Errorf("invalid engine state schema version: %w", err)
+	}
+	return version, nil
+}
+
+func putSchemaVersion(ctx context.Context, rw dsReadWriter, version int) error {
+	return rw.Put(ctx, dsSchemaVersionKey, []byte(strconv.Itoa(version)))
+}
+
+// latestAdPerContextID walks the advertisement chain backwards starting from the given head, and
+// returns the latest advertisement per context ID.
+func (e *Engine) latestAdPerContextID(ctx context.Context, head cid.Cid) (map[string]*schema.Advertisement, error) {
+	latest := make(map[string]*schema.Advertisement)
+	err := e.walkChain(ctx, head, func(_ cid.Cid, ad *schema.Advertisement) bool {
+		if isAddrsAdv(ad) {
+			return true
+		}
+		if _, seen := latest[string(ad.ContextID)]; !seen {
+			latest[string(ad.ContextID)] = ad
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return latest, nil
+}
+
+// listStaleMappings lists the mappings with the given prefix whose keys are not present in the
+// given set of advertised keys.
+func (e *Engine) listStaleMappings(ctx context.Context, prefix string, advertised map[datastore.Key]struct{}) ([]dsq.Entry, error) {
+	results, err := e.ds.Query(ctx, dsq.Query{Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var stale []dsq.Entry
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("cannot list mappings: %w", r.Error)
+		}
+		if _, ok := advertised[datastore.NewKey(r.Key)]; !ok {
+			stale = append(stale, r.Entry)
+		}
+	}
+	return stale, nil
+}
+
+// listOrphanCidKeyMappings lists the entries CIDs that are mapped to a context ID which in turn is
+// not mapped back to them, as observed via the given dsReadWriter.
+func (e *Engine) listOrphanCidKeyMappings(ctx context.Context, rw dsReadWriter) ([]cid.Cid, error) {
+	results, err := e.ds.Query(ctx, dsq.Query{Prefix: cidToKeyMapPrefix})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var orphans []cid.Cid
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("cannot list entries cid mappings: %w", r.Error)
+		}
+		c, err := cid.Decode(strings.TrimPrefix(r.Key, "/"+cidToKeyMapPrefix))
+		if err != nil {
+			return nil, err
+		}
+		// Read the mapping via rw, since it may have already been repaired.
+		contextID, err := rw.Get(ctx, datastore.NewKey(r.Key))
+		if err == datastore.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		mapped, err := getKeyCidMap(ctx, rw, contextID)
+		if err != nil && err != datastore.ErrNotFound {
+			return nil, err
+		}
+		if mapped != c {
+			orphans = append(orphans, c)
+		}
+	}
+	return orphans, nil
+}
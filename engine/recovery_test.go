@@ -0,0 +1,333 @@
+package engine
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	provider "github.com/filecoin-project/index-provider"
+	"github.com/filecoin-project/index-provider/metadata"
+	"github.com/filecoin-project/index-provider/testutil"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_StartRepairsInconsistentMappings(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rng := rand.New(rand.NewSource(1413))
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+	lister := func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	}
+
+	subject, err := New(WithDatastore(ds), WithPublisherKind(NoPublisher))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	subject.RegisterMultihashLister(lister)
+
+	fish := []byte("fish")
+	lobster := []byte("lobster")
+	md := metadata.New(metadata.Bitswap{})
+	_, err = subject.NotifyPut(ctx, fish, md)
+	require.NoError(t, err)
+	_, err = subject.NotifyPut(ctx, lobster, md)
+	require.NoError(t, err)
+	_, err = subject.NotifyRemove(ctx, lobster)
+	require.NoError(t, err)
+
+	wantFishCid, err := getKeyCidMap(ctx, ds, fish)
+	require.NoError(t, err)
+	wantFishMd, err := getKeyMetadataMap(ctx, ds, fish)
+	require.NoError(t, err)
+	require.NoError(t, subject.Shutdown())
+
+	// Simulate state left behind by an interrupted write.
+	orphanCid := testutil.RandomCids(t, rng, 1)[0]
+	require.NoError(t, deleteKeyCidMap(ctx, ds, fish))
+	staleMd := metadata.New(&metadata.GraphsyncFilecoinV1{PieceCID: orphanCid, FastRetrieval: true})
+	require.NoError(t, putKeyMetadataMap(ctx, ds, fish, &staleMd))
+	require.NoError(t, putKeyCidMap(ctx, ds, lobster, wantFishCid))
+	require.NoError(t, ds.Put(ctx, datastore.NewKey(cidToKeyMapPrefix+orphanCid.String()), []byte("squid")))
+	require.NoError(t, ds.Delete(ctx, dsSchemaVersionKey))
+
+	subject, err = New(WithDatastore(ds), WithPublisherKind(NoPublisher))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+
+	gotFishCid, err := getKeyCidMap(ctx, ds, fish)
+	require.NoError(t, err)
+	require.Equal(t, wantFishCid, gotFishCid)
+	gotContextID, err := subject.getCidKeyMap(ctx, wantFishCid)
+	require.NoError(t, err)
+	require.Equal(t, fish, gotContextID)
+	gotFishMd, err := getKeyMetadataMap(ctx, ds, fish)
+	require.NoError(t, err)
+	require.True(t, wantFishMd.Equal(gotFishMd))
+
+	_, err = getKeyCidMap(ctx, ds, lobster)
+	require.Equal(t, datastore.ErrNotFound, err)
+	_, err = getKeyMetadataMap(ctx, ds, lobster)
+	require.Equal(t, datastore.ErrNotFound, err)
+	_, err = subject.getCidKeyMap(ctx, orphanCid)
+	require.Equal(t, datastore.ErrNotFound, err)
+
+	version, err := getSchemaVersion(ctx, ds)
+	require.NoError(t, err)
+	require.Equal(t, schemaVersion, version)
+
+	// Assert the repaired state is usable.
+	subject.RegisterMultihashLister(lister)
+	_, err = subject.NotifyPut(ctx, fish, md)
+	require.Equal(t, provider.ErrAlreadyAdvertised, err)
+	_, err = subject.NotifyPut(ctx, lobster, md)
+	require.NoError(t, err)
+}
+
+func TestEngine_StartBackfillsContextIDSet(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rng := rand.New(rand.NewSource(1413))
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+	lister := func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	}
+
+	subject, err := New(WithDatastore(ds), WithPublisherKind(NoPublisher))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	subject.RegisterMultihashLister(lister)
+
+	// Advertise context IDs that share the same entries CID.
+	fish := []byte("fish")
+	lobster := []byte("lobster")
+	md := metadata.New(metadata.Bitswap{})
+	_, err = subject.NotifyPut(ctx, fish, md)
+	require.NoError(t, err)
+	_, err = subject.NotifyPut(ctx, lobster, md)
+	require.NoError(t, err)
+	infos, err := subject.ListContextIDs(ctx)
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	require.Equal(t, infos[0].EntriesCid, infos[1].EntriesCid)
+	require.NoError(t, subject.Shutdown())
+
+	// Simulate state verified by a version of the engine that predates the set of context IDs.
+	require.NoError(t, ds.Delete(ctx, contextIDSetKey(fish)))
+	require.NoError(t, ds.Delete(ctx, contextIDSetKey(lobster)))
+	require.NoError(t, putSchemaVersion(ctx, ds, schemaVersionVerified))
+
+	subject, err = New(WithDatastore(ds), WithPublisherKind(NoPublisher))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+
+	infos, err = subject.ListContextIDs(ctx)
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	require.Equal(t, fish, infos[0].ContextID)
+	require.Equal(t, lobster, infos[1].ContextID)
+}
+
+func TestEngine_StartLeavesEntriesFormatOfLegacyContextIDsUnset(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rng := rand.New(rand.NewSource(1413))
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+	lister := func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	}
+
+	subject, err := New(WithDatastore(ds), WithPublisherKind(NoPublisher), WithChainedEntries(10))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	subject.RegisterMultihashLister(lister)
+	fish := []byte("fish")
+	_, err = subject.NotifyPut(ctx, fish, metadata.New(metadata.Bitswap{}))
+	require.NoError(t, err)
+	advertised, err := getKeyCidMap(ctx, ds, fish)
+	require.NoError(t, err)
+	format, err := getKeyFormatMap(ctx, ds, fish)
+	require.NoError(t, err)
+	require.Equal(t, "chain/10", format)
+	require.NoError(t, subject.Shutdown())
+
+	// Simulate state verified by a version of the engine that predates the entries format mapping.
+	require.NoError(t, ds.Delete(ctx, datastore.NewKey(keyToFormatMapPrefix+string(fish))))
+	require.NoError(t, ds.Delete(ctx, dsEntriesFormatsKey))
+	require.NoError(t, putSchemaVersion(ctx, ds, schemaVersionContextIDSet))
+
+	// Change the entries format as part of the upgrade.
+	subject, err = New(WithDatastore(ds), WithPublisherKind(NoPublisher), WithHamtEntries(multicodec.Identity, 3, 1))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+	subject.RegisterMultihashLister(lister)
+
+	format, err = getKeyFormatMap(ctx, ds, fish)
+	require.NoError(t, err)
+	require.Empty(t, format)
+
+	// The format in which the entries were advertised is detected and recorded on regeneration.
+	root, err := subject.regenerateEntries(ctx, fish, advertised)
+	require.NoError(t, err)
+	require.Equal(t, advertised, root.(cidlink.Link).Cid)
+	format, err = getKeyFormatMap(ctx, ds, fish)
+	require.NoError(t, err)
+	require.Equal(t, "chain/10", format)
+}
+
+func TestEngine_StartBackfillsIndexedEntries(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rng := rand.New(rand.NewSource(1413))
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+	lister := func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	}
+
+	subject, err := New(WithDatastore(ds), WithPublisherKind(NoPublisher), WithMultihashIndex(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	subject.RegisterMultihashLister(lister)
+	fish := []byte("fish")
+	_, err = subject.NotifyPut(ctx, fish, metadata.New(metadata.Bitswap{}))
+	require.NoError(t, err)
+	require.NoError(t, subject.Shutdown())
+
+	// Simulate state verified by a version of the engine that predates the indexed entries mapping.
+	require.NoError(t, ds.Delete(ctx, indexedEntriesKey(fish)))
+	require.NoError(t, putSchemaVersion(ctx, ds, schemaVersionEntriesFormat))
+
+	subject, err = New(WithDatastore(ds), WithPublisherKind(NoPublisher), WithMultihashIndex(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+
+	indexed, err := isIndexed(ctx, ds, fish)
+	require.NoError(t, err)
+	require.True(t, indexed)
+	infos, err := subject.LookupMultihash(ctx, mhs[3])
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+}
+
+func TestEngine_StartFallsBackOnLastResolvableAdv(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rng := rand.New(rand.NewSource(1413))
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+	lister := func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	}
+
+	subject, err := New(WithDatastore(ds), WithPublisherKind(NoPublisher))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	subject.RegisterMultihashLister(lister)
+	fish := []byte("fish")
+	lobster := []byte("lobster")
+	md := metadata.New(metadata.Bitswap{})
+	fishAdCid, err := subject.NotifyPut(ctx, fish, md)
+	require.NoError(t, err)
+	lobsterAdCid, err := subject.NotifyPut(ctx, lobster, md)
+	require.NoError(t, err)
+	require.NoError(t, subject.Shutdown())
+
+	// Simulate a missing latest advertisement.
+	require.NoError(t, ds.Delete(ctx, datastore.NewKey(lobsterAdCid.String())))
+
+	subject, err = New(WithDatastore(ds), WithPublisherKind(NoPublisher))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+
+	head, err := subject.getLatestAdCid(ctx)
+	require.NoError(t, err)
+	require.Equal(t, fishAdCid, head)
+	infos, err := subject.ListContextIDs(ctx)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	require.Equal(t, fish, infos[0].ContextID)
+
+	// Assert the repaired state is usable.
+	subject.RegisterMultihashLister(lister)
+	lobsterAdCid, err = subject.NotifyPut(ctx, lobster, md)
+	require.NoError(t, err)
+	lobsterAd, err := subject.GetAdv(ctx, lobsterAdCid)
+	require.NoError(t, err)
+	require.Equal(t, fishAdCid, (*lobsterAd.PreviousID).(cidlink.Link).Cid)
+}
+
+func TestEngine_StartFallsBackOnNoAdvWhenNoneIsResolvable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rng := rand.New(rand.NewSource(1413))
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	missing := testutil.RandomCids(t, rng, 1)[0]
+	require.NoError(t, putLatestAdv(ctx, ds, missing.Bytes()))
+
+	subject, err := New(WithDatastore(ds), WithPublisherKind(NoPublisher))
+	require.NoError(t, err)
+	require.NoError(t, subject.Start(ctx))
+	defer subject.Shutdown()
+	head, err := subject.getLatestAdCid(ctx)
+	require.NoError(t, err)
+	require.Equal(t, cid.Undef, head)
+}
+
+func TestEngine_StartFailsWhenChainHeadIsAmbiguous(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rng := rand.New(rand.NewSource(1413))
+
+	mhs := testutil.RandomMultihashes(t, rng, 42)
+	lister := func(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	}
+	publish := func(ds datastore.Batching, contextIDs ...string) cid.Cid {
+		subject, err := New(WithDatastore(ds), WithPublisherKind(NoPublisher))
+		require.NoError(t, err)
+		require.NoError(t, subject.Start(ctx))
+		defer subject.Shutdown()
+		subject.RegisterMultihashLister(lister)
+		var c cid.Cid
+		for _, contextID := range contextIDs {
+			c, err = subject.NotifyPut(ctx, []byte(contextID), metadata.New(metadata.Bitswap{}))
+			require.NoError(t, err)
+		}
+		return c
+	}
+
+	// Store an advertisement of an unrelated chain, then simulate a missing latest advertisement.
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	otherDs := dssync.MutexWrap(datastore.NewMapDatastore())
+	otherAdCid := publish(otherDs, "squid")
+	otherAd, err := otherDs.Get(ctx, datastore.NewKey(otherAdCid.String()))
+	require.NoError(t, err)
+	require.NoError(t, ds.Put(ctx, datastore.NewKey(otherAdCid.String()), otherAd))
+	head := publish(ds, "fish", "lobster")
+	require.NoError(t, ds.Delete(ctx, datastore.NewKey(head.String())))
+
+	subject, err := New(WithDatastore(ds), WithPublisherKind(NoPublisher))
+	require.NoError(t, err)
+	err = subject.Start(ctx)
+	require.ErrorContains(t, err, "cannot determine the chain head")
+}
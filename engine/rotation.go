@@ -0,0 +1,180 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filecoin-project/index-provider/metadata"
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// RotationReport summarizes the outcome of a provider identity rotation.
+// See: Engine.RotateIdentity.
+type RotationReport struct {
+	// PreviousProvider is the provider ID under which context IDs were advertised prior to
+	// rotation.
+	PreviousProvider peer.ID
+	// Provider is the provider ID under which context IDs are advertised after rotation.
+	Provider peer.ID
+	// LiveContextIDs is the number of context IDs re-advertised under the new provider ID.
+	LiveContextIDs int
+	// PreviousHead is the head of the advertisement chain prior to rotation.
+	PreviousHead cid.Cid
+	// Head is the head of the advertisement chain after rotation, or cid.Undef if no
+	// advertisements were published.
+	Head cid.Cid
+}
+
+// RotateIdentity re-advertises all context IDs that are currently advertised by the provider under
+// the provider ID that corresponds to the given private key, such that the content remains
+// available from indexers after the provider identity is rotated.
+//
+// For each live context ID, a removal advertisement is published under the current provider ID,
+// signed by the current identity. Then, a put advertisement with the same entries and metadata is
+// published under the new provider ID, signed by the new key. All advertisements are appended to
+// the existing chain, which keeps the chain continuous; indexers that sync the chain from the new
+// identity observe the removals followed by the puts. Removals are published first so that the
+// latest advertisement of every context ID is a put under the new provider ID.
+//
+// The given progress function, if non-nil, is called after each advertisement is generated with
+// the number of advertisements generated so far and the total number to generate. All
+// advertisements are stored atomically once generated.
+//
+// If an error occurs after the advertisements are stored, such as a failure to announce them, the
+// report is returned along with the error. A non-nil report with a defined Head therefore
+// signals that advertisements signed by the new key are part of the chain, even if an error is
+// returned. Otherwise, the report is nil on error.
+//
+// Note that the engine continues to use its current identity once rotation is complete. The
+// engine must be restarted with a libp2p host of the new identity to publish further
+// advertisements under the new provider ID.
+func (e *Engine) RotateIdentity(ctx context.Context, newKey crypto.PrivKey, progress func(done, total int)) (*RotationReport, error) {
+	report, err := e.rotateIdentity(ctx, newKey, progress)
+	if err != nil || report.Head == cid.Undef {
+		return report, err
+	}
+	if err := e.announce(ctx, report.Head); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// rotateIdentity commits the advertisements of Engine.RotateIdentity without announcing them. The
+// head of the committed advertisements is set in the returned report, if any.
+func (e *Engine) rotateIdentity(ctx context.Context, newKey crypto.PrivKey, progress func(done, total int)) (*RotationReport, error) {
+	e.publishLk.Lock()
+	defer e.publishLk.Unlock()
+
+	newID, err := peer.IDFromPrivateKey(newKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get peer ID of new identity: %w", err)
+	}
+	report := &RotationReport{
+		PreviousProvider: e.options.provider.ID,
+		Provider:         newID,
+	}
+	if newID == e.options.provider.ID {
+		return nil, fmt.Errorf("new identity must differ from current provider ID %s", newID)
+	}
+
+	head, err := e.getLatestAdCid(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get latest advertisement cid: %w", err)
+	}
+	report.PreviousHead = head
+	if head == cid.Undef {
+		log.Info("No advertisements published; nothing to rotate")
+		return report, nil
+	}
+
+	live, _, liveContextIDs, err := e.liveAdvs(ctx, head)
+	if err != nil {
+		return nil, err
+	}
+	report.LiveContextIDs = liveContextIDs
+	if len(live) == 0 {
+		log.Info("No live context IDs; nothing to rotate")
+		return report, nil
+	}
+
+	// Removal advertisements require a valid metadata even though it is not used.
+	rmMd := metadata.New(metadata.Bitswap{})
+	rmMdBytes, err := rmMd.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := newDsBatch(ctx, e.ds)
+	if err != nil {
+		return nil, fmt.Errorf("could not create datastore batch: %w", err)
+	}
+	addrs := e.retrievalAddrsAsString()
+	total := liveContextIDs + len(live)
+	prev := head
+	publish := func(ad schema.Advertisement, key crypto.PrivKey) error {
+		prevLnk := ipld.Link(cidlink.Link{Cid: prev})
+		ad.PreviousID = &prevLnk
+		if err := ad.Sign(key); err != nil {
+			return err
+		}
+		c, err := e.storeAdv(ctx, b, ad)
+		if err != nil {
+			return err
+		}
+		prev = c
+		return nil
+	}
+
+	var done int
+	removed := make(map[string]struct{})
+	for _, ad := range live {
+		// Context IDs updated via Engine.NotifyUpdate have more than one live advertisement.
+		if _, ok := removed[string(ad.ContextID)]; ok {
+			continue
+		}
+		removed[string(ad.ContextID)] = struct{}{}
+		err := publish(schema.Advertisement{
+			Provider:  e.options.provider.ID.String(),
+			Addresses: addrs,
+			Entries:   schema.NoEntries,
+			ContextID: ad.ContextID,
+			Metadata:  rmMdBytes,
+			IsRm:      true,
+		}, e.key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate removal advertisement: %w", err)
+		}
+		done++
+		if progress != nil {
+			progress(done, total)
+		}
+	}
+	for _, ad := range live {
+		err := publish(schema.Advertisement{
+			Provider:  newID.String(),
+			Addresses: addrs,
+			Entries:   ad.Entries,
+			ContextID: ad.ContextID,
+			Metadata:  ad.Metadata,
+		}, newKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate advertisement under new identity: %w", err)
+		}
+		done++
+		if progress != nil {
+			progress(done, total)
+		}
+	}
+
+	if err := b.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit rotated advertisements: %w", err)
+	}
+	report.Head = prev
+	log.Infow("Rotated provider identity", "previousProvider", report.PreviousProvider, "provider", newID, "liveContextIDs", len(live), "head", prev)
+	return report, nil
+}
@@ -0,0 +1,50 @@
+package engine
+
+import (
+	"errors"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	pb "github.com/libp2p/go-libp2p-core/crypto/pb"
+)
+
+// Signer signs the advertisements published by the engine, along with the announcements and head
+// of advertisement chain exposed by the publisher. A crypto.PrivKey satisfies Signer, which allows
+// an implementation to keep the private key outside the process, e.g. in a separate signing daemon.
+// See: WithSigner.
+type Signer interface {
+	// Sign signs the given bytes.
+	Sign(data []byte) ([]byte, error)
+	// GetPublic returns the public key of the signer, used to verify signatures.
+	GetPublic() crypto.PubKey
+}
+
+var errSignerKeyNotExportable = errors.New("signer private key is not exportable")
+
+var _ crypto.PrivKey = (*signerKey)(nil)
+
+// signerKey adapts a Signer to crypto.PrivKey, as expected by advertisement signing and the
+// publishers.
+type signerKey struct {
+	Signer
+}
+
+// toPrivKey returns the given signer as a crypto.PrivKey.
+func toPrivKey(s Signer) crypto.PrivKey {
+	if key, ok := s.(crypto.PrivKey); ok {
+		return key
+	}
+	return &signerKey{s}
+}
+
+func (k *signerKey) Equals(other crypto.Key) bool {
+	o, ok := other.(*signerKey)
+	return ok && o.Signer == k.Signer
+}
+
+func (k *signerKey) Raw() ([]byte, error) {
+	return nil, errSignerKeyNotExportable
+}
+
+func (k *signerKey) Type() pb.KeyType {
+	return k.GetPublic().Type()
+}
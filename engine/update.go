@@ -0,0 +1,501 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	provider "github.com/filecoin-project/index-provider"
+	"github.com/filecoin-project/index-provider/engine/chunker"
+	"github.com/filecoin-project/index-provider/metadata"
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	// Delta entries prefix, keyed by the CID of entries chunk. Unlike the entries generated via
+	// the multihash lister, delta entries cannot be regenerated and are therefore persisted.
+	deltaEntriesPrefix = "map/deltaEntries/"
+	// Context ID to delta entries prefix, keyed by base64url encoded context ID followed by the
+	// entries chunk CID.
+	keyToDeltaPrefix = "map/keyDelta/"
+	// Delta entries to context ID prefix, keyed by the entries chunk CID followed by base64url
+	// encoded context ID.
+	deltaToKeyPrefix = "map/deltaKey/"
+	// Context ID to added multihashes prefix, keyed by base64url encoded context ID followed by
+	// base58 encoded multihash.
+	keyToAddedMhPrefix = "map/keyAddedMh/"
+)
+
+// ErrContextIDNotIndexed signals that the multihashes of an advertised context ID are not present
+// in the multihash index, e.g. because it was advertised before the index was enabled, or because
+// its index could not be completed.
+var ErrContextIDNotIndexed = errors.New("multihashes of context ID are not indexed")
+
+// NotifyUpdate advertises the changes to the multihashes of a context ID since it was last
+// advertised. The multihashes listed for the context ID via the registered
+// provider.MultihashLister are compared to the ones previously advertised, as recorded by the
+// multihash index:
+//   - If multihashes are only added, a put advertisement is published with entries that contain
+//     the added multihashes only. Since indexers add the multihashes of every put advertisement to
+//     its context ID, the previously advertised multihashes remain indexed.
+//   - If any multihashes are removed, a removal advertisement is published for the context ID,
+//     followed by a put advertisement with all of its multihashes, since indexers can only remove
+//     multihashes by context ID.
+//   - If no multihashes are changed, the context ID is advertised with the given metadata as if by
+//     Engine.NotifyPut, which returns provider.ErrAlreadyAdvertised if the metadata is unchanged.
+//
+// The entries of added multihashes are persisted, since they cannot be regenerated via the
+// multihash lister. When regenerating previously advertised entries, the added multihashes are
+// excluded from the multihashes listed for the context ID. A context ID that is not yet
+// advertised is advertised as if by Engine.NotifyPut.
+//
+// The multihash index must be enabled, and must contain the multihashes of the context ID;
+// otherwise ErrNoMultihashIndex or ErrContextIDNotIndexed is returned respectively. See:
+// WithMultihashIndex.
+//
+// Note that comparing the multihashes holds the previously advertised multihashes of the context
+// ID in memory, along with the added ones, i.e. memory usage grows linearly with the number of
+// multihashes of the context ID.
+//
+// The advertisements are committed to the datastore as a single batch, and only the latest one is
+// announced. The CID of the latest advertisement is returned. An error of type
+// metadata.ErrInvalidMetadata is returned if the given metadata is not valid.
+func (e *Engine) NotifyUpdate(ctx context.Context, contextID []byte, md metadata.Metadata) (cid.Cid, error) {
+	if err := md.Validate(); err != nil {
+		return cid.Undef, err
+	}
+	c, err := e.commitUpdate(ctx, contextID, md)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := e.announce(ctx, c); err != nil {
+		return cid.Undef, err
+	}
+	return c, nil
+}
+
+// commitUpdate generates, stores and commits the advertisements of Engine.NotifyUpdate, and
+// returns the CID of the latest one.
+func (e *Engine) commitUpdate(ctx context.Context, contextID []byte, md metadata.Metadata) (cid.Cid, error) {
+	e.publishLk.Lock()
+	defer e.publishLk.Unlock()
+
+	b, err := newDsBatch(ctx, e.ds)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("could not create datastore batch: %w", err)
+	}
+	c, err := e.notifyUpdate(ctx, b, contextID, md)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := b.Commit(ctx); err != nil {
+		log.Errorw("Failed to commit advertisement", "err", err)
+		return cid.Undef, fmt.Errorf("failed to commit advertisement: %w", err)
+	}
+	return c, nil
+}
+
+// notifyUpdate generates and stores the advertisements that signal the update of the given
+// context ID via the given dsBatch, and returns the CID of the latest one.
+func (e *Engine) notifyUpdate(ctx context.Context, b *dsBatch, contextID []byte, md metadata.Metadata) (cid.Cid, error) {
+	log := log.With("contextID", base64.StdEncoding.EncodeToString(contextID))
+
+	c, err := getKeyCidMap(ctx, b, contextID)
+	if err != nil && err != datastore.ErrNotFound {
+		return cid.Undef, fmt.Errorf("could not get entries cid by context id: %s", err)
+	}
+	if c == cid.Undef {
+		log.Info("Context ID is not advertised; advertising all multihashes")
+		return e.generateAndStoreAdv(ctx, b, contextID, md, false)
+	}
+	if !e.mhIndex {
+		return cid.Undef, ErrNoMultihashIndex
+	}
+	if e.mhLister == nil {
+		return cid.Undef, provider.ErrNoMultihashLister
+	}
+
+	added, removed, err := e.diffMultihashes(ctx, b, contextID)
+	if err != nil {
+		return cid.Undef, err
+	}
+	log = log.With("added", len(added), "removed", removed)
+
+	switch {
+	case removed > 0:
+		log.Info("Multihashes are removed; re-advertising context ID")
+		if _, err := e.generateAndStoreAdv(ctx, b, contextID, md, true); err != nil {
+			return cid.Undef, err
+		}
+		return e.generateAndStoreAdv(ctx, b, contextID, md, false)
+	case len(added) == 0:
+		log.Info("No multihashes are changed")
+		return e.generateAndStoreAdv(ctx, b, contextID, md, false)
+	default:
+		log.Info("Multihashes are added; advertising delta entries")
+		adv, err := e.generateDeltaAdv(ctx, b, contextID, md, added)
+		if err != nil {
+			return cid.Undef, err
+		}
+		return e.storeAdv(ctx, b, *adv)
+	}
+}
+
+// generateAndStoreAdv generates and stores an advertisement as described by
+// Engine.generateAdvForIndex.
+func (e *Engine) generateAndStoreAdv(ctx context.Context, rw dsReadWriter, contextID []byte, md metadata.Metadata, isRm bool) (cid.Cid, error) {
+	adv, err := e.generateAdvForIndex(ctx, rw, contextID, md, isRm)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return e.storeAdv(ctx, rw, *adv)
+}
+
+// diffMultihashes compares the multihashes listed for the given context ID to the ones in its
+// multihash index, and returns the multihashes added in the order in which they are listed, along
+// with the number of multihashes removed.
+//
+// The indexed multihashes are loaded into memory, since neither the lister nor the datastore
+// guarantee to list multihashes in the same order, and the lister may list duplicate multihashes.
+func (e *Engine) diffMultihashes(ctx context.Context, rw dsReadWriter, contextID []byte) ([]multihash.Multihash, int, error) {
+	indexed, err := isIndexed(ctx, rw, contextID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !indexed {
+		return nil, 0, ErrContextIDNotIndexed
+	}
+	// Map the previously advertised multihashes to whether they are still listed. The multihash
+	// index is written directly to the datastore; see Engine.generateAdvForIndex.
+	prev := make(map[string]bool)
+	err = forEachIndexedMultihash(ctx, e.ds, contextID, func(mh multihash.Multihash) {
+		prev[string(mh)] = false
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	mhIter, err := e.mhLister(ctx, contextID)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer closeMhIterator(mhIter)
+	var added []multihash.Multihash
+	addedSet := make(map[string]struct{})
+	var retained int
+	for {
+		mh, err := mhIter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if listed, ok := prev[string(mh)]; ok {
+			if !listed {
+				prev[string(mh)] = true
+				retained++
+			}
+			continue
+		}
+		if _, ok := addedSet[string(mh)]; ok {
+			continue
+		}
+		addedSet[string(mh)] = struct{}{}
+		added = append(added, mh)
+	}
+	return added, len(prev) - retained, nil
+}
+
+// generateDeltaAdv persists the entries of the given added multihashes of a context ID via the
+// given dsReadWriter, and generates a signed put advertisement with them as its entries.
+func (e *Engine) generateDeltaAdv(ctx context.Context, rw dsReadWriter, contextID []byte, md metadata.Metadata, added []multihash.Multihash) (*schema.Advertisement, error) {
+	encContextID := base64.RawURLEncoding.EncodeToString(contextID)
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.StorageWriteOpener = func(lctx ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+		buf := bytes.NewBuffer(nil)
+		return buf, func(lnk ipld.Link) error {
+			c := lnk.(cidlink.Link).Cid.String()
+			if err := rw.Put(lctx.Ctx, datastore.NewKey(deltaEntriesPrefix+c), buf.Bytes()); err != nil {
+				return err
+			}
+			if err := rw.Put(lctx.Ctx, datastore.NewKey(keyToDeltaPrefix+encContextID+"/"+c), []byte{}); err != nil {
+				return err
+			}
+			return rw.Put(lctx.Ctx, datastore.NewKey(deltaToKeyPrefix+c+"/"+encContextID), []byte{})
+		}, nil
+	}
+	deltaChunker, err := e.chunker(&lsys)
+	if err != nil {
+		return nil, err
+	}
+	lnk, err := deltaChunker.Chunk(ctx, provider.SliceMultihashIterator(added))
+	if err != nil {
+		return nil, fmt.Errorf("could not generate delta entries: %s", err)
+	}
+
+	// Index the added multihashes directly in the datastore like the rest of the multihash index,
+	// marking it incomplete until the advertisement is committed.
+	indexWriter, err := e.newMultihashIndexWriter(ctx, contextID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create multihash index writer: %s", err)
+	}
+	for _, mh := range added {
+		if err := putMultihashIndex(ctx, indexWriter, contextID, mh); err != nil {
+			return nil, fmt.Errorf("failed to index multihash: %w", err)
+		}
+	}
+	if err := indexWriter.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to write multihash index: %s", err)
+	}
+	c, err := getKeyCidMap(ctx, rw, contextID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get entries cid by context id: %s", err)
+	}
+	if err := putIndexedEntries(ctx, rw, contextID, c); err != nil {
+		return nil, fmt.Errorf("failed to write context id to indexed entries mapping: %s", err)
+	}
+
+	for _, mh := range added {
+		if err := rw.Put(ctx, datastore.NewKey(keyToAddedMhPrefix+encContextID+"/"+mh.B58String()), []byte{}); err != nil {
+			return nil, fmt.Errorf("failed to record added multihash: %w", err)
+		}
+	}
+	if err := putKeyMetadataMap(ctx, rw, contextID, &md); err != nil {
+		return nil, fmt.Errorf("failed to write context id to metadata mapping: %s", err)
+	}
+	return e.newSignedAdv(ctx, rw, contextID, lnk, md, false)
+}
+
+// getDeltaEntries returns the persisted delta entries chunk with the given CID, or nil if there is
+// no such chunk.
+func (e *Engine) getDeltaEntries(ctx context.Context, c cid.Cid) ([]byte, error) {
+	v, err := e.ds.Get(ctx, datastore.NewKey(deltaEntriesPrefix+c.String()))
+	if err == datastore.ErrNotFound {
+		return nil, nil
+	}
+	return v, err
+}
+
+// isDeltaEntries checks whether the given entries link is the root of persisted delta entries.
+func (e *Engine) isDeltaEntries(ctx context.Context, entries ipld.Link) (bool, error) {
+	v, err := e.getDeltaEntries(ctx, entries.(cidlink.Link).Cid)
+	return v != nil, err
+}
+
+// listAdvertisedMultihashes lists the multihashes of the given context ID via the registered
+// multihash lister, excluding the multihashes advertised as delta entries via Engine.NotifyUpdate.
+// The returned iterator therefore lists the multihashes from which the entries advertised via
+// Engine.NotifyPut are regenerated. The returned iterator must be closed via closeMhIterator.
+func (e *Engine) listAdvertisedMultihashes(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
+	prefix := keyToAddedMhPrefix + base64.RawURLEncoding.EncodeToString(contextID) + "/"
+	results, err := e.ds.Query(ctx, dsq.Query{Prefix: prefix, KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	added := make(map[string]struct{})
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		mh, err := multihash.FromB58String(datastore.NewKey(r.Key).BaseNamespace())
+		if err != nil {
+			return nil, err
+		}
+		added[string(mh)] = struct{}{}
+	}
+	mhIter, err := e.mhLister(ctx, contextID)
+	if err != nil {
+		return nil, err
+	}
+	if len(added) == 0 {
+		return mhIter, nil
+	}
+	return &excludingMultihashIterator{mhi: mhIter, exclude: added}, nil
+}
+
+// regenerateEntries regenerates the entries of the given context ID from its advertised
+// multihashes, in the format in which they were advertised, and returns the link to root. The
+// regenerated entries are expected to match the given advertised entries.
+//
+// The format of entries advertised by previous versions of the engine is not recorded, in which
+// case the entries are regenerated in each of the formats returned by
+// Engine.entriesFormatCandidates until they match, and the matching format is recorded. The link to
+// the entries regenerated in the last format is returned if none match.
+func (e *Engine) regenerateEntries(ctx context.Context, contextID []byte, advertised cid.Cid) (ipld.Link, error) {
+	format, err := getKeyFormatMap(ctx, e.ds, contextID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get entries format of context id: %w", err)
+	}
+	if format != "" {
+		return e.regenerateEntriesAs(ctx, contextID, format)
+	}
+
+	log := log.With("contextID", base64.StdEncoding.EncodeToString(contextID), "advertised", advertised)
+	formats, err := e.entriesFormatCandidates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var root ipld.Link
+	for _, format := range formats {
+		if root != nil {
+			e.releaseRegeneratedEntries(ctx, root.(cidlink.Link).Cid)
+		}
+		root, err = e.regenerateEntriesAs(ctx, contextID, format)
+		if err != nil {
+			return nil, err
+		}
+		if root.(cidlink.Link).Cid != advertised {
+			log.Debugw("Entries of unknown format do not match when regenerated in candidate format", "format", format)
+			continue
+		}
+		if format != "" {
+			// Record the format, unless the context ID has since been re-advertised.
+			if c, err := getKeyCidMap(ctx, e.ds, contextID); err == nil && c == advertised {
+				log.Infow("Recording entries format of context ID", "format", format)
+				if err := putKeyFormatMap(ctx, e.ds, contextID, format); err != nil {
+					log.Errorw("Failed to record entries format of context ID", "err", err)
+				}
+			}
+		}
+		break
+	}
+	return root, nil
+}
+
+// regenerateEntriesAs regenerates the entries of the given context ID from its advertised
+// multihashes in the given format, and returns the link to root.
+func (e *Engine) regenerateEntriesAs(ctx context.Context, contextID []byte, format string) (ipld.Link, error) {
+	mhIter, err := e.listAdvertisedMultihashes(ctx, contextID)
+	if err != nil {
+		return nil, err
+	}
+	defer closeMhIterator(mhIter)
+	return e.entriesChunker.ChunkAs(ctx, format, mhIter)
+}
+
+// entriesFormatCandidates returns the formats in which entries of unknown format may have been
+// generated, from the most likely: the formats used by the engine from the most recent, followed
+// by the sorted or unsorted counterparts of chain formats. The format of the entries chunker is
+// returned as empty string if unknown.
+func (e *Engine) entriesFormatCandidates(ctx context.Context) ([]string, error) {
+	used, err := getEntriesFormats(ctx, e.ds)
+	if err != nil {
+		return nil, err
+	}
+	formats := []string{e.entriesChunker.Format()}
+	seen := map[string]struct{}{formats[0]: {}}
+	add := func(format string) {
+		if _, ok := seen[format]; !ok && format != "" {
+			seen[format] = struct{}{}
+			formats = append(formats, format)
+		}
+	}
+	for _, format := range used {
+		add(format)
+	}
+	for _, format := range append([]string{}, formats...) {
+		add(chunker.ChainCounterpart(format))
+	}
+	return formats, nil
+}
+
+// excludingMultihashIterator skips the multihashes returned by the wrapped iterator that are
+// present in the exclude set.
+type excludingMultihashIterator struct {
+	mhi     provider.MultihashIterator
+	exclude map[string]struct{}
+}
+
+// Close closes the wrapped iterator; see closeMhIterator.
+func (i *excludingMultihashIterator) Close() error {
+	closeMhIterator(i.mhi)
+	return nil
+}
+
+func (i *excludingMultihashIterator) Next() (multihash.Multihash, error) {
+	for {
+		mh, err := i.mhi.Next()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := i.exclude[string(mh)]; !ok {
+			return mh, nil
+		}
+	}
+}
+
+// forEachIndexedMultihash calls f with every multihash in the multihash index of the given
+// context ID.
+func forEachIndexedMultihash(ctx context.Context, rw dsReadWriter, contextID []byte, f func(multihash.Multihash)) error {
+	prefix := keyToMhIndexPrefix + base64.RawURLEncoding.EncodeToString(contextID) + "/"
+	results, err := rw.Query(ctx, dsq.Query{Prefix: prefix, KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+	for r := range results.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		mh, err := multihash.FromB58String(datastore.NewKey(r.Key).BaseNamespace())
+		if err != nil {
+			return fmt.Errorf("could not decode indexed multihash: %w", err)
+		}
+		f(mh)
+	}
+	return nil
+}
+
+// deleteDeltaEntries deletes the delta entries and added multihashes of the given context ID, if
+// any. Delta entries chunks shared with other context IDs are retained.
+func deleteDeltaEntries(ctx context.Context, rw dsReadWriter, contextID []byte) error {
+	encContextID := base64.RawURLEncoding.EncodeToString(contextID)
+	for _, prefix := range []string{keyToDeltaPrefix, keyToAddedMhPrefix} {
+		results, err := rw.Query(ctx, dsq.Query{Prefix: prefix + encContextID + "/", KeysOnly: true})
+		if err != nil {
+			return err
+		}
+		entries, err := results.Rest()
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			key := datastore.NewKey(entry.Key)
+			if err := rw.Delete(ctx, key); err != nil {
+				return err
+			}
+			if prefix != keyToDeltaPrefix {
+				continue
+			}
+			c := key.BaseNamespace()
+			if err := rw.Delete(ctx, datastore.NewKey(deltaToKeyPrefix+c+"/"+encContextID)); err != nil {
+				return err
+			}
+			results, err := rw.Query(ctx, dsq.Query{Prefix: deltaToKeyPrefix + c + "/", KeysOnly: true, Limit: 1})
+			if err != nil {
+				return err
+			}
+			others, err := results.Rest()
+			if err != nil {
+				return err
+			}
+			if len(others) == 0 {
+				if err := rw.Delete(ctx, datastore.NewKey(deltaEntriesPrefix+c)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
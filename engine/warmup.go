@@ -0,0 +1,279 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	provider "github.com/filecoin-project/index-provider"
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
+	"github.com/ipfs/go-cid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// ErrWarmUpInProgress signals that the advertisement entries cache is already being warmed up.
+var ErrWarmUpInProgress = errors.New("entries cache warm-up is already in progress")
+
+// WarmUpProgress represents the progress of warming up the advertisement entries cache.
+// See: Engine.WarmUpEntriesCache.
+type WarmUpProgress struct {
+	// Running is whether the warm-up is in progress.
+	Running bool
+	// Total is the number of advertisements selected for warm-up. It is zero until the
+	// advertisement chain is walked to select them.
+	Total int
+	// Generated is the number of advertisements the entries of which were generated and cached.
+	Generated int
+	// Cached is the number of advertisements the entries of which were already cached.
+	Cached int
+	// Failed is the number of advertisements the entries of which could not be generated.
+	Failed int
+	// Err is the error that stopped the warm-up early, if any.
+	Err error
+	// StartedAt is the time at which the warm-up started.
+	StartedAt time.Time
+	// FinishedAt is the time at which the warm-up finished, or zero if it is running.
+	FinishedAt time.Time
+}
+
+// Done returns the number of selected advertisements that are processed.
+func (p WarmUpProgress) Done() int {
+	return p.Generated + p.Cached + p.Failed
+}
+
+// WarmUpEntriesCache starts generating the entries of published advertisements in the background
+// and storing them in the entries cache, so that indexers syncing the advertisements are not
+// held up by on-demand generation of entries after a restart or cache eviction.
+//
+// The advertisements are selected by walking the chain backwards from the latest advertisement,
+// until either the given number of advertisements with entries are selected, or the advertisement
+// with the given since CID is reached, excluding it. A zero ads and cid.Undef since select all
+// the advertisements in the chain. Removal advertisements are skipped, along with the
+// advertisements of context IDs that are no longer advertised. The entries of selected
+// advertisements are then generated from the oldest to the newest, so that the newest are the
+// least likely to be evicted from the cache.
+//
+// The concurrency specifies the number of advertisements processed at a time. Note that storing
+// entries in the cache is serialized by the cache; concurrency overlaps the listing of multihashes
+// across advertisements. See WithParallelChainedEntries to speed up the generation of entries
+// within a single advertisement.
+//
+// The warm-up is stopped when the engine is shut down. Only a single warm-up runs at a time;
+// ErrWarmUpInProgress is returned if one is already running. The progress of the warm-up is
+// available via Engine.EntriesCacheWarmUpProgress.
+//
+// The engine must be started, and a multihash lister must be registered prior to calling this
+// function.
+func (e *Engine) WarmUpEntriesCache(ads int, since cid.Cid, concurrency int) error {
+	if ads < 0 {
+		return fmt.Errorf("number of advertisements must not be negative; got: %d", ads)
+	}
+	if concurrency < 1 {
+		return fmt.Errorf("concurrency must be at least 1; got: %d", concurrency)
+	}
+	if e.mhLister == nil {
+		return provider.ErrNoMultihashLister
+	}
+
+	e.warmUpLk.Lock()
+	defer e.warmUpLk.Unlock()
+	if e.warmUp.Running {
+		return ErrWarmUpInProgress
+	}
+	if e.warmUpDone != nil {
+		// Wait for the previous warm-up goroutine to return.
+		<-e.warmUpDone
+	}
+	e.warmUp = WarmUpProgress{
+		Running:   true,
+		StartedAt: time.Now(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	e.warmUpCancel, e.warmUpDone = cancel, done
+	go func() {
+		defer close(done)
+		defer cancel()
+		e.warmUpEntriesCache(ctx, ads, since, concurrency)
+	}()
+	return nil
+}
+
+// EntriesCacheWarmUpProgress returns the progress of the current warm-up of the entries cache, or
+// the last one if no warm-up is running. The progress is zero-value if the cache has not been
+// warmed up.
+// See: Engine.WarmUpEntriesCache.
+func (e *Engine) EntriesCacheWarmUpProgress() WarmUpProgress {
+	e.warmUpLk.Lock()
+	defer e.warmUpLk.Unlock()
+	return e.warmUp
+}
+
+// stopWarmUp stops any warm-up in progress and waits for it to return.
+func (e *Engine) stopWarmUp() {
+	e.warmUpLk.Lock()
+	cancel, done := e.warmUpCancel, e.warmUpDone
+	e.warmUpLk.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+func (e *Engine) warmUpEntriesCache(ctx context.Context, ads int, since cid.Cid, concurrency int) {
+	log := log.With("ads", ads, "since", since, "concurrency", concurrency)
+	log.Info("Warming up entries cache")
+
+	err := e.warmUpAdvs(ctx, ads, since, concurrency)
+
+	e.warmUpLk.Lock()
+	e.warmUp.Running = false
+	e.warmUp.Err = err
+	e.warmUp.FinishedAt = time.Now()
+	p := e.warmUp
+	e.warmUpLk.Unlock()
+
+	if err != nil {
+		log.Errorw("Stopped warming up entries cache", "err", err, "done", p.Done(), "total", p.Total)
+		return
+	}
+	log.Infow("Finished warming up entries cache", "total", p.Total, "generated", p.Generated, "cached", p.Cached, "failed", p.Failed, "elapsed", p.FinishedAt.Sub(p.StartedAt))
+}
+
+func (e *Engine) warmUpAdvs(ctx context.Context, ads int, since cid.Cid, concurrency int) error {
+	selected, err := e.selectWarmUpAdvs(ctx, ads, since)
+	if err != nil {
+		return err
+	}
+	e.warmUpLk.Lock()
+	e.warmUp.Total = len(selected)
+	e.warmUpLk.Unlock()
+
+	advs := make(chan *schema.Advertisement)
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for ad := range advs {
+				e.warmUpAdv(ctx, ad)
+			}
+		}()
+	}
+	for _, ad := range selected {
+		select {
+		case advs <- ad:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(advs)
+	wg.Wait()
+	return ctx.Err()
+}
+
+// selectWarmUpAdvs selects the advertisements to warm up, ordered from the oldest to the newest.
+func (e *Engine) selectWarmUpAdvs(ctx context.Context, ads int, since cid.Cid) ([]*schema.Advertisement, error) {
+	head, err := e.getLatestAdCid(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get latest advertisement: %w", err)
+	}
+
+	// Track the context IDs that are removed, since their entries can no longer be generated,
+	// along with the entries already selected, since advertisements may share entries.
+	removed := make(map[string]struct{})
+	selectedEntries := make(map[string]struct{})
+	var selected []*schema.Advertisement
+	var foundSince bool
+	err = e.walkChain(ctx, head, func(c cid.Cid, ad *schema.Advertisement) bool {
+		if c == since {
+			foundSince = true
+			return false
+		}
+		if isAddrsAdv(ad) {
+			return true
+		}
+		if ad.IsRm {
+			removed[string(ad.ContextID)] = struct{}{}
+			return true
+		}
+		if _, ok := removed[string(ad.ContextID)]; ok || ad.Entries == schema.NoEntries {
+			return true
+		}
+		if _, ok := selectedEntries[ad.Entries.String()]; ok {
+			return true
+		}
+		selectedEntries[ad.Entries.String()] = struct{}{}
+		selected = append(selected, ad)
+		return ads == 0 || len(selected) < ads
+	})
+	if err != nil {
+		return nil, err
+	}
+	if since != cid.Undef && !foundSince && (ads == 0 || len(selected) < ads) {
+		return nil, fmt.Errorf("advertisement %s is not found in chain", since)
+	}
+
+	for i, j := 0, len(selected)-1; i < j; i, j = i+1, j-1 {
+		selected[i], selected[j] = selected[j], selected[i]
+	}
+	return selected, nil
+}
+
+// warmUpAdv generates and caches the entries of the given advertisement, unless already cached.
+func (e *Engine) warmUpAdv(ctx context.Context, ad *schema.Advertisement) {
+	log := log.With("entries", ad.Entries)
+	var cached bool
+	err := func() error {
+		// Delta entries are persisted, and need not be cached.
+		delta, err := e.isDeltaEntries(ctx, ad.Entries)
+		if err != nil {
+			return err
+		}
+		if delta {
+			cached = true
+			return nil
+		}
+		b, err := e.entriesChunker.GetRawCachedChunk(ctx, ad.Entries)
+		if err != nil {
+			return err
+		}
+		if b != nil {
+			cached = true
+			return nil
+		}
+		advertised := ad.Entries.(cidlink.Link).Cid
+		root, err := e.regenerateEntries(ctx, ad.ContextID, advertised)
+		if err != nil {
+			return err
+		}
+		if regenerated := root.(cidlink.Link).Cid; regenerated != advertised {
+			mismatch := &EntriesMismatchError{ContextID: ad.ContextID, Advertised: advertised, Regenerated: regenerated}
+			e.handleEntriesMismatch(ctx, mismatch)
+			return mismatch
+		}
+		return nil
+	}()
+	if ctx.Err() != nil {
+		// Do not count advertisements interrupted by the warm-up being stopped.
+		return
+	}
+
+	e.warmUpLk.Lock()
+	defer e.warmUpLk.Unlock()
+	switch {
+	case err != nil:
+		log.Warnw("Failed to warm up entries cache for advertisement", "err", err)
+		e.warmUp.Failed++
+	case cached:
+		log.Debug("Entries are already cached")
+		e.warmUp.Cached++
+	default:
+		log.Debug("Generated entries")
+		e.warmUp.Generated++
+	}
+}
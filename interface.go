@@ -68,6 +68,42 @@ type Interface interface {
 	// This function returns the ID of the advertisement published.
 	NotifyRemove(ctx context.Context, contextID []byte) (cid.Cid, error)
 
+	// NotifyUpdate signals the provider that the list of multihashes looked up
+	// by the given contextID has changed since it was last advertised.  The
+	// list is compared to the previously advertised multihashes, and only the
+	// changes are advertised: added multihashes are advertised as additional
+	// entries under the same contextID, whereas removed multihashes result in
+	// the removal of the contextID followed by the advertisement of all of its
+	// multihashes, since indexers only support removal by contextID.
+	//
+	// If the contextID has not been advertised it is advertised as if by
+	// NotifyPut.  If the multihashes are unchanged and so is the metadata,
+	// then ErrAlreadyAdvertised is returned.
+	//
+	// A MultihashLister must be registered prior to using this function.
+	// ErrNoMultihashLister is returned if no such lister is registered.
+	//
+	// This function returns the ID of the latest advertisement published.
+	NotifyUpdate(ctx context.Context, contextID []byte, md metadata.Metadata) (cid.Cid, error)
+
+	// NotifyBatch signals the provider of a batch of changes, each of which
+	// either puts or removes a context ID as described by NotifyPut and
+	// NotifyRemove respectively.  A linked advertisement is generated per
+	// notification in the given order, and the batch is appended to the chain
+	// of advertisements atomically.  Only the last advertisement in the batch
+	// is announced onto the gossip pubsub channel.
+	//
+	// A MultihashLister must be registered prior to using this function.
+	// ErrNoMultihashLister is returned if no such lister is registered.
+	//
+	// Put notifications that would otherwise result in ErrAlreadyAdvertised
+	// are skipped.  Any other error discards the entire batch.
+	//
+	// This function returns the ID of the advertisement published for each
+	// notification at the same index, or cid.Undef if the notification was
+	// skipped.
+	NotifyBatch(ctx context.Context, notifs []Notification) ([]cid.Cid, error)
+
 	// GetAdv gets the advertisement that corresponds to the given cid.
 	GetAdv(context.Context, cid.Cid) (*schema.Advertisement, error)
 
@@ -81,9 +117,27 @@ type Interface interface {
 	Shutdown() error
 }
 
+// Notification represents a change to the multihashes advertised by a provider
+// in the form of a put or removal of a context ID.
+//
+// See: Interface.NotifyBatch.
+type Notification struct {
+	// ContextID is the context ID to put or remove.
+	ContextID []byte
+	// Metadata is the metadata associated to ContextID.  It is ignored if
+	// IsRemove is set.
+	Metadata metadata.Metadata
+	// IsRemove signals that the multihashes associated to ContextID are no
+	// longer available.
+	IsRemove bool
+}
+
 // MultihashIterator iterates over a list of multihashes.
 //
-// See: CarMultihashIterator.
+// An iterator that holds resources, such as an open file, may also implement io.Closer, in which
+// case it is closed once no longer used, whether or not it is iterated to the end.
+//
+// See: CarMultihashIterator, CarDataMultihashIterator.
 type MultihashIterator interface {
 	// Next returns the next multihash in the list of mulitihashes.  The
 	// iterator fails fast: errors that occur during iteration are returned
@@ -0,0 +1,157 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	cborMajorBytes  = 2
+	cborMajorString = 3
+	cborMajorArray  = 4
+	cborMajorMap    = 5
+	cborMajorTag    = 6
+	cborMajorSimple = 7
+
+	cborInfoIndefinite = 31
+)
+
+// errCborItemTooLarge signals that a CBOR data item is larger than the maximum length read by
+// readCborItem, or declares a length that exceeds it.
+var errCborItemTooLarge = errors.New("cbor data item is too large")
+
+// readCborItem reads a single CBOR data item from r and returns its encoded bytes, without reading
+// any of the bytes that follow it. The lengths declared by the item are checked against maxLen
+// before the bytes they describe are read.
+//
+// CBOR decoders allocate the declared length of byte and text strings before reading them, which
+// allows a few bytes of input to demand a large allocation. Decoding the bytes returned by
+// readCborItem instead bounds the allocations to the length of the item.
+func readCborItem(r io.ByteReader, maxLen int) ([]byte, error) {
+	cr := &cborItemReader{r: r, maxLen: maxLen}
+	isBreak, err := cr.readItem()
+	if err != nil {
+		return nil, err
+	}
+	if isBreak {
+		return nil, errors.New("unexpected cbor break")
+	}
+	return cr.read, nil
+}
+
+type cborItemReader struct {
+	r      io.ByteReader
+	read   []byte
+	maxLen int
+}
+
+// remaining returns the number of bytes that can be read before exceeding maxLen.
+func (c *cborItemReader) remaining() uint64 {
+	return uint64(c.maxLen - len(c.read))
+}
+
+func (c *cborItemReader) readByte() (byte, error) {
+	if c.remaining() == 0 {
+		return 0, errCborItemTooLarge
+	}
+	b, err := c.r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	c.read = append(c.read, b)
+	return b, nil
+}
+
+// readHead reads the head of a data item, and returns its major type, additional information and
+// argument. The argument is zero if the additional information denotes indefinite length.
+func (c *cborItemReader) readHead() (major, info byte, arg uint64, err error) {
+	b, err := c.readByte()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = b>>5, b&0x1f
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info < 28:
+		for i := 0; i < 1<<(info-24); i++ {
+			b, err := c.readByte()
+			if err != nil {
+				return 0, 0, 0, err
+			}
+			arg = arg<<8 | uint64(b)
+		}
+	case info == cborInfoIndefinite:
+	default:
+		return 0, 0, 0, fmt.Errorf("invalid cbor additional information: %d", info)
+	}
+	return major, info, arg, nil
+}
+
+// readItem reads a single data item, and returns true if the item is a break that terminates an
+// indefinite length item.
+func (c *cborItemReader) readItem() (bool, error) {
+	major, info, arg, err := c.readHead()
+	if err != nil {
+		return false, err
+	}
+	if info == cborInfoIndefinite {
+		switch major {
+		case cborMajorBytes, cborMajorString, cborMajorArray, cborMajorMap:
+			for {
+				isBreak, err := c.readItem()
+				if err != nil {
+					return false, err
+				}
+				if isBreak {
+					return false, nil
+				}
+			}
+		case cborMajorSimple:
+			return true, nil
+		default:
+			return false, fmt.Errorf("invalid indefinite length for cbor major type: %d", major)
+		}
+	}
+
+	var items uint64
+	switch major {
+	case cborMajorBytes, cborMajorString:
+		if arg > c.remaining() {
+			return false, errCborItemTooLarge
+		}
+		for i := uint64(0); i < arg; i++ {
+			if _, err := c.readByte(); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	case cborMajorArray:
+		items = arg
+	case cborMajorMap:
+		if arg > c.remaining()/2 {
+			return false, errCborItemTooLarge
+		}
+		items = 2 * arg
+	case cborMajorTag:
+		items = 1
+	}
+	// Every item is at least one byte long.
+	if items > c.remaining() {
+		return false, errCborItemTooLarge
+	}
+	for i := uint64(0); i < items; i++ {
+		isBreak, err := c.readItem()
+		if err != nil {
+			return false, err
+		}
+		if isBreak {
+			return false, errors.New("unexpected cbor break")
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,121 @@
+package metadata_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/index-provider/metadata"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-varint"
+	"github.com/stretchr/testify/require"
+)
+
+// corpus returns the encoded metadata of past releases, keyed by path relative to testdata.
+func corpus(t testing.TB) map[string][]byte {
+	files, err := filepath.Glob(filepath.Join("testdata", "*", "*.bin"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	encoded := make(map[string][]byte, len(files))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		name, err := filepath.Rel("testdata", file)
+		require.NoError(t, err)
+		encoded[name] = data
+	}
+	return encoded
+}
+
+func TestMetadata_DecodesPastReleases(t *testing.T) {
+	for name, data := range corpus(t) {
+		data := data
+		t.Run(name, func(t *testing.T) {
+			wantJSON, err := ioutil.ReadFile(filepath.Join("testdata", strings.TrimSuffix(name, ".bin")+".json"))
+			require.NoError(t, err)
+
+			var md metadata.Metadata
+			require.NoError(t, md.UnmarshalBinary(data))
+			require.NoError(t, md.Validate())
+			gotJSON, err := json.Marshal(md)
+			require.NoError(t, err)
+			require.JSONEq(t, string(wantJSON), string(gotJSON))
+
+			// Assert that re-encoding produces the same bytes as the past release.
+			reencoded, err := md.MarshalBinary()
+			require.NoError(t, err)
+			require.Equal(t, data, reencoded)
+		})
+	}
+}
+
+func TestMetadata_UnmarshalBinaryMalformedIsError(t *testing.T) {
+	gsID := varint.ToUvarint(uint64(multicodec.TransportGraphsyncFilecoinv1))
+	tests := []struct {
+		name  string
+		given []byte
+	}{
+		{
+			name: "Empty",
+		},
+		{
+			name:  "Truncated varint",
+			given: []byte{0x80},
+		},
+		{
+			name:  "Non-minimal varint",
+			given: []byte{0x80, 0x92, 0x00},
+		},
+		{
+			name:  "Overflowing varint",
+			given: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+		},
+		{
+			name:  "Graphsync without payload",
+			given: gsID,
+		},
+		{
+			name:  "Graphsync with invalid CBOR",
+			given: append(gsID, 0xff, 0xff),
+		},
+		{
+			name:  "Graphsync with CBOR of wrong shape",
+			given: append(gsID, 0x83, 0x01, 0x02, 0x03),
+		},
+		{
+			name:  "Too large",
+			given: make([]byte, metadata.MaxLen+1),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var md metadata.Metadata
+			require.Error(t, md.UnmarshalBinary(test.given))
+		})
+	}
+}
+
+func TestMetadata_UnmarshalBinaryDoesNotPanic(t *testing.T) {
+	for name, data := range corpus(t) {
+		data := data
+		t.Run(name, func(t *testing.T) {
+			// Truncate the encoded metadata at every offset, and flip every bit of it.
+			for i := 0; i < len(data); i++ {
+				require.NotPanics(t, func() {
+					var md metadata.Metadata
+					_ = md.UnmarshalBinary(data[:i])
+				})
+				for bit := 0; bit < 8; bit++ {
+					mutated := append([]byte(nil), data...)
+					mutated[i] ^= 1 << bit
+					require.NotPanics(t, func() {
+						var md metadata.Metadata
+						_ = md.UnmarshalBinary(mutated)
+					})
+				}
+			}
+		})
+	}
+}
@@ -4,6 +4,10 @@
 // multihashes advertised by a provider. It is represented as an array of bytes in the indexer
 // protocol, starting with a varint ProtocolID that defines how to decode the remaining bytes.
 //
-// Two metadata types are currently represented here: Bitswap and
-// GraphsyncFilecoinV1.
+// Three metadata types are currently represented here: Bitswap, GraphsyncFilecoinV1 and
+// IpfsGatewayHttp. Applications can register additional types via Register. Any transport
+// with no registered type is decoded as Unknown, which preserves its raw bytes.
+//
+// Metadata also has a human-readable JSON representation, keyed by protocol name; see
+// Metadata.MarshalJSON.
 package metadata
@@ -0,0 +1,114 @@
+//go:build go1.18
+
+package metadata_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/filecoin-project/index-provider/metadata"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-varint"
+	"github.com/stretchr/testify/require"
+)
+
+func FuzzMetadata_UnmarshalBinary(f *testing.F) {
+	for _, data := range corpus(f) {
+		f.Add(data)
+	}
+	gatewayMetadata := metadata.New(&metadata.IpfsGatewayHttp{})
+	gateway, err := gatewayMetadata.MarshalBinary()
+	require.NoError(f, err)
+	f.Add(gateway)
+	f.Add(append(varint.ToUvarint(0x3f0001), []byte("fish")...))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var md metadata.Metadata
+		if err := md.UnmarshalBinary(data); err != nil {
+			return
+		}
+
+		// Assert that decoded metadata survives a binary round trip.
+		encoded, err := md.MarshalBinary()
+		require.NoError(t, err)
+		var decoded metadata.Metadata
+		require.NoError(t, decoded.UnmarshalBinary(encoded))
+		require.Equal(t, md, decoded)
+
+		// Assert that valid metadata survives a JSON round trip.
+		if md.Validate() != nil {
+			return
+		}
+		encoded, err = json.Marshal(md)
+		require.NoError(t, err)
+		decoded = metadata.Metadata{}
+		require.NoError(t, json.Unmarshal(encoded, &decoded))
+		require.Equal(t, md, decoded)
+	})
+}
+
+func FuzzMetadata_UnmarshalJSON(f *testing.F) {
+	f.Add(`{"bitswap":{}}`)
+	f.Add(`{"graphsync-filecoinv1":{"pieceCID":"baga6ea4seaqlwzed5tgjtyhrugjziutzthx2wrympvsuqhfngwdwqzvosuchmja","verifiedDeal":true}}`)
+	f.Add(`{"transport-ipfs-gateway-http":{},"0x3f0001":{"payload":"ZmlzaA=="}}`)
+
+	f.Fuzz(func(t *testing.T, data string) {
+		var md metadata.Metadata
+		if err := json.Unmarshal([]byte(data), &md); err != nil {
+			return
+		}
+
+		// Assert that metadata decoded from JSON is valid and survives a binary round trip.
+		require.NoError(t, md.Validate())
+		encoded, err := md.MarshalBinary()
+		require.NoError(t, err)
+		var decoded metadata.Metadata
+		require.NoError(t, decoded.UnmarshalBinary(encoded))
+		require.Equal(t, md, decoded)
+	})
+}
+
+func FuzzBitswap_UnmarshalBinary(f *testing.F) {
+	fuzzProtocol(f, func() metadata.Protocol { return &metadata.Bitswap{} })
+}
+
+func FuzzGraphsyncFilecoinV1_UnmarshalBinary(f *testing.F) {
+	fuzzProtocol(f, func() metadata.Protocol { return &metadata.GraphsyncFilecoinV1{} })
+}
+
+func FuzzIpfsGatewayHttp_UnmarshalBinary(f *testing.F) {
+	fuzzProtocol(f, func() metadata.Protocol { return &metadata.IpfsGatewayHttp{} })
+}
+
+func FuzzUnknown_UnmarshalBinary(f *testing.F) {
+	fuzzProtocol(f, func() metadata.Protocol { return &metadata.Unknown{} })
+}
+
+// fuzzProtocol fuzzes the binary decoding of the Protocol instantiated by the given function,
+// seeded by the protocols in the corpus of metadata from past releases. Decoded protocols must
+// survive a binary round trip, and decoding must never panic.
+func fuzzProtocol(f *testing.F, newProtocol func() metadata.Protocol) {
+	for _, data := range corpus(f) {
+		var md metadata.Metadata
+		require.NoError(f, md.UnmarshalBinary(data))
+		for _, id := range md.Protocols() {
+			encoded, err := md.Get(id).MarshalBinary()
+			require.NoError(f, err)
+			f.Add(encoded)
+		}
+	}
+	f.Add(varint.ToUvarint(uint64(multicodec.TransportBitswap)))
+	f.Add(varint.ToUvarint(uint64(metadata.TransportIpfsGatewayHttp)))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p := newProtocol()
+		if err := p.UnmarshalBinary(data); err != nil {
+			return
+		}
+		encoded, err := p.MarshalBinary()
+		require.NoError(t, err)
+		decoded := newProtocol()
+		require.NoError(t, decoded.UnmarshalBinary(encoded))
+		require.Equal(t, p, decoded)
+	})
+}
@@ -3,6 +3,8 @@ package metadata
 import (
 	"bytes"
 	_ "embed"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 
@@ -13,10 +15,12 @@ import (
 	"github.com/ipld/go-ipld-prime/schema"
 	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-varint"
+	"github.com/polydawn/refmt/cbor"
 )
 
 var (
-	_ Protocol = (*GraphsyncFilecoinV1)(nil)
+	_ Protocol          = (*GraphsyncFilecoinV1)(nil)
+	_ ProtocolValidator = (*GraphsyncFilecoinV1)(nil)
 
 	//go:embed graphsync_filecoinv1.ipldsch
 	schemaBytes                  []byte
@@ -46,6 +50,52 @@ func (dtm *GraphsyncFilecoinV1) ID() multicodec.Code {
 	return multicodec.TransportGraphsyncFilecoinv1
 }
 
+// graphsyncFilecoinV1JSON is the JSON representation of GraphsyncFilecoinV1.
+type graphsyncFilecoinV1JSON struct {
+	PieceCID      string `json:"pieceCID"`
+	VerifiedDeal  bool   `json:"verifiedDeal"`
+	FastRetrieval bool   `json:"fastRetrieval"`
+}
+
+// MarshalJSON implements json.Marshaler, representing the PieceCID as a string.
+func (dtm *GraphsyncFilecoinV1) MarshalJSON() ([]byte, error) {
+	v := graphsyncFilecoinV1JSON{
+		VerifiedDeal:  dtm.VerifiedDeal,
+		FastRetrieval: dtm.FastRetrieval,
+	}
+	if dtm.PieceCID.Defined() {
+		v.PieceCID = dtm.PieceCID.String()
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (dtm *GraphsyncFilecoinV1) UnmarshalJSON(data []byte) error {
+	var v graphsyncFilecoinV1JSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	pieceCID := cid.Undef
+	if v.PieceCID != "" {
+		var err error
+		if pieceCID, err = cid.Decode(v.PieceCID); err != nil {
+			return fmt.Errorf("invalid piece CID: %w", err)
+		}
+	}
+	dtm.PieceCID = pieceCID
+	dtm.VerifiedDeal = v.VerifiedDeal
+	dtm.FastRetrieval = v.FastRetrieval
+	return nil
+}
+
+// Validate checks that the PieceCID is defined.
+func (dtm *GraphsyncFilecoinV1) Validate() error {
+	if !dtm.PieceCID.Defined() {
+		return errors.New("piece CID must be defined")
+	}
+	return nil
+}
+
 // MarshalBinary implements encoding.BinaryMarshaler.
 func (dtm *GraphsyncFilecoinV1) MarshalBinary() ([]byte, error) {
 	buf := bytes.NewBuffer(varint.ToUvarint(uint64(dtm.ID())))
@@ -59,10 +109,18 @@ func (dtm *GraphsyncFilecoinV1) MarshalBinary() ([]byte, error) {
 // UnmarshalBinary implements encoding.BinaryUnmarshaler.
 func (dtm *GraphsyncFilecoinV1) UnmarshalBinary(data []byte) error {
 	r := bytes.NewReader(data)
-	_, err := dtm.ReadFrom(r)
-	return err
+	if _, err := dtm.ReadFrom(r); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return dagcbor.ErrTrailingBytes
+	}
+	return nil
 }
 
+// ReadFrom reads the transport ID followed by a single dag-cbor encoded GraphsyncFilecoinV1 from
+// r. Any bytes following the encoded GraphsyncFilecoinV1 are not read, so that transports that
+// follow it in metadata can be decoded.
 func (dtm *GraphsyncFilecoinV1) ReadFrom(r io.Reader) (n int64, err error) {
 	cr := &countingReader{r: r}
 	v, err := varint.ReadUvarint(cr)
@@ -74,8 +132,13 @@ func (dtm *GraphsyncFilecoinV1) ReadFrom(r io.Reader) (n int64, err error) {
 		return cr.readCount, fmt.Errorf("transport id does not match %s: %s", multicodec.TransportGraphsyncFilecoinv1, id)
 	}
 
+	// Metadata is at most MaxLen bytes long, and so is the encoded GraphsyncFilecoinV1 within it.
+	item, err := readCborItem(cr, MaxLen)
+	if err != nil {
+		return cr.readCount, err
+	}
 	nb := graphSyncFilecoinV1Prototype.NewBuilder()
-	err = dagcbor.Decode(nb, cr)
+	err = dagcbor.Unmarshal(nb, cbor.NewDecoder(cbor.DecodeOptions{CoerceUndefToNull: true}, bytes.NewReader(item)), dagcbor.DecodeOptions{AllowLinks: true})
 	if err != nil {
 		return cr.readCount, err
 	}
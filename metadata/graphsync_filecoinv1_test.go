@@ -2,6 +2,7 @@ package metadata_test
 
 import (
 	"math/rand"
+	"runtime"
 	"testing"
 
 	"github.com/filecoin-project/index-provider/metadata"
@@ -54,3 +55,39 @@ func TestGraphsyncFilecoinV1Metadata_FromIndexerMetadataErr(t *testing.T) {
 	err := dst.UnmarshalBinary(varint.ToUvarint(uint64(multicodec.TransportBitswap)))
 	require.Errorf(t, err, "invalid transport ID: transport-bitswap")
 }
+
+func TestGraphsyncFilecoinV1Metadata_RejectsOversizedLengths(t *testing.T) {
+	id := varint.ToUvarint(uint64(multicodec.TransportGraphsyncFilecoinv1))
+	tests := []struct {
+		name  string
+		given []byte
+	}{
+		{
+			name:  "byte string",
+			given: []byte{0x5a, 0x01, 0xff, 0xff, 0xff},
+		},
+		{
+			name:  "text string",
+			given: []byte{0x7a, 0x01, 0xff, 0xff, 0xff},
+		},
+		{
+			name:  "map",
+			given: []byte{0xbb, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		},
+		{
+			name:  "piece CID",
+			given: append([]byte{0xa1, 0x68}, append([]byte("PieceCID"), 0xd8, 0x2a, 0x5a, 0x01, 0xff, 0xff, 0xff)...),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Assert that decoding fails without allocating the declared length.
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			dst := &metadata.GraphsyncFilecoinV1{}
+			require.Error(t, dst.UnmarshalBinary(append(id, test.given...)))
+			runtime.ReadMemStats(&after)
+			require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package metadata
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-varint"
+)
+
+const (
+	// TransportIpfsGatewayHttp is the multicodec of retrieval over HTTP from a trustless IPFS
+	// gateway, i.e. an HTTP server that serves blocks and CAR files verifiable by the client.
+	//
+	// The code is defined here since it is not yet present in the multicodec table of
+	// go-multicodec.
+	TransportIpfsGatewayHttp multicodec.Code = 0x0920
+	// TransportIpfsGatewayHttpName is the multicodec name of TransportIpfsGatewayHttp.
+	TransportIpfsGatewayHttpName = "transport-ipfs-gateway-http"
+)
+
+var (
+	ipfsGatewayHttpBytes          = varint.ToUvarint(uint64(TransportIpfsGatewayHttp))
+	_                    Protocol = (*IpfsGatewayHttp)(nil)
+)
+
+// IpfsGatewayHttp represents the indexing metadata that uses TransportIpfsGatewayHttp. The
+// content is retrievable over HTTP from the addresses of the provider, and has no additional
+// metadata.
+type IpfsGatewayHttp struct {
+}
+
+func (g IpfsGatewayHttp) ID() multicodec.Code {
+	return TransportIpfsGatewayHttp
+}
+
+func (g IpfsGatewayHttp) MarshalBinary() ([]byte, error) {
+	return ipfsGatewayHttpBytes, nil
+}
+
+func (g IpfsGatewayHttp) UnmarshalBinary(data []byte) error {
+	if !bytes.Equal(data, ipfsGatewayHttpBytes) {
+		return fmt.Errorf("transport ID does not match %s", TransportIpfsGatewayHttpName)
+	}
+	return nil
+}
+
+func (g IpfsGatewayHttp) ReadFrom(r io.Reader) (n int64, err error) {
+	cr := &countingReader{r: r}
+	v, err := varint.ReadUvarint(cr)
+	if err != nil {
+		return cr.readCount, err
+	}
+	if id := multicodec.Code(v); id != TransportIpfsGatewayHttp {
+		return cr.readCount, fmt.Errorf("transport ID does not match %s", TransportIpfsGatewayHttpName)
+	}
+	return cr.readCount, nil
+}
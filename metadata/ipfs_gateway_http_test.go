@@ -0,0 +1,58 @@
+package metadata_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/filecoin-project/index-provider/metadata"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-varint"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewIpfsGatewayHttpTransport(t *testing.T) {
+	wantBytes := varint.ToUvarint(uint64(metadata.TransportIpfsGatewayHttp))
+
+	var subject metadata.IpfsGatewayHttp
+	require.Equal(t, metadata.TransportIpfsGatewayHttp, subject.ID())
+
+	gotBytes, err := subject.MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, wantBytes, gotBytes)
+
+	err = subject.UnmarshalBinary(gotBytes)
+	require.NoError(t, err)
+
+	// Assert that ReadFrom reads no further than the transport.
+	reader := bytes.NewReader(append(gotBytes, varint.ToUvarint(uint64(multicodec.TransportBitswap))...))
+	read, err := subject.ReadFrom(reader)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(wantBytes)), read)
+}
+
+func TestIpfsGatewayHttpTransport_UnmarshalBinaryIsErrorForMismatchingID(t *testing.T) {
+	tests := []struct {
+		name       string
+		givenBytes []byte
+	}{
+		{
+			name:       "Invalid Code",
+			givenBytes: varint.ToUvarint(uint64(multicodec.TransportBitswap)),
+		},
+		{
+			name:       "Invalid Unknwon Code",
+			givenBytes: varint.ToUvarint(uint64(789456)),
+		},
+		{
+			name:       "Trailing bytes",
+			givenBytes: append(varint.ToUvarint(uint64(metadata.TransportIpfsGatewayHttp)), 0x01),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var subject metadata.IpfsGatewayHttp
+			err := subject.UnmarshalBinary(test.givenBytes)
+			require.EqualError(t, err, "transport ID does not match transport-ipfs-gateway-http")
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-varint"
+)
+
+var (
+	_ json.Marshaler   = Metadata{}
+	_ json.Unmarshaler = (*Metadata)(nil)
+)
+
+// ProtocolName returns the multicodec name of the protocol with the given ID, including the
+// protocols that are not yet present in the multicodec table of go-multicodec.
+func ProtocolName(id multicodec.Code) string {
+	if id == TransportIpfsGatewayHttp {
+		return TransportIpfsGatewayHttpName
+	}
+	return id.String()
+}
+
+// ParseProtocol parses the ID of a protocol from its multicodec name or code. Any code that can be
+// encoded as varint is accepted, whereas names must either be present in the multicodec table of
+// go-multicodec or be the name of a protocol defined by this package.
+func ParseProtocol(name string) (multicodec.Code, error) {
+	if name == TransportIpfsGatewayHttpName {
+		return TransportIpfsGatewayHttp, nil
+	}
+	if n, err := strconv.ParseUint(name, 0, 64); err == nil {
+		if n > varint.MaxValueUvarint63 {
+			return 0, fmt.Errorf("protocol code is too large to encode as varint: %s", name)
+		}
+		return multicodec.Code(n), nil
+	}
+	var code multicodec.Code
+	if err := code.Set(name); err != nil {
+		return 0, err
+	}
+	return code, nil
+}
+
+// jsonKey returns the key of the protocol with the given ID in the JSON representation of
+// Metadata, which is its multicodec name without the "transport-" prefix, or its code in
+// hexadecimal if the name is not known.
+func jsonKey(id multicodec.Code) string {
+	name := ProtocolName(id)
+	if strings.HasPrefix(name, "Code(") {
+		return fmt.Sprintf("0x%x", uint64(id))
+	}
+	return strings.TrimPrefix(name, "transport-")
+}
+
+// parseJSONKey parses the ID of a protocol from its key in the JSON representation of Metadata.
+// Keys with the "transport-" prefix are also accepted.
+func parseJSONKey(key string) (multicodec.Code, error) {
+	if id, err := ParseProtocol("transport-" + key); err == nil {
+		return id, nil
+	}
+	return ParseProtocol(key)
+}
+
+// MarshalJSON implements json.Marshaler.
+//
+// Metadata is represented as a JSON object, with one field per protocol in order of protocol
+// ID. The field name is the multicodec name of the protocol without the "transport-" prefix, or
+// its code in hexadecimal if the name is not known. The field value is the JSON representation of
+// the protocol. For example:
+//
+//	{"bitswap":{},"graphsync-filecoinv1":{"pieceCID":"baga...","verifiedDeal":true,"fastRetrieval":true}}
+func (m Metadata) MarshalJSON() ([]byte, error) {
+	protocols := append([]Protocol(nil), m.protocols...)
+	sort.SliceStable(protocols, func(i, j int) bool { return protocols[i].ID() < protocols[j].ID() })
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, p := range protocols {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(jsonKey(p.ID()))
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(p)
+		if err != nil {
+			return nil, fmt.Errorf("cannot encode %s as JSON: %w", ProtocolName(p.ID()), err)
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See Metadata.MarshalJSON for the JSON
+// representation of Metadata.
+//
+// Protocols are decoded using the Protocol registered for their ID, or as Unknown if no protocol
+// is registered. See Register. The decoded Metadata is validated; see Metadata.Validate.
+func (m *Metadata) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('{') {
+		return fmt.Errorf("metadata must be a JSON object; got: %v", tok)
+	}
+	var protocols []Protocol
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		id, err := parseJSONKey(key)
+		if err != nil {
+			return fmt.Errorf("unknown protocol: %s", key)
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		p := newProtocol(id)
+		if err := json.Unmarshal(value, p); err != nil {
+			return fmt.Errorf("cannot decode %s from JSON: %w", ProtocolName(id), err)
+		}
+		if u, ok := p.(*Unknown); ok {
+			u.Code = id
+		}
+		protocols = append(protocols, p)
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	*m = New(protocols...)
+	return m.Validate()
+}
@@ -0,0 +1,154 @@
+package metadata_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/filecoin-project/index-provider/metadata"
+	"github.com/filecoin-project/index-provider/testutil"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata_JSON(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	pieceCID := testutil.RandomCids(t, rng, 1)[0]
+
+	tests := []struct {
+		name  string
+		given metadata.Metadata
+		want  string
+	}{
+		{
+			name:  "Bitswap",
+			given: metadata.New(&metadata.Bitswap{}),
+			want:  `{"bitswap":{}}`,
+		},
+		{
+			name: "Graphsync",
+			given: metadata.New(&metadata.GraphsyncFilecoinV1{
+				PieceCID:     pieceCID,
+				VerifiedDeal: true,
+			}),
+			want: `{"graphsync-filecoinv1":{"pieceCID":"` + pieceCID.String() + `","verifiedDeal":true,"fastRetrieval":false}}`,
+		},
+		{
+			name: "Mixed transports in order of ID",
+			given: metadata.New(
+				&metadata.IpfsGatewayHttp{},
+				&metadata.GraphsyncFilecoinV1{PieceCID: pieceCID, FastRetrieval: true},
+				&metadata.Bitswap{},
+			),
+			want: `{"bitswap":{},"graphsync-filecoinv1":{"pieceCID":"` + pieceCID.String() + `","verifiedDeal":false,"fastRetrieval":true},"ipfs-gateway-http":{}}`,
+		},
+		{
+			name:  "Unknown",
+			given: metadata.New(&metadata.Unknown{Code: 0x3f0001, Payload: []byte("fish")}),
+			want:  `{"0x3f0001":{"payload":"ZmlzaA=="}}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := json.Marshal(test.given)
+			require.NoError(t, err)
+			require.Equal(t, test.want, string(got))
+
+			var decoded metadata.Metadata
+			require.NoError(t, json.Unmarshal(got, &decoded))
+			require.Equal(t, test.given, decoded)
+		})
+	}
+}
+
+func TestMetadata_UnmarshalJSON(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	pieceCID := testutil.RandomCids(t, rng, 1)[0]
+
+	var md metadata.Metadata
+	err := json.Unmarshal([]byte(`{
+  "transport-ipfs-gateway-http": {},
+  "graphsync-filecoinv1": {"pieceCID": "`+pieceCID.String()+`", "verifiedDeal": true}
+}`), &md)
+	require.NoError(t, err)
+	require.Equal(t, metadata.New(
+		&metadata.GraphsyncFilecoinV1{PieceCID: pieceCID, VerifiedDeal: true},
+		&metadata.IpfsGatewayHttp{},
+	), md)
+	require.Equal(t, []multicodec.Code{multicodec.TransportGraphsyncFilecoinv1, metadata.TransportIpfsGatewayHttp}, md.Protocols())
+
+	// An empty payload of unknown protocol is decoded as nil, as it is from binary.
+	md = metadata.Metadata{}
+	require.NoError(t, json.Unmarshal([]byte(`{"0x3f0001":{"payload":""}}`), &md))
+	require.Equal(t, metadata.New(&metadata.Unknown{Code: 0x3f0001}), md)
+	encoded, err := md.MarshalBinary()
+	require.NoError(t, err)
+	var decoded metadata.Metadata
+	require.NoError(t, decoded.UnmarshalBinary(encoded))
+	require.Equal(t, md, decoded)
+
+	tests := []struct {
+		name        string
+		given       string
+		wantInvalid bool
+	}{
+		{
+			name:  "Not an object",
+			given: `["bitswap"]`,
+		},
+		{
+			name:  "Unknown protocol name",
+			given: `{"fish":{}}`,
+		},
+		{
+			name:  "Invalid piece CID",
+			given: `{"graphsync-filecoinv1":{"pieceCID":"fish"}}`,
+		},
+		{
+			name:        "Missing piece CID",
+			given:       `{"graphsync-filecoinv1":{"verifiedDeal":true}}`,
+			wantInvalid: true,
+		},
+		{
+			name:        "No protocols",
+			given:       `{}`,
+			wantInvalid: true,
+		},
+		{
+			name:        "Duplicate protocols",
+			given:       `{"bitswap":{},"transport-bitswap":{}}`,
+			wantInvalid: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var md metadata.Metadata
+			err := json.Unmarshal([]byte(test.given), &md)
+			require.Error(t, err)
+			var invalid metadata.ErrInvalidMetadata
+			require.Equal(t, test.wantInvalid, errors.As(err, &invalid))
+		})
+	}
+}
+
+func TestParseProtocol(t *testing.T) {
+	for _, id := range []multicodec.Code{
+		multicodec.TransportBitswap,
+		multicodec.TransportGraphsyncFilecoinv1,
+		metadata.TransportIpfsGatewayHttp,
+	} {
+		got, err := metadata.ParseProtocol(metadata.ProtocolName(id))
+		require.NoError(t, err)
+		require.Equal(t, id, got)
+	}
+
+	got, err := metadata.ParseProtocol("0x3f0001")
+	require.NoError(t, err)
+	require.Equal(t, multicodec.Code(0x3f0001), got)
+
+	_, err = metadata.ParseProtocol("fish")
+	require.Error(t, err)
+	_, err = metadata.ParseProtocol("0x8000000000000000")
+	require.Error(t, err)
+}
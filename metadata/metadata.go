@@ -8,11 +8,33 @@ import (
 	"io"
 	"sort"
 
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
 	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-varint"
 )
 
+// MaxLen is the maximum length of encoded metadata in bytes accepted by indexers.
+const MaxLen = schema.MaxMetadataLen
+
+// The reasons for which metadata is invalid, wrapped by ErrInvalidMetadata.
+var (
+	// ErrNoProtocols signals that metadata contains no protocols.
+	ErrNoProtocols = errors.New("at least one transport must be specified")
+	// ErrUnsortedProtocols signals that the protocols of metadata are not sorted by ID.
+	ErrUnsortedProtocols = errors.New("metadata transports must be sorted by ID")
+	// ErrDuplicateProtocol signals that metadata contains more than one protocol with the same ID.
+	ErrDuplicateProtocol = errors.New("metadata transports must be unique")
+	// ErrTooLarge signals that the encoded metadata is larger than MaxLen.
+	ErrTooLarge = fmt.Errorf("metadata must not be larger than %d bytes", MaxLen)
+	// ErrInvalidProtocol signals that a protocol of metadata is not valid. See ProtocolValidator.
+	ErrInvalidProtocol = errors.New("invalid metadata transport")
+)
+
+// ErrInvalidMetadata is the error returned when metadata is not valid. Its Reason is one of
+// ErrNoProtocols, ErrUnsortedProtocols, ErrDuplicateProtocol, ErrTooLarge or ErrInvalidProtocol,
+// and can be checked via errors.Is.
 type ErrInvalidMetadata struct {
+	Reason  error
 	Message string
 }
 
@@ -20,6 +42,19 @@ func (e ErrInvalidMetadata) Error() string {
 	return fmt.Sprintf("storetheindex: invalid metadata: %v", e.Message)
 }
 
+// Unwrap returns the reason for which metadata is invalid.
+func (e ErrInvalidMetadata) Unwrap() error {
+	return e.Reason
+}
+
+func invalidMetadata(reason error, format string, args ...interface{}) ErrInvalidMetadata {
+	msg := reason.Error()
+	if format != "" {
+		msg = fmt.Sprintf("%s: %s", msg, fmt.Sprintf(format, args...))
+	}
+	return ErrInvalidMetadata{Reason: reason, Message: msg}
+}
+
 var (
 	_ sort.Interface             = (*Metadata)(nil)
 	_ encoding.BinaryMarshaler   = (*Metadata)(nil)
@@ -42,6 +77,13 @@ type (
 		// ID is the multicodec of the transport protocol represented by this Protocol.
 		ID() multicodec.Code
 	}
+
+	// ProtocolValidator is optionally implemented by Protocol in order to check whether it is
+	// valid as part of Metadata.Validate.
+	ProtocolValidator interface {
+		// Validate returns an error if the protocol is not valid.
+		Validate() error
+	}
 )
 
 // New instantiates a new Metadata with the given transports.
@@ -65,21 +107,50 @@ func (m *Metadata) Swap(one, other int) {
 	m.protocols[one], m.protocols[other] = m.protocols[other], m.protocols[one]
 }
 
-// Validate checks whether this Metadata is valid.
+// Validate checks whether this Metadata is valid. Metadata is valid if it has at least one
+// protocol, its protocols are sorted by ID with no two protocols having the same ID, any Unknown
+// protocol is the last protocol, any protocol that implements ProtocolValidator is valid, and its
+// encoded length does not exceed MaxLen.
+//
+// The returned error is of type ErrInvalidMetadata.
 func (m *Metadata) Validate() error {
 	if len(m.protocols) == 0 {
-		return errors.New("at least one transport must be specified")
+		return invalidMetadata(ErrNoProtocols, "")
 	}
 
-	var lastID multicodec.Code
-	for _, transport := range m.protocols {
-		if lastID > transport.ID() {
-			return errors.New("metadata transports must be sorted by ID")
+	var size int
+	for i, transport := range m.protocols {
+		if transport == nil {
+			return invalidMetadata(ErrInvalidProtocol, "nil transport at index %d", i)
+		}
+		id := transport.ID()
+		if i > 0 {
+			lastID := m.protocols[i-1].ID()
+			if lastID > id {
+				return invalidMetadata(ErrUnsortedProtocols, "%s follows %s", id, lastID)
+			}
+			if lastID == id {
+				return invalidMetadata(ErrDuplicateProtocol, "%s", id)
+			}
+		}
+		if _, ok := transport.(*Unknown); ok && i < len(m.protocols)-1 {
+			// The payload of an unknown transport extends to the end of the encoded metadata.
+			return invalidMetadata(ErrInvalidProtocol, "unrecognized %s must be the last transport", id)
+		}
+		if v, ok := transport.(ProtocolValidator); ok {
+			if err := v.Validate(); err != nil {
+				return invalidMetadata(ErrInvalidProtocol, "%s: %v", id, err)
+			}
 		}
+		encoded, err := transport.MarshalBinary()
+		if err != nil {
+			return invalidMetadata(ErrInvalidProtocol, "%s: %v", id, err)
+		}
+		size += len(encoded)
+	}
+	if size > MaxLen {
+		return invalidMetadata(ErrTooLarge, "got %d bytes", size)
 	}
-
-	//TODO: Assert all IDs are known and transports are unique once we discuss what's techincally valid?
-	//      For example, is it valid to have repeated graphsync metadata with different values?
 	return nil
 }
 
@@ -102,6 +173,43 @@ func (m *Metadata) Protocols() []multicodec.Code {
 	return out
 }
 
+// With returns a copy of this Metadata with the given protocols added. Any existing protocol with
+// the same ID as one of the given protocols is replaced.
+func (m Metadata) With(p ...Protocol) Metadata {
+	protocols := make([]Protocol, 0, len(m.protocols)+len(p))
+	for _, existing := range m.protocols {
+		var replaced bool
+		for _, given := range p {
+			if existing.ID() == given.ID() {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			protocols = append(protocols, existing)
+		}
+	}
+	return New(append(protocols, p...)...)
+}
+
+// Without returns a copy of this Metadata without the protocols that have any of the given IDs.
+func (m Metadata) Without(ids ...multicodec.Code) Metadata {
+	protocols := make([]Protocol, 0, len(m.protocols))
+	for _, existing := range m.protocols {
+		var removed bool
+		for _, id := range ids {
+			if existing.ID() == id {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			protocols = append(protocols, existing)
+		}
+	}
+	return New(protocols...)
+}
+
 // MarshalBinary implements encoding.BinaryMarshaler.
 func (m *Metadata) MarshalBinary() ([]byte, error) {
 	sort.Sort(m)
@@ -119,19 +227,19 @@ func (m *Metadata) MarshalBinary() ([]byte, error) {
 }
 
 // UnmarshalBinary implements encoding.BinaryUnmarshaler.
+//
+// Transport protocols are decoded using the Protocol registered for their ID, or as Unknown if no
+// protocol is registered. See Register. The decoded Metadata is validated; see Metadata.Validate.
 func (m *Metadata) UnmarshalBinary(data []byte) error {
-	var read int64
-	for read < int64(len(data)) {
-		data = data[read:]
+	if len(data) > MaxLen {
+		return invalidMetadata(ErrTooLarge, "got %d bytes", len(data))
+	}
+	for len(data) > 0 {
 		v, _, err := varint.FromUvarint(data)
 		if err != nil {
 			return err
 		}
-		id := multicodec.Code(v)
-		t, err := newTransport(id)
-		if err != nil {
-			return err
-		}
+		t := newProtocol(multicodec.Code(v))
 
 		buf := bytes.NewBuffer(data)
 		tLen, err := t.ReadFrom(buf)
@@ -139,7 +247,7 @@ func (m *Metadata) UnmarshalBinary(data []byte) error {
 			return err
 		}
 		m.protocols = append(m.protocols, t)
-		read += tLen
+		data = data[tLen:]
 	}
 	return m.Validate()
 }
@@ -174,14 +282,3 @@ func protocolEqual(one, other Protocol) bool {
 	}
 	return bytes.Equal(oneBytes, otherBytes)
 }
-
-func newTransport(id multicodec.Code) (Protocol, error) {
-	switch id {
-	case multicodec.TransportBitswap:
-		return &Bitswap{}, nil
-	case multicodec.TransportGraphsyncFilecoinv1:
-		return &GraphsyncFilecoinV1{}, nil
-	default:
-		return nil, fmt.Errorf("unknwon transport id: %s", id.String())
-	}
-}
@@ -1,6 +1,10 @@
 package metadata_test
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
 	"math/rand"
 	"testing"
 
@@ -30,9 +34,21 @@ func TestMetadata(t *testing.T) {
 				&metadata.Bitswap{},
 			},
 		},
+		{
+			name: "Mixed transports",
+			givenTransports: []metadata.Protocol{
+				&metadata.IpfsGatewayHttp{},
+				&metadata.GraphsyncFilecoinV1{
+					PieceCID:      cids[1],
+					VerifiedDeal:  true,
+					FastRetrieval: true,
+				},
+				&metadata.Bitswap{},
+			},
+		},
 		{
 			name:            "No transports is invalid",
-			wantValidateErr: "at least one transport must be specified",
+			wantValidateErr: "storetheindex: invalid metadata: at least one transport must be specified",
 		},
 	}
 	for _, test := range tests {
@@ -82,12 +98,12 @@ func TestMetadata_UnmarshalBinary(t *testing.T) {
 	}{
 		{
 			name:    "Empty byetes is error",
-			wantErr: "at least one transport must be specified",
+			wantErr: "storetheindex: invalid metadata: at least one transport must be specified",
 		},
 		{
-			name:       "Unknown transport ID is error",
-			givenBytes: varint.ToUvarint(uint64(multicodec.Libp2pRelayRsvp)),
-			wantErr:    "unknwon transport id: libp2p-relay-rsvp",
+			name:         "Unknown transport ID is not error",
+			givenBytes:   varint.ToUvarint(uint64(multicodec.Libp2pRelayRsvp)),
+			wantMetadata: metadata.New(&metadata.Unknown{Code: multicodec.Libp2pRelayRsvp}),
 		},
 		{
 			name:         "Known transport ID is not error",
@@ -96,9 +112,20 @@ func TestMetadata_UnmarshalBinary(t *testing.T) {
 		},
 
 		{
-			name:       "Known transport ID mixed with unknown ID is not error",
+			name:       "Known transport ID following unknown ID is decoded as unknown",
 			givenBytes: append(varint.ToUvarint(uint64(123456)), varint.ToUvarint(uint64(multicodec.TransportBitswap))...),
-			wantErr:    "unknwon transport id: Code(123456)",
+			wantMetadata: metadata.New(&metadata.Unknown{
+				Code:    multicodec.Code(123456),
+				Payload: varint.ToUvarint(uint64(multicodec.TransportBitswap)),
+			}),
+		},
+		{
+			name:       "Known transport ID followed by unknown ID is not error",
+			givenBytes: append(varint.ToUvarint(uint64(multicodec.TransportBitswap)), 0xc0, 0xc4, 0x07, 0x01, 0x02),
+			wantMetadata: metadata.New(
+				&metadata.Bitswap{},
+				&metadata.Unknown{Code: multicodec.Code(123456), Payload: []byte{0x01, 0x02}},
+			),
 		},
 	}
 	for _, test := range tests {
@@ -115,3 +142,200 @@ func TestMetadata_UnmarshalBinary(t *testing.T) {
 		})
 	}
 }
+
+func TestMetadata_WithAndWithout(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	cids := testutil.RandomCids(t, rng, 2)
+	gs := &metadata.GraphsyncFilecoinV1{PieceCID: cids[0]}
+	anotherGs := &metadata.GraphsyncFilecoinV1{PieceCID: cids[1], FastRetrieval: true}
+
+	subject := metadata.New(gs)
+	withBitswap := subject.With(&metadata.Bitswap{})
+	require.Equal(t, metadata.New(&metadata.Bitswap{}, gs), withBitswap)
+	require.Equal(t, metadata.New(gs), subject, "original metadata must not be modified")
+
+	replaced := withBitswap.With(anotherGs)
+	require.Equal(t, metadata.New(&metadata.Bitswap{}, anotherGs), replaced)
+
+	require.Equal(t, metadata.New(anotherGs), replaced.Without(multicodec.TransportBitswap))
+	none := replaced.Without(multicodec.TransportBitswap, multicodec.TransportGraphsyncFilecoinv1)
+	require.Equal(t, 0, none.Len())
+	require.Equal(t, replaced, replaced.Without(multicodec.Identity))
+}
+
+func TestMetadata_Validate(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	cids := testutil.RandomCids(t, rng, 1)
+	graphsync := &metadata.GraphsyncFilecoinV1{PieceCID: cids[0]}
+	graphsyncBytes, err := graphsync.MarshalBinary()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		given      func() (metadata.Metadata, error)
+		wantReason error
+	}{
+		{
+			name: "No transports",
+			given: func() (metadata.Metadata, error) {
+				return metadata.New(), nil
+			},
+			wantReason: metadata.ErrNoProtocols,
+		},
+		{
+			name: "Unsorted transports",
+			given: func() (metadata.Metadata, error) {
+				var md metadata.Metadata
+				err := md.UnmarshalBinary(append(graphsyncBytes, varint.ToUvarint(uint64(multicodec.TransportBitswap))...))
+				return md, err
+			},
+			wantReason: metadata.ErrUnsortedProtocols,
+		},
+		{
+			name: "Duplicate transports",
+			given: func() (metadata.Metadata, error) {
+				return metadata.New(metadata.Bitswap{}, &metadata.Bitswap{}), nil
+			},
+			wantReason: metadata.ErrDuplicateProtocol,
+		},
+		{
+			name: "Graphsync with undefined piece CID",
+			given: func() (metadata.Metadata, error) {
+				return metadata.New(&metadata.GraphsyncFilecoinV1{VerifiedDeal: true}), nil
+			},
+			wantReason: metadata.ErrInvalidProtocol,
+		},
+		{
+			name: "Nil transport",
+			given: func() (metadata.Metadata, error) {
+				return metadata.New(nil), nil
+			},
+			wantReason: metadata.ErrInvalidProtocol,
+		},
+		{
+			name: "Unknown transport followed by another",
+			given: func() (metadata.Metadata, error) {
+				return metadata.New(&metadata.Unknown{Code: 0x01}, &metadata.Bitswap{}), nil
+			},
+			wantReason: metadata.ErrInvalidProtocol,
+		},
+		{
+			name: "Too large",
+			given: func() (metadata.Metadata, error) {
+				return metadata.New(&metadata.Unknown{Code: 0x3f0001, Payload: make([]byte, metadata.MaxLen)}), nil
+			},
+			wantReason: metadata.ErrTooLarge,
+		},
+		{
+			name: "Too large to decode",
+			given: func() (metadata.Metadata, error) {
+				var md metadata.Metadata
+				err := md.UnmarshalBinary(append(graphsyncBytes, make([]byte, metadata.MaxLen)...))
+				return md, err
+			},
+			wantReason: metadata.ErrTooLarge,
+		},
+		{
+			name: "Valid",
+			given: func() (metadata.Metadata, error) {
+				return metadata.New(graphsync, &metadata.Bitswap{}, &metadata.IpfsGatewayHttp{}), nil
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			md, err := test.given()
+			if err == nil {
+				err = md.Validate()
+			}
+			if test.wantReason == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, test.wantReason)
+			var invalid metadata.ErrInvalidMetadata
+			require.True(t, errors.As(err, &invalid))
+			require.Equal(t, test.wantReason, invalid.Reason)
+		})
+	}
+}
+
+func TestMetadata_UnknownRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	cids := testutil.RandomCids(t, rng, 1)
+	unknown := &metadata.Unknown{
+		Code:    multicodec.Code(0x3f0001),
+		Payload: []byte("fish"),
+	}
+	subject := metadata.New(
+		unknown,
+		&metadata.Bitswap{},
+		&metadata.GraphsyncFilecoinV1{PieceCID: cids[0]},
+		&metadata.IpfsGatewayHttp{},
+	)
+	wantBytes, err := subject.MarshalBinary()
+	require.NoError(t, err)
+
+	var decoded metadata.Metadata
+	require.NoError(t, decoded.UnmarshalBinary(wantBytes))
+	require.True(t, subject.Equal(decoded))
+	require.Equal(t, unknown, decoded.Get(unknown.Code))
+
+	gotBytes, err := decoded.MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, wantBytes, gotBytes)
+}
+
+func TestRegister(t *testing.T) {
+	const fishCode = multicodec.Code(0x3f0002)
+	require.Equal(t, []multicodec.Code{multicodec.TransportBitswap, multicodec.TransportGraphsyncFilecoinv1, metadata.TransportIpfsGatewayHttp}, metadata.Registered())
+	require.Error(t, metadata.Register(multicodec.TransportBitswap, func() metadata.Protocol { return &metadata.Bitswap{} }))
+	require.Error(t, metadata.Register(fishCode, nil))
+
+	mdBytes := append(varint.ToUvarint(uint64(fishCode)), []byte("lobster")...)
+	var unknown metadata.Metadata
+	require.NoError(t, unknown.UnmarshalBinary(mdBytes))
+	require.IsType(t, &metadata.Unknown{}, unknown.Get(fishCode))
+
+	require.NoError(t, metadata.Register(fishCode, func() metadata.Protocol { return &fish{} }))
+	defer metadata.Deregister(fishCode)
+	require.Contains(t, metadata.Registered(), fishCode)
+
+	var known metadata.Metadata
+	require.NoError(t, known.UnmarshalBinary(mdBytes))
+	require.Equal(t, &fish{Name: "lobster"}, known.Get(fishCode))
+	gotBytes, err := known.MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, mdBytes, gotBytes)
+}
+
+// fish is a test Protocol that encodes a name following its ID.
+type fish struct {
+	Name string
+}
+
+func (f *fish) ID() multicodec.Code {
+	return multicodec.Code(0x3f0002)
+}
+
+func (f *fish) MarshalBinary() ([]byte, error) {
+	return append(varint.ToUvarint(uint64(f.ID())), f.Name...), nil
+}
+
+func (f *fish) UnmarshalBinary(data []byte) error {
+	_, err := f.ReadFrom(bytes.NewReader(data))
+	return err
+}
+
+func (f *fish) ReadFrom(r io.Reader) (int64, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return int64(len(data)), err
+	}
+	_, n, err := varint.FromUvarint(data)
+	if err != nil {
+		return int64(len(data)), err
+	}
+	f.Name = string(data[n:])
+	return int64(len(data)), nil
+}
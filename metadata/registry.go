@@ -0,0 +1,73 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/multiformats/go-multicodec"
+)
+
+// ProtocolFactory instantiates a new Protocol into which a transport protocol is decoded.
+type ProtocolFactory func() Protocol
+
+var (
+	registryLk sync.RWMutex
+	registry   = map[multicodec.Code]ProtocolFactory{
+		multicodec.TransportBitswap:             func() Protocol { return &Bitswap{} },
+		multicodec.TransportGraphsyncFilecoinv1: func() Protocol { return &GraphsyncFilecoinV1{} },
+		TransportIpfsGatewayHttp:                func() Protocol { return &IpfsGatewayHttp{} },
+	}
+)
+
+// Register registers the factory of the Protocol that decodes the transport protocol with the
+// given ID, such that Metadata.UnmarshalBinary decodes it using the Protocol instantiated by the
+// factory instead of Unknown.
+//
+// Bitswap, GraphsyncFilecoinV1 and IpfsGatewayHttp are registered by default. An error is returned
+// if a factory is already registered for the given ID. See Deregister.
+func Register(id multicodec.Code, factory ProtocolFactory) error {
+	if factory == nil {
+		return errors.New("protocol factory must not be nil")
+	}
+	registryLk.Lock()
+	defer registryLk.Unlock()
+	if _, exists := registry[id]; exists {
+		return fmt.Errorf("protocol already registered: %s", id)
+	}
+	registry[id] = factory
+	return nil
+}
+
+// Deregister removes the factory registered for the given ID, if any, such that the transport
+// protocol with the given ID is decoded as Unknown.
+func Deregister(id multicodec.Code) {
+	registryLk.Lock()
+	defer registryLk.Unlock()
+	delete(registry, id)
+}
+
+// Registered returns the IDs of registered protocols, sorted in ascending order.
+func Registered() []multicodec.Code {
+	registryLk.RLock()
+	defer registryLk.RUnlock()
+	ids := make([]multicodec.Code, 0, len(registry))
+	for id := range registry {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// newProtocol instantiates the Protocol registered for the given ID, or Unknown if no protocol is
+// registered.
+func newProtocol(id multicodec.Code) Protocol {
+	registryLk.RLock()
+	factory, ok := registry[id]
+	registryLk.RUnlock()
+	if !ok {
+		return &Unknown{}
+	}
+	return factory()
+}
@@ -0,0 +1,81 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-varint"
+)
+
+var _ Protocol = (*Unknown)(nil)
+
+// Unknown represents a transport protocol for which no Protocol is registered. It preserves the
+// raw bytes of the transport so that metadata containing it is re-encoded as it was decoded.
+//
+// Because the length of an unrecognized transport cannot be determined, Unknown consumes all the
+// remaining bytes of metadata when decoded, including any transports that follow it. For the same
+// reason, metadata is only valid if Unknown is its last transport.
+//
+// See: Register.
+type Unknown struct {
+	// Code is the ID of the transport protocol. It is omitted from the JSON representation of
+	// Unknown, since it is the key of the protocol in the JSON representation of Metadata.
+	Code multicodec.Code `json:"-"`
+	// Payload is the raw bytes of the transport protocol that follow its ID, represented as base64
+	// in JSON.
+	Payload []byte `json:"payload,omitempty"`
+}
+
+// ID returns the ID of the unrecognized transport protocol.
+func (u *Unknown) ID() multicodec.Code {
+	return u.Code
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (u *Unknown) MarshalBinary() ([]byte, error) {
+	return append(varint.ToUvarint(uint64(u.Code)), u.Payload...), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (u *Unknown) UnmarshalBinary(data []byte) error {
+	_, err := u.ReadFrom(bytes.NewReader(data))
+	return err
+}
+
+// ReadFrom reads the ID of the transport protocol followed by all the remaining bytes of r as its
+// payload.
+func (u *Unknown) ReadFrom(r io.Reader) (n int64, err error) {
+	cr := &countingReader{r: r}
+	v, err := varint.ReadUvarint(cr)
+	if err != nil {
+		return cr.readCount, err
+	}
+	payload, err := ioutil.ReadAll(cr)
+	if err != nil {
+		return cr.readCount, err
+	}
+	u.Code = multicodec.Code(v)
+	u.Payload = nil
+	if len(payload) != 0 {
+		u.Payload = payload
+	}
+	return cr.readCount, nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Like ReadFrom, an empty payload is decoded as nil so
+// that Unknown decoded from JSON is equal to Unknown decoded from its binary representation.
+func (u *Unknown) UnmarshalJSON(data []byte) error {
+	type unknown Unknown
+	var v unknown
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if len(v.Payload) == 0 {
+		v.Payload = nil
+	}
+	u.Payload = v.Payload
+	return nil
+}
@@ -70,6 +70,21 @@ func (mr *MockInterfaceMockRecorder) GetLatestAdv(arg0 interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLatestAdv", reflect.TypeOf((*MockInterface)(nil).GetLatestAdv), arg0)
 }
 
+// NotifyBatch mocks base method.
+func (m *MockInterface) NotifyBatch(ctx context.Context, notifs []provider.Notification) ([]cid.Cid, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NotifyBatch", ctx, notifs)
+	ret0, _ := ret[0].([]cid.Cid)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NotifyBatch indicates an expected call of NotifyBatch.
+func (mr *MockInterfaceMockRecorder) NotifyBatch(ctx, notifs interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NotifyBatch", reflect.TypeOf((*MockInterface)(nil).NotifyBatch), ctx, notifs)
+}
+
 // NotifyPut mocks base method.
 func (m *MockInterface) NotifyPut(ctx context.Context, contextID []byte, md metadata.Metadata) (cid.Cid, error) {
 	m.ctrl.T.Helper()
@@ -100,6 +115,21 @@ func (mr *MockInterfaceMockRecorder) NotifyRemove(ctx, contextID interface{}) *g
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NotifyRemove", reflect.TypeOf((*MockInterface)(nil).NotifyRemove), ctx, contextID)
 }
 
+// NotifyUpdate mocks base method.
+func (m *MockInterface) NotifyUpdate(ctx context.Context, contextID []byte, md metadata.Metadata) (cid.Cid, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NotifyUpdate", ctx, contextID, md)
+	ret0, _ := ret[0].(cid.Cid)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NotifyUpdate indicates an expected call of NotifyUpdate.
+func (mr *MockInterfaceMockRecorder) NotifyUpdate(ctx, contextID, md interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NotifyUpdate", reflect.TypeOf((*MockInterface)(nil).NotifyUpdate), ctx, contextID, md)
+}
+
 // Publish mocks base method.
 func (m *MockInterface) Publish(arg0 context.Context, arg1 schema.Advertisement) (cid.Cid, error) {
 	m.ctrl.T.Helper()
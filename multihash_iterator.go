@@ -32,6 +32,9 @@ type iteratorStep struct {
 // corresponding CAR offset. The order is maintained consistently regardless of
 // the underlying IterableIndex implementation. Returns error if duplicate
 // offsets detected.
+//
+// Note that this iterator holds every multihash in the index in memory. For large CARs use
+// CarDataMultihashIterator instead, which streams multihashes in the same order.
 func CarMultihashIterator(idx carindex.IterableIndex) (MultihashIterator, error) {
 	var steps []iteratorStep
 	if err := idx.ForEach(func(mh multihash.Multihash, offset uint64) error {
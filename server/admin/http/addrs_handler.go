@@ -0,0 +1,63 @@
+package adminserver
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func (s *Server) listRetrievalAddrsHandler(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, &RetrievalAddrsRes{
+		Addrs: addrsAsString(s.e.RetrievalAddrs()),
+		AdvId: cid.Undef,
+	})
+}
+
+func (s *Server) setRetrievalAddrsHandler(w http.ResponseWriter, r *http.Request) {
+	// Decode request
+	var req SetRetrievalAddrsReq
+	if _, err := req.ReadFrom(r.Body); err != nil {
+		msg := fmt.Sprintf("failed to unmarshal request: %v", err)
+		log.Errorw(msg, "err", err)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	addrs := make([]multiaddr.Multiaddr, 0, len(req.Addrs))
+	for _, addrStr := range req.Addrs {
+		addr, err := multiaddr.NewMultiaddr(addrStr)
+		if err != nil {
+			msg := fmt.Sprintf("failed to decode retrieval address %q: %v", addrStr, err)
+			log.Errorw(msg, "err", err)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		addrs = append(addrs, addr)
+	}
+
+	advID, err := s.e.SetRetrievalAddrs(r.Context(), addrs...)
+	if err != nil {
+		msg := fmt.Sprintf("failed to set retrieval addresses: %v", err)
+		log.Errorw(msg, "err", err)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+
+	// Respond success case.
+	current := s.e.RetrievalAddrs()
+	log.Infow("Set retrieval addresses successfully", "retrievalAddrs", current, "advId", advID)
+	respond(w, http.StatusOK, &RetrievalAddrsRes{
+		Addrs: addrsAsString(current),
+		AdvId: advID,
+	})
+}
+
+func addrsAsString(addrs []multiaddr.Multiaddr) []string {
+	addrStrs := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		addrStrs = append(addrStrs, addr.String())
+	}
+	return addrStrs
+}
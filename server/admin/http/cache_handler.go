@@ -0,0 +1,76 @@
+package adminserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/filecoin-project/index-provider/engine"
+)
+
+func (s *Server) cacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats := s.e.EntriesCacheStats()
+	respond(w, http.StatusOK, &CacheStatsRes{
+		Len:        stats.Len,
+		Cap:        stats.Cap,
+		Bytes:      stats.Bytes,
+		MaxBytes:   stats.MaxBytes,
+		Hits:       stats.Hits,
+		Misses:     stats.Misses,
+		HitRate:    stats.HitRate(),
+		Mismatches: stats.Mismatches,
+	})
+}
+
+func (s *Server) warmUpCacheHandler(w http.ResponseWriter, r *http.Request) {
+	// Decode request
+	var req WarmUpCacheReq
+	if _, err := req.ReadFrom(r.Body); err != nil {
+		msg := fmt.Sprintf("failed to unmarshal request: %v", err)
+		log.Errorw(msg, "err", err)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if req.Ads < 0 || req.Concurrency < 0 {
+		http.Error(w, "ads and concurrency must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.Concurrency == 0 {
+		req.Concurrency = 1
+	}
+
+	if err := s.e.WarmUpEntriesCache(req.Ads, req.Since, req.Concurrency); err != nil {
+		var errCode int
+		if errors.Is(err, engine.ErrWarmUpInProgress) {
+			errCode = http.StatusConflict
+		} else {
+			errCode = http.StatusInternalServerError
+		}
+		msg := fmt.Sprintf("failed to warm up entries cache: %v", err)
+		log.Errorw(msg, "err", err)
+		http.Error(w, msg, errCode)
+		return
+	}
+	log.Infow("Started warming up entries cache", "ads", req.Ads, "since", req.Since, "concurrency", req.Concurrency)
+	respond(w, http.StatusOK, newWarmUpCacheRes(s.e.EntriesCacheWarmUpProgress()))
+}
+
+func (s *Server) warmUpCacheStatusHandler(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, newWarmUpCacheRes(s.e.EntriesCacheWarmUpProgress()))
+}
+
+func newWarmUpCacheRes(p engine.WarmUpProgress) *WarmUpCacheRes {
+	res := &WarmUpCacheRes{
+		Running:    p.Running,
+		Total:      p.Total,
+		Generated:  p.Generated,
+		Cached:     p.Cached,
+		Failed:     p.Failed,
+		StartedAt:  p.StartedAt,
+		FinishedAt: p.FinishedAt,
+	}
+	if p.Err != nil {
+		res.Error = p.Err.Error()
+	}
+	return res
+}
@@ -3,6 +3,7 @@ package adminserver
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -34,7 +35,15 @@ func (h *carHandler) handleImport(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
 
 	var md metadata.Metadata
-	if err := md.UnmarshalBinary(req.Metadata); err != nil {
+	if req.MetadataJSON != nil {
+		if len(req.Metadata) != 0 {
+			msg := "only one of metadata or metadata_json may be set"
+			log.Error(msg)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		md = *req.MetadataJSON
+	} else if err := md.UnmarshalBinary(req.Metadata); err != nil {
 		msg := fmt.Sprintf("failed to unmarshal metadata: %v", err)
 		log.Errorw(msg, "err", err)
 		http.Error(w, msg, http.StatusBadRequest)
@@ -52,9 +61,14 @@ func (h *carHandler) handleImport(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, msg, http.StatusConflict)
 			return
 		}
+		errCode := http.StatusInternalServerError
+		var invalid metadata.ErrInvalidMetadata
+		if errors.As(err, &invalid) {
+			errCode = http.StatusBadRequest
+		}
 		msg := fmt.Sprintf("failed to import CAR: %v", err)
 		log.Errorw(msg, "err", err, "path", req.Path)
-		http.Error(w, msg, http.StatusInternalServerError)
+		http.Error(w, msg, errCode)
 		return
 	}
 
@@ -170,6 +170,122 @@ func Test_importCarAlreadyAdvertised(t *testing.T) {
 	require.Equal(t, "CAR already advertised\n", string(respBytes))
 }
 
+func Test_importCarHandler_InvalidMetadataIsBadRequest(t *testing.T) {
+	wantKey := []byte("lobster")
+	wantTp, err := cardatatransfer.TransportFromContextID(wantKey)
+	require.NoError(t, err)
+	wantMetadata := metadata.New(wantTp)
+	mdBytes, err := wantMetadata.MarshalBinary()
+	require.NoError(t, err)
+	icReq := &ImportCarReq{
+		Path:     "fish",
+		Key:      wantKey,
+		Metadata: mdBytes,
+	}
+	jsonReq, err := json.Marshal(icReq)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, "/admin/import/car", bytes.NewReader(jsonReq))
+	require.NoError(t, err)
+
+	mc := gomock.NewController(t)
+	mockEng := mock_provider.NewMockInterface(mc)
+	mockEng.EXPECT().RegisterMultihashLister(gomock.Any())
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	cs := supplier.NewCarSupplier(mockEng, ds)
+
+	subject := carHandler{cs}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(subject.handleImport)
+
+	mockEng.
+		EXPECT().
+		NotifyPut(gomock.Any(), gomock.Eq(wantKey), gomock.Eq(wantMetadata)).
+		Return(cid.Undef, metadata.ErrInvalidMetadata{Reason: metadata.ErrTooLarge, Message: "fish"})
+
+	handler.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// Assert that metadata that is invalid prior to import is also a bad request.
+	icReq.Metadata = append(mdBytes, mdBytes...)
+	jsonReq, err = json.Marshal(icReq)
+	require.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, "/admin/import/car", bytes.NewReader(jsonReq))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	respBytes, err := ioutil.ReadAll(rr.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(respBytes), "metadata transports must be unique")
+}
+
+func Test_importCarHandler_JSONMetadata(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	wantKey := []byte("lobster")
+	wantTp, err := cardatatransfer.TransportFromContextID(wantKey)
+	require.NoError(t, err)
+	wantMetadata := metadata.New(&metadata.Bitswap{}, wantTp)
+
+	icReq := &ImportCarReq{
+		Path:         "fish",
+		Key:          wantKey,
+		MetadataJSON: &wantMetadata,
+	}
+	jsonReq, err := json.Marshal(icReq)
+	require.NoError(t, err)
+	require.Contains(t, string(jsonReq), `"metadata_json":{"bitswap":{},"graphsync-filecoinv1":{"pieceCID":`)
+
+	req, err := http.NewRequest(http.MethodPost, "/admin/import/car", bytes.NewReader(jsonReq))
+	require.NoError(t, err)
+
+	mc := gomock.NewController(t)
+	mockEng := mock_provider.NewMockInterface(mc)
+	mockEng.EXPECT().RegisterMultihashLister(gomock.Any())
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	cs := supplier.NewCarSupplier(mockEng, ds)
+
+	subject := carHandler{cs}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(subject.handleImport)
+	wantCid := testutil.RandomCids(t, rng, 1)[0]
+
+	mockEng.
+		EXPECT().
+		NotifyPut(gomock.Any(), gomock.Eq(wantKey), gomock.Eq(wantMetadata)).
+		Return(wantCid, nil)
+
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var resp ImportCarRes
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	require.Equal(t, wantKey, resp.Key)
+	require.Equal(t, wantCid, resp.AdvId)
+
+	// Assert that setting both binary and JSON metadata is a bad request.
+	icReq.Metadata, err = wantMetadata.MarshalBinary()
+	require.NoError(t, err)
+	jsonReq, err = json.Marshal(icReq)
+	require.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, "/admin/import/car", bytes.NewReader(jsonReq))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// Assert that invalid JSON metadata is a bad request.
+	req, err = http.NewRequest(http.MethodPost, "/admin/import/car",
+		bytes.NewReader([]byte(`{"path":"fish","metadata_json":{"graphsync-filecoinv1":{"pieceCID":"fish"}}}`)))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
 func Test_removeCarHandler(t *testing.T) {
 	rng := rand.New(rand.NewSource(1413))
 	wantKey := []byte("lobster")
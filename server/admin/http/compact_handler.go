@@ -0,0 +1,45 @@
+package adminserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/filecoin-project/index-provider/engine"
+)
+
+func (s *Server) compactHandler(w http.ResponseWriter, r *http.Request) {
+	// Decode request
+	var req CompactReq
+	if _, err := req.ReadFrom(r.Body); err != nil {
+		msg := fmt.Sprintf("failed to unmarshal request: %v", err)
+		log.Errorw(msg, "err", err)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	report, err := s.e.Compact(r.Context(), req.DryRun)
+	if err != nil {
+		var errCode int
+		if errors.Is(err, engine.ErrNoLiveContextIDs) {
+			errCode = http.StatusBadRequest
+		} else {
+			errCode = http.StatusInternalServerError
+		}
+		msg := fmt.Sprintf("failed to compact advertisement chain: %v", err)
+		log.Errorw(msg, "err", err)
+		http.Error(w, msg, errCode)
+		return
+	}
+
+	// Respond success case.
+	log.Infow("Compacted advertisement chain successfully", "dryRun", report.DryRun, "head", report.Head)
+	resp := &CompactRes{
+		DryRun:         report.DryRun,
+		ChainLength:    report.ChainLength,
+		LiveContextIDs: report.LiveContextIDs,
+		PreviousAdvId:  report.PreviousHead,
+		AdvId:          report.Head,
+	}
+	respond(w, http.StatusOK, resp)
+}
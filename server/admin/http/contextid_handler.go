@@ -0,0 +1,99 @@
+package adminserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/filecoin-project/index-provider/engine"
+	"github.com/gorilla/mux"
+	"github.com/multiformats/go-multihash"
+)
+
+func (s *Server) listContextIDsHandler(w http.ResponseWriter, r *http.Request) {
+	infos, err := s.e.ListContextIDs(r.Context())
+	if err != nil {
+		msg := fmt.Sprintf("failed to list context IDs: %v", err)
+		log.Errorw(msg, "err", err)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := toContextIDInfos(infos)
+	if err != nil {
+		log.Errorw("failed to encode context IDs", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respond(w, http.StatusOK, &ListContextIDsRes{ContextIDs: resp})
+}
+
+func (s *Server) lookupMultihashHandler(w http.ResponseWriter, r *http.Request) {
+	mh, err := multihash.FromB58String(mux.Vars(r)["multihash"])
+	if err != nil {
+		msg := fmt.Sprintf("failed to decode multihash: %v", err)
+		log.Errorw(msg, "err", err)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	infos, err := s.e.LookupMultihash(r.Context(), mh)
+	if err != nil {
+		var errCode int
+		if errors.Is(err, engine.ErrNoMultihashIndex) {
+			errCode = http.StatusNotImplemented
+		} else {
+			errCode = http.StatusInternalServerError
+		}
+		msg := fmt.Sprintf("failed to look up multihash: %v", err)
+		log.Errorw(msg, "err", err)
+		http.Error(w, msg, errCode)
+		return
+	}
+
+	resp, err := toContextIDInfos(infos)
+	if err != nil {
+		log.Errorw("failed to encode context IDs", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respond(w, http.StatusOK, &LookupMultihashRes{ContextIDs: resp})
+}
+
+func (s *Server) listCorruptedContextIDsHandler(w http.ResponseWriter, r *http.Request) {
+	corrupted, err := s.e.ListCorruptedContextIDs(r.Context())
+	if err != nil {
+		msg := fmt.Sprintf("failed to list corrupted context IDs: %v", err)
+		log.Errorw(msg, "err", err)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]CorruptedContextID, 0, len(corrupted))
+	for _, cc := range corrupted {
+		resp = append(resp, CorruptedContextID{
+			ContextID:          cc.ContextID,
+			AdvertisedEntries:  cc.AdvertisedEntries,
+			RegeneratedEntries: cc.RegeneratedEntries,
+			DetectedAt:         cc.DetectedAt,
+			RemovalAdvId:       cc.RemovalAdvId,
+		})
+	}
+	respond(w, http.StatusOK, &ListCorruptedContextIDsRes{ContextIDs: resp})
+}
+
+func toContextIDInfos(infos []engine.ContextIDInfo) ([]ContextIDInfo, error) {
+	res := make([]ContextIDInfo, 0, len(infos))
+	for _, info := range infos {
+		mdBytes, err := info.Metadata.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, ContextIDInfo{
+			ContextID:  info.ContextID,
+			EntriesCid: info.EntriesCid,
+			Metadata:   mdBytes,
+		})
+	}
+	return res, nil
+}
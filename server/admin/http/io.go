@@ -14,6 +14,18 @@ var (
 	_ io.ReaderFrom = (*RemoveCarRes)(nil)
 	_ io.ReaderFrom = (*ConnectReq)(nil)
 	_ io.ReaderFrom = (*ConnectRes)(nil)
+	_ io.ReaderFrom = (*CompactReq)(nil)
+	_ io.ReaderFrom = (*CompactRes)(nil)
+	_ io.ReaderFrom = (*ListContextIDsRes)(nil)
+	_ io.ReaderFrom = (*LookupMultihashRes)(nil)
+	_ io.ReaderFrom = (*ListCorruptedContextIDsRes)(nil)
+	_ io.ReaderFrom = (*UpdateMetadataReq)(nil)
+	_ io.ReaderFrom = (*UpdateMetadataRes)(nil)
+	_ io.ReaderFrom = (*SetRetrievalAddrsReq)(nil)
+	_ io.ReaderFrom = (*RetrievalAddrsRes)(nil)
+	_ io.ReaderFrom = (*CacheStatsRes)(nil)
+	_ io.ReaderFrom = (*WarmUpCacheReq)(nil)
+	_ io.ReaderFrom = (*WarmUpCacheRes)(nil)
 
 	_ io.WriterTo = (*ImportCarReq)(nil)
 	_ io.WriterTo = (*ImportCarRes)(nil)
@@ -21,6 +33,18 @@ var (
 	_ io.WriterTo = (*RemoveCarRes)(nil)
 	_ io.WriterTo = (*ConnectReq)(nil)
 	_ io.WriterTo = (*ConnectRes)(nil)
+	_ io.WriterTo = (*CompactReq)(nil)
+	_ io.WriterTo = (*CompactRes)(nil)
+	_ io.WriterTo = (*ListContextIDsRes)(nil)
+	_ io.WriterTo = (*LookupMultihashRes)(nil)
+	_ io.WriterTo = (*ListCorruptedContextIDsRes)(nil)
+	_ io.WriterTo = (*UpdateMetadataReq)(nil)
+	_ io.WriterTo = (*UpdateMetadataRes)(nil)
+	_ io.WriterTo = (*SetRetrievalAddrsReq)(nil)
+	_ io.WriterTo = (*RetrievalAddrsRes)(nil)
+	_ io.WriterTo = (*CacheStatsRes)(nil)
+	_ io.WriterTo = (*WarmUpCacheReq)(nil)
+	_ io.WriterTo = (*WarmUpCacheRes)(nil)
 )
 
 func (er *ImportCarReq) WriteTo(w io.Writer) (int64, error) {
@@ -87,6 +111,102 @@ func (er *AnnounceRes) ReadFrom(r io.Reader) (int64, error) {
 	return unmarshalAsJson(r, er)
 }
 
+func (er *CompactReq) WriteTo(w io.Writer) (int64, error) {
+	return marshalToJson(w, er)
+}
+
+func (er *CompactReq) ReadFrom(r io.Reader) (int64, error) {
+	return unmarshalAsJson(r, er)
+}
+
+func (er *CompactRes) WriteTo(w io.Writer) (int64, error) {
+	return marshalToJson(w, er)
+}
+
+func (er *CompactRes) ReadFrom(r io.Reader) (int64, error) {
+	return unmarshalAsJson(r, er)
+}
+
+func (er *ListContextIDsRes) WriteTo(w io.Writer) (int64, error) {
+	return marshalToJson(w, er)
+}
+
+func (er *ListContextIDsRes) ReadFrom(r io.Reader) (int64, error) {
+	return unmarshalAsJson(r, er)
+}
+
+func (er *LookupMultihashRes) WriteTo(w io.Writer) (int64, error) {
+	return marshalToJson(w, er)
+}
+
+func (er *LookupMultihashRes) ReadFrom(r io.Reader) (int64, error) {
+	return unmarshalAsJson(r, er)
+}
+
+func (er *ListCorruptedContextIDsRes) WriteTo(w io.Writer) (int64, error) {
+	return marshalToJson(w, er)
+}
+
+func (er *ListCorruptedContextIDsRes) ReadFrom(r io.Reader) (int64, error) {
+	return unmarshalAsJson(r, er)
+}
+
+func (er *UpdateMetadataReq) WriteTo(w io.Writer) (int64, error) {
+	return marshalToJson(w, er)
+}
+
+func (er *UpdateMetadataReq) ReadFrom(r io.Reader) (int64, error) {
+	return unmarshalAsJson(r, er)
+}
+
+func (er *UpdateMetadataRes) WriteTo(w io.Writer) (int64, error) {
+	return marshalToJson(w, er)
+}
+
+func (er *UpdateMetadataRes) ReadFrom(r io.Reader) (int64, error) {
+	return unmarshalAsJson(r, er)
+}
+
+func (er *SetRetrievalAddrsReq) WriteTo(w io.Writer) (int64, error) {
+	return marshalToJson(w, er)
+}
+
+func (er *SetRetrievalAddrsReq) ReadFrom(r io.Reader) (int64, error) {
+	return unmarshalAsJson(r, er)
+}
+
+func (er *RetrievalAddrsRes) WriteTo(w io.Writer) (int64, error) {
+	return marshalToJson(w, er)
+}
+
+func (er *RetrievalAddrsRes) ReadFrom(r io.Reader) (int64, error) {
+	return unmarshalAsJson(r, er)
+}
+
+func (er *CacheStatsRes) WriteTo(w io.Writer) (int64, error) {
+	return marshalToJson(w, er)
+}
+
+func (er *CacheStatsRes) ReadFrom(r io.Reader) (int64, error) {
+	return unmarshalAsJson(r, er)
+}
+
+func (er *WarmUpCacheReq) WriteTo(w io.Writer) (int64, error) {
+	return marshalToJson(w, er)
+}
+
+func (er *WarmUpCacheReq) ReadFrom(r io.Reader) (int64, error) {
+	return unmarshalAsJson(r, er)
+}
+
+func (er *WarmUpCacheRes) WriteTo(w io.Writer) (int64, error) {
+	return marshalToJson(w, er)
+}
+
+func (er *WarmUpCacheRes) ReadFrom(r io.Reader) (int64, error) {
+	return unmarshalAsJson(r, er)
+}
+
 func respond(w http.ResponseWriter, statusCode int, body io.WriterTo) {
 	w.WriteHeader(statusCode)
 	// Attempt to serialize body as JSON
@@ -0,0 +1,81 @@
+package adminserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/filecoin-project/index-provider/metadata"
+)
+
+var errNoProtocolsLeft = errors.New("updated metadata must have at least one protocol")
+
+func (s *Server) updateMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	// Decode request
+	var req UpdateMetadataReq
+	if _, err := req.ReadFrom(r.Body); err != nil {
+		msg := fmt.Sprintf("failed to unmarshal request: %v", err)
+		log.Errorw(msg, "err", err)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	var add metadata.Metadata
+	if len(req.Add) != 0 {
+		if err := add.UnmarshalBinary(req.Add); err != nil {
+			msg := fmt.Sprintf("failed to unmarshal metadata to add: %v", err)
+			log.Errorw(msg, "err", err)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+	}
+	if add.Len() == 0 && len(req.Remove) == 0 {
+		http.Error(w, "at least one protocol to add or remove must be specified", http.StatusBadRequest)
+		return
+	}
+	var filter map[string]struct{}
+	if len(req.ContextIDs) != 0 {
+		filter = make(map[string]struct{}, len(req.ContextIDs))
+		for _, contextID := range req.ContextIDs {
+			filter[string(contextID)] = struct{}{}
+		}
+	}
+
+	var protocolsToAdd []metadata.Protocol
+	for _, id := range add.Protocols() {
+		protocolsToAdd = append(protocolsToAdd, add.Get(id))
+	}
+	updated, adCid, err := s.e.UpdateMetadata(r.Context(), func(contextID []byte, md metadata.Metadata) (metadata.Metadata, bool, error) {
+		if filter != nil {
+			if _, ok := filter[string(contextID)]; !ok {
+				return md, false, nil
+			}
+		}
+		updated := md.Without(req.Remove...).With(protocolsToAdd...)
+		if updated.Len() == 0 {
+			return md, false, errNoProtocolsLeft
+		}
+		return updated, true, nil
+	})
+	if err != nil {
+		var errCode int
+		var invalid metadata.ErrInvalidMetadata
+		if errors.Is(err, errNoProtocolsLeft) || errors.As(err, &invalid) {
+			errCode = http.StatusBadRequest
+		} else {
+			errCode = http.StatusInternalServerError
+		}
+		msg := fmt.Sprintf("failed to update metadata: %v", err)
+		log.Errorw(msg, "err", err)
+		http.Error(w, msg, errCode)
+		return
+	}
+
+	// Respond success case.
+	log.Infow("Updated metadata successfully", "updated", updated, "adCid", adCid)
+	resp := &UpdateMetadataRes{
+		Updated: updated,
+		AdvId:   adCid,
+	}
+	respond(w, http.StatusOK, resp)
+}
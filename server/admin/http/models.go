@@ -1,7 +1,11 @@
 package adminserver
 
 import (
+	"time"
+
+	"github.com/filecoin-project/index-provider/metadata"
 	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
 )
 
 type (
@@ -23,6 +27,9 @@ type (
 		Key []byte `json:"key"`
 		// The optional metadata.
 		Metadata []byte `json:"metadata"`
+		// The optional metadata in its JSON representation, such as {"bitswap":{}}, as an
+		// alternative to Metadata. At most one of Metadata and MetadataJSON may be set.
+		MetadataJSON *metadata.Metadata `json:"metadata_json,omitempty"`
 	}
 	// ImportCarRes represents the response to an ImportCarReq.
 	ImportCarRes struct {
@@ -60,3 +67,159 @@ type (
 		AdvId cid.Cid `json:"adv_id"`
 	}
 )
+
+type (
+	// CompactReq represents a request to compact the advertisement chain.
+	CompactReq struct {
+		// DryRun specifies whether to only report the outcome of compaction without making any
+		// changes.
+		DryRun bool `json:"dry_run"`
+	}
+	// CompactRes represents the response to a CompactReq.
+	CompactRes struct {
+		// Whether the compaction was a dry-run.
+		DryRun bool `json:"dry_run"`
+		// The number of advertisements in the chain prior to compaction.
+		ChainLength int `json:"chain_length"`
+		// The number of context IDs advertised by the chain.
+		LiveContextIDs int `json:"live_context_ids"`
+		// The CID of the latest advertisement prior to compaction.
+		PreviousAdvId cid.Cid `json:"previous_adv_id"`
+		// The CID of the latest advertisement in the compacted chain, or cid.Undef if no
+		// compaction took place.
+		AdvId cid.Cid `json:"adv_id"`
+	}
+)
+
+type (
+	// ContextIDInfo represents an advertised context ID, along with its entries CID and metadata.
+	ContextIDInfo struct {
+		// The advertised context ID.
+		ContextID []byte `json:"context_id"`
+		// The CID of the advertised entries.
+		EntriesCid cid.Cid `json:"entries_cid"`
+		// The metadata with which the context ID is advertised.
+		Metadata []byte `json:"metadata"`
+	}
+	// ListContextIDsRes represents the response to list advertised context IDs.
+	ListContextIDsRes struct {
+		// The advertised context IDs.
+		ContextIDs []ContextIDInfo `json:"context_ids"`
+	}
+	// LookupMultihashRes represents the response to look up the context IDs that contain a
+	// multihash.
+	LookupMultihashRes struct {
+		// The advertised context IDs that contain the multihash.
+		ContextIDs []ContextIDInfo `json:"context_ids"`
+	}
+	// CorruptedContextID represents an advertised context ID the regenerated entries of which do
+	// not match the advertised entries.
+	CorruptedContextID struct {
+		// The corrupted context ID.
+		ContextID []byte `json:"context_id"`
+		// The CID of the advertised entries.
+		AdvertisedEntries cid.Cid `json:"advertised_entries"`
+		// The CID of the regenerated entries.
+		RegeneratedEntries cid.Cid `json:"regenerated_entries"`
+		// The time at which the mismatch was first detected.
+		DetectedAt time.Time `json:"detected_at"`
+		// The CID of the removal advertisement published for the context ID, or cid.Undef if
+		// none was published.
+		RemovalAdvId cid.Cid `json:"removal_adv_id"`
+	}
+	// ListCorruptedContextIDsRes represents the response to list corrupted context IDs.
+	ListCorruptedContextIDsRes struct {
+		// The corrupted context IDs.
+		ContextIDs []CorruptedContextID `json:"context_ids"`
+	}
+)
+
+type (
+	// UpdateMetadataReq represents a request to update the metadata of advertised context IDs.
+	UpdateMetadataReq struct {
+		// The optional metadata, the protocols of which are added to the metadata of each context
+		// ID. Any existing protocol with the same ID is replaced.
+		Add []byte `json:"add"`
+		// The optional IDs of protocols to remove from the metadata of each context ID.
+		Remove []multicodec.Code `json:"remove"`
+		// The optional context IDs to update. If not specified, all advertised context IDs are
+		// updated.
+		ContextIDs [][]byte `json:"context_ids"`
+	}
+	// UpdateMetadataRes represents the response to an UpdateMetadataReq.
+	UpdateMetadataRes struct {
+		// The number of context IDs updated.
+		Updated int `json:"updated"`
+		// The CID of the latest advertisement published as a result of update, or cid.Undef if no
+		// context IDs were updated.
+		AdvId cid.Cid `json:"adv_id"`
+	}
+)
+
+type (
+	// SetRetrievalAddrsReq represents a request to set the retrieval addresses of the provider.
+	SetRetrievalAddrsReq struct {
+		// The retrieval addresses in multiaddr string form. If empty, the retrieval addresses are
+		// reset to the configured ones.
+		Addrs []string `json:"addrs"`
+	}
+	// RetrievalAddrsRes represents the current retrieval addresses of the provider.
+	RetrievalAddrsRes struct {
+		// The retrieval addresses in multiaddr string form.
+		Addrs []string `json:"addrs"`
+		// The CID of the advertisement published as a result of setting the addresses, or
+		// cid.Undef if no advertisement was published.
+		AdvId cid.Cid `json:"adv_id"`
+	}
+)
+
+type (
+	// CacheStatsRes represents the usage of the advertisement entries cache.
+	CacheStatsRes struct {
+		// The number of cached entries DAGs.
+		Len int `json:"len"`
+		// The maximum number of cached entries DAGs, or zero if unlimited.
+		Cap int `json:"cap"`
+		// The total size of cached entries chunks in bytes.
+		Bytes int64 `json:"bytes"`
+		// The maximum total size of cached entries chunks in bytes, or zero if unlimited.
+		MaxBytes int64 `json:"max_bytes"`
+		// The number of entries chunks requested by indexers that were found in cache.
+		Hits uint64 `json:"hits"`
+		// The number of entries chunks requested by indexers that were regenerated.
+		Misses uint64 `json:"misses"`
+		// The ratio of entries chunk requests that were found in cache.
+		HitRate float64 `json:"hit_rate"`
+		// The number of times the regenerated entries did not match the advertised entries.
+		Mismatches uint64 `json:"mismatches"`
+	}
+	// WarmUpCacheReq represents a request to warm up the advertisement entries cache.
+	WarmUpCacheReq struct {
+		// The maximum number of most recent advertisements to warm up, or zero if unlimited.
+		Ads int `json:"ads"`
+		// The CID of the advertisement after which to warm up advertisements, excluding it, or
+		// cid.Undef to warm up advertisements back to the start of the chain.
+		Since cid.Cid `json:"since"`
+		// The number of advertisements to warm up at a time. Defaults to 1 if zero.
+		Concurrency int `json:"concurrency"`
+	}
+	// WarmUpCacheRes represents the progress of warming up the advertisement entries cache.
+	WarmUpCacheRes struct {
+		// Whether the warm-up is in progress.
+		Running bool `json:"running"`
+		// The number of advertisements selected for warm-up.
+		Total int `json:"total"`
+		// The number of advertisements the entries of which were generated.
+		Generated int `json:"generated"`
+		// The number of advertisements the entries of which were already cached.
+		Cached int `json:"cached"`
+		// The number of advertisements the entries of which could not be generated.
+		Failed int `json:"failed"`
+		// The error that stopped the warm-up early, if any.
+		Error string `json:"error,omitempty"`
+		// The time at which the warm-up started, or zero if the cache was never warmed up.
+		StartedAt time.Time `json:"started_at"`
+		// The time at which the warm-up finished, or zero if it is running.
+		FinishedAt time.Time `json:"finished_at"`
+	}
+)
@@ -46,10 +46,39 @@ func New(h host.Host, e *engine.Engine, cs *supplier.CarSupplier, o ...Option) (
 	r.HandleFunc("/admin/announcehttp", s.announceHttpHandler).
 		Methods(http.MethodPost)
 
+	r.HandleFunc("/admin/compact", s.compactHandler).
+		Methods(http.MethodPost).
+		Headers("Content-Type", "application/json")
+
 	r.HandleFunc("/admin/connect", s.connectHandler).
 		Methods(http.MethodPost).
 		Headers("Content-Type", "application/json")
 
+	r.HandleFunc("/admin/list/contextid", s.listContextIDsHandler).
+		Methods(http.MethodGet)
+	r.HandleFunc("/admin/list/corrupted", s.listCorruptedContextIDsHandler).
+		Methods(http.MethodGet)
+	r.HandleFunc("/admin/lookup/multihash/{multihash}", s.lookupMultihashHandler).
+		Methods(http.MethodGet)
+
+	r.HandleFunc("/admin/update/metadata", s.updateMetadataHandler).
+		Methods(http.MethodPost).
+		Headers("Content-Type", "application/json")
+
+	r.HandleFunc("/admin/addrs", s.listRetrievalAddrsHandler).
+		Methods(http.MethodGet)
+	r.HandleFunc("/admin/addrs", s.setRetrievalAddrsHandler).
+		Methods(http.MethodPost).
+		Headers("Content-Type", "application/json")
+
+	r.HandleFunc("/admin/cache/stats", s.cacheStatsHandler).
+		Methods(http.MethodGet)
+	r.HandleFunc("/admin/cache/warmup", s.warmUpCacheStatusHandler).
+		Methods(http.MethodGet)
+	r.HandleFunc("/admin/cache/warmup", s.warmUpCacheHandler).
+		Methods(http.MethodPost).
+		Headers("Content-Type", "application/json")
+
 	cHandler := &carHandler{cs}
 	r.HandleFunc("/admin/import/car", cHandler.handleImport).
 		Methods(http.MethodPost).
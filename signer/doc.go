@@ -0,0 +1,10 @@
+// Package signer provides a Signer that delegates signing of advertisements to a local signing
+// daemon over a Unix socket, such that the signing key does not need to be present in the memory
+// of index provider process. Note that this only holds if the libp2p host of the process has an
+// identity of its own; the provider daemon keeps the configured private key as the host identity.
+//
+// The signing daemon accepts one JSON encoded request per connection, and responds with a JSON
+// encoded response before closing the connection. A request either asks for the public key of the
+// signer, or for the signature of the given data. Server implements the daemon side of protocol
+// backed by a crypto.PrivKey, and may be used as a reference implementation.
+package signer
@@ -0,0 +1,39 @@
+package signer
+
+import (
+	"fmt"
+	"time"
+)
+
+type (
+	// Option sets a configuration parameter for the SocketSigner.
+	Option func(*options) error
+
+	options struct {
+		timeout time.Duration
+	}
+)
+
+func newOptions(o ...Option) (*options, error) {
+	opts := &options{
+		timeout: 10 * time.Second,
+	}
+	for _, apply := range o {
+		if err := apply(opts); err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+// WithTimeout sets the maximum time to wait for a response from the signing daemon.
+// Defaults to 10 seconds if unspecified.
+func WithTimeout(t time.Duration) Option {
+	return func(o *options) error {
+		if t <= 0 {
+			return fmt.Errorf("timeout must be greater than zero; got %s", t)
+		}
+		o.timeout = t
+		return nil
+	}
+}
@@ -0,0 +1,25 @@
+package signer
+
+const (
+	methodPublicKey = "public_key"
+	methodSign      = "sign"
+)
+
+type (
+	// request represents a request to the signing daemon.
+	request struct {
+		// The requested method, either methodPublicKey or methodSign.
+		Method string `json:"method"`
+		// The data to sign, if the method is methodSign.
+		Data []byte `json:"data,omitempty"`
+	}
+	// response represents the response of signing daemon to a request.
+	response struct {
+		// The protobuf marshalled public key of the signer, if the method is methodPublicKey.
+		PublicKey []byte `json:"public_key,omitempty"`
+		// The signature of requested data, if the method is methodSign.
+		Signature []byte `json:"signature,omitempty"`
+		// The error that occurred while processing the request, if any.
+		Error string `json:"error,omitempty"`
+	}
+)
@@ -0,0 +1,63 @@
+package signer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+)
+
+// Server serves signing requests from SocketSigner using a private key.
+type Server struct {
+	key crypto.PrivKey
+}
+
+// NewServer instantiates a new Server that signs using the given private key.
+func NewServer(key crypto.PrivKey) *Server {
+	return &Server{key: key}
+}
+
+// Serve accepts connections on the given listener and serves signing requests, until the listener
+// is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var req request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		log.Errorw("Failed to decode signing request", "err", err)
+		return
+	}
+
+	var resp response
+	var err error
+	switch req.Method {
+	case methodPublicKey:
+		resp.PublicKey, err = crypto.MarshalPublicKey(s.key.GetPublic())
+	case methodSign:
+		resp.Signature, err = s.key.Sign(req.Data)
+	default:
+		err = fmt.Errorf("unknown method: %q", req.Method)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		log.Errorw("Failed to write signing response", "err", err)
+	}
+}
@@ -0,0 +1,102 @@
+package signer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/crypto"
+)
+
+var log = logging.Logger("provider/signer")
+
+// SocketSigner signs data by sending requests to a signing daemon listening on a Unix socket.
+// SocketSigner satisfies engine.Signer.
+type SocketSigner struct {
+	path    string
+	timeout time.Duration
+	pubKey  crypto.PubKey
+}
+
+// NewSocketSigner instantiates a new SocketSigner that talks to the signing daemon listening on
+// the Unix socket at the given path. The public key of the signer is fetched from the daemon upon
+// instantiation.
+func NewSocketSigner(ctx context.Context, path string, o ...Option) (*SocketSigner, error) {
+	opts, err := newOptions(o...)
+	if err != nil {
+		return nil, err
+	}
+	s := &SocketSigner{
+		path:    path,
+		timeout: opts.timeout,
+	}
+
+	resp, err := s.do(ctx, &request{Method: methodPublicKey})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key from signer: %w", err)
+	}
+	s.pubKey, err = crypto.UnmarshalPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode public key of signer: %w", err)
+	}
+	return s, nil
+}
+
+// Sign signs the given data via the signing daemon.
+func (s *SocketSigner) Sign(data []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	resp, err := s.do(ctx, &request{Method: methodSign, Data: data})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	// Defensively verify the signature to catch a misbehaving daemon early, since an invalid
+	// signature would otherwise only surface when indexers reject the advertisement.
+	ok, err := s.pubKey.Verify(data, resp.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify signature: %w", err)
+	}
+	if !ok {
+		return nil, errors.New("signer returned invalid signature")
+	}
+	return resp.Signature, nil
+}
+
+// GetPublic returns the public key of the signer.
+func (s *SocketSigner) GetPublic() crypto.PubKey {
+	return s.pubKey
+}
+
+func (s *SocketSigner) do(ctx context.Context, req *request) (*response, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(s.timeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	var resp response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	log.Debugw("Received response from signer", "method", req.Method)
+	return &resp, nil
+}
@@ -0,0 +1,60 @@
+package signer_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/index-provider/engine"
+	"github.com/filecoin-project/index-provider/signer"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+var _ engine.Signer = (*signer.SocketSigner)(nil)
+
+func TestSocketSigner(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	path := listenSigner(t, key)
+
+	subject, err := signer.NewSocketSigner(ctx, path)
+	require.NoError(t, err)
+	require.True(t, key.GetPublic().Equals(subject.GetPublic()))
+
+	data := []byte("fish")
+	sig, err := subject.Sign(data)
+	require.NoError(t, err)
+	ok, err := key.GetPublic().Verify(data, sig)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestNewSocketSigner_FailsWhenDaemonIsUnreachable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := signer.NewSocketSigner(ctx, filepath.Join(t.TempDir(), "missing.sock"))
+	require.Error(t, err)
+}
+
+// listenSigner starts a signing daemon backed by the given key, and returns the path to its socket.
+func listenSigner(t *testing.T, key crypto.PrivKey) string {
+	// Use a short path, since the length of Unix socket paths is limited.
+	dir, err := os.MkdirTemp("", "signer")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "signer.sock")
+
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() { _ = signer.NewServer(key).Serve(l) }()
+	return path
+}
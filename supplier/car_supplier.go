@@ -15,8 +15,7 @@ import (
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/ipld/go-car/v2"
 	"github.com/ipld/go-car/v2/blockstore"
-	"github.com/ipld/go-car/v2/index"
-	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 )
 
 const (
@@ -127,12 +126,55 @@ func (cs *CarSupplier) List(ctx context.Context) ([]string, error) {
 
 // ListMultihashes supplies an iterator over CIDs of the CAR file that corresponds to
 // the given key.  An error is returned if no CAR file is found for the key.
+//
+// The multihashes are streamed from the CAR data payload in order of their offset, which bounds
+// the memory used regardless of the CAR size. The CAR file is closed once the iterator is
+// exhausted, returns an error, or is closed; the returned iterator implements io.Closer.
 func (cs *CarSupplier) ListMultihashes(ctx context.Context, contextID []byte) (provider.MultihashIterator, error) {
-	idx, err := cs.lookupIterableIndex(ctx, contextID)
+	path, err := cs.getPath(ctx, contextID)
 	if err != nil {
 		return nil, err
 	}
-	return provider.CarMultihashIterator(idx)
+	cr, err := car.OpenReader(path, cs.opts...)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := cr.DataReader()
+	if err != nil {
+		_ = cr.Close()
+		return nil, err
+	}
+	return &closingMhIterator{
+		MultihashIterator: provider.CarDataMultihashIterator(dr, cs.opts...),
+		c:                 cr,
+	}, nil
+}
+
+// closingMhIterator closes the underlying io.Closer once the wrapped iterator returns an error,
+// including io.EOF, or once closed itself.
+type closingMhIterator struct {
+	provider.MultihashIterator
+	c io.Closer
+}
+
+func (i *closingMhIterator) Next() (multihash.Multihash, error) {
+	mh, err := i.MultihashIterator.Next()
+	if err != nil {
+		if cerr := i.Close(); cerr != nil {
+			log.Warnw("Failed to close CAR", "err", cerr)
+		}
+	}
+	return mh, err
+}
+
+// Close closes the underlying io.Closer, unless already closed.
+func (i *closingMhIterator) Close() error {
+	if i.c == nil {
+		return nil
+	}
+	err := i.c.Close()
+	i.c = nil
+	return err
 }
 
 // ClosableBlockstore is a blockstore that can be closed
@@ -161,61 +203,6 @@ func (cs *CarSupplier) getPath(ctx context.Context, contextID []byte) (path stri
 	return string(b), nil
 }
 
-func (cs *CarSupplier) lookupIterableIndex(ctx context.Context, contextID []byte) (index.IterableIndex, error) {
-	log := log.With("contextID", contextID)
-
-	path, err := cs.getPath(ctx, contextID)
-	if err != nil {
-		return nil, err
-	}
-
-	cr, err := car.OpenReader(path, cs.opts...)
-	if err != nil {
-		return nil, err
-	}
-	idxReader, err := cr.IndexReader()
-	if err != nil {
-		return nil, err
-	}
-	if idxReader == nil {
-		// Missing index; generate it.
-		log.Debugw("CAR has no index; generating.")
-		return cs.generateIterableIndex(cr)
-	}
-	idx, err := index.ReadFrom(idxReader)
-	if err != nil {
-		return nil, err
-	}
-	codec := idx.Codec()
-	log = log.With("codec", codec)
-	if codec != multicodec.CarMultihashIndexSorted {
-		log.Debugw("CAR index not iterable; regenerating index.")
-		return cs.generateIterableIndex(cr)
-	}
-	itIdx, ok := idx.(index.IterableIndex)
-	if !ok {
-		// Though technically possible, this should not happen, since the expectation is that
-		// multicodec.CarMultihashIndexSorted implements the index.IterableIndex interface.
-		// Regardless, defensively check this and re-generate as needed in case go-car library
-		// changes this expectation.
-		log.Warnw("expected CAR index to implement index.IterableIndex interface; regenerating index.")
-		return cs.generateIterableIndex(cr)
-	}
-	return itIdx, nil
-}
-
-func (cs *CarSupplier) generateIterableIndex(cr *car.Reader) (index.IterableIndex, error) {
-	idx := index.NewMultihashSorted()
-	dr, err := cr.DataReader()
-	if err != nil {
-		return nil, err
-	}
-	if err := car.LoadIndex(idx, dr, cs.opts...); err != nil {
-		return nil, err
-	}
-	return idx, nil
-}
-
 // Close permanently closes this supplier.
 // After calling Close this supplier is no longer usable.
 func (cs *CarSupplier) Close() error {
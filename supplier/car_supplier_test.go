@@ -193,3 +193,32 @@ func generateCidV1(t *testing.T, rng *rand.Rand) cid.Cid {
 	require.NoError(t, err)
 	return cid.NewCidV1(cid.Raw, mh)
 }
+
+func TestListMultihashesIteratorIsClosable(t *testing.T) {
+	mc := gomock.NewController(t)
+	t.Cleanup(mc.Finish)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockEng := mock_provider.NewMockInterface(mc)
+	mockEng.EXPECT().RegisterMultihashLister(gomock.Any())
+	subject := NewCarSupplier(mockEng, datastore.NewMapDatastore())
+	t.Cleanup(func() { require.NoError(t, subject.Close()) })
+
+	contextID := []byte("fish")
+	md := metadata.New()
+	mockEng.EXPECT().NotifyPut(ctx, contextID, md).Return(cid.Undef, nil)
+	_, err := subject.Put(ctx, contextID, "../testdata/sample-v1.car", md)
+	require.NoError(t, err)
+
+	gotIterator, err := subject.ListMultihashes(ctx, contextID)
+	require.NoError(t, err)
+	_, err = gotIterator.Next()
+	require.NoError(t, err)
+
+	// Assert the CAR is closed when iteration stops early, and closing is idempotent.
+	closer, ok := gotIterator.(io.Closer)
+	require.True(t, ok)
+	require.NoError(t, closer.Close())
+	require.NoError(t, closer.Close())
+}